@@ -60,11 +60,11 @@ func resolveValue(s *State, line []uint8, opcode opcode) uint32 {
 	address := resolveAddress(s, line, opcode)
 	switch s.rWidth {
 	case R24:
-		return uint32(s.mem.Peek(address)) | uint32(s.mem.Peek(address+1)) << 8 | uint32(s.mem.Peek(address+2)) << 16
+		return uint32(s.peek(address, AccessRead)) | uint32(s.peek(address+1, AccessRead)) << 8 | uint32(s.peek(address+2, AccessRead)) << 16
 	case R16:
-		return uint32(s.mem.Peek(address)) | uint32(s.mem.Peek(address+1)) << 8
+		return uint32(s.peek(address, AccessRead)) | uint32(s.peek(address+1, AccessRead)) << 8
 	default:
-		return uint32(s.mem.Peek(address))
+		return uint32(s.peek(address, AccessRead))
 	}
 }
 
@@ -85,14 +85,14 @@ func resolveSetValue(s *State, line []uint8, opcode opcode, value uint32) {
 	address := resolveAddress(s, line, opcode)
 	switch s.rWidth {
 	case R24:
-		s.mem.Poke(address, uint8(value))
-		s.mem.Poke(address+1, uint8(value >> 8))
-		s.mem.Poke(address+2, uint8(value >> 16))
+		s.poke(address, uint8(value), AccessWrite)
+		s.poke(address+1, uint8(value >> 8), AccessWrite)
+		s.poke(address+2, uint8(value >> 16), AccessWrite)
 	case R16:
-		s.mem.Poke(address, uint8(value))
-		s.mem.Poke(address+1, uint8(value >> 8))
+		s.poke(address, uint8(value), AccessWrite)
+		s.poke(address+1, uint8(value >> 8), AccessWrite)
 	default:
-		s.mem.Poke(address, uint8(value))
+		s.poke(address, uint8(value), AccessWrite)
 	}
 
 	// On writes, the possible extra cycle crossing page boundaries is
@@ -144,36 +144,36 @@ func resolveAddress(s *State, line []uint8, opcode opcode) uint32 {
 		switch s.abWidth {
 		case AB24:
 			addressAddress := uint32(line[1]) + s.reg.getX(s.abWidth)
-			address = uint32(getZeroPage24Bits(s.mem, addressAddress))
+			address = uint32(getZeroPage24Bits(s, addressAddress, AccessPointer))
 		default:
 			addressAddress := uint32(line[1]) + s.reg.getX(s.abWidth)
 			// 24T8 BACKWARD COMPATIBILITY - in 16-bit mode (zp,X) wraps within 64K
 			for addressAddress > 0x0ffff {
 				addressAddress -= 0x10000
 			}
-			address = uint32(getZeroPageWord(s.mem, addressAddress))
+			address = uint32(getZeroPageWord(s, addressAddress, AccessPointer))
 		}
 	case modeIndirect:
 		switch s.abWidth {
 		case AB24:
 			addressAddress := get24BitsInLine(line)
-			address = get24Bits(s.mem, addressAddress)
+			address = get24Bits(s, addressAddress, AccessPointer)
 		default:
 			addressAddress := uint32(getWordInLine(line))
 			// 24T8 BACKWARD COMPATIBILITY - in 16-bit mode (aaaa) wraps within 64K
 			for addressAddress > 0x0ffff {
 				addressAddress -= 0x10000
 			}
-			address = uint32(getWordNoCrossPage(s.mem, addressAddress))
+			address = uint32(getWordNoCrossPage(s, addressAddress, AccessPointer))
 		}
 	case modeIndirect65c02Fix:
 		switch s.abWidth {
 		case AB24:
 			addressAddress := get24BitsInLine(line)
-			address = get24Bits(s.mem, addressAddress)
+			address = get24Bits(s, addressAddress, AccessPointer)
 		default:
 			addressAddress := uint32(getWordInLine(line))
-			address = uint32(getWord(s.mem, addressAddress))
+			address = uint32(getWord(s, addressAddress, AccessPointer))
 			// 24T8 BACKWARD COMPATIBILITY - in 16-bit mode (aaaa) wraps within 64K
 			for addressAddress > 0x0ffff {
 				addressAddress -= 0x10000
@@ -182,32 +182,32 @@ func resolveAddress(s *State, line []uint8, opcode opcode) uint32 {
 	case modeIndirectIndexedY:
 		switch s.abWidth {
 			case AB24:
-				base := uint32(getZeroPage24Bits(s.mem, uint32(line[1])))
+				base := uint32(getZeroPage24Bits(s, uint32(line[1]), AccessPointer))
 				address, extraCycle = addOffset(s, base, s.reg.getY(s.abWidth))
 			default:
-				base := uint32(getZeroPageWord(s.mem, uint32(line[1])))
+				base := uint32(getZeroPageWord(s, uint32(line[1]), AccessPointer))
 				address, extraCycle = addOffset(s, base, s.reg.getY(s.abWidth))
 		}
 	// 65c02 additions
 	case modeIndirectZeroPage:
 		switch s.abWidth {
 			case AB24:
-				address = uint32(getZeroPage24Bits(s.mem, uint32(line[1])))
+				address = uint32(getZeroPage24Bits(s, uint32(line[1]), AccessPointer))
 			default:
-				address = uint32(getZeroPageWord(s.mem, uint32(line[1])))
+				address = uint32(getZeroPageWord(s, uint32(line[1]), AccessPointer))
 		}
 	case modeAbsoluteIndexedIndirectX:
 		switch s.abWidth {
 			case AB24:
 				addressAddress := get24BitsInLine(line) + s.reg.getX(s.abWidth)
-				address = get24Bits(s.mem, addressAddress)
+				address = get24Bits(s, addressAddress, AccessPointer)
 			default:
 				addressAddress := getWordInLine(line) + s.reg.getX(s.abWidth)
 				// 24T8 BACKWARD COMPATIBILITY - in 16-bit mode (aaaa,x) wraps within 64K
 				for addressAddress > 0x0ffff {
 					addressAddress -= 0x10000
 				}
-				address = uint32(getWord(s.mem, addressAddress))
+				address = uint32(getWord(s, addressAddress, AccessPointer))
 		}
 	case modeRelative:
 		// This assumes that PC is already pointing to the next instruction
@@ -233,13 +233,15 @@ func resolveAddress(s *State, line []uint8, opcode opcode) uint32 {
 		address = s.reg.getX(s.abWidth) + s.reg.getY(s.abWidth)
 		extraCycle = false
 	default:
-		panic("Assert failed. Missing addressing mode")
+		panic(assertError("Missing addressing mode"))
 	}
 
 	if extraCycle {
 		s.extraCycleCrossingBoundaries = true
 	}
 
+	s.effectiveAddress = address
+	s.hasEffectiveAddress = true
 	return address
 }
 
@@ -307,6 +309,39 @@ func addOffsetRelative16(s *State, base uint32, offset uint32) (uint32, bool) {
 	}
 }
 
+// AddressMode is the addressing mode of an instruction
+type AddressMode int
+
+// Addressing modes of the instructions
+const (
+	ModeImplicit                 AddressMode = modeImplicit
+	ModeImplicitX                AddressMode = modeImplicitX
+	ModeImplicitY                AddressMode = modeImplicitY
+	ModeAccumulator              AddressMode = modeAccumulator
+	ModeImmediate                AddressMode = modeImmediate
+	ModeZeroPage                 AddressMode = modeZeroPage
+	ModeZeroPageX                AddressMode = modeZeroPageX
+	ModeZeroPageY                AddressMode = modeZeroPageY
+	ModeRelative                 AddressMode = modeRelative
+	ModeAbsolute                 AddressMode = modeAbsolute
+	ModeAbsoluteX                AddressMode = modeAbsoluteX
+	ModeAbsoluteX65c02           AddressMode = modeAbsoluteX65c02
+	ModeAbsoluteY                AddressMode = modeAbsoluteY
+	ModeIndirect                 AddressMode = modeIndirect
+	ModeIndexedIndirectX         AddressMode = modeIndexedIndirectX
+	ModeIndirectIndexedY         AddressMode = modeIndirectIndexedY
+	ModeIndirect65c02Fix         AddressMode = modeIndirect65c02Fix
+	ModeIndirectZeroPage         AddressMode = modeIndirectZeroPage
+	ModeAbsoluteIndexedIndirectX AddressMode = modeAbsoluteIndexedIndirectX
+	ModeZeroPageAndRelative      AddressMode = modeZeroPageAndRelative
+	ModeX                        AddressMode = modeX
+	ModeXY                       AddressMode = modeXY
+)
+
+func (m AddressMode) String() string {
+	return addressModeString(int(m))
+}
+
 func addressModeString(addressMode int) string {
 	switch (addressMode) {
 	case modeImplicit: return "modeImplicit"
@@ -317,6 +352,7 @@ func addressModeString(addressMode int) string {
 	case modeZeroPage: return "modeZeroPage"
 	case modeZeroPageX: return "modeZeroPageX"
 	case modeZeroPageY: return "modeZeroPageY"
+	case modeRelative: return "modeRelative"
 	case modeAbsolute: return "modeAbsolute"
 	case modeAbsoluteX: return "modeAbsoluteX"
 	case modeAbsoluteX65c02: return "modeAbsoluteX65c02"
@@ -328,90 +364,8 @@ func addressModeString(addressMode int) string {
 	case modeIndirectZeroPage: return "modeIndirectZeroPage"
 	case modeAbsoluteIndexedIndirectX: return "modeAbsoluteIndexedIndirectX"
 	case modeZeroPageAndRelative: return "modeZeroPageAndRelative"
+	case modeX: return "modeX"
+	case modeXY: return "modeXY"
 	default: return fmt.Sprintf("modeUnknown %d", addressMode)
 	}
 }
-
-func lineString(s *State, line []uint8, opcode opcode) string {
-	t := opcode.name
-	switch opcode.addressMode {
-	case modeImplicit:
-	case modeImplicitX:
-	case modeImplicitY:
-		//Nothing
-	case modeAccumulator:
-		t += " A"
-	case modeImmediate:
-		switch s.rWidth {
-		case R24:
-			t += fmt.Sprintf(" #$%02x%02x%02x", line[3], line[2], line[1])
-		case R16:
-			t += fmt.Sprintf(" #$%02x%02x", line[2], line[1])
-		default:
-			t += fmt.Sprintf(" #$%02x", line[1])
-		}
-	case modeZeroPage:
-		t += fmt.Sprintf(" $%02x", line[1])
-	case modeZeroPageX:
-		t += fmt.Sprintf(" $%02x,X", line[1])
-	case modeZeroPageY:
-		t += fmt.Sprintf(" $%02x,Y", line[1])
-	case modeRelative:
-		t += fmt.Sprintf(" *%+x", int8(line[1]))
-	case modeAbsolute:
-		switch s.abWidth {
-		case AB24:
-			t += fmt.Sprintf(" $%06x", get24BitsInLine(line))
-		default:
-			t += fmt.Sprintf(" $%04x", getWordInLine(line))
-		}
-	case modeAbsoluteX65c02:
-		fallthrough
-	case modeAbsoluteX:
-		switch s.abWidth {
-		case AB24:
-			t += fmt.Sprintf(" $%06x,X", get24BitsInLine(line))
-		default:
-			t += fmt.Sprintf(" $%04x,X", getWordInLine(line))
-		}
-	case modeAbsoluteY:
-		switch s.abWidth {
-		case AB24:
-			t += fmt.Sprintf(" $%06x,Y", get24BitsInLine(line))
-		default:
-			t += fmt.Sprintf(" $%04x,Y", getWordInLine(line))
-		}
-	case modeIndirect65c02Fix:
-		fallthrough
-	case modeIndirect:
-		switch s.abWidth {
-		case AB24:
-			t += fmt.Sprintf(" ($%06x)", get24BitsInLine(line))
-		default:
-			t += fmt.Sprintf(" ($%04x)", getWordInLine(line))
-		}
-	case modeIndexedIndirectX:
-		t += fmt.Sprintf(" ($%02x,X)", line[1])
-	case modeIndirectIndexedY:
-		t += fmt.Sprintf(" ($%02x),Y", line[1])
-	// 65c02 additions:
-	case modeIndirectZeroPage:
-		t += fmt.Sprintf(" ($%02x)", line[1])
-	case modeAbsoluteIndexedIndirectX:
-		switch s.abWidth {
-		case AB24:
-			t += fmt.Sprintf(" ($%06x,X)", get24BitsInLine(line))
-		default:
-			t += fmt.Sprintf(" ($%04x,X)", getWordInLine(line))
-		}
-	case modeZeroPageAndRelative:
-		t += fmt.Sprintf(" $%02x %+x", line[1], int8(line[2]))
-	case modeX:
-		t += fmt.Sprintf(" X")
-	case modeXY:
-		t += fmt.Sprintf(" XY")
-	default:
-		t += "UNKNOWN MODE"
-	}
-	return t
-}
@@ -0,0 +1,440 @@
+/*
+Package asm is an assembler for the 6502, the 65c02 and the 65c24T8.
+
+The syntax follows ca65:
+
+	label:  LDA #$42        ; comment
+	        STA a:$0012     ; a: forces absolute, z: forces zero page
+	@local: DEX             ; local to the previous label
+	value = $1234
+	        .org $0400
+	        .byte 1, "text", <value
+	        .word value
+	        .long $123456   ; 3 bytes
+	        .res 16, $ea
+	        .include "file.s"
+	        .macro name param1, param2
+	        .endmacro
+
+On the 65c24T8 the prefixes (A24, R16, R24, W16 and W24) can be written
+as instructions. Without them, the assembler inserts the prefix needed for
+immediates wider than 8 bits, addresses above $ffff and branches beyond
+the range of 8 bits.
+
+The opcodes are taken from the tables of the emulator. The assembler is
+two-pass, with extra passes while the size of 65c24T8 instructions grows.
+*/
+package asm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+const maxPasses = 16
+
+// Error is an assembly error on a line of source
+type Error struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v:%v: %v", e.File, e.Line, e.Message)
+}
+
+// Segment is a block of contiguous assembled bytes
+type Segment struct {
+	Address uint32
+	Data    []uint8
+}
+
+// Program is the output of the assembler
+type Program struct {
+	Segments []Segment
+	Symbols  map[string]uint32
+}
+
+// Load pokes the assembled bytes in memory
+func (p *Program) Load(mem iz6502.Memory) {
+	for _, s := range p.Segments {
+		for i, v := range s.Data {
+			mem.Poke(s.Address+uint32(i), v)
+		}
+	}
+}
+
+// Image returns the assembled bytes as a single block starting at the lowest
+// address. Gaps are filled with zeros.
+func (p *Program) Image() (uint32, []uint8) {
+	if len(p.Segments) == 0 {
+		return 0, nil
+	}
+	start := p.Segments[0].Address
+	end := start
+	for _, s := range p.Segments {
+		if s.Address < start {
+			start = s.Address
+		}
+		if s.Address+uint32(len(s.Data)) > end {
+			end = s.Address + uint32(len(s.Data))
+		}
+	}
+	image := make([]uint8, end-start)
+	for _, s := range p.Segments {
+		copy(image[s.Address-start:], s.Data)
+	}
+	return start, image
+}
+
+// SymbolNames returns the names of the symbols sorted by address
+func (p *Program) SymbolNames() []string {
+	names := make([]string, 0, len(p.Symbols))
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if p.Symbols[names[i]] != p.Symbols[names[j]] {
+			return p.Symbols[names[i]] < p.Symbols[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// Assembler assembles source for a cpu model
+type Assembler struct {
+	model    iz6502.Model
+	opcodes  map[string]map[iz6502.AddressMode]iz6502.OpcodeInfo
+	prefixes map[iz6502.Widths]iz6502.OpcodeInfo
+
+	// ReadFile is used to read the source files and the includes
+	ReadFile func(filename string) ([]byte, error)
+}
+
+// New returns an assembler for the model
+func New(model iz6502.Model) (*Assembler, error) {
+	infos, err := iz6502.Opcodes(model)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Assembler{
+		model:    model,
+		opcodes:  make(map[string]map[iz6502.AddressMode]iz6502.OpcodeInfo),
+		prefixes: make(map[iz6502.Widths]iz6502.OpcodeInfo),
+		ReadFile: ioutil.ReadFile,
+	}
+	for _, info := range infos {
+		if info.IsPrefix {
+			a.prefixes[info.Widths()] = info
+		}
+		modes, ok := a.opcodes[info.Mnemonic]
+		if !ok {
+			modes = make(map[iz6502.AddressMode]iz6502.OpcodeInfo)
+			a.opcodes[info.Mnemonic] = modes
+		}
+		// Keep the first opcode for a mode, but the documented NOP is $ea
+		if _, ok := modes[info.Mode]; !ok || info.Opcode == 0xea {
+			modes[info.Mode] = info
+		}
+	}
+	return a, nil
+}
+
+// Assemble assembles the source with the given model
+func Assemble(model iz6502.Model, source string) (*Program, error) {
+	a, err := New(model)
+	if err != nil {
+		return nil, err
+	}
+	return a.AssembleSource("source", source)
+}
+
+// AssembleFile assembles a source file
+func (a *Assembler) AssembleFile(filename string) (*Program, error) {
+	e := &expander{a: a, macros: make(map[string]*macro)}
+	err := e.expandFile(filename, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.run(e.statements)
+}
+
+// AssembleSource assembles source text. The filename is used on errors and
+// as the base for includes.
+func (a *Assembler) AssembleSource(filename string, source string) (*Program, error) {
+	e := &expander{a: a, macros: make(map[string]*macro)}
+	err := e.expandSource(filename, source, 0)
+	if err != nil {
+		return nil, err
+	}
+	return a.run(e.statements)
+}
+
+func (a *Assembler) run(statements []*statement) (*Program, error) {
+	var previous map[string]int64
+	for pass := 0; pass < maxPasses; pass++ {
+		p := &assemblyPass{a: a, previous: previous, symbols: make(map[string]int64),
+			assignments: make(map[string]*assignment)}
+		p.run(statements)
+		if pass == 0 {
+			// Would never converge
+			if err := p.circular(); err != nil {
+				return nil, err
+			}
+		}
+
+		if pass > 0 && !p.grew && sameSymbols(p.symbols, previous) {
+			if len(p.errors) > 0 {
+				return nil, p.errors[0]
+			}
+			program := &Program{
+				Segments: p.segments,
+				Symbols:  make(map[string]uint32),
+			}
+			for name, value := range p.symbols {
+				program.Symbols[name] = uint32(value)
+			}
+			return program, nil
+		}
+		previous = p.symbols
+	}
+	return nil, fmt.Errorf("the assembly did not converge after %v passes", maxPasses)
+}
+
+func sameSymbols(a map[string]int64, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// assignment is a symbol defined with =, and the symbols in its expression
+type assignment struct {
+	st   *statement
+	name string
+	refs []string
+}
+
+// assemblyPass has the state of one pass over the statements
+type assemblyPass struct {
+	a        *Assembler
+	previous map[string]int64
+	symbols  map[string]int64
+	scope    string
+	pc       uint32
+	grew     bool
+	errors   []error
+	segments []Segment
+
+	// The assignments in source order, to detect the circular definitions
+	assignments map[string]*assignment
+	assigned    []*assignment
+	refs        *[]string // Collects the symbols looked up when not nil
+
+	// Widths selected by an explicit 65c24T8 prefix for the next instruction
+	prefixed bool
+	widths   iz6502.Widths
+}
+
+func (p *assemblyPass) errorf(st *statement, format string, args ...interface{}) {
+	p.errors = append(p.errors, &Error{st.file, st.line, fmt.Sprintf(format, args...)})
+}
+
+func (p *assemblyPass) lookup(name string) (int64, bool) {
+	if strings.HasPrefix(name, "@") {
+		name = p.scope + name
+	}
+	if p.refs != nil {
+		*p.refs = append(*p.refs, name)
+	}
+	if value, ok := p.symbols[name]; ok {
+		return value, true
+	}
+	value, ok := p.previous[name]
+	return value, ok
+}
+
+// define defines the symbol and returns its name, with the scope for locals
+func (p *assemblyPass) define(st *statement, name string, value int64) string {
+	if strings.HasPrefix(name, "@") {
+		name = p.scope + name
+	} else {
+		p.scope = name
+	}
+	if _, ok := p.symbols[name]; ok {
+		p.errorf(st, "symbol %v already defined", name)
+		return name
+	}
+	p.symbols[name] = value
+	return name
+}
+
+// circular returns an error for the first assignment depending on itself
+func (p *assemblyPass) circular() error {
+	for _, a := range p.assigned {
+		visited := make(map[string]bool)
+		pending := append([]string(nil), a.refs...)
+		for len(pending) > 0 {
+			name := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			if name == a.name {
+				return &Error{a.st.file, a.st.line, fmt.Sprintf("circular definition of %v", a.name)}
+			}
+			if other, ok := p.assignments[name]; ok && !visited[name] {
+				visited[name] = true
+				pending = append(pending, other.refs...)
+			}
+		}
+	}
+	return nil
+}
+
+// eval evaluates an expression. On the last pass unknown symbols are errors.
+func (p *assemblyPass) eval(st *statement, text string) (int64, bool) {
+	value, known, err := evaluate(text, p.pc, p.lookup)
+	if err != nil {
+		p.errorf(st, "%v", err)
+		return 0, false
+	}
+	if !known {
+		p.errorf(st, "undefined symbol in '%v'", text)
+	}
+	return value, known
+}
+
+func (p *assemblyPass) emit(data ...uint8) {
+	n := len(p.segments)
+	if n > 0 {
+		last := &p.segments[n-1]
+		if last.Address+uint32(len(last.Data)) == p.pc {
+			last.Data = append(last.Data, data...)
+			p.pc += uint32(len(data))
+			return
+		}
+	}
+	p.segments = append(p.segments, Segment{p.pc, append([]uint8(nil), data...)})
+	p.pc += uint32(len(data))
+}
+
+func (p *assemblyPass) run(statements []*statement) {
+	for _, st := range statements {
+		if st.name == "=" {
+			var refs []string
+			p.refs = &refs
+			value, _ := p.eval(st, st.operand)
+			p.refs = nil
+			name := p.define(st, st.label, value)
+			if _, ok := p.assignments[name]; !ok {
+				a := &assignment{st, name, refs}
+				p.assignments[name] = a
+				p.assigned = append(p.assigned, a)
+			}
+			continue
+		}
+		if st.label != "" {
+			p.define(st, st.label, int64(p.pc))
+		}
+		if st.name == "" {
+			continue
+		}
+
+		if strings.HasPrefix(st.name, ".") {
+			p.directive(st)
+		} else {
+			p.instruction(st)
+		}
+	}
+	if p.prefixed {
+		p.errorf(statements[len(statements)-1], "prefix without instruction")
+	}
+}
+
+func (p *assemblyPass) directive(st *statement) {
+	if p.prefixed {
+		p.errorf(st, "prefix followed by %v", st.name)
+		p.prefixed = false
+	}
+
+	operands := splitOperands(st.operand)
+	switch strings.ToLower(st.name) {
+	case ".org":
+		value, _ := p.eval(st, st.operand)
+		p.pc = uint32(value)
+	case ".byte", ".byt":
+		for _, operand := range operands {
+			if strings.HasPrefix(operand, "\"") {
+				text, err := parseString(operand)
+				if err != nil {
+					p.errorf(st, "%v", err)
+				}
+				p.emit([]uint8(text)...)
+				continue
+			}
+			value, _ := p.eval(st, operand)
+			p.checkRange(st, value, 1)
+			p.emit(uint8(value))
+		}
+	case ".word", ".addr":
+		for _, operand := range operands {
+			value, _ := p.eval(st, operand)
+			p.checkRange(st, value, 2)
+			p.emit(uint8(value), uint8(value>>8))
+		}
+	case ".long", ".faraddr":
+		for _, operand := range operands {
+			value, _ := p.eval(st, operand)
+			p.checkRange(st, value, 3)
+			p.emit(uint8(value), uint8(value>>8), uint8(value>>16))
+		}
+	case ".dword":
+		for _, operand := range operands {
+			value, _ := p.eval(st, operand)
+			p.emit(uint8(value), uint8(value>>8), uint8(value>>16), uint8(value>>24))
+		}
+	case ".res":
+		count, _ := p.eval(st, operands[0])
+		fill := int64(0)
+		if len(operands) > 1 {
+			fill, _ = p.eval(st, operands[1])
+		}
+		if count < 0 {
+			p.errorf(st, "negative size for .res")
+			return
+		}
+		p.emit(make([]uint8, count)...)
+		if fill != 0 {
+			last := p.segments[len(p.segments)-1].Data
+			for i := int64(len(last)) - count; i < int64(len(last)); i++ {
+				last[i] = uint8(fill)
+			}
+		}
+	case ".setcpu":
+		// The model is chosen on the assembler
+	default:
+		p.errorf(st, "unknown directive %v", st.name)
+	}
+}
+
+// checkRange verifies that a value fits in the bytes, signed or unsigned
+func (p *assemblyPass) checkRange(st *statement, value int64, bytes uint) {
+	if !fitsIn(value, bytes) {
+		p.errorf(st, "value $%x does not fit in %v bytes", value, bytes)
+	}
+}
+
+func fitsIn(value int64, bytes uint) bool {
+	limit := int64(1) << (8 * bytes)
+	return value >= -limit/2 && value < limit
+}
@@ -0,0 +1,217 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+func assertBytes(t *testing.T, p *Program, address uint32, expected []uint8) {
+	start, image := p.Image()
+	if start != address || !bytes.Equal(image, expected) {
+		t.Errorf("Assembled $%04x: % x, expected $%04x: % x", start, image, address, expected)
+	}
+}
+
+func TestAssembleNMOS(t *testing.T) {
+	p, err := Assemble(iz6502.ModelNMOS6502, `
+		.org $0400
+start:	LDX #0
+@loop:	LDA text,X      ; forward reference, absolute
+		BEQ done
+		STA $0200,X
+		INX
+		BNE @loop
+done:	JMP (vector)
+vector = $fffc
+text:	.byte "Hi", 0
+		LAX $12
+		NOP
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, p, 0x0400, []uint8{
+		0xA2, 0x00,
+		0xBD, 0x10, 0x04,
+		0xF0, 0x06,
+		0x9D, 0x00, 0x02,
+		0xE8,
+		0xD0, 0xF5,
+		0x6C, 0xFC, 0xFF,
+		'H', 'i', 0x00,
+		0xA7, 0x12,
+		0xEA,
+	})
+	if p.Symbols["start@loop"] != 0x0402 || p.Symbols["done"] != 0x040D {
+		t.Errorf("Wrong symbols %v", p.Symbols)
+	}
+}
+
+func TestAssembleAndRun(t *testing.T) {
+	p, err := Assemble(iz6502.ModelCMOS65c02, `
+		.org $0400
+		LDA #<(value * 2)
+		CLC
+		ADC #>value
+		STA result
+		STZ result+1
+		BRA *
+value = $0120
+result:	.res 2, $ff
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(iz6502.FlatMemory)
+	p.Load(m)
+	cpu := iz6502.NewCMOS65c02(m)
+	cpu.SetPC(0x0400)
+	for i := 0; i < 5; i++ {
+		cpu.ExecuteInstruction()
+	}
+	result := p.Symbols["result"]
+	if m.Peek(result) != 0x41 || m.Peek(result+1) != 0x00 {
+		t.Errorf("Wrong result %02x %02x", m.Peek(result), m.Peek(result+1))
+	}
+}
+
+func TestAssembleMacroAndInclude(t *testing.T) {
+	files := map[string]string{
+		"main.s": `
+		.include "macros.s"
+		.org $1000
+		store $55, $20
+		store $66, $21
+		.word $1234
+		.long $123456
+`,
+		"macros.s": `
+		.macro store value, address
+		LDA #value
+		STA address
+		.endmacro
+`,
+	}
+	a, err := New(iz6502.ModelNMOS6502)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ReadFile = func(filename string) ([]byte, error) {
+		source, ok := files[filename]
+		if !ok {
+			return nil, errors.New("file not found " + filename)
+		}
+		return []byte(source), nil
+	}
+	p, err := a.AssembleFile("main.s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, p, 0x1000, []uint8{
+		0xA9, 0x55, 0x85, 0x20,
+		0xA9, 0x66, 0x85, 0x21,
+		0x34, 0x12,
+		0x56, 0x34, 0x12,
+	})
+}
+
+func TestAssemble24T8(t *testing.T) {
+	p, err := Assemble(iz6502.ModelMythical65c24T8, `
+		.org $0400
+		LDA #$12          ; 8 bits
+		LDA #$1234        ; R16
+		LDX #$123456      ; R24
+		STA $123456       ; A24
+		W16
+		STA $012345       ; explicit W16
+		BNE far           ; long branch
+		RTS
+		.org $0600
+far:	NOP
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Segments[0].Address != 0x0400 {
+		t.Fatalf("Wrong segments %v", p.Segments)
+	}
+	expected := []uint8{
+		0xA9, 0x12,
+		0x1F, 0xA9, 0x34, 0x12,
+		0x2F, 0xA2, 0x56, 0x34, 0x12,
+		0x4F, 0x8D, 0x56, 0x34, 0x12,
+		0x5F, 0x8D, 0x45, 0x23, 0x01,
+		0x4F, 0xD0, 0xE7, 0x01,
+		0x60,
+	}
+	if !bytes.Equal(p.Segments[0].Data, expected) {
+		t.Errorf("Assembled % x, expected % x", p.Segments[0].Data, expected)
+	}
+
+	// The encoding must match the disassembler
+	m := new(iz6502.Flat256KMemory)
+	p.Load(m)
+	inst, _, _ := iz6502.Disassemble(iz6502.ModelMythical65c24T8, m, 0x0415, iz6502.Widths{})
+	if inst.String() != "A24 BNE $0600" {
+		t.Errorf("Long branch disassembled as %v", inst)
+	}
+}
+
+func TestListingRoundTrip(t *testing.T) {
+	source := `
+		.org $0800
+		LDA $12
+		LDA a:$0012
+		LDA ($12),Y
+		JMP ($1234,X)
+		BBS7 $10,*
+		RMB3 $20
+		INC A
+		BIT #$80
+`
+	p, err := Assemble(iz6502.ModelCMOS65c02, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(iz6502.FlatMemory)
+	p.Load(m)
+	start, image := p.Image()
+
+	var listing bytes.Buffer
+	err = iz6502.WriteListing(&listing, iz6502.ModelCMOS65c02, m, start, start+uint32(len(image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := Assemble(iz6502.ModelCMOS65c02, listing.String())
+	if err != nil {
+		t.Fatalf("%v in listing:\n%v", err, listing.String())
+	}
+	assertBytes(t, p2, start, image)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	cases := []struct {
+		source string
+		error  string
+	}{
+		{"LDA missing", "source:1: undefined symbol"},
+		{"FOO #1", "unknown instruction"},
+		{"LDA #$1234", "does not fit"},
+		{"BNE *+200", "branch out of range"},
+		{"a: NOP\na: NOP", "already defined"},
+		{".macro m\nNOP", "missing .endmacro"},
+		{"JMP $12,X", "addressing mode not available"},
+		{"NOP\nx = x+1", "source:2: circular definition of x"},
+		{"a = b+1\nb = c\nc = a*2\nLDA #a", "source:1: circular definition of a"},
+	}
+	for _, c := range cases {
+		_, err := Assemble(iz6502.ModelNMOS6502, c.source)
+		if err == nil || !strings.Contains(err.Error(), c.error) {
+			t.Errorf("Expected error '%v' for '%v', got %v", c.error, c.source, err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package asm
+
+import (
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+type operandSyntax int
+
+const (
+	syntaxNone operandSyntax = iota
+	syntaxAccumulator
+	syntaxImmediate
+	syntaxDirect
+	syntaxDirectX
+	syntaxDirectY
+	syntaxIndirect
+	syntaxIndirectX
+	syntaxIndirectY
+	syntaxDirectAndTarget
+	syntaxRegisterX
+	syntaxRegisterXY
+)
+
+type operand struct {
+	syntax operandSyntax
+	exprs  []string
+	force  string // "a" or "z" to force absolute or zero page
+}
+
+func parseOperand(text string) operand {
+	upper := strings.ToUpper(strings.Replace(text, " ", "", -1))
+	switch {
+	case text == "":
+		return operand{syntax: syntaxNone}
+	case upper == "A":
+		return operand{syntax: syntaxAccumulator}
+	case upper == "X":
+		return operand{syntax: syntaxRegisterX}
+	case upper == "XY":
+		return operand{syntax: syntaxRegisterXY}
+	case strings.HasPrefix(text, "#"):
+		return operand{syntax: syntaxImmediate, exprs: []string{strings.TrimSpace(text[1:])}}
+	}
+
+	if strings.HasPrefix(text, "(") && closingParen(text) == len(text)-1 {
+		inner := text[1 : len(text)-1]
+		parts := splitOperands(inner)
+		if len(parts) == 2 && strings.ToUpper(parts[1]) == "X" {
+			return operand{syntax: syntaxIndirectX, exprs: parts[:1]}
+		}
+		return operand{syntax: syntaxIndirect, exprs: []string{inner}}
+	}
+
+	parts := splitOperands(text)
+	if len(parts) == 2 && strings.HasPrefix(parts[0], "(") && closingParen(parts[0]) == len(parts[0])-1 &&
+		strings.ToUpper(parts[1]) == "Y" {
+		return operand{syntax: syntaxIndirectY, exprs: []string{parts[0][1 : len(parts[0])-1]}}
+	}
+
+	o := operand{syntax: syntaxDirect, exprs: parts[:1]}
+	if len(parts) == 2 {
+		switch strings.ToUpper(parts[1]) {
+		case "X":
+			o.syntax = syntaxDirectX
+		case "Y":
+			o.syntax = syntaxDirectY
+		default:
+			o.syntax = syntaxDirectAndTarget
+			o.exprs = parts
+		}
+	}
+	lower := strings.ToLower(o.exprs[0])
+	if strings.HasPrefix(lower, "a:") || strings.HasPrefix(lower, "z:") {
+		o.force = lower[:1]
+		o.exprs[0] = strings.TrimSpace(o.exprs[0][2:])
+	}
+	return o
+}
+
+// closingParen returns the position of the parenthesis closing the first one
+func closingParen(text string) int {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (p *assemblyPass) instruction(st *statement) {
+	mnemonic := strings.ToUpper(st.name)
+	modes, ok := p.a.opcodes[mnemonic]
+	if !ok {
+		p.errorf(st, "unknown instruction %v", st.name)
+		return
+	}
+
+	o := parseOperand(st.operand)
+	values := make([]int64, len(o.exprs))
+	known := true
+	for i, expr := range o.exprs {
+		var k bool
+		values[i], k = p.eval(st, expr)
+		known = known && k
+	}
+
+	var info iz6502.OpcodeInfo
+	found := false
+	choose := func(candidates ...iz6502.AddressMode) bool {
+		for _, mode := range candidates {
+			if info, found = modes[mode]; found {
+				return true
+			}
+		}
+		return false
+	}
+
+	zeroPage := o.force == "z" || (o.force == "" && known && !st.absolute && len(values) > 0 && values[0] >= 0 && values[0] < 0x100)
+	if o.syntax != syntaxNone && o.syntax != syntaxImmediate && o.force != "z" && !zeroPage {
+		// Once an operand is absolute it stays so on the next passes
+		st.absolute = true
+	}
+
+	switch o.syntax {
+	case syntaxNone:
+		choose(iz6502.ModeImplicit, iz6502.ModeImplicitX, iz6502.ModeImplicitY, iz6502.ModeAccumulator)
+	case syntaxAccumulator:
+		choose(iz6502.ModeAccumulator)
+	case syntaxRegisterX:
+		choose(iz6502.ModeX)
+	case syntaxRegisterXY:
+		choose(iz6502.ModeXY)
+	case syntaxImmediate:
+		choose(iz6502.ModeImmediate)
+	case syntaxDirect:
+		if !choose(iz6502.ModeRelative) {
+			if !zeroPage || !choose(iz6502.ModeZeroPage) {
+				choose(iz6502.ModeAbsolute)
+			}
+		}
+	case syntaxDirectX:
+		if !zeroPage || !choose(iz6502.ModeZeroPageX) {
+			choose(iz6502.ModeAbsoluteX, iz6502.ModeAbsoluteX65c02)
+		}
+	case syntaxDirectY:
+		if !zeroPage || !choose(iz6502.ModeZeroPageY) {
+			choose(iz6502.ModeAbsoluteY)
+		}
+	case syntaxIndirect:
+		if !zeroPage || !choose(iz6502.ModeIndirectZeroPage) {
+			choose(iz6502.ModeIndirect, iz6502.ModeIndirect65c02Fix)
+		}
+	case syntaxIndirectX:
+		if !zeroPage || !choose(iz6502.ModeIndexedIndirectX) {
+			choose(iz6502.ModeAbsoluteIndexedIndirectX)
+		}
+	case syntaxIndirectY:
+		choose(iz6502.ModeIndirectIndexedY)
+	case syntaxDirectAndTarget:
+		choose(iz6502.ModeZeroPageAndRelative)
+	}
+	if !found {
+		p.errorf(st, "addressing mode not available for %v %v", mnemonic, st.operand)
+		return
+	}
+
+	if info.IsPrefix {
+		if p.prefixed {
+			p.errorf(st, "two prefixes in a row")
+		}
+		p.emit(info.Opcode)
+		p.prefixed = true
+		p.widths = info.Widths()
+		return
+	}
+
+	widths := p.widths
+	if !p.prefixed {
+		widths = p.autoWidths(st, info, values)
+	} else {
+		p.prefixed = false
+	}
+	p.widths = iz6502.Widths{}
+
+	p.encode(st, info, widths, values)
+}
+
+// autoWidths selects the 65c24T8 widths needed for the operands and emits
+// the prefix if needed
+func (p *assemblyPass) autoWidths(st *statement, info iz6502.OpcodeInfo, values []int64) iz6502.Widths {
+	if len(p.a.prefixes) == 0 {
+		return iz6502.Widths{}
+	}
+
+	switch info.Mode {
+	case iz6502.ModeImmediate:
+		value := values[0]
+		if !fitsIn(value, 1) && st.rWidth < iz6502.R16 {
+			st.rWidth = iz6502.R16
+			p.grew = true
+		}
+		if !fitsIn(value, 2) && st.rWidth < iz6502.R24 {
+			st.rWidth = iz6502.R24
+			p.grew = true
+		}
+	case iz6502.ModeAbsolute, iz6502.ModeAbsoluteX, iz6502.ModeAbsoluteX65c02, iz6502.ModeAbsoluteY,
+		iz6502.ModeIndirect, iz6502.ModeIndirect65c02Fix, iz6502.ModeAbsoluteIndexedIndirectX:
+		if (values[0] < 0 || values[0] > 0xffff) && !st.ab24 {
+			st.ab24 = true
+			p.grew = true
+		}
+	case iz6502.ModeRelative:
+		offset := values[0] - int64(p.pc) - int64(info.Length(iz6502.Widths{}))
+		if (offset < -128 || offset > 127) && !st.ab24 {
+			st.ab24 = true
+			p.grew = true
+		}
+	}
+
+	widths := iz6502.Widths{Register: st.rWidth}
+	if st.ab24 {
+		widths.Address = iz6502.AB24
+	}
+	if widths != (iz6502.Widths{}) {
+		prefix, ok := p.a.prefixes[widths]
+		if !ok {
+			p.errorf(st, "no prefix available for the operand size")
+			return iz6502.Widths{}
+		}
+		p.emit(prefix.Opcode)
+	}
+	return widths
+}
+
+func (p *assemblyPass) encode(st *statement, info iz6502.OpcodeInfo, widths iz6502.Widths, values []int64) {
+	length := info.Length(widths)
+	bytes := []uint8{info.Opcode}
+	operandBytes := func(value int64, n int) {
+		if !fitsIn(value, uint(n)) {
+			p.errorf(st, "value $%x does not fit in %v bytes", value, n)
+		}
+		for i := 0; i < n; i++ {
+			bytes = append(bytes, uint8(value>>(8*uint(i))))
+		}
+	}
+	relative := func(target int64, n int) {
+		offset := target - int64(p.pc) - int64(length)
+		limit := int64(1) << (8*uint(n) - 1)
+		if offset < -limit || offset >= limit {
+			p.errorf(st, "branch out of range by %v bytes", offset)
+		}
+		for i := 0; i < n; i++ {
+			bytes = append(bytes, uint8(offset>>(8*uint(i))))
+		}
+	}
+
+	switch info.Mode {
+	case iz6502.ModeImplicit, iz6502.ModeImplicitX, iz6502.ModeImplicitY, iz6502.ModeAccumulator,
+		iz6502.ModeX, iz6502.ModeXY:
+		// No operand
+	case iz6502.ModeRelative:
+		relative(values[0], length-1)
+	case iz6502.ModeZeroPageAndRelative:
+		operandBytes(values[0], 1)
+		relative(values[1], 1)
+	default:
+		operandBytes(values[0], length-1)
+	}
+	p.emit(bytes...)
+}
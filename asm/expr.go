@@ -0,0 +1,232 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Expressions, with C like precedence:
+	|   ^   &   << >>   + -   * / %
+	unary: - ~ < (low byte) > (high byte) ^ (bank byte)
+	primary: $hex, %binary, decimal, 'c', symbol, @local, * (current address), (expr)
+*/
+
+// lookupFunc resolves a symbol. Returns false if it is not known yet.
+type lookupFunc func(name string) (int64, bool)
+
+type exprParser struct {
+	text    string
+	pos     int
+	pc      uint32
+	lookup  lookupFunc
+	unknown bool
+}
+
+// evaluate computes an expression. known is false if it references symbols
+// not defined yet, the value is then a placeholder.
+func evaluate(text string, pc uint32, lookup lookupFunc) (value int64, known bool, err error) {
+	p := &exprParser{text: text, pc: pc, lookup: lookup}
+	value, err = p.parseBinary(0)
+	if err != nil {
+		return 0, false, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.text) {
+		return 0, false, fmt.Errorf("unexpected '%v' in expression '%v'", p.text[p.pos:], text)
+	}
+	return value, !p.unknown, nil
+}
+
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) parseBinary(level int) (int64, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		p.skipSpaces()
+		operator := ""
+		for _, candidate := range binaryOperators[level] {
+			if strings.HasPrefix(p.text[p.pos:], candidate) {
+				operator = candidate
+				break
+			}
+		}
+		if operator == "" {
+			return left, nil
+		}
+		p.pos += len(operator)
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				if p.unknown {
+					// Placeholders may be zero before all the symbols are known
+					left = 0
+					continue
+				}
+				return 0, fmt.Errorf("division by zero in '%v'", p.text)
+			}
+			if operator == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (int64, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.text) {
+		return 0, fmt.Errorf("missing operand in expression '%v'", p.text)
+	}
+
+	operator := p.text[p.pos]
+	switch operator {
+	case '-', '~', '<', '>', '^', '+':
+		p.pos++
+		value, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case '-':
+			return -value, nil
+		case '~':
+			return ^value, nil
+		case '<':
+			return value & 0xff, nil
+		case '>':
+			return (value >> 8) & 0xff, nil
+		case '^':
+			return (value >> 16) & 0xff, nil
+		default:
+			return value, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (int64, error) {
+	c := p.text[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		value, err := p.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.text) || p.text[p.pos] != ')' {
+			return 0, fmt.Errorf("missing ')' in expression '%v'", p.text)
+		}
+		p.pos++
+		return value, nil
+	case c == '*':
+		p.pos++
+		return int64(p.pc), nil
+	case c == '\'':
+		if p.pos+2 >= len(p.text) || p.text[p.pos+2] != '\'' {
+			return 0, fmt.Errorf("bad character constant in '%v'", p.text)
+		}
+		value := int64(p.text[p.pos+1])
+		p.pos += 3
+		return value, nil
+	case c == '$':
+		return p.parseNumber(1, 16)
+	case c == '%':
+		return p.parseNumber(1, 2)
+	case c >= '0' && c <= '9':
+		return p.parseNumber(0, 10)
+	case isSymbolStart(c):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.text) && isSymbolChar(p.text[p.pos]) {
+			p.pos++
+		}
+		name := p.text[start:p.pos]
+		value, ok := p.lookup(name)
+		if !ok {
+			p.unknown = true
+			return 0, nil
+		}
+		return value, nil
+	}
+	return 0, fmt.Errorf("unexpected '%v' in expression '%v'", p.text[p.pos:], p.text)
+}
+
+func (p *exprParser) parseNumber(skip int, base int) (int64, error) {
+	p.pos += skip
+	start := p.pos
+	for p.pos < len(p.text) && isDigit(p.text[p.pos], base) {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("bad number in expression '%v'", p.text)
+	}
+	value, err := strconv.ParseInt(p.text[start:p.pos], base, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func isDigit(c byte, base int) bool {
+	switch base {
+	case 2:
+		return c == '0' || c == '1'
+	case 16:
+		return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+	default:
+		return c >= '0' && c <= '9'
+	}
+}
+
+func isSymbolStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '@'
+}
+
+func isSymbolChar(c byte) bool {
+	return isSymbolStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,50 @@
+package asm
+
+import (
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	symbols := map[string]int64{"base": 0x1234, "main@loop": 0x10}
+	lookup := func(name string) (int64, bool) {
+		value, ok := symbols[name]
+		return value, ok
+	}
+
+	cases := []struct {
+		text  string
+		value int64
+	}{
+		{"$ff", 0xff},
+		{"%1010", 10},
+		{"42", 42},
+		{"'A'", 0x41},
+		{"base+1", 0x1235},
+		{"<base", 0x34},
+		{">base", 0x12},
+		{"^$123456", 0x12},
+		{"2+3*4", 14},
+		{"(2+3)*4", 20},
+		{"1<<4|1", 17},
+		{"-1", -1},
+		{"~0&$ff", 0xff},
+		{"*+2", 0x0402},
+		{"*-*", 0},
+	}
+	for _, c := range cases {
+		value, known, err := evaluate(c.text, 0x0400, lookup)
+		if err != nil || !known || value != c.value {
+			t.Errorf("'%v' evaluated to %v (%v, %v) instead of %v", c.text, value, known, err, c.value)
+		}
+	}
+
+	_, known, err := evaluate("undefined+1", 0, lookup)
+	if known || err != nil {
+		t.Errorf("Undefined symbol evaluated as known, %v", err)
+	}
+
+	_, _, err = evaluate("1+", 0, lookup)
+	if err == nil {
+		t.Error("Missing error for incomplete expression")
+	}
+}
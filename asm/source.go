@@ -0,0 +1,294 @@
+package asm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const maxExpansionDepth = 16
+
+// statement is a source line after includes and macros are expanded
+type statement struct {
+	file    string
+	line    int
+	label   string // Label defined with a colon, or the symbol of an assignment
+	name    string // Mnemonic, directive or "=" for assignments
+	operand string
+
+	// Sizing decisions, they only grow between passes
+	absolute bool
+	ab24     bool
+	rWidth   uint8
+}
+
+type macro struct {
+	params []string
+	body   []sourceLine
+}
+
+type sourceLine struct {
+	file string
+	line int
+	text string
+}
+
+type expander struct {
+	a          *Assembler
+	macros     map[string]*macro
+	statements []*statement
+}
+
+func (e *expander) expandFile(filename string, depth int) error {
+	data, err := e.a.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return e.expandSource(filename, string(data), depth)
+}
+
+func (e *expander) expandSource(filename string, source string, depth int) error {
+	var lines []sourceLine
+	for i, text := range strings.Split(source, "\n") {
+		lines = append(lines, sourceLine{filename, i + 1, strings.TrimRight(text, "\r")})
+	}
+	return e.expandLines(lines, depth)
+}
+
+func (e *expander) expandLines(lines []sourceLine, depth int) error {
+	if depth > maxExpansionDepth {
+		return &Error{lines[0].file, lines[0].line, "too many nested includes or macros"}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		st, err := parseLine(l)
+		if err != nil {
+			return err
+		}
+		if st == nil {
+			continue
+		}
+
+		switch strings.ToLower(st.name) {
+		case ".macro":
+			args := splitOperands(st.operand)
+			if len(args) == 0 || args[0] == "" {
+				return &Error{l.file, l.line, "macro without name"}
+			}
+			// The name may be separated from the first parameter by a space
+			nameAndParam := strings.Fields(args[0])
+			m := &macro{params: append(nameAndParam[1:], args[1:]...)}
+			for i := range m.params {
+				m.params[i] = strings.TrimSpace(m.params[i])
+			}
+			closed := false
+			for i++; i < len(lines); i++ {
+				body, err := parseLine(lines[i])
+				if err != nil {
+					return err
+				}
+				if body != nil && body.label == "" && isEndMacro(body.name) {
+					closed = true
+					break
+				}
+				m.body = append(m.body, lines[i])
+			}
+			if !closed {
+				return &Error{l.file, l.line, "missing .endmacro"}
+			}
+			e.macros[nameAndParam[0]] = m
+
+		case ".include":
+			name, err := parseString(st.operand)
+			if err != nil {
+				return &Error{l.file, l.line, err.Error()}
+			}
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(filepath.Dir(l.file), name)
+			}
+			if st.label != "" {
+				e.statements = append(e.statements, &statement{file: l.file, line: l.line, label: st.label})
+			}
+			err = e.expandFile(name, depth+1)
+			if err != nil {
+				if _, ok := err.(*Error); ok {
+					return err
+				}
+				return &Error{l.file, l.line, err.Error()}
+			}
+
+		default:
+			m, ok := e.macros[st.name]
+			if !ok {
+				e.statements = append(e.statements, st)
+				continue
+			}
+
+			args := splitOperands(st.operand)
+			if st.operand == "" {
+				args = nil
+			}
+			if len(args) > len(m.params) {
+				return &Error{l.file, l.line, fmt.Sprintf("too many arguments for macro %v", st.name)}
+			}
+			if st.label != "" {
+				e.statements = append(e.statements, &statement{file: l.file, line: l.line, label: st.label})
+			}
+			var expanded []sourceLine
+			for _, body := range m.body {
+				text := body.text
+				for j, param := range m.params {
+					arg := ""
+					if j < len(args) {
+						arg = args[j]
+					}
+					text = replaceSymbol(text, param, arg)
+				}
+				// Errors are reported on the invocation line
+				expanded = append(expanded, sourceLine{l.file, l.line, text})
+			}
+			if len(expanded) > 0 {
+				err = e.expandLines(expanded, depth+1)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isEndMacro(name string) bool {
+	name = strings.ToLower(name)
+	return name == ".endmacro" || name == ".endm"
+}
+
+// parseLine splits a line in label, name and operand. Returns nil for empty lines.
+func parseLine(l sourceLine) (*statement, error) {
+	text := strings.TrimSpace(stripComment(l.text))
+	if text == "" {
+		return nil, nil
+	}
+	st := &statement{file: l.file, line: l.line}
+
+	// Assignment
+	if eq := strings.Index(text, "="); eq > 0 && isSymbol(strings.TrimSpace(text[:eq])) {
+		st.label = strings.TrimSpace(text[:eq])
+		st.name = "="
+		st.operand = strings.TrimSpace(text[eq+1:])
+		return st, nil
+	}
+
+	// Label
+	if colon := strings.Index(text, ":"); colon > 0 && isSymbol(text[:colon]) {
+		st.label = text[:colon]
+		text = strings.TrimSpace(text[colon+1:])
+	}
+
+	fields := strings.SplitN(text, " ", 2)
+	if tab := strings.IndexByte(fields[0], '\t'); tab >= 0 {
+		fields = []string{text[:tab], text[tab+1:]}
+	}
+	st.name = fields[0]
+	if len(fields) > 1 {
+		st.operand = strings.TrimSpace(fields[1])
+	}
+	if st.label == "" && st.name == "" {
+		return nil, nil
+	}
+	return st, nil
+}
+
+func stripComment(text string) string {
+	inString := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString != 0:
+			if c == inString {
+				inString = 0
+			}
+		case c == '"':
+			inString = c
+		case c == '\'' && i+2 < len(text) && text[i+2] == '\'':
+			i += 2
+		case c == ';':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func isSymbol(text string) bool {
+	if text == "" || !isSymbolStart(text[0]) {
+		return false
+	}
+	for i := 1; i < len(text); i++ {
+		if !isSymbolChar(text[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitOperands splits by the commas not in strings or parenthesis
+func splitOperands(text string) []string {
+	var parts []string
+	depth := 0
+	inString := false
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '\'' && i+2 < len(text) && text[i+2] == '\'':
+			i += 2
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(text[start:]))
+}
+
+// replaceSymbol replaces the whole word occurrences of a symbol out of strings
+func replaceSymbol(text string, symbol string, value string) string {
+	var b strings.Builder
+	inString := false
+	for i := 0; i < len(text); {
+		c := text[i]
+		if c == '"' {
+			inString = !inString
+		}
+		if !inString && isSymbolStart(c) && (i == 0 || !isSymbolChar(text[i-1])) {
+			j := i + 1
+			for j < len(text) && isSymbolChar(text[j]) {
+				j++
+			}
+			if text[i:j] == symbol {
+				b.WriteString(value)
+			} else {
+				b.WriteString(text[i:j])
+			}
+			i = j
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+func parseString(text string) (string, error) {
+	if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+		return "", fmt.Errorf("expected a string and got '%v'", text)
+	}
+	return text[1 : len(text)-1], nil
+}
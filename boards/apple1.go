@@ -0,0 +1,126 @@
+package boards
+
+/*
+Apple I.
+
+The 6821 PIA at $D010 has the keyboard on side A and the display on side B,
+without the IRQ lines connected. A key is latched on port A with bit 7 set
+and strobed on CA1. The display reads the character written on port B when the
+CB2 handshake goes low, then acknowledges it on CB1.
+
+The Woz Monitor is at $FF00. Integer BASIC, loaded from the cassette on the
+real machine, is in RAM at $E000.
+*/
+
+import (
+	"io"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/devices"
+)
+
+const (
+	appleIRAMSize    = 0x8000
+	appleIPIAAddress = 0xd010
+	appleIBASIC      = 0xe000
+	appleIMonitor    = 0xff00
+
+	appleIKBDCR  = 1 // Offset of the keyboard control register in the PIA
+	appleICR     = 0x0d
+	appleIRubout = '_' // The backspace of the Woz Monitor
+)
+
+// appleIPIA is the PIA with the keyboard delivering the keys as they are
+// polled, and the IRQ lines not connected
+type appleIPIA struct {
+	*devices.PIA
+	keys *keyboard
+	err  error
+}
+
+func (p *appleIPIA) Read(offset uint32) uint8 {
+	if offset&0x3 == appleIKBDCR && p.PIA.Read(offset)&devices.PIAControlIRQ1 == 0 && p.err == nil {
+		key, ok, err := p.keys.poll()
+		if err != nil {
+			p.err = err
+		} else if ok {
+			p.SetInputA(appleIKey(key) | 0x80)
+			p.SetCA1(false)
+			p.SetCA1(true)
+		}
+	}
+	return p.PIA.Read(offset)
+}
+
+func (p *appleIPIA) IRQ() bool {
+	return false
+}
+
+// appleIKey maps a key of the terminal to the keyboard of the Apple I
+func appleIKey(key uint8) uint8 {
+	switch key {
+	case '\n':
+		return appleICR
+	case 0x7f, 0x08:
+		return appleIRubout
+	}
+	return upper(key) & 0x7f
+}
+
+// NewAppleI returns an Apple I with 32Kb of RAM, the Woz Monitor ROM of 256
+// bytes and Integer BASIC, nil if not loaded. The keyboard reads the console
+// and the display writes to it.
+func NewAppleI(monitor []uint8, basic []uint8, console io.ReadWriter) (*Board, error) {
+	if err := checkROM("Woz Monitor", monitor, 0x100); err != nil {
+		return nil, err
+	}
+	b, err := newBoard("Apple I", iz6502.ModelNMOS6502)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.mapRAM(0x0000, appleIRAMSize); err != nil {
+		return nil, err
+	}
+	if basic != nil {
+		if err := checkROM("Integer BASIC", basic, 0x1000); err != nil {
+			return nil, err
+		}
+		if err := b.Memory.MapRAM(appleIBASIC, append([]uint8(nil), basic...)); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.Memory.MapROM(appleIMonitor, monitor); err != nil {
+		return nil, err
+	}
+
+	pia := &appleIPIA{PIA: devices.NewPIA(), keys: newKeyboard(console)}
+	var display uint8
+	pia.OutputB = func(pins uint8) {
+		display = pins
+	}
+	pia.OutputCB2 = func(high bool) {
+		if high {
+			return
+		}
+		if console != nil && pia.err == nil {
+			ch := display & 0x7f
+			if ch == appleICR {
+				_, pia.err = console.Write([]uint8{'\n'})
+			} else if ch >= 0x20 && ch < 0x60 {
+				_, pia.err = console.Write([]uint8{ch})
+			}
+		}
+		// The display is ready for the next character
+		pia.SetCB1(false)
+		pia.SetCB1(true)
+	}
+	// PB7 reads low, the display is never busy
+	pia.SetInputB(0x00)
+	if err := b.mount(appleIPIAAddress, 4, pia, pia.PIA.Reset); err != nil {
+		return nil, err
+	}
+	b.hooks = append(b.hooks, func(s *iz6502.State) error {
+		return pia.err
+	})
+	return b, nil
+}
@@ -0,0 +1,64 @@
+package boards
+
+/*
+Ben Eater breadboard computer.
+
+A W65C02 with 16Kb of RAM at $0000, the 6551 ACIA of the serial console at
+$5000, the 6522 VIA at $6000 and 32Kb of ROM at $8000. The chips are selected
+by the top address lines, so the registers repeat over their whole region.
+The interrupts of the ACIA and the VIA are wired to IRQ. The run ends when the
+ACIA is polled after the end of the console input.
+*/
+
+import (
+	"io"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/devices"
+)
+
+const (
+	benEaterRAMSize  = 0x4000
+	benEaterACIA     = 0x5000
+	benEaterACIASize = 0x1000
+	benEaterVIA      = 0x6000
+	benEaterVIASize  = 0x2000
+	benEaterROM      = 0x8000
+	benEaterClockHz  = 1000000
+)
+
+// NewBenEater returns a breadboard computer with the 32Kb ROM, the ACIA
+// connected to the console
+func NewBenEater(rom []uint8, console io.ReadWriter) (*Board, error) {
+	if err := checkROM("breadboard computer", rom, 0x8000); err != nil {
+		return nil, err
+	}
+	b, err := newBoard("Ben Eater breadboard computer", iz6502.ModelWDC65c02)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.mapRAM(0x0000, benEaterRAMSize); err != nil {
+		return nil, err
+	}
+	if err := b.Memory.MapROM(benEaterROM, rom); err != nil {
+		return nil, err
+	}
+
+	acia := devices.NewACIA(console)
+	acia.ClockHz = benEaterClockHz
+	if err := b.mount(benEaterACIA, benEaterACIASize, acia, acia.Reset); err != nil {
+		return nil, err
+	}
+	via := devices.NewVIA()
+	if err := b.mount(benEaterVIA, benEaterVIASize, via, via.Reset); err != nil {
+		return nil, err
+	}
+	b.hooks = append(b.hooks, func(s *iz6502.State) error {
+		err := acia.Err()
+		if err == io.EOF {
+			return consoleClosed{}
+		}
+		return err
+	})
+	return b, nil
+}
@@ -0,0 +1,266 @@
+/*
+Package boards has ready made machines: the processor, the memory map with its
+RAM and ROM, and the standard devices of some classic 6502 boards.
+
+The ROMs are not included, they are loaded from files supplied by the user. The
+console of the board is connected to an io.ReadWriter, the terminal on the
+command line.
+*/
+package boards
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/devices"
+	"github.com/lunarmobiscuit/iz6502/runner"
+)
+
+// Board is a machine ready to boot with Reset
+type Board struct {
+	Name   string
+	CPU    *iz6502.State
+	Memory *iz6502.MappedMemory
+	Bus    *devices.Bus
+
+	resets []func()
+	hooks  []func(s *iz6502.State) error
+}
+
+func newBoard(name string, model iz6502.Model) (*Board, error) {
+	var b Board
+	b.Name = name
+	mem, err := iz6502.NewMappedMemory(0x100)
+	if err != nil {
+		return nil, err
+	}
+	b.Memory = mem
+	b.Bus = devices.NewBus(mem)
+	if b.CPU, err = iz6502.NewState(model, b.Bus); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// mapRAM maps new RAM on the region
+func (b *Board) mapRAM(address uint32, size int) error {
+	return b.Memory.MapRAM(address, make([]uint8, size))
+}
+
+// mount mounts a device, resetting it with the board
+func (b *Board) mount(address uint32, size uint32, d devices.Device, reset func()) error {
+	if err := b.Bus.Mount(address, size, d); err != nil {
+		return err
+	}
+	if reset != nil {
+		b.resets = append(b.resets, reset)
+	}
+	return nil
+}
+
+// Reset resets the devices and boots the processor from the reset vector
+func (b *Board) Reset() {
+	for _, reset := range b.resets {
+		reset()
+	}
+	b.CPU.Reset()
+}
+
+// Hook clocks the devices and serves the console, to be called before each
+// instruction
+func (b *Board) Hook(s *iz6502.State) error {
+	b.Bus.Sync(s)
+	for _, hook := range b.hooks {
+		if err := hook(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run runs the board with the runner, with the hook of the board before the
+// one of the config
+func (b *Board) Run(config runner.Config) runner.Result {
+	next := config.Hook
+	config.Hook = func(s *iz6502.State) error {
+		if err := b.Hook(s); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(s)
+		}
+		return nil
+	}
+	return runner.Run(b.CPU, config)
+}
+
+// ROM is a ROM image expected by a profile
+type ROM struct {
+	Name     string
+	Address  uint32
+	Size     int
+	Optional bool
+}
+
+// Profile describes a board and builds it
+type Profile struct {
+	Name        string
+	Description string
+	ROMs        []ROM
+	// New builds the board with the ROMs, nil for the optional ones missing
+	New func(roms [][]uint8, console io.ReadWriter) (*Board, error)
+}
+
+// Profiles are the boards available
+var Profiles = []Profile{
+	{
+		Name:        "apple1",
+		Description: "Apple I, 6502 with 32Kb of RAM, the Woz Monitor and a 6821 PIA for the keyboard and display",
+		ROMs: []ROM{
+			{"Woz Monitor", appleIMonitor, 0x100, false},
+			{"Integer BASIC", appleIBASIC, 0x1000, true},
+		},
+		New: func(roms [][]uint8, console io.ReadWriter) (*Board, error) {
+			return NewAppleI(roms[0], roms[1], console)
+		},
+	},
+	{
+		Name:        "kim1",
+		Description: "KIM-1, 6502 with 1Kb of RAM and two 6530 with the monitor, the console on the TTY",
+		ROMs: []ROM{
+			{"6530-003 and 6530-002 ROMs", kimROM, 0x800, false},
+		},
+		New: func(roms [][]uint8, console io.ReadWriter) (*Board, error) {
+			return NewKIM1(roms[0], console)
+		},
+	},
+	{
+		Name:        "beneater",
+		Description: "Ben Eater breadboard computer, 65c02 with 16Kb of RAM, 32Kb of ROM, a 6522 VIA and a 6551 ACIA console",
+		ROMs: []ROM{
+			{"ROM", benEaterROM, 0x8000, false},
+		},
+		New: func(roms [][]uint8, console io.ReadWriter) (*Board, error) {
+			return NewBenEater(roms[0], console)
+		},
+	},
+}
+
+// FindProfile returns the profile with the name
+func FindProfile(name string) (*Profile, error) {
+	var names []string
+	for i := range Profiles {
+		if strings.EqualFold(Profiles[i].Name, name) {
+			return &Profiles[i], nil
+		}
+		names = append(names, Profiles[i].Name)
+	}
+	return nil, fmt.Errorf("unknown board '%v', use %v", name, strings.Join(names, ", "))
+}
+
+// LoadROMs reads the ROM files of the profile, in order
+func (p *Profile) LoadROMs(filenames []string) ([][]uint8, error) {
+	if len(filenames) > len(p.ROMs) {
+		return nil, fmt.Errorf("%v has %v ROMs, %v files given", p.Name, len(p.ROMs), len(filenames))
+	}
+	roms := make([][]uint8, len(p.ROMs))
+	for i, rom := range p.ROMs {
+		if i >= len(filenames) {
+			if !rom.Optional {
+				return nil, fmt.Errorf("missing %v for %v", rom.Name, p.Name)
+			}
+			continue
+		}
+		data, err := ioutil.ReadFile(filenames[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != rom.Size {
+			return nil, fmt.Errorf("%v is %v bytes, expected %v bytes for %v at $%04x",
+				filenames[i], len(data), rom.Size, rom.Name, rom.Address)
+		}
+		roms[i] = data
+	}
+	return roms, nil
+}
+
+// checkROM verifies the size of a ROM given to a board constructor
+func checkROM(name string, data []uint8, size int) error {
+	if len(data) != size {
+		return fmt.Errorf("the %v ROM is %v bytes, expected %v", name, len(data), size)
+	}
+	return nil
+}
+
+// consoleClosed ends the run when a key is needed after the end of the input
+// of the console
+type consoleClosed struct{}
+
+func (consoleClosed) Error() string {
+	return "console closed"
+}
+
+func (consoleClosed) ExitCode() int {
+	return runner.ExitSuccess
+}
+
+// keyboard reads the console on a goroutine, the bytes wait on the channel
+type keyboard struct {
+	keys chan uint8
+}
+
+// newKeyboard reads the console, nil for no console
+func newKeyboard(r io.Reader) *keyboard {
+	k := &keyboard{make(chan uint8, 4096)}
+	if r == nil {
+		close(k.keys)
+		return k
+	}
+	go func() {
+		buf := make([]uint8, 1)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				k.keys <- buf[0]
+			}
+			if err != nil {
+				close(k.keys)
+				return
+			}
+		}
+	}()
+	return k
+}
+
+// poll returns a key if one is waiting
+func (k *keyboard) poll() (uint8, bool, error) {
+	select {
+	case key, ok := <-k.keys:
+		if !ok {
+			return 0, false, consoleClosed{}
+		}
+		return key, true, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+// wait returns the next key
+func (k *keyboard) wait() (uint8, error) {
+	key, ok := <-k.keys
+	if !ok {
+		return 0, consoleClosed{}
+	}
+	return key, nil
+}
+
+// upper converts the lower case letters, the boards only have upper case
+func upper(key uint8) uint8 {
+	if key >= 'a' && key <= 'z' {
+		return key - 'a' + 'A'
+	}
+	return key
+}
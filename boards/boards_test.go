@@ -0,0 +1,227 @@
+package boards
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502/runner"
+)
+
+type testConsole struct {
+	io.Reader
+	io.Writer
+}
+
+func newTestConsole(input string) (*testConsole, *bytes.Buffer) {
+	out := new(bytes.Buffer)
+	return &testConsole{strings.NewReader(input), out}, out
+}
+
+// testROM returns a ROM with the code at offset and the reset vector to
+// address
+func testROM(size int, offset int, address uint16, code []uint8) []uint8 {
+	rom := make([]uint8, size)
+	copy(rom[offset:], code)
+	rom[size-4] = uint8(address)
+	rom[size-3] = uint8(address >> 8)
+	return rom
+}
+
+func TestAppleI(t *testing.T) {
+	// Echoes the keys as the Woz Monitor does
+	monitor := testROM(0x100, 0, 0xff00, []uint8{
+		0xa9, 0x7f, // LDA #$7f
+		0x8d, 0x12, 0xd0, // STA DSP, the DDR
+		0xa9, 0xa7, // LDA #$a7
+		0x8d, 0x11, 0xd0, // STA KBDCR
+		0x8d, 0x13, 0xd0, // STA DSPCR
+		0xad, 0x11, 0xd0, // loop: LDA KBDCR
+		0x10, 0xfb, // BPL loop
+		0xad, 0x10, 0xd0, // LDA KBD
+		0x2c, 0x12, 0xd0, // wait: BIT DSP
+		0x30, 0xfb, // BMI wait
+		0x8d, 0x12, 0xd0, // STA DSP
+		0x4c, 0x0d, 0xff, // JMP loop
+	})
+	console, out := newTestConsole("hi\n")
+	b, err := NewAppleI(monitor, nil, console)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	r := b.Run(runner.Config{Timeout: time.Second})
+	if r.Reason != runner.ReasonExit || r.Code != runner.ExitSuccess {
+		t.Errorf("Wrong end %v", &r)
+	}
+	if out.String() != "HI\n" {
+		t.Errorf("Wrong display '%v'", out.String())
+	}
+	if b.Memory.Peek(0xe000) != b.Memory.OpenBus {
+		t.Error("BASIC mapped without its ROM")
+	}
+}
+
+func TestKIM1(t *testing.T) {
+	// Prints each key, after its echo, plus one
+	rom := testROM(0x800, kimSTART-kimROM, kimDETCPS, []uint8{
+		0xa2, 0xff, // LDX #$ff
+		0x9a,             // TXS
+		0x20, 0x5a, 0x1e, // loop: JSR GETCH
+		0x18,       // CLC
+		0x69, 0x01, // ADC #1
+		0x20, 0xa0, 0x1e, // JSR OUTCH
+		0x4c, 0x52, 0x1c, // JMP loop
+	})
+	console, out := newTestConsole("AB")
+	b, err := NewKIM1(rom, console)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if b.Bus.Peek(0xfffc) != kimDETCPS&0xff || b.Bus.Peek(0x1740)&0x01 != 0 {
+		t.Fatal("Wrong vectors or TTY not selected")
+	}
+	r := b.Run(runner.Config{Timeout: time.Second})
+	if r.Reason != runner.ReasonExit {
+		t.Errorf("Wrong end %v", &r)
+	}
+	if out.String() != "ABBC" {
+		t.Errorf("Wrong teletype '%v'", out.String())
+	}
+	if b.Bus.Peek(kimCNTL30) == 0 {
+		t.Error("Baud rate not set")
+	}
+}
+
+func TestBenEater(t *testing.T) {
+	// Echoes the characters received until CR
+	rom := testROM(0x8000, 0, 0x8000, []uint8{
+		0xa9, 0x0b, // LDA #$0b
+		0x8d, 0x02, 0x50, // STA COMMAND, no interrupts
+		0xad, 0x01, 0x50, // loop: LDA STATUS
+		0x29, 0x08, // AND #$08
+		0xf0, 0xf9, // BEQ loop
+		0xad, 0x00, 0x50, // LDA DATA
+		0x8d, 0x00, 0x50, // STA DATA
+		0xc9, 0x0d, // CMP #$0d
+		0xd0, 0xef, // BNE loop
+		0x00, // BRK
+	})
+	console, out := newTestConsole("hello\r")
+	b, err := NewBenEater(rom, console)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	r := b.Run(runner.Config{StopOnBRK: true, Timeout: time.Second})
+	if r.Reason != runner.ReasonBRK || out.String() != "hello\r" {
+		t.Errorf("Wrong echo '%v', %v", out.String(), &r)
+	}
+
+	// The VIA registers repeat over its region
+	b.Bus.Poke(0x7ff3, 0x5a)
+	if b.Bus.Peek(0x6003) != 0x5a {
+		t.Error("VIA not mirrored")
+	}
+}
+
+func TestBenEaterConsoleClosed(t *testing.T) {
+	// Echoes the characters received forever
+	rom := testROM(0x8000, 0, 0x8000, []uint8{
+		0xa9, 0x0b, // LDA #$0b
+		0x8d, 0x02, 0x50, // STA COMMAND, no interrupts
+		0xad, 0x01, 0x50, // loop: LDA STATUS
+		0x29, 0x08, // AND #$08
+		0xf0, 0xf9, // BEQ loop
+		0xad, 0x00, 0x50, // LDA DATA
+		0x8d, 0x00, 0x50, // STA DATA
+		0x80, 0xf1, // BRA loop
+	})
+	console, out := newTestConsole("hi")
+	b, err := NewBenEater(rom, console)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	r := b.Run(runner.Config{Timeout: time.Second})
+	if r.Reason != runner.ReasonExit || r.Code != runner.ExitSuccess {
+		t.Errorf("Wrong end %v", &r)
+	}
+	if out.String() != "hi" {
+		t.Errorf("Wrong echo '%v'", out.String())
+	}
+}
+
+func TestBenEaterInterrupts(t *testing.T) {
+	// Counts the interrupts of the VIA timer while waiting
+	rom := testROM(0x8000, 0, 0x8000, []uint8{
+		0xa9, 0x40, // LDA #$40
+		0x8d, 0x0b, 0x60, // STA ACR, T1 free run
+		0xa9, 0xc0, // LDA #$c0
+		0x8d, 0x0e, 0x60, // STA IER, T1 interrupt
+		0xa9, 0x00, // LDA #$00
+		0x8d, 0x04, 0x60, // STA T1CL
+		0xa9, 0x01, // LDA #$01
+		0x8d, 0x05, 0x60, // STA T1CH, every $102 cycles
+		0x58,       // CLI
+		0xcb,       // loop: WAI
+		0x80, 0xfd, // BRA loop
+		0xe6, 0x10, // irq: INC $10
+		0xad, 0x04, 0x60, // LDA T1CL
+		0x40, // RTI
+	})
+	rom[0x7ffe] = 0x18
+	rom[0x7fff] = 0x80
+	b, err := NewBenEater(rom, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	r := b.Run(runner.Config{MaxCycles: 0x1000})
+	if r.Reason != runner.ReasonCycles {
+		t.Fatalf("Wrong end %v", &r)
+	}
+	if count := b.Bus.Peek(0x10); count < 14 || count > 16 {
+		t.Errorf("%v interrupts in $1000 cycles", count)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	p, err := FindProfile("Apple1")
+	if err != nil || p.Name != "apple1" {
+		t.Fatal("Profile not found")
+	}
+	if _, err := FindProfile("pet"); err == nil {
+		t.Error("Unknown profile found")
+	}
+
+	dir, err := ioutil.TempDir("", "boards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	monitor := filepath.Join(dir, "wozmon.bin")
+	short := filepath.Join(dir, "short.bin")
+	ioutil.WriteFile(monitor, make([]uint8, 0x100), 0644)
+	ioutil.WriteFile(short, make([]uint8, 0x80), 0644)
+
+	if _, err := p.LoadROMs(nil); err == nil {
+		t.Error("Missing ROM accepted")
+	}
+	if _, err := p.LoadROMs([]string{short}); err == nil {
+		t.Error("ROM of the wrong size accepted")
+	}
+	roms, err := p.LoadROMs([]string{monitor})
+	if err != nil || len(roms) != 2 || roms[1] != nil {
+		t.Fatalf("Optional ROM not skipped: %v", err)
+	}
+	if _, err := p.New(roms, nil); err != nil {
+		t.Error(err)
+	}
+}
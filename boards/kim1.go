@@ -0,0 +1,152 @@
+package boards
+
+/*
+MOS KIM-1.
+
+The two 6530 have their I/O and timer at $1700 (6530-003) and $1740
+(6530-002), 64 bytes of RAM each at $1780 and $17C0, and 1Kb of ROM each at
+$1800 and $1C00. Only 13 address lines are decoded, the vectors are read from
+the top of the ROM of the 6530-002, mirrored at $FF00.
+
+The console is the teletype, selected with PA0 of the 6530-002 low. The bit
+banged teletype routines of the monitor are trapped: GETCH reads a key from the
+console and OUTCH writes A to it. The measure of the baud rate after reset,
+waiting for a rubout, is skipped.
+*/
+
+import (
+	"io"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/devices"
+)
+
+const (
+	kimRAMSize  = 0x400
+	kimRIOT003  = 0x1700
+	kimRIOT002  = 0x1740
+	kimRAM003   = 0x1780
+	kimRAM002   = 0x17c0
+	kimRIOTSize = 0x40
+	kimROM      = 0x1800
+	kimVectors  = 0xff00
+
+	// Entry points of the monitor
+	kimDETCPS = 0x1c2a
+	kimSTART  = 0x1c4f
+	kimGETCH  = 0x1e5a
+	kimOUTCH  = 0x1ea0
+	kimCNTL30 = 0x17f2
+	kimCNTH30 = 0x17f3
+
+	kimCR       = 0x0d
+	kimTTYInput = 0xfe // PA0 low
+)
+
+// NewKIM1 returns a KIM-1 with the 2Kb of ROM of the 6530-003 followed by the
+// 6530-002. The teletype reads and writes the console.
+func NewKIM1(rom []uint8, console io.ReadWriter) (*Board, error) {
+	if err := checkROM("KIM-1", rom, 0x800); err != nil {
+		return nil, err
+	}
+	b, err := newBoard("KIM-1", iz6502.ModelNMOS6502)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.mapRAM(0x0000, kimRAMSize); err != nil {
+		return nil, err
+	}
+	if err := b.Memory.MapROM(kimROM, rom); err != nil {
+		return nil, err
+	}
+	if err := b.Memory.MapROM(kimVectors, rom[0x700:]); err != nil {
+		return nil, err
+	}
+
+	riot003 := devices.NewRIOT()
+	riot003.Layout6530 = true
+	riot002 := devices.NewRIOT()
+	riot002.Layout6530 = true
+	riot002.SetInputA(kimTTYInput)
+	mounts := []struct {
+		address uint32
+		d       devices.Device
+		reset   func()
+	}{
+		{kimRIOT003, riot003, riot003.Reset},
+		{kimRIOT002, riot002, riot002.Reset},
+		{kimRAM003, riot003.RAM(), nil},
+		{kimRAM002, riot002.RAM(), nil},
+	}
+	for _, m := range mounts {
+		if err := b.mount(m.address, kimRIOTSize, m.d, m.reset); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := newKeyboard(console)
+	write := func(ch uint8) error {
+		if console == nil {
+			return nil
+		}
+		_, err := console.Write([]uint8{ch})
+		return err
+	}
+	b.hooks = append(b.hooks, func(s *iz6502.State) error {
+		switch s.GetPC() {
+		case kimDETCPS:
+			// Any bit time will do, the teletype routines are trapped
+			mem := s.GetMemory()
+			mem.Poke(kimCNTL30, 0x01)
+			mem.Poke(kimCNTH30, 0x00)
+			s.SetPC(kimSTART)
+		case kimGETCH:
+			key, err := keys.wait()
+			if err != nil {
+				return err
+			}
+			if key == '\n' {
+				key = kimCR
+			}
+			key = upper(key) & 0x7f
+			// The teletype echoes the bits received
+			if err := kimOutput(write, key); err != nil {
+				return err
+			}
+			_, x, _, p := s.GetAXYP()
+			s.SetAXYP(uint32(key), x, 0xff, p)
+			kimReturn(s)
+		case kimOUTCH:
+			a, _, _, _ := s.GetAXYP()
+			if err := kimOutput(write, uint8(a)&0x7f); err != nil {
+				return err
+			}
+			kimReturn(s)
+		}
+		return nil
+	})
+	return b, nil
+}
+
+// kimOutput writes a character of the teletype, with CR as a new line
+func kimOutput(write func(ch uint8) error, ch uint8) error {
+	switch {
+	case ch == kimCR:
+		return write('\n')
+	case ch == '\n':
+		// The new line already done with CR
+		return nil
+	}
+	return write(ch)
+}
+
+// kimReturn returns from a trapped subroutine as RTS does
+func kimReturn(s *iz6502.State) {
+	r := s.GetRegisters()
+	mem := s.GetMemory()
+	low := uint32(mem.Peek(0x100 + (r.SP+1)&0xff))
+	high := uint32(mem.Peek(0x100 + (r.SP+2)&0xff))
+	r.SP = (r.SP + 2) & 0xff
+	r.PC = (high<<8 | low) + 1
+	s.SetRegisters(r)
+}
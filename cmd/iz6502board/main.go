@@ -0,0 +1,156 @@
+// Command iz6502board boots a classic 6502 board with its ROMs, with the
+// console of the board on the terminal:
+//
+//	iz6502board -board apple1 wozmon.bin [basic.bin]
+//	iz6502board -board kim1 kim.bin
+//	iz6502board -board beneater rom.bin
+//
+// The ROMs are not included, -list shows the files expected by each board.
+// The ROM of the KIM-1 is the 6530-003 followed by the 6530-002.
+//
+// The terminal sends the keys as they are typed, Ctrl-C ends the run. The
+// loops on themselves do not stop the board, they wait for an interrupt.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/boards"
+	"github.com/lunarmobiscuit/iz6502/runner"
+)
+
+// console is the standard input and output as a stream
+type console struct {
+	io.Reader
+	io.Writer
+}
+
+// throttleCycles is how often the speed is checked
+const throttleCycles = 10000
+
+// throttle returns a hook sleeping to run at the clock given in MHz
+func throttle(mhz float64) func(s *iz6502.State) error {
+	start := time.Now()
+	var next uint64
+	return func(s *iz6502.State) error {
+		cycles := s.GetCycles()
+		if cycles < next {
+			return nil
+		}
+		next = cycles + throttleCycles
+		ahead := time.Duration(float64(cycles)/mhz)*time.Microsecond - time.Since(start)
+		if ahead > 0 {
+			time.Sleep(ahead)
+		}
+		return nil
+	}
+}
+
+// rawTerminal disables the line editing and the echo of the terminal, and
+// returns the function restoring it. Does nothing when stdin is not a
+// terminal.
+func rawTerminal() func() {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return func() {}
+	}
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		return cmd.Output()
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return func() {}
+	}
+	if _, err := stty("-icanon", "-echo", "-icrnl"); err != nil {
+		return func() {}
+	}
+	return func() {
+		stty(strings.TrimSpace(string(saved)))
+	}
+}
+
+func listProfiles(w io.Writer) {
+	for _, p := range boards.Profiles {
+		fmt.Fprintf(w, "%v\t%v\n", p.Name, p.Description)
+		for _, rom := range p.ROMs {
+			optional := ""
+			if rom.Optional {
+				optional = ", optional"
+			}
+			fmt.Fprintf(w, "\t%v: %v bytes at $%04x%v\n", rom.Name, rom.Size, rom.Address, optional)
+		}
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(runner.ExitUsage)
+}
+
+func main() {
+	boardName := flag.String("board", "", "board to boot: apple1, kim1 or beneater")
+	list := flag.Bool("list", false, "list the boards and their ROMs")
+	cycles := flag.Uint64("cycles", 0, "cycle budget, 0 for no limit")
+	mhz := flag.Float64("mhz", 1, "clock in MHz, 0 to run as fast as possible")
+	trace := flag.Bool("trace", false, "trace the instructions to stderr")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v -board name [flags] rom...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *list {
+		listProfiles(os.Stdout)
+		return
+	}
+	if *boardName == "" {
+		flag.Usage()
+		os.Exit(runner.ExitUsage)
+	}
+
+	profile, err := boards.FindProfile(*boardName)
+	if err != nil {
+		fail("%v", err)
+	}
+	roms, err := profile.LoadROMs(flag.Args())
+	if err != nil {
+		fail("%v", err)
+	}
+	b, err := profile.New(roms, console{os.Stdin, os.Stdout})
+	if err != nil {
+		fail("%v", err)
+	}
+	if *trace {
+		b.CPU.SetTracer(iz6502.NewTextTracer(os.Stderr))
+	}
+
+	restore := rawTerminal()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		restore()
+		fmt.Fprintln(os.Stderr)
+		os.Exit(runner.ExitSuccess)
+	}()
+
+	config := runner.Config{MaxCycles: *cycles, NoTrap: true}
+	if *mhz > 0 {
+		config.Hook = throttle(*mhz)
+	}
+	b.Reset()
+	result := b.Run(config)
+	restore()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, &result)
+	os.Exit(result.ExitCode(&config))
+}
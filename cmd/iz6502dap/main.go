@@ -0,0 +1,33 @@
+// Command iz6502dap is a Debug Adapter Protocol server for the emulator. It
+// serves a session on the standard streams, or sessions on a local socket.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502/dap"
+)
+
+func main() {
+	listen := flag.String("listen", "", "serve on a socket, like tcp:localhost:4711 or unix:/tmp/iz6502.sock, instead of stdio")
+	flag.Parse()
+
+	var err error
+	if *listen == "" {
+		err = dap.NewServer(os.Stdin, os.Stdout).Serve()
+	} else {
+		parts := strings.SplitN(*listen, ":", 2)
+		if len(parts) != 2 || (parts[0] != "tcp" && parts[0] != "unix") {
+			fmt.Fprintf(os.Stderr, "Invalid address '%v', use tcp:host:port or unix:path\n", *listen)
+			os.Exit(2)
+		}
+		err = dap.ListenAndServe(parts[0], parts[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,49 @@
+// Command iz6502mon is an interactive machine language monitor for the
+// emulator. Type help for the commands and Ctrl-C to stop a running program.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/monitor"
+)
+
+func main() {
+	modelName := flag.String("model", "65c02", "cpu model: nmos, cmos, wdc or 24t8")
+	flag.Parse()
+
+	model, err := iz6502.ParseModel(*modelName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	m, err := monitor.New(model, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Ctrl-C stops the program running, not the monitor
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			m.Interrupt()
+		}
+	}()
+
+	// The commands are the arguments, then the standard input
+	for _, command := range flag.Args() {
+		if err := m.Execute(command); err != nil {
+			fmt.Fprintf(os.Stderr, "?%v\n", err)
+		}
+	}
+	if err := m.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,376 @@
+// Command iz6502run runs a program without supervision, like a test ROM in
+// CI, and exits with a code telling how it ended:
+//
+//	0  a trap (jump or branch to itself) or BRK, at the -success address if given
+//	1  a trap or BRK elsewhere, or the processor halted
+//	2  invalid arguments or images
+//	3  the cycle budget used up
+//	4  the timeout expired
+//	5  an illegal opcode
+//
+// A write to the -exit-port address exits with the value written.
+//
+// The images are raw binaries, PRG or Intel HEX files, chosen by extension or
+// with -format. Raw binaries load at the address after @, like rom.bin@e000.
+//
+// With -sim65, the single image is a program built for sim65, the simulator
+// of cc65, followed by its arguments. It exits with the code passed to exit,
+// and opens its files in the -sandbox directory.
+//
+// With -acia, a 6551 ACIA at the address is the serial console of the program,
+// on the standard input and output or on a TCP connection with -acia-listen.
+// The run exits with 0 when the program polls it after the end of the input or
+// the client disconnecting.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/devices"
+	"github.com/lunarmobiscuit/iz6502/loader"
+	"github.com/lunarmobiscuit/iz6502/runner"
+	"github.com/lunarmobiscuit/iz6502/sim65"
+)
+
+// parseAddress parses hex with an optional $ or 0x prefix
+func parseAddress(text string) (uint32, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(text), "$"), "0x")
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address '%v'", text)
+	}
+	return uint32(v), nil
+}
+
+// addressFlag is an optional address
+type addressFlag struct {
+	value uint32
+	set   bool
+}
+
+func (f *addressFlag) String() string {
+	if !f.set {
+		return ""
+	}
+	return fmt.Sprintf("$%04x", f.value)
+}
+
+func (f *addressFlag) Set(text string) error {
+	v, err := parseAddress(text)
+	f.value, f.set = v, err == nil
+	return err
+}
+
+type memoryRange struct {
+	start, end uint32
+}
+
+// rangesFlag is a list of START:END memory ranges, inclusive
+type rangesFlag []memoryRange
+
+func (f *rangesFlag) String() string {
+	var parts []string
+	for _, r := range *f {
+		parts = append(parts, fmt.Sprintf("$%04x:$%04x", r.start, r.end))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *rangesFlag) Set(text string) error {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid range '%v', use START:END", text)
+	}
+	start, err := parseAddress(parts[0])
+	if err != nil {
+		return err
+	}
+	end, err := parseAddress(parts[1])
+	if err != nil {
+		return err
+	}
+	if end < start {
+		return fmt.Errorf("invalid range '%v', end before start", text)
+	}
+	if end >= iz6502.MappedAddressSpace {
+		return fmt.Errorf("invalid range '%v', end past the 24 bits address space", text)
+	}
+	*f = append(*f, memoryRange{start, end})
+	return nil
+}
+
+type jsonRegisters struct {
+	A  uint32 `json:"a"`
+	X  uint32 `json:"x"`
+	Y  uint32 `json:"y"`
+	SP uint32 `json:"sp"`
+	PC uint32 `json:"pc"`
+	P  uint8  `json:"p"`
+}
+
+type jsonMemory struct {
+	Start uint32 `json:"start"`
+	Data  string `json:"data"`
+}
+
+type jsonReport struct {
+	Reason    string        `json:"reason"`
+	ExitCode  int           `json:"exitCode"`
+	PC        uint32        `json:"pc"`
+	Cycles    uint64        `json:"cycles"`
+	Value     *uint8        `json:"value,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Registers jsonRegisters `json:"registers"`
+	Memory    []jsonMemory  `json:"memory,omitempty"`
+}
+
+func writeReport(w io.Writer, s *iz6502.State, r *runner.Result, code int, ranges rangesFlag) error {
+	regs := s.GetRegisters()
+	report := jsonReport{
+		Reason:    r.Reason.String(),
+		ExitCode:  code,
+		PC:        r.PC,
+		Cycles:    r.Cycles,
+		Registers: jsonRegisters{regs.A, regs.X, regs.Y, regs.SP, regs.PC, regs.P},
+	}
+	if r.Reason == runner.ReasonExitPort {
+		report.Value = &r.Value
+	}
+	if r.Err != nil {
+		report.Error = r.Err.Error()
+	}
+	mem := s.GetMemory()
+	for _, mr := range ranges {
+		data := make([]uint8, 0, mr.end-mr.start+1)
+		for a := mr.start; a <= mr.end; a++ {
+			data = append(data, mem.Peek(a))
+		}
+		report.Memory = append(report.Memory, jsonMemory{mr.start, hex.EncodeToString(data)})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// console is the standard input and output as a stream
+type console struct {
+	io.Reader
+	io.Writer
+}
+
+// serialStream returns the console, or the first connection to the address
+func serialStream(address string) (io.ReadWriter, error) {
+	if address == "" {
+		return console{os.Stdin, os.Stdout}, nil
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "Waiting for the serial connection on %v\n", l.Addr())
+	return l.Accept()
+}
+
+// serialClosed ends the run when the program polls the ACIA after the end of
+// its input
+type serialClosed struct{}
+
+func (serialClosed) Error() string {
+	return "serial input closed"
+}
+
+func (serialClosed) ExitCode() int {
+	return runner.ExitSuccess
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(runner.ExitUsage)
+}
+
+func main() {
+	var pc, success, exitPort, acia addressFlag
+	var dump rangesFlag
+	modelName := flag.String("model", "65c02", "cpu model: nmos, cmos, wdc or 24t8")
+	formatName := flag.String("format", "auto", "image format: auto, raw, prg or hex")
+	flag.Var(&pc, "pc", "start address, instead of the entry of the HEX file or the reset vector")
+	flag.Var(&success, "success", "address of the trap or BRK meaning success")
+	flag.Var(&exitPort, "exit-port", "address that ends the run when written, exiting with the value")
+	brk := flag.Bool("brk", true, "stop on BRK")
+	cycles := flag.Uint64("cycles", 0, "cycle budget, 0 for no limit")
+	timeout := flag.Duration("timeout", 0, "wall clock timeout, like 10s, 0 for no limit")
+	jsonFile := flag.String("json", "", "write the final registers as JSON to a file, - for stdout")
+	flag.Var(&dump, "dump", "memory range START:END to add to the JSON, can be repeated")
+	quiet := flag.Bool("q", false, "do not print the result")
+	sim65Program := flag.Bool("sim65", false, "run a sim65 program with its arguments, with the host calls of cc65")
+	sandbox := flag.String("sandbox", "", "directory for the files of the sim65 program, none if empty")
+	flag.Var(&acia, "acia", "address of a 6551 ACIA with the serial console")
+	aciaListen := flag.String("acia-listen", "", "TCP address like localhost:6551 to serve the ACIA on, instead of stdin and stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] image[@address]...\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %v -sim65 [flags] program [args]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(runner.ExitUsage)
+	}
+
+	var header *sim65.Header
+	var program *loader.Image
+	if *sim65Program {
+		var err error
+		if header, program, err = sim65.LoadProgram(flag.Arg(0)); err != nil {
+			fail("%v", err)
+		}
+		// The model of the header, unless given
+		modelSet := false
+		flag.Visit(func(f *flag.Flag) {
+			modelSet = modelSet || f.Name == "model"
+		})
+		if !modelSet {
+			model, err := header.Model()
+			if err != nil {
+				fail("%v", err)
+			}
+			*modelName = model.String()
+		}
+	}
+
+	model, err := iz6502.ParseModel(*modelName)
+	if err != nil {
+		fail("%v", err)
+	}
+	format, err := loader.ParseFormat(*formatName)
+	if err != nil {
+		fail("%v", err)
+	}
+	var mem iz6502.Memory = new(iz6502.FlatMemory)
+	if model == iz6502.ModelMythical65c24T8 {
+		mem = new(iz6502.Flat256KMemory)
+	}
+	var bus *devices.Bus
+	var serial *devices.ACIA
+	cpuMem := mem
+	if acia.set {
+		stream, err := serialStream(*aciaListen)
+		if err != nil {
+			fail("%v", err)
+		}
+		bus = devices.NewBus(mem)
+		serial = devices.NewACIA(stream)
+		if err := bus.Mount(acia.value, 4, serial); err != nil {
+			fail("%v", err)
+		}
+		cpuMem = bus
+	}
+	s, err := iz6502.NewState(model, cpuMem)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	var entry *uint32
+	var hook func(s *iz6502.State) error
+	if program != nil {
+		p := sim65.New(header.SP, *sandbox, flag.Args()...)
+		p.Wide = model == iz6502.ModelMythical65c24T8
+		p.Install(mem)
+		hook = p.Hook
+		program.Poke(mem)
+		entry = &program.Entry
+	}
+	for _, arg := range flag.Args() {
+		if program != nil {
+			break
+		}
+		filename, address := arg, uint32(0)
+		if at := strings.LastIndexByte(arg, '@'); at >= 0 {
+			filename = arg[:at]
+			if address, err = parseAddress(arg[at+1:]); err != nil {
+				fail("%v", err)
+			}
+		}
+		img, err := loader.LoadFile(filename, format, address)
+		if err != nil {
+			fail("%v", err)
+		}
+		img.Poke(mem)
+		if img.HasEntry {
+			entry = &img.Entry
+		}
+	}
+
+	if bus != nil {
+		next := hook
+		hook = func(s *iz6502.State) error {
+			bus.Sync(s)
+			if err := serial.Err(); err == io.EOF {
+				return serialClosed{}
+			} else if err != nil {
+				return err
+			}
+			if next != nil {
+				return next(s)
+			}
+			return nil
+		}
+	}
+
+	switch {
+	case pc.set:
+		s.SetPC(pc.value)
+	case entry != nil:
+		s.SetPC(*entry)
+	default:
+		s.Reset()
+	}
+
+	config := runner.Config{
+		MaxCycles:   *cycles,
+		Timeout:     *timeout,
+		ExitPort:    exitPort.value,
+		HasExitPort: exitPort.set,
+		StopOnBRK:   *brk,
+		Success:     success.value,
+		HasSuccess:  success.set,
+		Hook:        hook,
+	}
+	result := runner.Run(s, config)
+	if serial != nil {
+		serial.Close()
+	}
+	code := result.ExitCode(&config)
+	if !*quiet {
+		fmt.Fprintln(os.Stderr, &result)
+	}
+
+	if *jsonFile != "" {
+		w := os.Stdout
+		if *jsonFile != "-" {
+			if w, err = os.Create(*jsonFile); err != nil {
+				fail("%v", err)
+			}
+		}
+		err = writeReport(w, s, &result, code, dump)
+		if w != os.Stdout {
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fail("%v", err)
+		}
+	}
+	os.Exit(code)
+}
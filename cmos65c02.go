@@ -12,7 +12,8 @@ For the diffrences with NMOS6502 see:
 // NewCMOS65c02 returns an initialized 65c02
 func NewCMOS65c02(m Memory) *State {
 	var s State
-	s.mem = m
+	s.SetMemory(m)
+	s.model = ModelCMOS65c02
 
 	var opcodes [256]opcode
 	for i := 0; i < 256; i++ {
@@ -26,6 +27,21 @@ func NewCMOS65c02(m Memory) *State {
 	return &s
 }
 
+// NewWDC65c02 returns an initialized WDC 65c02, a 65c02 with the WAI and STP instructions
+func NewWDC65c02(m Memory) *State {
+	s := NewCMOS65c02(m)
+	s.model = ModelWDC65c02
+
+	opcodes := *s.opcodes
+	for i := 0; i < 256; i++ {
+		if opcodesWDC65c02Delta[i].cycles != 0 {
+			opcodes[i] = opcodesWDC65c02Delta[i]
+		}
+	}
+	s.opcodes = &opcodes
+	return s
+}
+
 func add65c02NOPs(opcodes *[256]opcode) {
 	nop11 := opcode{"NOP", 1, 1, false, modeImplicit, opNOP}
 	nop22 := opcode{"NOP", 2, 2, false, modeImmediate, opNOP}
@@ -170,5 +186,10 @@ var opcodes65c02Delta = [256]opcode{
 	0xe7: {"SMB6", 2, 5, false, modeZeroPage, buildOpSetBit(6, true)},
 	0xf7: {"SMB7", 2, 5, false, modeZeroPage, buildOpSetBit(7, true)},
 
-	// Maybe additional Rockwell: STP, WAI
+	// Additional WDC: STP, WAI. See opcodesWDC65c02Delta
+}
+
+var opcodesWDC65c02Delta = [256]opcode{
+	0xcb: {"WAI", 1, 3, false, modeImplicit, opWAI},
+	0xdb: {"STP", 1, 3, false, modeImplicit, opSTP},
 }
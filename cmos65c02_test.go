@@ -30,3 +30,60 @@ func TestCMOS65c02(t *testing.T) {
 	m.loadBinary("testdata/65C02_extended_opcodes_test.bin")
 	executeSuite(t, s, 0x202, 240, false, 255)
 }
+
+func TestWDC65c02asNMOS(t *testing.T) {
+	m := new(FlatMemory)
+	s := NewWDC65c02(m)
+
+	m.loadBinary("testdata/6502_functional_test.bin")
+	executeSuite(t, s, 0x200, 240, false, 255)
+}
+
+func TestWDC65c02WAI(t *testing.T) {
+	m := new(FlatMemory)
+	s := NewWDC65c02(m)
+	m.Poke(0x0400, 0xcb) // WAI
+	m.Poke(0x0401, 0xea) // NOP
+	m.Poke(0xfffe, 0x00)
+	m.Poke(0xffff, 0x80)
+	s.reg.setPC(0x0400)
+	s.reg.setP(flagI)
+
+	s.ExecuteInstruction()
+	if !s.IsWaiting() {
+		t.Fatal("WAI did not suspend the processor")
+	}
+	cycles := s.GetCycles()
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x0401 || s.GetCycles() != cycles+1 {
+		t.Fatalf("Processor not idle after WAI, PC = $%04x", s.reg.getPC())
+	}
+
+	// With the I flag set, the IRQ resumes execution without being serviced
+	s.RaiseIRQ()
+	s.ExecuteInstruction()
+	if s.IsWaiting() || s.reg.getPC() != 0x0402 {
+		t.Errorf("IRQ did not resume after WAI, PC = $%04x", s.reg.getPC())
+	}
+}
+
+func TestWDC65c02STP(t *testing.T) {
+	m := new(FlatMemory)
+	s := NewWDC65c02(m)
+	m.Poke(0x0400, 0xdb) // STP
+	m.Poke(0xfffc, 0x00)
+	m.Poke(0xfffd, 0x04)
+	s.reg.setPC(0x0400)
+
+	s.ExecuteInstruction()
+	s.RaiseNMI()
+	s.ExecuteInstruction()
+	if !s.IsStopped() || s.reg.getPC() != 0x0401 {
+		t.Fatalf("Processor not stopped after STP, PC = $%04x", s.reg.getPC())
+	}
+
+	s.Reset()
+	if s.IsStopped() || s.reg.getPC() != 0x0400 {
+		t.Errorf("Reset did not restart the processor, PC = $%04x", s.reg.getPC())
+	}
+}
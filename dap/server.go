@@ -0,0 +1,673 @@
+// Package dap serves the Debug Adapter Protocol, to debug guest code from IDEs
+// like VS Code, at the source level with the ld65 debug info.
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/dbginfo"
+	"github.com/lunarmobiscuit/iz6502/debug"
+	"github.com/lunarmobiscuit/iz6502/symbols"
+)
+
+const threadID = 1
+
+// Variables references of the scopes
+const (
+	refRegisters = iota + 1
+	refFlags
+	refZeroPage
+)
+
+// Server is a debug adapter for a session, on a connection or the standard streams
+type Server struct {
+	r *bufio.Reader
+	w io.Writer
+
+	outMu sync.Mutex // Protects w and seq
+	seq   int
+
+	d           *debug.Debugger
+	info        *dbginfo.Info
+	stopOnEntry bool
+
+	// Debugger ids of the breakpoints, to replace them on each request
+	sourceBreakpoints      map[string][]int
+	instructionBreakpoints []int
+
+	runMu         sync.Mutex // Protects the fields below, shared with the running goroutine
+	running       bool
+	internalPause bool
+	done          chan debug.Stop
+}
+
+// NewServer creates a debug adapter reading requests from r and writing to w
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		r:                 bufio.NewReader(r),
+		w:                 w,
+		sourceBreakpoints: make(map[string][]int),
+	}
+}
+
+// ListenAndServe accepts connections on a "tcp" or "unix" address, serving a
+// session on each of them
+func ListenAndServe(network string, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			NewServer(conn, conn).Serve()
+			conn.Close()
+		}()
+	}
+}
+
+// Serve handles requests until a disconnect request or the end of the input
+func (s *Server) Serve() error {
+	for {
+		data, err := readMessage(s.r)
+		if err == io.EOF {
+			s.pause()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if end := s.handle(&req); end {
+			return nil
+		}
+	}
+}
+
+func (s *Server) send(message interface{}) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.seq++
+	switch m := message.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	writeMessage(s.w, message)
+}
+
+func (s *Server) respond(req *request, body interface{}) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req *request, err error) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+}
+
+func (s *Server) event(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) isRunning() bool {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	return s.running
+}
+
+// resume runs the processor on a goroutine, reporting the stop with an event
+func (s *Server) resume(run func() debug.Stop) {
+	done := make(chan debug.Stop, 1)
+	s.runMu.Lock()
+	s.running = true
+	s.done = done
+	s.runMu.Unlock()
+
+	go func() {
+		stop := run()
+		s.runMu.Lock()
+		s.running = false
+		// An interrupt not seen before stopping by itself
+		s.d.CancelInterrupt()
+		internal := s.internalPause && stop.Reason == debug.StopInterrupted
+		s.internalPause = false
+		s.runMu.Unlock()
+
+		if !internal {
+			s.stopped(stop)
+		}
+		done <- stop
+	}()
+}
+
+// pause stops the processor if running, without reporting it. Returns true if
+// it was running.
+func (s *Server) pause() bool {
+	s.runMu.Lock()
+	if !s.running {
+		s.runMu.Unlock()
+		return false
+	}
+	s.internalPause = true
+	done := s.done
+	s.d.Interrupt()
+	s.runMu.Unlock()
+
+	stop := <-done
+	// False if stopped by itself before seeing the interrupt
+	return stop.Reason == debug.StopInterrupted
+}
+
+// whileStopped calls f with the processor stopped. The debugger is not safe to
+// modify while running, a running processor is paused and then continued.
+func (s *Server) whileStopped(f func()) {
+	resume := s.pause()
+	f()
+	if resume {
+		s.resume(s.d.Continue)
+	}
+}
+
+func (s *Server) stopped(stop debug.Stop) {
+	body := map[string]interface{}{
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	}
+	switch stop.Reason {
+	case debug.StopBreakpoint:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+	case debug.StopWatchpoint:
+		body["reason"] = "data breakpoint"
+		body["description"] = stop.String()
+	case debug.StopInterrupted:
+		body["reason"] = "pause"
+	case debug.StopHalted, debug.StopError:
+		body["reason"] = "exception"
+		body["description"] = stop.String()
+		body["text"] = stop.String()
+	default:
+		body["reason"] = "step"
+	}
+	s.event("stopped", body)
+}
+
+func (s *Server) handle(req *request) bool {
+	if s.d == nil {
+		switch req.Command {
+		case "initialize", "launch", "disconnect", "terminate":
+		default:
+			s.fail(req, fmt.Errorf("no program launched"))
+			return false
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsDisassembleRequest":       true,
+			"supportsReadMemoryRequest":        true,
+			"supportsTerminateRequest":         true,
+		})
+		s.event("initialized", nil)
+	case "launch":
+		var args launchArguments
+		if err := s.launch(req, &args); err != nil {
+			s.fail(req, err)
+			return false
+		}
+		s.respond(req, nil)
+	case "setBreakpoints":
+		s.whileStopped(func() { s.setBreakpoints(req) })
+	case "setInstructionBreakpoints":
+		s.whileStopped(func() { s.setInstructionBreakpoints(req) })
+	case "setExceptionBreakpoints":
+		s.respond(req, map[string]interface{}{"breakpoints": []breakpoint{}})
+	case "configurationDone":
+		s.respond(req, nil)
+		if s.stopOnEntry {
+			s.event("stopped", map[string]interface{}{
+				"reason":            "entry",
+				"threadId":          threadID,
+				"allThreadsStopped": true,
+			})
+		} else {
+			s.resume(s.d.Continue)
+		}
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": s.d.State().Model().String()}},
+		})
+	case "continue":
+		s.respond(req, map[string]interface{}{"allThreadsContinued": true})
+		if !s.isRunning() {
+			s.resume(s.d.Continue)
+		}
+	case "next", "stepIn", "stepOut":
+		if s.isRunning() {
+			s.fail(req, fmt.Errorf("the processor is running"))
+			return false
+		}
+		s.respond(req, nil)
+		switch req.Command {
+		case "next":
+			s.resume(s.d.StepOver)
+		case "stepIn":
+			s.resume(s.d.Step)
+		default:
+			s.resume(s.d.StepOut)
+		}
+	case "pause":
+		s.respond(req, nil)
+		s.runMu.Lock()
+		if s.running {
+			s.d.Interrupt()
+		}
+		s.runMu.Unlock()
+	case "stackTrace", "scopes", "variables", "disassemble", "readMemory":
+		if s.isRunning() {
+			s.fail(req, fmt.Errorf("the processor is running"))
+			return false
+		}
+		var body interface{}
+		var err error
+		switch req.Command {
+		case "stackTrace":
+			body = s.stackTrace()
+		case "scopes":
+			body = map[string]interface{}{"scopes": []scope{
+				{"Registers", refRegisters, false},
+				{"Flags", refFlags, false},
+				{"Zero page", refZeroPage, false},
+			}}
+		case "variables":
+			body, err = s.variables(req)
+		case "disassemble":
+			body, err = s.disassemble(req)
+		case "readMemory":
+			body, err = s.readMemory(req)
+		}
+		if err != nil {
+			s.fail(req, err)
+		} else {
+			s.respond(req, body)
+		}
+	case "disconnect", "terminate":
+		if s.d != nil {
+			s.pause()
+		}
+		s.respond(req, nil)
+		if req.Command == "terminate" {
+			s.event("terminated", nil)
+			return false
+		}
+		return true
+	default:
+		s.fail(req, fmt.Errorf("unsupported request %v", req.Command))
+	}
+	return false
+}
+
+func (s *Server) launch(req *request, args *launchArguments) error {
+	if err := json.Unmarshal(req.Arguments, args); err != nil {
+		return err
+	}
+	if s.d != nil {
+		return fmt.Errorf("a program is already launched")
+	}
+
+	model := iz6502.ModelCMOS65c02
+	if args.Model != "" {
+		var err error
+		if model, err = iz6502.ParseModel(args.Model); err != nil {
+			return err
+		}
+	}
+	var mem iz6502.Memory = new(iz6502.FlatMemory)
+	if model == iz6502.ModelMythical65c24T8 {
+		mem = new(iz6502.Flat256KMemory)
+	}
+	state, err := iz6502.NewState(model, mem)
+	if err != nil {
+		return err
+	}
+
+	image, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	for i, b := range image {
+		mem.Poke(uint32(args.LoadAddress)+uint32(i), b)
+	}
+	table := symbols.New()
+	if args.DebugInfo != "" {
+		if s.info, err = dbginfo.Load(args.DebugInfo); err != nil {
+			return err
+		}
+		table.AddDbgInfo(s.info)
+	}
+	for _, filename := range args.Symbols {
+		if err := table.LoadFile(filename); err != nil {
+			return err
+		}
+	}
+
+	pc := uint32(args.LoadAddress)
+	if args.Entry != nil {
+		pc = uint32(*args.Entry)
+	}
+	state.SetRegisters(iz6502.Registers{SP: 0xff, PC: pc})
+	s.d = debug.New(state)
+	if table.Len() > 0 {
+		s.d.SetSymbols(table)
+	}
+	s.stopOnEntry = args.StopOnEntry
+	return nil
+}
+
+func (s *Server) setBreakpoints(req *request) {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, err)
+		return
+	}
+
+	path := args.Source.Path
+	for _, id := range s.sourceBreakpoints[path] {
+		s.d.Remove(id)
+	}
+	var ids []int
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, sb := range args.Breakpoints {
+		bp := breakpoint{Line: sb.Line, Source: &args.Source}
+		var addresses []uint32
+		if s.info != nil {
+			addresses = s.info.Addresses(path, sb.Line)
+		}
+		if len(addresses) == 0 {
+			bp.Message = "No code generated by this line"
+		}
+		for _, a := range addresses {
+			b, err := s.d.AddBreakpoint(a, sb.Condition)
+			if err != nil {
+				bp.Message = err.Error()
+				break
+			}
+			ids = append(ids, b.ID)
+			if !bp.Verified {
+				bp.ID = b.ID
+				bp.Verified = true
+				bp.InstructionReference = formatAddress(a)
+			}
+		}
+		result = append(result, bp)
+	}
+	s.sourceBreakpoints[path] = ids
+	s.respond(req, map[string]interface{}{"breakpoints": result})
+}
+
+func (s *Server) setInstructionBreakpoints(req *request) {
+	var args setInstructionBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, err)
+		return
+	}
+
+	for _, id := range s.instructionBreakpoints {
+		s.d.Remove(id)
+	}
+	s.instructionBreakpoints = nil
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, ib := range args.Breakpoints {
+		bp := breakpoint{InstructionReference: ib.InstructionReference}
+		a, err := parseAddress(ib.InstructionReference)
+		if err == nil {
+			var b *debug.Breakpoint
+			b, err = s.d.AddBreakpoint(a+uint32(ib.Offset), ib.Condition)
+			if err == nil {
+				bp.ID = b.ID
+				bp.Verified = true
+				s.instructionBreakpoints = append(s.instructionBreakpoints, b.ID)
+			}
+		}
+		if err != nil {
+			bp.Message = err.Error()
+		}
+		result = append(result, bp)
+	}
+	s.respond(req, map[string]interface{}{"breakpoints": result})
+}
+
+func (s *Server) is24Bits() bool {
+	return s.d.State().AddressMaxWidth() == iz6502.AB24
+}
+
+func formatAddress(a uint32) string {
+	if a > 0xffff {
+		return fmt.Sprintf("0x%06x", a)
+	}
+	return fmt.Sprintf("0x%04x", a)
+}
+
+// sourceLine returns the source and line for an address, if known
+func (s *Server) sourceLine(a uint32) (*source, int) {
+	if s.info == nil {
+		return nil, 0
+	}
+	l, ok := s.info.LineAt(a)
+	if !ok {
+		return nil, 0
+	}
+	return &source{Name: filepath.Base(l.File.Path), Path: l.File.Path}, l.Line
+}
+
+func (s *Server) stackTrace() interface{} {
+	frames := s.d.Frames()
+	pc := s.d.State().GetPC()
+	var result []stackFrame
+	for level := 0; level <= len(frames); level++ {
+		name := "entry"
+		if level < len(frames) {
+			name = iz6502.SymbolString(s.d.Symbols(), frames[level].Target)
+			if frames[level].Interrupt {
+				name = "interrupt " + name
+			}
+		}
+		f := stackFrame{
+			ID:                          level + 1,
+			Name:                        name,
+			Column:                      1,
+			InstructionPointerReference: formatAddress(pc),
+		}
+		f.Source, f.Line = s.sourceLine(pc)
+		result = append(result, f)
+		if level < len(frames) {
+			pc = frames[level].Call
+		}
+	}
+	return map[string]interface{}{"stackFrames": result, "totalFrames": len(result)}
+}
+
+func (s *Server) variables(req *request) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	r := s.d.State().GetRegisters()
+	registerFormat, pcFormat := "$%02x", "$%04x"
+	if s.is24Bits() {
+		registerFormat, pcFormat = "$%06x", "$%06x"
+	}
+	var result []variable
+	switch args.VariablesReference {
+	case refRegisters:
+		result = []variable{
+			{Name: "A", Value: fmt.Sprintf(registerFormat, r.A)},
+			{Name: "X", Value: fmt.Sprintf(registerFormat, r.X)},
+			{Name: "Y", Value: fmt.Sprintf(registerFormat, r.Y)},
+			{Name: "SP", Value: fmt.Sprintf(registerFormat, r.SP)},
+			{Name: "PC", Value: fmt.Sprintf(pcFormat, r.PC), MemoryReference: formatAddress(r.PC)},
+			{Name: "P", Value: fmt.Sprintf("$%02x", r.P)},
+		}
+	case refFlags:
+		for i, name := range "NV-BDIZC" {
+			if name == '-' {
+				continue
+			}
+			value := (r.P >> uint(7-i)) & 1
+			result = append(result, variable{Name: string(name), Value: fmt.Sprint(value)})
+		}
+	case refZeroPage:
+		mem := s.d.Memory()
+		for row := uint32(0); row < 0x100; row += 0x10 {
+			values := make([]string, 16)
+			for i := range values {
+				values[i] = fmt.Sprintf("%02x", mem.Peek(row+uint32(i)))
+			}
+			result = append(result, variable{
+				Name:            fmt.Sprintf("$%02x", row),
+				Value:           strings.Join(values, " "),
+				MemoryReference: formatAddress(row),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown variables reference %v", args.VariablesReference)
+	}
+	return map[string]interface{}{"variables": result}, nil
+}
+
+func (s *Server) disassembled(i iz6502.Instruction) disassembledInstruction {
+	d := disassembledInstruction{
+		Address:          formatAddress(i.Address),
+		InstructionBytes: fmt.Sprintf("% x", i.Bytes),
+		Instruction:      i.String(),
+	}
+	if sym := s.d.Symbols(); sym != nil {
+		d.Symbol, _ = sym.SymbolAt(i.Address)
+	}
+	if i.Undefined {
+		d.PresentationHint = "invalid"
+	}
+	d.Location, d.Line = s.sourceLine(i.Address)
+	return d
+}
+
+func (s *Server) disassemble(req *request) (interface{}, error) {
+	var args disassembleArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+	base, err := parseAddress(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	base += uint32(args.Offset)
+	model := s.d.State().Model()
+	mem := s.d.Memory()
+	sym := s.d.Symbols()
+
+	var result []disassembledInstruction
+	if args.InstructionOffset < 0 {
+		// Instructions can't be decoded backwards. Decode from far enough
+		// before and keep the last ones.
+		count := -args.InstructionOffset
+		start := uint32(0)
+		if base > uint32(3*count) {
+			start = base - uint32(3*count)
+		}
+		var before []disassembledInstruction
+		for a := start; a < base; {
+			i, next, err := iz6502.DisassembleSymbols(model, mem, a, iz6502.Widths{}, sym)
+			if err != nil {
+				return nil, err
+			}
+			before = append(before, s.disassembled(i))
+			a = next
+		}
+		if len(before) > count {
+			before = before[len(before)-count:]
+		}
+		for len(before)+len(result) < count {
+			result = append(result, disassembledInstruction{
+				Address:          formatAddress(start),
+				Instruction:      "",
+				PresentationHint: "invalid",
+			})
+		}
+		result = append(result, before...)
+	}
+
+	a := base
+	for i := 0; i < args.InstructionOffset; i++ {
+		var err error
+		if _, a, err = iz6502.Disassemble(model, mem, a, iz6502.Widths{}); err != nil {
+			return nil, err
+		}
+	}
+	for len(result) < args.InstructionCount {
+		i, next, err := iz6502.DisassembleSymbols(model, mem, a, iz6502.Widths{}, sym)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s.disassembled(i))
+		a = next
+	}
+	if len(result) > args.InstructionCount {
+		result = result[:args.InstructionCount]
+	}
+	return map[string]interface{}{"instructions": result}, nil
+}
+
+func (s *Server) readMemory(req *request) (interface{}, error) {
+	var args readMemoryArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+	base, err := parseAddress(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	if args.Count < 0 {
+		return nil, fmt.Errorf("invalid count %v", args.Count)
+	}
+	base += uint32(args.Offset)
+	if args.Count > 0x10000 {
+		args.Count = 0x10000
+	}
+	mem := s.d.Memory()
+	data := make([]byte, args.Count)
+	for i := range data {
+		data[i] = mem.Peek(base + uint32(i))
+	}
+	return map[string]interface{}{
+		"address": formatAddress(base),
+		"data":    base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
@@ -0,0 +1,289 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/debug"
+)
+
+type testClient struct {
+	t        *testing.T
+	w        io.Writer
+	messages chan map[string]interface{}
+	seq      int
+	events   []map[string]interface{}
+}
+
+func newTestClient(t *testing.T) *testClient {
+	requestsR, requestsW := io.Pipe()
+	responsesR, responsesW := io.Pipe()
+	go NewServer(requestsR, responsesW).Serve()
+
+	// The messages are read as they come, the pipes have no buffer
+	c := &testClient{t: t, w: requestsW, messages: make(chan map[string]interface{}, 100)}
+	go func() {
+		r := bufio.NewReader(responsesR)
+		for {
+			data, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var m map[string]interface{}
+			json.Unmarshal(data, &m)
+			c.messages <- m
+		}
+	}()
+	return c
+}
+
+func (c *testClient) read() map[string]interface{} {
+	select {
+	case m, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("Connection closed")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("Timeout waiting for a message")
+	}
+	return nil
+}
+
+// request sends a request and returns its response, keeping the events received
+func (c *testClient) request(command string, arguments interface{}) map[string]interface{} {
+	m := c.response(command, arguments)
+	if m["success"] != true {
+		c.t.Fatalf("Request %v failed: %v", command, m["message"])
+	}
+	body, _ := m["body"].(map[string]interface{})
+	return body
+}
+
+// response sends a request and returns its response, failed or not
+func (c *testClient) response(command string, arguments interface{}) map[string]interface{} {
+	c.seq++
+	writeMessage(c.w, map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": arguments,
+	})
+	for {
+		m := c.read()
+		if m["type"] == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		if m["request_seq"] != float64(c.seq) {
+			c.t.Fatalf("Unexpected response %v", m)
+		}
+		return m
+	}
+}
+
+// waitEvent returns the next event with the name
+func (c *testClient) waitEvent(name string) map[string]interface{} {
+	for i, e := range c.events {
+		if e["event"] == name {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			body, _ := e["body"].(map[string]interface{})
+			return body
+		}
+	}
+	for {
+		m := c.read()
+		if m["type"] == "event" && m["event"] == name {
+			body, _ := m["body"].(map[string]interface{})
+			return body
+		}
+	}
+}
+
+const testProgram = "\xa2\x00" + // $0400 LDX #$00
+	"\x20\x08\x04" + //             $0402 JSR $0408
+	"\x4c\x02\x04" + //             $0405 JMP $0402
+	"\xe8" + //                     $0408 INX
+	"\x86\x10" + //                 $0409 STX $10
+	"\x60" //                       $040B RTS
+
+const testDbg = `version	major=2,minor=0
+file	id=0,name="test.s",size=100,mtime=0x5F5E1000,mod=0
+line	id=0,file=0,line=1,span=0
+line	id=1,file=0,line=2,span=1
+line	id=2,file=0,line=3,span=2
+line	id=3,file=0,line=6,span=3
+line	id=4,file=0,line=7,span=4
+line	id=5,file=0,line=8,span=5
+seg	id=0,name="CODE",start=0x000400,size=0x00000C,addrsize=absolute,type=ro
+span	id=0,seg=0,start=0,size=2
+span	id=1,seg=0,start=2,size=3
+span	id=2,seg=0,start=5,size=3
+span	id=3,seg=0,start=8,size=1
+span	id=4,seg=0,start=9,size=2
+span	id=5,seg=0,start=11,size=1
+sym	id=0,name="increment",addrsize=absolute,scope=0,def=3,ref=1,val=0x408,seg=0,type=lab
+`
+
+func launchTest(t *testing.T) (*testClient, string) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "test.bin"), []byte(testProgram), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "test.dbg"), []byte(testDbg), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "test.lbl"), []byte("al 000400 .start\n"), 0644)
+
+	c := newTestClient(t)
+	body := c.request("initialize", map[string]interface{}{"adapterID": "iz6502"})
+	if body["supportsDisassembleRequest"] != true {
+		t.Errorf("Wrong capabilities %v", body)
+	}
+	c.request("launch", map[string]interface{}{
+		"program":     filepath.Join(dir, "test.bin"),
+		"loadAddress": "$0400",
+		"model":       "65c02",
+		"debugInfo":   filepath.Join(dir, "test.dbg"),
+		"symbols":     []string{filepath.Join(dir, "test.lbl")},
+		"stopOnEntry": true,
+	})
+	c.waitEvent("initialized")
+	return c, dir
+}
+
+func TestSourceBreakpointAndStack(t *testing.T) {
+	c, dir := launchTest(t)
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "test.s")
+
+	body := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": source},
+		"breakpoints": []map[string]interface{}{{"line": 7}, {"line": 4}},
+	})
+	bps := body["breakpoints"].([]interface{})
+	if bps[0].(map[string]interface{})["verified"] != true || bps[1].(map[string]interface{})["verified"] != false {
+		t.Errorf("Wrong breakpoints %v", bps)
+	}
+	c.request("configurationDone", nil)
+	if e := c.waitEvent("stopped"); e["reason"] != "entry" {
+		t.Errorf("Expected stop on entry, got %v", e)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	if e := c.waitEvent("stopped"); e["reason"] != "breakpoint" {
+		t.Fatalf("Expected breakpoint, got %v", e)
+	}
+
+	body = c.request("stackTrace", map[string]interface{}{"threadId": 1})
+	frames := body["stackFrames"].([]interface{})
+	if len(frames) != 2 {
+		t.Fatalf("Wrong stack %v", frames)
+	}
+	top := frames[0].(map[string]interface{})
+	caller := frames[1].(map[string]interface{})
+	if top["line"] != float64(7) || top["name"] != "increment" || top["instructionPointerReference"] != "0x0409" {
+		t.Errorf("Wrong top frame %v", top)
+	}
+	if caller["line"] != float64(2) || caller["instructionPointerReference"] != "0x0402" {
+		t.Errorf("Wrong caller frame %v", caller)
+	}
+
+	body = c.request("variables", map[string]interface{}{"variablesReference": refRegisters})
+	vars := body["variables"].([]interface{})
+	if x := vars[1].(map[string]interface{}); x["name"] != "X" || x["value"] != "$01" {
+		t.Errorf("Wrong X %v", x)
+	}
+
+	c.request("stepOut", map[string]interface{}{"threadId": 1})
+	c.waitEvent("stopped")
+	body = c.request("variables", map[string]interface{}{"variablesReference": refZeroPage})
+	row := body["variables"].([]interface{})[1].(map[string]interface{})
+	if row["name"] != "$10" || row["value"].(string)[:2] != "01" {
+		t.Errorf("Wrong zero page %v", row)
+	}
+
+	c.request("disconnect", nil)
+}
+
+func TestDisassembleAndPause(t *testing.T) {
+	c, dir := launchTest(t)
+	defer os.RemoveAll(dir)
+	c.request("configurationDone", nil)
+	c.waitEvent("stopped")
+
+	body := c.request("disassemble", map[string]interface{}{
+		"memoryReference":   "0x0402",
+		"instructionOffset": -1,
+		"instructionCount":  3,
+	})
+	instructions := body["instructions"].([]interface{})
+	if len(instructions) != 3 {
+		t.Fatalf("Wrong disassembly %v", instructions)
+	}
+	first := instructions[0].(map[string]interface{})
+	second := instructions[1].(map[string]interface{})
+	if first["address"] != "0x0400" || first["instruction"] != "LDX #$00" || first["symbol"] != "start" {
+		t.Errorf("Wrong instruction %v", first)
+	}
+	if second["instruction"] != "JSR increment" || second["line"] != float64(2) {
+		t.Errorf("Wrong instruction %v", second)
+	}
+
+	body = c.request("readMemory", map[string]interface{}{"memoryReference": "0x0400", "count": 2})
+	if body["data"] != "ogA=" {
+		t.Errorf("Wrong memory %v", body)
+	}
+	m := c.response("readMemory", map[string]interface{}{"memoryReference": "0x0400", "count": -1})
+	if m["success"] != false {
+		t.Errorf("Negative count accepted %v", m)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	time.Sleep(10 * time.Millisecond)
+	c.request("setInstructionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]interface{}{{"instructionReference": "0x0405", "condition": "X == 5"}},
+	})
+	if e := c.waitEvent("stopped"); e["reason"] != "breakpoint" {
+		t.Fatalf("Expected breakpoint, got %v", e)
+	}
+	body = c.request("variables", map[string]interface{}{"variablesReference": refRegisters})
+	if x := body["variables"].([]interface{})[1].(map[string]interface{}); x["value"] != "$05" {
+		t.Errorf("Wrong condition %v", x)
+	}
+
+	c.request("setInstructionBreakpoints", map[string]interface{}{"breakpoints": []interface{}{}})
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	time.Sleep(10 * time.Millisecond)
+	c.request("pause", map[string]interface{}{"threadId": 1})
+	if e := c.waitEvent("stopped"); e["reason"] != "pause" {
+		t.Errorf("Expected pause, got %v", e)
+	}
+	c.request("disconnect", nil)
+}
+
+func TestInterruptAfterStop(t *testing.T) {
+	mem := new(iz6502.FlatMemory)
+	mem.Poke(0x0400, 0xea) // NOP
+	mem.Poke(0x0401, 0xea) // NOP
+	state := iz6502.NewCMOS65c02(mem)
+	state.SetPC(0x0400)
+	s := NewServer(strings.NewReader(""), ioutil.Discard)
+	s.d = debug.New(state)
+
+	// A pause arriving as the run stops by itself
+	s.resume(func() debug.Stop {
+		stop := s.d.Step()
+		s.d.Interrupt()
+		return stop
+	})
+	<-s.done
+	if stop := s.d.Step(); stop.Reason != debug.StopStep {
+		t.Errorf("Next run stopped by the stale interrupt: %v", stop.Reason)
+	}
+}
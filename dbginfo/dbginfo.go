@@ -0,0 +1,329 @@
+// Package dbginfo reads the debug info files written by the cc65 linker, ld65,
+// with the --dbgfile option, to map source lines to addresses and get the
+// symbols.
+package dbginfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File is a source file
+type File struct {
+	ID   int
+	Name string // As in the debug info, usually relative to the working directory of ld65
+	Path string // Name resolved relative to the debug info file
+}
+
+// Span is a range of addresses generated by a source line
+type Span struct {
+	Start uint32
+	End   uint32 // Exclusive
+}
+
+// Line is a source line that generated code or data
+type Line struct {
+	File  *File
+	Line  int
+	Type  int // 0 for assembler source, 1 for C source, 2 for macro expansions
+	Spans []Span
+}
+
+// Symbol is a label or constant of the program
+type Symbol struct {
+	Name  string
+	Value uint32
+	Label bool // A label, as opposed to a constant defined with =
+	Size  int  // Bytes generated after the label, if known
+}
+
+// Info is the content of a debug info file
+type Info struct {
+	Files   []*File
+	Lines   []*Line
+	Symbols []*Symbol
+}
+
+// Load reads a debug info file
+func Load(filename string) (*Info, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, filepath.Dir(filename))
+}
+
+type record struct {
+	kind   string
+	fields map[string]string
+}
+
+func (r *record) int(name string) (int, error) {
+	v, ok := r.fields[name]
+	if !ok {
+		return 0, fmt.Errorf("missing %v in %v", name, r.kind)
+	}
+	i, err := strconv.ParseInt(v, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v in %v: %v", name, r.kind, v)
+	}
+	return int(i), nil
+}
+
+// ids parses a list of ids separated by +
+func (r *record) ids(name string) ([]int, error) {
+	v, ok := r.fields[name]
+	if !ok {
+		return nil, nil
+	}
+	var ids []int
+	for _, s := range strings.Split(v, "+") {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v in %v: %v", name, r.kind, v)
+		}
+		ids = append(ids, i)
+	}
+	return ids, nil
+}
+
+func parseRecord(text string) (*record, error) {
+	parts := strings.SplitN(text, "\t", 2)
+	r := &record{kind: parts[0], fields: make(map[string]string)}
+	if len(parts) == 1 {
+		return r, nil
+	}
+
+	fields := parts[1]
+	for fields != "" {
+		eq := strings.IndexByte(fields, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid field in '%v'", text)
+		}
+		name := fields[:eq]
+		fields = fields[eq+1:]
+		var value string
+		if strings.HasPrefix(fields, "\"") {
+			end := strings.IndexByte(fields[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in '%v'", text)
+			}
+			value = fields[1 : end+1]
+			fields = fields[end+2:]
+		} else {
+			end := strings.IndexByte(fields, ',')
+			if end < 0 {
+				end = len(fields)
+			}
+			value = fields[:end]
+			fields = fields[end:]
+		}
+		fields = strings.TrimPrefix(fields, ",")
+		r.fields[name] = value
+	}
+	return r, nil
+}
+
+// Parse reads debug info. File names are resolved relative to dir.
+func Parse(r io.Reader, dir string) (*Info, error) {
+	var records []*record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		rec, err := parseRecord(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The records reference each other by id, in any order
+	info := &Info{}
+	files := make(map[int]*File)
+	segs := make(map[int]uint32)
+	spans := make(map[int]Span)
+	for _, rec := range records {
+		switch rec.kind {
+		case "version":
+			if major, err := rec.int("major"); err != nil || major != 2 {
+				return nil, fmt.Errorf("unsupported debug info version %v", rec.fields["major"])
+			}
+		case "file":
+			id, err := rec.int("id")
+			if err != nil {
+				return nil, err
+			}
+			f := &File{ID: id, Name: rec.fields["name"]}
+			f.Path = f.Name
+			if !filepath.IsAbs(f.Path) {
+				f.Path = filepath.Join(dir, f.Path)
+			}
+			files[id] = f
+			info.Files = append(info.Files, f)
+		case "seg":
+			id, err := rec.int("id")
+			if err != nil {
+				return nil, err
+			}
+			start, err := rec.int("start")
+			if err != nil {
+				return nil, err
+			}
+			segs[id] = uint32(start)
+		}
+	}
+
+	for _, rec := range records {
+		if rec.kind != "span" {
+			continue
+		}
+		id, err := rec.int("id")
+		if err != nil {
+			return nil, err
+		}
+		seg, err := rec.int("seg")
+		if err != nil {
+			return nil, err
+		}
+		start, err := rec.int("start")
+		if err != nil {
+			return nil, err
+		}
+		size, err := rec.int("size")
+		if err != nil {
+			return nil, err
+		}
+		base, ok := segs[seg]
+		if !ok {
+			return nil, fmt.Errorf("span %v references unknown segment %v", id, seg)
+		}
+		spans[id] = Span{base + uint32(start), base + uint32(start+size)}
+	}
+
+	for _, rec := range records {
+		if rec.kind != "sym" {
+			continue
+		}
+		if _, ok := rec.fields["val"]; !ok {
+			// Imports have the value in the export
+			continue
+		}
+		value, err := rec.int("val")
+		if err != nil {
+			return nil, err
+		}
+		sym := &Symbol{
+			Name:  rec.fields["name"],
+			Value: uint32(value),
+			Label: rec.fields["type"] == "lab",
+		}
+		if _, ok := rec.fields["size"]; ok {
+			if sym.Size, err = rec.int("size"); err != nil {
+				return nil, err
+			}
+		}
+		info.Symbols = append(info.Symbols, sym)
+	}
+
+	for _, rec := range records {
+		if rec.kind != "line" {
+			continue
+		}
+		fileID, err := rec.int("file")
+		if err != nil {
+			return nil, err
+		}
+		number, err := rec.int("line")
+		if err != nil {
+			return nil, err
+		}
+		spanIDs, err := rec.ids("span")
+		if err != nil {
+			return nil, err
+		}
+		if len(spanIDs) == 0 {
+			// Lines without code
+			continue
+		}
+		l := &Line{File: files[fileID], Line: number}
+		if l.File == nil {
+			return nil, fmt.Errorf("line references unknown file %v", fileID)
+		}
+		if _, ok := rec.fields["type"]; ok {
+			if l.Type, err = rec.int("type"); err != nil {
+				return nil, err
+			}
+		}
+		for _, id := range spanIDs {
+			if span, ok := spans[id]; ok {
+				l.Spans = append(l.Spans, span)
+			}
+		}
+		info.Lines = append(info.Lines, l)
+	}
+
+	return info, nil
+}
+
+// sameFile compares a file of the debug info with a path given by the user
+func sameFile(f *File, path string) bool {
+	path = filepath.Clean(path)
+	if path == filepath.Clean(f.Path) || path == filepath.Clean(f.Name) {
+		return true
+	}
+	if abs, err := filepath.Abs(f.Path); err == nil {
+		if other, err := filepath.Abs(path); err == nil && abs == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Addresses returns the start addresses of the code generated by a source line
+func (info *Info) Addresses(path string, line int) []uint32 {
+	var addresses []uint32
+	for _, l := range info.Lines {
+		if l.Line == line && l.Type != 2 && sameFile(l.File, path) {
+			for _, span := range l.Spans {
+				addresses = append(addresses, span.Start)
+			}
+		}
+	}
+	return addresses
+}
+
+// LineAt returns the source line that generated the code at an address. The
+// source lines are preferred to the macro expansions, and the lines of smaller
+// spans to the lines including them.
+func (info *Info) LineAt(address uint32) (*Line, bool) {
+	var best *Line
+	var bestSize uint32
+	for _, l := range info.Lines {
+		for _, span := range l.Spans {
+			if address < span.Start || address >= span.End {
+				continue
+			}
+			size := span.End - span.Start
+			if best == nil ||
+				(best.Type == 2 && l.Type != 2) ||
+				((best.Type == 2) == (l.Type == 2) && size < bestSize) {
+				best = l
+				bestSize = size
+			}
+		}
+	}
+	return best, best != nil
+}
@@ -0,0 +1,86 @@
+package dbginfo
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDbg = `version	major=2,minor=0
+info	csym=0,file=2,lib=0,line=5,mod=1,scope=1,seg=2,span=4,sym=2,type=0
+file	id=0,name="hello.s",size=120,mtime=0x5F5E1000,mod=0
+file	id=1,name="macros.inc",size=40,mtime=0x5F5E1000,mod=0
+line	id=0,file=0,line=3
+line	id=1,file=0,line=5,span=0
+line	id=2,file=0,line=6,span=1
+line	id=3,file=1,line=2,type=2,span=2
+line	id=4,file=0,line=7,span=3+2
+mod	id=0,name="hello.o",file=0
+seg	id=0,name="CODE",start=0x000400,size=0x000009,addrsize=absolute,type=ro,oname="hello.bin",ooffs=0
+seg	id=1,name="DATA",start=0x002000,size=0x000002,addrsize=absolute,type=rw
+span	id=0,seg=0,start=0,size=2
+span	id=1,seg=0,start=2,size=3
+span	id=2,seg=0,start=5,size=1
+span	id=3,seg=0,start=5,size=4
+scope	id=0,name="",mod=0,size=9
+sym	id=0,name="main",addrsize=absolute,size=5,scope=0,def=1,ref=4,val=0x400,seg=0,type=lab
+sym	id=1,name="SCREEN",addrsize=absolute,scope=0,def=0,val=0x2000,type=equ
+sym	id=2,name="print",addrsize=absolute,scope=0,type=imp,exp=3
+`
+
+func TestParse(t *testing.T) {
+	info, err := Parse(strings.NewReader(testDbg), "/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Files) != 2 || info.Files[0].Path != filepath.Join("/src", "hello.s") {
+		t.Errorf("Wrong files %+v", info.Files)
+	}
+	if len(info.Lines) != 4 {
+		t.Errorf("Lines without code must be skipped, got %v lines", len(info.Lines))
+	}
+
+	addresses := info.Addresses("/src/hello.s", 6)
+	if len(addresses) != 1 || addresses[0] != 0x0402 {
+		t.Errorf("Wrong addresses for line 6 %x", addresses)
+	}
+	if addresses := info.Addresses("hello.s", 5); len(addresses) != 1 || addresses[0] != 0x0400 {
+		t.Errorf("Wrong addresses for line 5 %x", addresses)
+	}
+
+	if l, ok := info.LineAt(0x0403); !ok || l.Line != 6 {
+		t.Errorf("Wrong line at $0403 %+v", l)
+	}
+	// The macro expansion at $0405 is inside the span of line 7
+	if l, ok := info.LineAt(0x0405); !ok || l.Line != 7 || l.File.Name != "hello.s" {
+		t.Errorf("Wrong line at $0405 %+v", l)
+	}
+	if _, ok := info.LineAt(0x0500); ok {
+		t.Error("Line found for an address without code")
+	}
+
+	if len(info.Symbols) != 2 {
+		t.Fatalf("Wrong symbols %+v", info.Symbols)
+	}
+	main, screen := info.Symbols[0], info.Symbols[1]
+	if main.Name != "main" || main.Value != 0x400 || !main.Label || main.Size != 5 {
+		t.Errorf("Wrong symbol %+v", main)
+	}
+	if screen.Name != "SCREEN" || screen.Value != 0x2000 || screen.Label {
+		t.Errorf("Wrong symbol %+v", screen)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		"version\tmajor=1,minor=0\n",
+		"file\tid=0,name=\"hello.s\n",
+		"line\tid=0,file=3,line=5,span=0\nspan\tid=0,seg=0,start=0,size=1\nseg\tid=0,start=0\n",
+		"span\tid=0,seg=9,start=0,size=1\n",
+	}
+	for _, c := range cases {
+		if _, err := Parse(strings.NewReader(c), ""); err == nil {
+			t.Errorf("Invalid debug info accepted: %q", c)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package debug
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+// condition is a compiled breakpoint condition
+type condition func(r iz6502.Registers, mem iz6502.Memory) uint32
+
+/*
+Conditions are expressions on the registers, like "A == $10 && X > 3":
+
+	expr    := and ("||" and)*
+	and     := compare ("&&" compare)*
+	compare := sum (("==" | "!=" | "<" | "<=" | ">" | ">=") sum)?
+	sum     := unary (("+" | "-" | "&" | "|" | "^") unary)*
+	unary   := ("!" | "-" | "~") unary | primary
+	primary := number | register | flag | "(" expr ")" | "[" expr "]"
+
+Registers are A, X, Y, SP, PC and P. Flags are N, V, B, D, I, Z and C, valued
+0 or 1. Numbers are decimal, $hex or %binary. [expr] reads a byte of memory.
+*/
+
+type conditionParser struct {
+	text string
+	pos  int
+}
+
+func compileCondition(text string) (condition, error) {
+	p := &conditionParser{text: text}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos != len(p.text) {
+		return nil, fmt.Errorf("unexpected '%s' in condition", p.text[p.pos:])
+	}
+	return c, nil
+}
+
+func (p *conditionParser) skipSpaces() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *conditionParser) accept(token string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.text[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func boolValue(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *conditionParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r iz6502.Registers, mem iz6502.Memory) uint32 {
+			return boolValue(l(r, mem) != 0 || right(r, mem) != 0)
+		}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (condition, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r iz6502.Registers, mem iz6502.Memory) uint32 {
+			return boolValue(l(r, mem) != 0 && right(r, mem) != 0)
+		}
+	}
+	return left, nil
+}
+
+var comparisons = []struct {
+	token   string
+	compare func(a, b uint32) bool
+}{
+	// Longest tokens first
+	{"==", func(a, b uint32) bool { return a == b }},
+	{"!=", func(a, b uint32) bool { return a != b }},
+	{"<=", func(a, b uint32) bool { return a <= b }},
+	{">=", func(a, b uint32) bool { return a >= b }},
+	{"<", func(a, b uint32) bool { return a < b }},
+	{">", func(a, b uint32) bool { return a > b }},
+}
+
+func (p *conditionParser) parseCompare() (condition, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range comparisons {
+		if p.accept(c.token) {
+			right, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			compare := c.compare
+			return func(r iz6502.Registers, mem iz6502.Memory) uint32 {
+				return boolValue(compare(left(r, mem), right(r, mem)))
+			}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseSum() (condition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.text) {
+			return left, nil
+		}
+		op := p.text[p.pos]
+		if strings.HasPrefix(p.text[p.pos:], "&&") || strings.HasPrefix(p.text[p.pos:], "||") {
+			return left, nil
+		}
+		if op != '+' && op != '-' && op != '&' && op != '|' && op != '^' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		switch op {
+		case '+':
+			left = func(r iz6502.Registers, mem iz6502.Memory) uint32 { return l(r, mem) + right(r, mem) }
+		case '-':
+			left = func(r iz6502.Registers, mem iz6502.Memory) uint32 { return l(r, mem) - right(r, mem) }
+		case '&':
+			left = func(r iz6502.Registers, mem iz6502.Memory) uint32 { return l(r, mem) & right(r, mem) }
+		case '|':
+			left = func(r iz6502.Registers, mem iz6502.Memory) uint32 { return l(r, mem) | right(r, mem) }
+		case '^':
+			left = func(r iz6502.Registers, mem iz6502.Memory) uint32 { return l(r, mem) ^ right(r, mem) }
+		}
+	}
+}
+
+func (p *conditionParser) parseUnary() (condition, error) {
+	switch {
+	case p.accept("!") && !strings.HasPrefix(p.text[p.pos:], "="):
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(r iz6502.Registers, mem iz6502.Memory) uint32 { return boolValue(c(r, mem) == 0) }, nil
+	case p.accept("-"):
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(r iz6502.Registers, mem iz6502.Memory) uint32 { return -c(r, mem) }, nil
+	case p.accept("~"):
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(r iz6502.Registers, mem iz6502.Memory) uint32 { return ^c(r, mem) }, nil
+	}
+	return p.parsePrimary()
+}
+
+var flagBits = map[string]uint8{
+	"N": 7, "V": 6, "B": 4, "D": 3, "I": 2, "Z": 1, "C": 0,
+}
+
+func (p *conditionParser) parsePrimary() (condition, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.text) {
+		return nil, fmt.Errorf("missing value at the end of condition")
+	}
+
+	switch {
+	case p.accept("("):
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing ')' in condition")
+		}
+		return c, nil
+	case p.accept("["):
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept("]") {
+			return nil, fmt.Errorf("missing ']' in condition")
+		}
+		return func(r iz6502.Registers, mem iz6502.Memory) uint32 {
+			return uint32(mem.Peek(c(r, mem)))
+		}, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.text) && isWordChar(p.text[p.pos]) {
+		p.pos++
+	}
+	word := p.text[start:p.pos]
+	if word == "" {
+		return nil, fmt.Errorf("unexpected '%s' in condition", p.text[start:])
+	}
+
+	if value, ok := parseNumber(word); ok {
+		return func(iz6502.Registers, iz6502.Memory) uint32 { return value }, nil
+	}
+
+	switch strings.ToUpper(word) {
+	case "A":
+		return func(r iz6502.Registers, _ iz6502.Memory) uint32 { return r.A }, nil
+	case "X":
+		return func(r iz6502.Registers, _ iz6502.Memory) uint32 { return r.X }, nil
+	case "Y":
+		return func(r iz6502.Registers, _ iz6502.Memory) uint32 { return r.Y }, nil
+	case "SP", "S":
+		return func(r iz6502.Registers, _ iz6502.Memory) uint32 { return r.SP }, nil
+	case "PC":
+		return func(r iz6502.Registers, _ iz6502.Memory) uint32 { return r.PC }, nil
+	case "P":
+		return func(r iz6502.Registers, _ iz6502.Memory) uint32 { return uint32(r.P) }, nil
+	}
+	if bit, ok := flagBits[strings.ToUpper(word)]; ok {
+		return func(r iz6502.Registers, _ iz6502.Memory) uint32 { return uint32(r.P>>bit) & 1 }, nil
+	}
+	return nil, fmt.Errorf("unknown register '%s' in condition", word)
+}
+
+func isWordChar(c byte) bool {
+	return c == '$' || c == '%' || c == '_' ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parseNumber parses decimal, $hex, 0xhex and %binary numbers
+func parseNumber(word string) (uint32, bool) {
+	var value uint64
+	var err error
+	switch {
+	case strings.HasPrefix(word, "$"):
+		value, err = strconv.ParseUint(word[1:], 16, 32)
+	case strings.HasPrefix(word, "0x"), strings.HasPrefix(word, "0X"):
+		value, err = strconv.ParseUint(word[2:], 16, 32)
+	case strings.HasPrefix(word, "%"):
+		value, err = strconv.ParseUint(word[1:], 2, 32)
+	case word[0] >= '0' && word[0] <= '9':
+		value, err = strconv.ParseUint(word, 10, 32)
+	default:
+		return 0, false
+	}
+	return uint32(value), err == nil
+}
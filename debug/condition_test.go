@@ -0,0 +1,49 @@
+package debug
+
+import (
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+func TestCondition(t *testing.T) {
+	m := new(iz6502.FlatMemory)
+	m.Poke(0x1234, 0x55)
+	r := iz6502.Registers{A: 0x10, X: 3, Y: 0x100, SP: 0xfd, PC: 0x0400, P: 0x81}
+
+	cases := []struct {
+		text  string
+		value uint32
+	}{
+		{"A", 0x10},
+		{"a == $10", 1},
+		{"X != 3", 0},
+		{"X + 1 == 4 && Y > 255", 1},
+		{"N && C", 1},
+		{"Z || X < 3", 0},
+		{"!Z", 1},
+		{"(A | X) == %10011", 1},
+		{"[$1234] == $55", 1},
+		{"[PC - $400 + $1234]", 0x55},
+		{"SP >= 0xfd", 1},
+		{"~0 & $ff", 0xff},
+		{"-1 == $ffffffff", 1},
+	}
+
+	for _, c := range cases {
+		cond, err := compileCondition(c.text)
+		if err != nil {
+			t.Errorf("Error in '%v': %v", c.text, err)
+			continue
+		}
+		if v := cond(r, m); v != c.value {
+			t.Errorf("Error in '%v', %v instead of %v", c.text, v, c.value)
+		}
+	}
+
+	for _, text := range []string{"", "A ==", "(A", "Q == 1", "A $10", "[1"} {
+		if _, err := compileCondition(text); err == nil {
+			t.Errorf("Invalid condition '%v' accepted", text)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+// Package debug wraps a processor with breakpoints, watchpoints and stepping
+package debug
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+// StopReason tells why the execution stopped
+type StopReason int
+
+const (
+	// StopStep is the end of a step, step over, step out or run to cycle
+	StopStep StopReason = iota
+	// StopBreakpoint is a breakpoint reached
+	StopBreakpoint
+	// StopWatchpoint is a watched address accessed
+	StopWatchpoint
+	// StopInterrupted is a call to Interrupt
+	StopInterrupted
+	// StopHalted is the processor stopped by STP or an illegal opcode
+	StopHalted
+	// StopError is an error returned by the processor
+	StopError
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopInterrupted:
+		return "interrupted"
+	case StopHalted:
+		return "halted"
+	case StopError:
+		return "error"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(r))
+	}
+}
+
+// Access is a kind of memory access, or a combination of them
+type Access uint8
+
+const (
+	// AccessRead is a read of data
+	AccessRead Access = 1 << iota
+	// AccessWrite is a write of data
+	AccessWrite
+	// AccessExecute is the execution of an instruction
+	AccessExecute
+)
+
+func (a Access) String() string {
+	s := ""
+	if a&AccessRead != 0 {
+		s += "r"
+	}
+	if a&AccessWrite != 0 {
+		s += "w"
+	}
+	if a&AccessExecute != 0 {
+		s += "x"
+	}
+	return s
+}
+
+// Stop describes why and where the execution stopped
+type Stop struct {
+	Reason     StopReason
+	PC         uint32
+	Cycles     uint64
+	Breakpoint *Breakpoint // For StopBreakpoint
+	Watchpoint *Watchpoint // For StopWatchpoint
+	Address    uint32      // Address accessed, for StopWatchpoint
+	Access     Access      // Kind of access, for StopWatchpoint
+	Err        error       // For StopError
+}
+
+func (s Stop) String() string {
+	return s.Format(nil)
+}
+
+// Format describes the stop with the addresses printed as the nearest symbol
+// plus offset, when sym has one
+func (s Stop) Format(sym iz6502.Symbolizer) string {
+	pc := iz6502.SymbolString(sym, s.PC)
+	switch s.Reason {
+	case StopBreakpoint:
+		return fmt.Sprintf("breakpoint %v at %v", s.Breakpoint.ID, pc)
+	case StopWatchpoint:
+		return fmt.Sprintf("watchpoint %v, %v access to %v, at %v", s.Watchpoint.ID, s.Access, iz6502.SymbolString(sym, s.Address), pc)
+	case StopError:
+		return fmt.Sprintf("error at %v: %v", pc, s.Err)
+	default:
+		return fmt.Sprintf("%v at %v", s.Reason, pc)
+	}
+}
+
+// Breakpoint stops the execution before the instruction at Address
+type Breakpoint struct {
+	ID        int
+	Address   uint32
+	Condition string // Empty if unconditional
+	Hits      int
+	cond      condition
+}
+
+// Watchpoint stops the execution after an access to an address in Start to End, inclusive
+type Watchpoint struct {
+	ID     int
+	Start  uint32
+	End    uint32
+	Access Access
+	Hits   int
+}
+
+// Frame is a subroutine call or interrupt seen by the debugger
+type Frame struct {
+	Call      uint32 // Address of the JSR, or PC when interrupted
+	Target    uint32 // Address of the subroutine or handler
+	Return    uint32 // Address execution continues at on return
+	SP        uint32 // Stack pointer before the call
+	Interrupt bool
+}
+
+// Debugger controls the execution of a processor. It installs itself as the
+// tracer of the processor and wraps its memory to detect the watched accesses.
+type Debugger struct {
+	s      *iz6502.State
+	mem    iz6502.Memory
+	tracer iz6502.Tracer
+	sym    iz6502.Symbolizer
+
+	breakpoints map[uint32][]*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+
+	frames      []Frame
+	interrupted int32
+	running     bool
+	hit         *Stop
+	returned    bool // The last instruction was a RTS or RTI
+}
+
+// New attaches a debugger to the processor
+func New(s *iz6502.State) *Debugger {
+	d := &Debugger{
+		s:           s,
+		mem:         s.GetMemory(),
+		tracer:      s.GetTracer(),
+		breakpoints: make(map[uint32][]*Breakpoint),
+		nextID:      1,
+	}
+	s.SetMemory(&watchedMemory{d})
+	s.SetTracer(d)
+	return d
+}
+
+// State returns the processor being debugged
+func (d *Debugger) State() *iz6502.State {
+	return d.s
+}
+
+// Memory returns the memory of the processor, without the watchpoints
+func (d *Debugger) Memory() iz6502.Memory {
+	return d.mem
+}
+
+// SetTracer sets a tracer to receive the events of the processor, as the debugger
+// is the tracer of the processor.
+func (d *Debugger) SetTracer(tracer iz6502.Tracer) {
+	d.tracer = tracer
+}
+
+// SetSymbols sets the symbols used to describe the addresses, nil for none
+func (d *Debugger) SetSymbols(sym iz6502.Symbolizer) {
+	d.sym = sym
+}
+
+// Symbols returns the symbols used to describe the addresses, nil if none
+func (d *Debugger) Symbols() iz6502.Symbolizer {
+	return d.sym
+}
+
+// Describe returns the stop with the symbols of the debugger
+func (d *Debugger) Describe(stop Stop) string {
+	return stop.Format(d.sym)
+}
+
+// AddBreakpoint stops before executing the instruction at address. With a non
+// empty condition, only if the condition is true. See condition.go for the syntax.
+func (d *Debugger) AddBreakpoint(address uint32, cond string) (*Breakpoint, error) {
+	b := &Breakpoint{Address: address, Condition: cond}
+	if cond != "" {
+		c, err := compileCondition(cond)
+		if err != nil {
+			return nil, err
+		}
+		b.cond = c
+	}
+	b.ID = d.nextID
+	d.nextID++
+	d.breakpoints[address] = append(d.breakpoints[address], b)
+	return b, nil
+}
+
+// AddWatchpoint stops after an access of the given kinds in start to end, inclusive
+func (d *Debugger) AddWatchpoint(start uint32, end uint32, access Access) *Watchpoint {
+	if end < start {
+		start, end = end, start
+	}
+	w := &Watchpoint{ID: d.nextID, Start: start, End: end, Access: access}
+	d.nextID++
+	d.watchpoints = append(d.watchpoints, w)
+	return w
+}
+
+// Remove deletes the breakpoint or watchpoint with the id. Returns false if not found.
+func (d *Debugger) Remove(id int) bool {
+	for address, list := range d.breakpoints {
+		for i, b := range list {
+			if b.ID == id {
+				list = append(list[:i], list[i+1:]...)
+				if len(list) == 0 {
+					delete(d.breakpoints, address)
+				} else {
+					d.breakpoints[address] = list
+				}
+				return true
+			}
+		}
+	}
+	for i, w := range d.watchpoints {
+		if w.ID == id {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the breakpoints sorted by id
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	var list []*Breakpoint
+	for _, l := range d.breakpoints {
+		list = append(list, l...)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Watchpoints returns the watchpoints sorted by id
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	return append([]*Watchpoint(nil), d.watchpoints...)
+}
+
+// Frames returns the calls seen by the debugger not returned yet, innermost first
+func (d *Debugger) Frames() []Frame {
+	frames := make([]Frame, len(d.frames))
+	for i, f := range d.frames {
+		frames[len(d.frames)-1-i] = f
+	}
+	return frames
+}
+
+// Interrupt stops the execution before the next instruction. It can be called
+// from another goroutine. If nothing is running, the next run stops at once.
+func (d *Debugger) Interrupt() {
+	atomic.StoreInt32(&d.interrupted, 1)
+}
+
+// CancelInterrupt discards an Interrupt not seen by a run
+func (d *Debugger) CancelInterrupt() {
+	atomic.StoreInt32(&d.interrupted, 0)
+}
+
+// Step executes a single instruction
+func (d *Debugger) Step() Stop {
+	return d.run(func() bool { return true })
+}
+
+// StepOver executes a single instruction, running a subroutine called with JSR up
+// to its return. With 24 bit addresses the return address pushed is 3 bytes long.
+func (d *Debugger) StepOver() Stop {
+	pc := d.s.GetPC()
+	// The model of a processor is always known
+	instruction, _, _ := iz6502.Disassemble(d.s.Model(), d.mem, pc, iz6502.Widths{})
+	if instruction.Mnemonic != "JSR" {
+		return d.Step()
+	}
+
+	ret := pc + uint32(instruction.Length)
+	if instruction.Widths.Address != iz6502.AB24 {
+		ret &= 0xffff
+	}
+	sp := d.s.GetRegisters().SP
+	return d.run(func() bool {
+		r := d.s.GetRegisters()
+		return r.PC == ret && r.SP == sp
+	})
+}
+
+// StepOut runs up to the return of the current subroutine or interrupt handler
+func (d *Debugger) StepOut() Stop {
+	sp := d.s.GetRegisters().SP
+	return d.run(func() bool {
+		return d.returned && d.s.GetRegisters().SP > sp
+	})
+}
+
+// Continue runs up to a breakpoint, watchpoint or error
+func (d *Debugger) Continue() Stop {
+	return d.run(func() bool { return false })
+}
+
+// RunToCycle runs until the cycle count reaches cycle
+func (d *Debugger) RunToCycle(cycle uint64) Stop {
+	if d.s.GetCycles() >= cycle {
+		return d.stop(StopStep)
+	}
+	return d.run(func() bool { return d.s.GetCycles() >= cycle })
+}
+
+func (d *Debugger) stop(reason StopReason) Stop {
+	return Stop{Reason: reason, PC: d.s.GetPC(), Cycles: d.s.GetCycles()}
+}
+
+func (d *Debugger) run(done func() bool) Stop {
+	d.running = true
+	defer func() { d.running = false }()
+
+	first := true
+	for {
+		if atomic.CompareAndSwapInt32(&d.interrupted, 1, 0) {
+			return d.stop(StopInterrupted)
+		}
+
+		// Breakpoints and execute watchpoints at the resume address are skipped
+		pc := d.s.GetPC()
+		if !first && !d.s.IsWaiting() && !d.s.IsStopped() {
+			if b := d.breakpointAt(pc); b != nil {
+				b.Hits++
+				stop := d.stop(StopBreakpoint)
+				stop.Breakpoint = b
+				return stop
+			}
+			if stop := d.watch(pc, AccessExecute); stop != nil {
+				return *stop
+			}
+		}
+		first = false
+
+		d.hit = nil
+		d.returned = false
+		if err := d.s.Step(); err != nil {
+			stop := d.stop(StopError)
+			stop.Err = err
+			return stop
+		}
+		if d.hit != nil {
+			stop := *d.hit
+			stop.PC = d.s.GetPC()
+			stop.Cycles = d.s.GetCycles()
+			return stop
+		}
+		if d.s.IsStopped() {
+			return d.stop(StopHalted)
+		}
+		if done() {
+			return d.stop(StopStep)
+		}
+	}
+}
+
+func (d *Debugger) breakpointAt(pc uint32) *Breakpoint {
+	for _, b := range d.breakpoints[pc] {
+		if b.cond == nil || b.cond(d.s.GetRegisters(), d.mem) != 0 {
+			return b
+		}
+	}
+	return nil
+}
+
+// watch returns a stop if the access hits a watchpoint
+func (d *Debugger) watch(address uint32, access Access) *Stop {
+	for _, w := range d.watchpoints {
+		if w.Access&access != 0 && address >= w.Start && address <= w.End {
+			w.Hits++
+			return &Stop{
+				Reason:     StopWatchpoint,
+				PC:         d.s.GetPC(),
+				Cycles:     d.s.GetCycles(),
+				Watchpoint: w,
+				Address:    address,
+				Access:     access,
+			}
+		}
+	}
+	return nil
+}
+
+// Trace keeps track of the calls and forwards the event to the tracer set
+func (d *Debugger) Trace(e *iz6502.TraceEvent) {
+	switch {
+	case e.Interrupt || e.Mnemonic == "BRK":
+		d.frames = append(d.frames, Frame{
+			Call:      e.PC,
+			Target:    e.After.PC,
+			Return:    e.PC,
+			SP:        e.Before.SP,
+			Interrupt: true,
+		})
+		if e.Mnemonic == "BRK" {
+			d.frames[len(d.frames)-1].Return = e.PC + 2
+		}
+	case e.Mnemonic == "JSR":
+		d.frames = append(d.frames, Frame{
+			Call:   e.PC,
+			Target: e.After.PC,
+			Return: e.PC + uint32(e.Length),
+			SP:     e.Before.SP,
+		})
+	case e.Mnemonic == "RTS" || e.Mnemonic == "RTI":
+		d.returned = true
+		for len(d.frames) > 0 && d.frames[len(d.frames)-1].SP <= e.After.SP {
+			d.frames = d.frames[:len(d.frames)-1]
+		}
+	}
+
+	if d.tracer != nil {
+		d.tracer.Trace(e)
+	}
+}
+
+// watchedMemory checks the watchpoints on the accesses of the processor. It
+// forwards the kinds of the accesses when the memory is an AccessMemory.
+type watchedMemory struct {
+	d *Debugger
+}
+
+func (m *watchedMemory) check(address uint32, access Access) {
+	if m.d.running && m.d.hit == nil && len(m.d.watchpoints) > 0 {
+		m.d.hit = m.d.watch(address, access)
+	}
+}
+
+func (m *watchedMemory) Peek(address uint32) uint8 {
+	m.check(address, AccessRead)
+	return m.d.mem.Peek(address)
+}
+
+func (m *watchedMemory) Poke(address uint32, value uint8) {
+	m.check(address, AccessWrite)
+	m.d.mem.Poke(address, value)
+}
+
+func (m *watchedMemory) PeekCode(address uint32) uint8 {
+	return m.d.mem.PeekCode(address)
+}
+
+func (m *watchedMemory) PeekAccess(address uint32, kind iz6502.AccessKind, cycle uint64) uint8 {
+	fetch := kind == iz6502.AccessOpcodeFetch || kind == iz6502.AccessOperandFetch
+	if !fetch {
+		m.check(address, AccessRead)
+	}
+	if access, ok := m.d.mem.(iz6502.AccessMemory); ok {
+		return access.PeekAccess(address, kind, cycle)
+	}
+	if fetch {
+		return m.d.mem.PeekCode(address)
+	}
+	return m.d.mem.Peek(address)
+}
+
+func (m *watchedMemory) PokeAccess(address uint32, value uint8, kind iz6502.AccessKind, cycle uint64) {
+	m.check(address, AccessWrite)
+	if access, ok := m.d.mem.(iz6502.AccessMemory); ok {
+		access.PokeAccess(address, value, kind, cycle)
+		return
+	}
+	m.d.mem.Poke(address, value)
+}
@@ -0,0 +1,224 @@
+package debug
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/symbols"
+)
+
+func poke(m iz6502.Memory, address uint32, bytes ...uint8) {
+	for i, b := range bytes {
+		m.Poke(address+uint32(i), b)
+	}
+}
+
+func newTestDebugger() *Debugger {
+	return newTestDebuggerOn(new(iz6502.FlatMemory))
+}
+
+func newTestDebuggerOn(m iz6502.Memory) *Debugger {
+	poke(m, 0x0400,
+		0x20, 0x00, 0x05, // JSR $0500
+		0xa5, 0x10, // LDA $10
+		0x8d, 0x00, 0x20, // STA $2000
+		0x4c, 0x08, 0x04) // JMP $0408
+	poke(m, 0x0500,
+		0xe8,             // INX
+		0x20, 0x00, 0x06, // JSR $0600
+		0x60) // RTS
+	poke(m, 0x0600,
+		0xc8, // INY
+		0x60) // RTS
+	s := iz6502.NewNMOS6502(m)
+	s.SetRegisters(iz6502.Registers{SP: 0xff, PC: 0x0400})
+	return New(s)
+}
+
+func TestBreakpointAndSteps(t *testing.T) {
+	d := newTestDebugger()
+	d.AddBreakpoint(0x0500, "")
+
+	stop := d.Continue()
+	if stop.Reason != StopBreakpoint || stop.PC != 0x0500 {
+		t.Fatalf("Expected breakpoint at $0500, got %v", stop)
+	}
+	frames := d.Frames()
+	if len(frames) != 1 || frames[0].Call != 0x0400 || frames[0].Return != 0x0403 {
+		t.Errorf("Wrong frames %+v", frames)
+	}
+
+	stop = d.Step()
+	if stop.Reason != StopStep || stop.PC != 0x0501 {
+		t.Errorf("Wrong step %v", stop)
+	}
+
+	stop = d.StepOver()
+	if stop.PC != 0x0504 || d.State().GetRegisters().Y != 1 {
+		t.Errorf("Wrong step over %v", stop)
+	}
+
+	stop = d.StepOut()
+	if stop.PC != 0x0403 {
+		t.Errorf("Wrong step out %v", stop)
+	}
+	if len(d.Frames()) != 0 {
+		t.Errorf("Frames not popped %+v", d.Frames())
+	}
+}
+
+func TestStepOutNested(t *testing.T) {
+	d := newTestDebugger()
+	d.AddBreakpoint(0x0501, "")
+	d.Continue()
+
+	stop := d.StepOut()
+	if stop.PC != 0x0403 {
+		t.Errorf("Step out stopped in the nested call, %v", stop)
+	}
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	d := newTestDebugger()
+	d.Memory().Poke(0x10, 0x42)
+	b, err := d.AddBreakpoint(0x0408, "A == $42 && [$2000] == $42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.AddBreakpoint(0x0403, "X == 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := d.Continue()
+	if stop.Reason != StopBreakpoint || stop.Breakpoint != b {
+		t.Errorf("Wrong stop %v", stop)
+	}
+	if b.Hits != 1 {
+		t.Errorf("Wrong hits %v", b.Hits)
+	}
+
+	if _, err := d.AddBreakpoint(0x0400, "A == "); err == nil {
+		t.Error("Invalid condition accepted")
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	d := newTestDebugger()
+	read := d.AddWatchpoint(0x10, 0x10, AccessRead)
+	write := d.AddWatchpoint(0x1ff0, 0x20ff, AccessWrite)
+
+	stop := d.Continue()
+	if stop.Reason != StopWatchpoint || stop.Watchpoint != read || stop.PC != 0x0405 {
+		t.Fatalf("Expected read watchpoint after LDA, got %v", stop)
+	}
+
+	stop = d.Continue()
+	if stop.Reason != StopWatchpoint || stop.Watchpoint != write || stop.Address != 0x2000 || stop.Access != AccessWrite {
+		t.Fatalf("Expected write watchpoint on STA, got %v", stop)
+	}
+
+	d.Remove(read.ID)
+	d.Remove(write.ID)
+	exec := d.AddWatchpoint(0x0600, 0x0600, AccessExecute)
+	d.State().SetPC(0x0400)
+	stop = d.Continue()
+	if stop.Reason != StopWatchpoint || stop.Watchpoint != exec || stop.PC != 0x0600 {
+		t.Errorf("Expected execute watchpoint, got %v", stop)
+	}
+
+	// The debugger memory does not trigger watchpoints
+	d.Memory().Peek(0x0600)
+	d.State().GetMemory().Peek(0x0600)
+	if exec.Hits != 1 {
+		t.Errorf("Watchpoint hit outside of the execution")
+	}
+}
+
+// accessRecorder records the kinds of the accesses
+type accessRecorder struct {
+	iz6502.FlatMemory
+	kinds map[uint32][]iz6502.AccessKind
+}
+
+func (m *accessRecorder) PeekAccess(address uint32, kind iz6502.AccessKind, cycle uint64) uint8 {
+	m.kinds[address] = append(m.kinds[address], kind)
+	return m.Peek(address)
+}
+
+func (m *accessRecorder) PokeAccess(address uint32, value uint8, kind iz6502.AccessKind, cycle uint64) {
+	m.kinds[address] = append(m.kinds[address], kind)
+	m.Poke(address, value)
+}
+
+func TestAccessKinds(t *testing.T) {
+	m := &accessRecorder{kinds: make(map[uint32][]iz6502.AccessKind)}
+	d := newTestDebuggerOn(m)
+	write := d.AddWatchpoint(0x2000, 0x2000, AccessWrite)
+
+	stop := d.Continue()
+	if stop.Reason != StopWatchpoint || stop.Watchpoint != write {
+		t.Fatalf("Expected the watchpoint, got %v", stop)
+	}
+	kinds := m.kinds
+	if len(kinds[0x0400]) != 1 || kinds[0x0400][0] != iz6502.AccessOpcodeFetch ||
+		len(kinds[0x01ff]) != 2 || kinds[0x01ff][0] != iz6502.AccessStackPush ||
+		len(kinds[0x2000]) != 1 || kinds[0x2000][0] != iz6502.AccessWrite {
+		t.Errorf("Wrong access kinds %v", kinds)
+	}
+}
+
+func TestRunToCycleAndInterrupt(t *testing.T) {
+	d := newTestDebugger()
+	stop := d.RunToCycle(100)
+	if stop.Reason != StopStep || stop.Cycles < 100 || stop.Cycles > 106 {
+		t.Errorf("Wrong run to cycle %v at %v", stop, stop.Cycles)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.Interrupt()
+	}()
+	stop = d.Continue()
+	if stop.Reason != StopInterrupted || stop.PC != 0x0408 {
+		t.Errorf("Wrong stop %v", stop)
+	}
+}
+
+func TestStepOver24T8(t *testing.T) {
+	m := new(iz6502.Flat256KMemory)
+	poke(m, 0x0400,
+		0x4f, 0x20, 0x00, 0x00, 0x01, // A24 JSR $010000
+		0xea) // NOP
+	poke(m, 0x010000,
+		0xe8,       // INX
+		0x4f, 0x60) // A24 RTS
+	s := iz6502.NewMythical65c24T8(m)
+	s.SetRegisters(iz6502.Registers{SP: 0xff, PC: 0x0400})
+	d := New(s)
+
+	stop := d.StepOver()
+	if stop.PC != 0x0405 || s.GetRegisters().X != 1 {
+		t.Errorf("Wrong step over a 24 bits JSR %v", stop)
+	}
+	if s.GetRegisters().SP != 0xff {
+		t.Errorf("Unbalanced stack, SP = $%x", s.GetRegisters().SP)
+	}
+}
+
+func TestDescribeWithSymbols(t *testing.T) {
+	d := newTestDebugger()
+	table := symbols.New()
+	table.Add("print", 0x0500, true)
+	d.SetSymbols(table)
+	d.AddBreakpoint(0x0501, "")
+
+	stop := d.Continue()
+	if text := d.Describe(stop); text != "breakpoint 1 at print+$1" {
+		t.Errorf("Wrong description '%v'", text)
+	}
+	if text := stop.String(); text != "breakpoint 1 at $0501" {
+		t.Errorf("Wrong description without symbols '%v'", text)
+	}
+}
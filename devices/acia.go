@@ -0,0 +1,285 @@
+package devices
+
+import (
+	"io"
+)
+
+/*
+MOS 6551 Asynchronous Communications Interface Adapter.
+
+The serial side is a host stream: the bytes written by the processor are
+written to it, and the bytes read from it are received. A goroutine reads the
+stream, and the bytes wait there until the processor reads the previous one,
+so there are no overruns. The end of the stream is reported by Err once the
+processor has read all the bytes received and polls the status again.
+
+With ClockHz set the characters take the time of their bits at the baud rate of
+the control register. Otherwise, or with the external clock selected, they are
+sent and received as soon as possible.
+*/
+
+// Registers of the 6551, by offset
+const (
+	aciaData    = 0x0
+	aciaStatus  = 0x1
+	aciaCommand = 0x2
+	aciaControl = 0x3
+)
+
+// Bits of the status register
+const (
+	ACIAStatusParity  uint8 = 0x01
+	ACIAStatusFraming uint8 = 0x02
+	ACIAStatusOverrun uint8 = 0x04
+	ACIAStatusRDRF    uint8 = 0x08 // Receiver data register full
+	ACIAStatusTDRE    uint8 = 0x10 // Transmitter data register empty
+	ACIAStatusDCD     uint8 = 0x20
+	ACIAStatusDSR     uint8 = 0x40
+	ACIAStatusIRQ     uint8 = 0x80
+)
+
+const (
+	aciaCommandDTR       = 0x01 // Receiver enabled
+	aciaCommandIRD       = 0x02 // Receiver interrupt disabled
+	aciaCommandTIC       = 0x0c // Transmitter interrupt control
+	aciaCommandTICEnable = 0x04 // Transmitter interrupt enabled
+	aciaCommandEcho      = 0x10
+	aciaCommandParity    = 0x20
+	aciaCommandReset     = 0x02
+	aciaControlStopBits  = 0x80
+	aciaReceiveBuffer    = 4096
+)
+
+var aciaBauds = [16]float64{0, 50, 75, 109.92, 134.58, 150, 300, 600, 1200,
+	1800, 2400, 3600, 4800, 7200, 9600, 19200}
+
+// ACIA is a 6551 mounted on 4 addresses
+type ACIA struct {
+	// ClockHz is the clock of the processor, to pace the characters
+	ClockHz uint64
+
+	stream  io.ReadWriter
+	rx      chan uint8
+	done    chan struct{}
+	err     error // Writing the stream
+	readErr error // Set by the goroutine before closing rx
+	drained bool  // rx closed and all its bytes received
+	rxErr   error // readErr once polled after the last byte
+
+	data    uint8
+	status  uint8
+	command uint8
+	control uint8
+	irq     bool
+
+	txCycles uint64 // Until the character sent is done
+	rxCycles uint64 // Until the next character can be received
+}
+
+// NewACIA returns an ACIA after reset, sending to and receiving from the
+// stream. The stream can be nil for no serial connection.
+func NewACIA(rw io.ReadWriter) *ACIA {
+	var a ACIA
+	a.rx = make(chan uint8, aciaReceiveBuffer)
+	a.done = make(chan struct{})
+	if rw != nil {
+		a.stream = rw
+		go a.receiveFrom(rw)
+	}
+	a.Reset()
+	return &a
+}
+
+func (a *ACIA) receiveFrom(r io.Reader) {
+	buf := make([]uint8, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			select {
+			case a.rx <- buf[0]:
+			case <-a.done:
+				return
+			}
+		}
+		if err != nil {
+			a.readErr = err
+			close(a.rx)
+			return
+		}
+	}
+}
+
+// Close stops receiving from the stream, and closes it if it is an io.Closer
+func (a *ACIA) Close() error {
+	select {
+	case <-a.done:
+		return nil
+	default:
+	}
+	close(a.done)
+	if c, ok := a.stream.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Reset sets the registers as the RES line does
+func (a *ACIA) Reset() {
+	a.status = ACIAStatusTDRE
+	a.command = aciaCommandReset
+	a.control = 0
+	a.irq = false
+	a.txCycles = 0
+	a.rxCycles = 0
+}
+
+// Err returns the first error writing to the stream. Otherwise, once the
+// processor polls the status after the last byte received, the error reading
+// it: io.EOF at its end.
+func (a *ACIA) Err() error {
+	if a.err != nil {
+		return a.err
+	}
+	return a.rxErr
+}
+
+// IRQ returns true from an interrupt until the status is read
+func (a *ACIA) IRQ() bool {
+	return a.irq
+}
+
+// Read returns the register at offset, the registers repeat every 4 bytes
+func (a *ACIA) Read(offset uint32) uint8 {
+	switch offset & 0x3 {
+	case aciaData:
+		a.status &^= ACIAStatusRDRF | ACIAStatusOverrun
+		return a.data
+	case aciaStatus:
+		status := a.status
+		if a.drained && status&ACIAStatusRDRF == 0 {
+			a.rxErr = a.readErr
+		}
+		if a.irq {
+			status |= ACIAStatusIRQ
+			a.irq = false
+		}
+		return status
+	case aciaCommand:
+		return a.command
+	default: // aciaControl
+		return a.control
+	}
+}
+
+// Write sets the register at offset, the registers repeat every 4 bytes
+func (a *ACIA) Write(offset uint32, value uint8) {
+	switch offset & 0x3 {
+	case aciaData:
+		a.transmit(value)
+	case aciaStatus:
+		// Programmed reset
+		a.command = a.command&0xe0 | aciaCommandReset
+		a.status &^= ACIAStatusOverrun
+	case aciaCommand:
+		a.command = value
+		if a.status&ACIAStatusTDRE != 0 && a.transmitInterrupts() {
+			a.irq = true
+		}
+	default: // aciaControl
+		a.control = value
+	}
+}
+
+// Tick ends the character being sent and receives the next one
+func (a *ACIA) Tick(cycles uint64) {
+	if a.txCycles > 0 {
+		if cycles < a.txCycles {
+			a.txCycles -= cycles
+		} else {
+			a.txCycles = 0
+			a.transmitted()
+		}
+	}
+
+	if a.rxCycles > cycles {
+		a.rxCycles -= cycles
+		return
+	}
+	a.rxCycles = 0
+	if a.command&aciaCommandDTR == 0 || a.status&ACIAStatusRDRF != 0 || a.drained {
+		return
+	}
+	select {
+	case value, ok := <-a.rx:
+		if !ok {
+			a.drained = true
+			return
+		}
+		a.receive(value)
+	default:
+	}
+}
+
+func (a *ACIA) transmitInterrupts() bool {
+	return a.command&aciaCommandTIC == aciaCommandTICEnable
+}
+
+func (a *ACIA) transmit(value uint8) {
+	a.send(value & a.wordMask())
+	a.status &^= ACIAStatusTDRE
+	a.txCycles = a.characterCycles()
+	if a.txCycles == 0 {
+		a.transmitted()
+	}
+}
+
+func (a *ACIA) transmitted() {
+	a.status |= ACIAStatusTDRE
+	if a.transmitInterrupts() {
+		a.irq = true
+	}
+}
+
+func (a *ACIA) receive(value uint8) {
+	a.data = value & a.wordMask()
+	a.status |= ACIAStatusRDRF
+	a.rxCycles = a.characterCycles()
+	if a.command&aciaCommandIRD == 0 {
+		a.irq = true
+	}
+	if a.command&aciaCommandEcho != 0 {
+		a.send(a.data)
+	}
+}
+
+func (a *ACIA) send(value uint8) {
+	if a.stream == nil || a.err != nil {
+		return
+	}
+	_, a.err = a.stream.Write([]uint8{value})
+}
+
+func (a *ACIA) wordLength() int {
+	return 8 - int(a.control>>5)&0x3
+}
+
+func (a *ACIA) wordMask() uint8 {
+	return uint8(0xff >> uint(8-a.wordLength()))
+}
+
+// characterCycles returns the cycles of a character with the start, data,
+// parity and stop bits
+func (a *ACIA) characterCycles() uint64 {
+	baud := aciaBauds[a.control&0xf]
+	if a.ClockHz == 0 || baud == 0 {
+		return 0
+	}
+	bits := 1 + a.wordLength() + 1
+	if a.command&aciaCommandParity != 0 {
+		bits++
+	}
+	if a.control&aciaControlStopBits != 0 {
+		bits++
+	}
+	return uint64(float64(a.ClockHz) * float64(bits) / baud)
+}
@@ -0,0 +1,188 @@
+package devices
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/runner"
+)
+
+type testStream struct {
+	io.Reader
+	io.Writer
+}
+
+func newTestACIA(input string) (*ACIA, *bytes.Buffer) {
+	out := new(bytes.Buffer)
+	return NewACIA(testStream{strings.NewReader(input), out}), out
+}
+
+// waitReceive ticks until a byte is received from the goroutine reading the
+// stream
+func waitReceive(t *testing.T, a *ACIA) {
+	deadline := time.Now().Add(time.Second)
+	for a.status&ACIAStatusRDRF == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Nothing received")
+		}
+		time.Sleep(time.Millisecond)
+		a.Tick(1)
+	}
+}
+
+func TestACIATransmit(t *testing.T) {
+	a, out := newTestACIA("")
+	a.Write(aciaData, 'A')
+	if out.String() != "A" || a.Read(aciaStatus)&ACIAStatusTDRE == 0 {
+		t.Errorf("Wrong transmit '%v'", out.String())
+	}
+	if a.IRQ() {
+		t.Error("Transmit interrupt not enabled")
+	}
+	a.Write(aciaCommand, 0x05)
+	if !a.IRQ() || a.Read(aciaStatus)&ACIAStatusIRQ == 0 || a.IRQ() {
+		t.Error("Wrong transmit interrupt")
+	}
+
+	// 7 bits
+	a.Write(aciaControl, 0x20)
+	a.Write(aciaData, 0xc1)
+	if out.Bytes()[1] != 0x41 {
+		t.Errorf("Wrong 7 bits transmit $%02x", out.Bytes()[1])
+	}
+}
+
+func TestACIATiming(t *testing.T) {
+	a, out := newTestACIA("")
+	a.ClockHz = 1000000
+	a.Write(aciaControl, 0x1e) // 9600 bauds, 8 bits, 1 stop bit
+	a.Write(aciaData, 'A')
+	if out.String() != "A" || a.Read(aciaStatus)&ACIAStatusTDRE != 0 {
+		t.Fatal("Transmit register empty while sending")
+	}
+	// 10 bits at 9600 bauds
+	a.Tick(1000)
+	if a.Read(aciaStatus)&ACIAStatusTDRE != 0 {
+		t.Fatal("Character sent too fast")
+	}
+	a.Tick(41)
+	if a.Read(aciaStatus)&ACIAStatusTDRE == 0 {
+		t.Fatal("Character sent too slow")
+	}
+}
+
+func TestACIAReceive(t *testing.T) {
+	a, _ := newTestACIA("hi")
+	a.Tick(1)
+	if a.Read(aciaStatus)&ACIAStatusRDRF != 0 {
+		t.Fatal("Received with the receiver disabled")
+	}
+
+	a.Write(aciaCommand, 0x09) // Receiver and its interrupt enabled
+	waitReceive(t, a)
+	if !a.IRQ() {
+		t.Fatal("No receive interrupt")
+	}
+	if status := a.Read(aciaStatus); status&(ACIAStatusIRQ|ACIAStatusRDRF) != ACIAStatusIRQ|ACIAStatusRDRF || a.IRQ() {
+		t.Errorf("Wrong status $%02x", status)
+	}
+	// Waits for the byte to be read
+	time.Sleep(10 * time.Millisecond)
+	a.Tick(1)
+	if data := a.Read(aciaData); data != 'h' {
+		t.Errorf("Received '%c'", data)
+	}
+	if a.Read(aciaStatus)&ACIAStatusRDRF != 0 {
+		t.Error("Data read did not empty the receiver")
+	}
+	waitReceive(t, a)
+	if data := a.Read(aciaData); data != 'i' {
+		t.Errorf("Received '%c'", data)
+	}
+
+	a.Write(aciaStatus, 0)
+	if a.Read(aciaCommand) != aciaCommandReset {
+		t.Error("Wrong programmed reset")
+	}
+}
+
+func TestACIAEcho(t *testing.T) {
+	a, out := newTestACIA("hello\r")
+	bus := NewBus(new(iz6502.FlatMemory))
+	bus.Mount(0x8000, 4, a)
+
+	// Echoes the characters received until CR
+	program := []uint8{
+		0xa9, 0x0b, // LDA #$0b
+		0x8d, 0x02, 0x80, // STA COMMAND, no interrupts
+		0xad, 0x01, 0x80, // loop: LDA STATUS
+		0x29, 0x08, // AND #$08
+		0xf0, 0xf9, // BEQ loop
+		0xad, 0x00, 0x80, // LDA DATA
+		0x8d, 0x00, 0x80, // STA DATA
+		0xc9, 0x0d, // CMP #$0d
+		0xd0, 0xef, // BNE loop
+		0x00, // BRK
+	}
+	for i, v := range program {
+		bus.Poke(0x0400+uint32(i), v)
+	}
+	s := iz6502.NewCMOS65c02(bus)
+	s.SetPC(0x0400)
+	r := runner.Run(s, runner.Config{Hook: bus.Hook, StopOnBRK: true, Timeout: time.Second})
+	if r.Reason != runner.ReasonBRK || out.String() != "hello\r" {
+		t.Errorf("Wrong echo '%v', %v", out.String(), &r)
+	}
+}
+
+func TestACIAEndOfStream(t *testing.T) {
+	a, _ := newTestACIA("h")
+	a.Write(aciaCommand, 0x0b) // Receiver enabled, no interrupts
+	waitReceive(t, a)
+	// The end waits for the byte to be read
+	time.Sleep(10 * time.Millisecond)
+	a.Tick(1)
+	a.Read(aciaStatus)
+	if a.Err() != nil {
+		t.Fatalf("End reported before reading the data: %v", a.Err())
+	}
+	if data := a.Read(aciaData); data != 'h' {
+		t.Errorf("Received '%c'", data)
+	}
+	deadline := time.Now().Add(time.Second)
+	for a.Err() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("End not reported")
+		}
+		time.Sleep(time.Millisecond)
+		a.Tick(1)
+		a.Read(aciaStatus)
+	}
+	if a.Err() != io.EOF {
+		t.Errorf("Wrong error %v", a.Err())
+	}
+}
+
+type testClosingStream struct {
+	*io.PipeReader
+	io.Writer
+}
+
+func TestACIAClose(t *testing.T) {
+	r, w := io.Pipe()
+	a := NewACIA(testClosingStream{r, ioutil.Discard})
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]uint8{'h'}); err != io.ErrClosedPipe {
+		t.Errorf("Stream not closed: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("Second close: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Package devices has the memory mapped I/O chips of the 6502 boards and the bus
+to mount them on top of a memory.
+
+A Device sees the accesses to its registers by offset, is clocked with the
+cycles elapsed and exposes its interrupt output. The Bus routes the accesses of
+the processor to the devices mounted and to the backing memory for the rest of
+the addresses, and drives the IRQ line of the processor with the outputs of the
+devices.
+*/
+package devices
+
+import (
+	"fmt"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+// Device is a chip mounted on the Bus
+type Device interface {
+	// Read returns the register at offset from the start of the device
+	Read(offset uint32) uint8
+	// Write sets the register at offset from the start of the device
+	Write(offset uint32, value uint8)
+	// Tick advances the device by the processor cycles elapsed
+	Tick(cycles uint64)
+	// IRQ returns true while the device asserts its interrupt output
+	IRQ() bool
+}
+
+type mount struct {
+	address uint32
+	size    uint32
+	device  Device
+}
+
+// Bus is a Memory with devices mounted over a backing memory. The devices are
+// clocked up to the cycle of each access to them, and up to the processor
+// cycles on Sync.
+type Bus struct {
+	mem    iz6502.Memory
+	access iz6502.AccessMemory
+	mounts []mount
+	cycles uint64
+}
+
+// NewBus returns a bus with no devices over the memory
+func NewBus(mem iz6502.Memory) *Bus {
+	var b Bus
+	b.mem = mem
+	b.access, _ = mem.(iz6502.AccessMemory)
+	return &b
+}
+
+// Mount puts the device on size addresses starting at address
+func (b *Bus) Mount(address uint32, size uint32, d Device) error {
+	if size == 0 {
+		return fmt.Errorf("device at $%04x with no addresses", address)
+	}
+	for _, m := range b.mounts {
+		if address < m.address+m.size && m.address < address+size {
+			return fmt.Errorf("device at $%04x-$%04x overlaps the one at $%04x-$%04x",
+				address, address+size-1, m.address, m.address+m.size-1)
+		}
+	}
+	b.mounts = append(b.mounts, mount{address, size, d})
+	return nil
+}
+
+// Unmount removes the device from the bus
+func (b *Bus) Unmount(d Device) {
+	for i, m := range b.mounts {
+		if m.device == d {
+			b.mounts = append(b.mounts[:i], b.mounts[i+1:]...)
+			return
+		}
+	}
+}
+
+// Devices returns the devices mounted, in mount order
+func (b *Bus) Devices() []Device {
+	devices := make([]Device, len(b.mounts))
+	for i, m := range b.mounts {
+		devices[i] = m.device
+	}
+	return devices
+}
+
+func (b *Bus) find(address uint32) (*mount, bool) {
+	for i := range b.mounts {
+		m := &b.mounts[i]
+		if address >= m.address && address-m.address < m.size {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// advance clocks the devices up to the cycle
+func (b *Bus) advance(cycle uint64) {
+	if cycle <= b.cycles {
+		return
+	}
+	elapsed := cycle - b.cycles
+	b.cycles = cycle
+	for _, m := range b.mounts {
+		m.device.Tick(elapsed)
+	}
+}
+
+// Sync clocks the devices up to the cycles of the processor and sets its IRQ
+// line to the outputs of the devices. The bus owns the IRQ line of the
+// processor.
+func (b *Bus) Sync(s *iz6502.State) {
+	b.advance(s.GetCycles())
+	if b.IRQ() {
+		s.RaiseIRQ()
+	} else {
+		s.ClearIRQ()
+	}
+}
+
+// Hook syncs the bus, to be used as the runner hook
+func (b *Bus) Hook(s *iz6502.State) error {
+	b.Sync(s)
+	return nil
+}
+
+// IRQ returns true if any device asserts its interrupt output
+func (b *Bus) IRQ() bool {
+	for _, m := range b.mounts {
+		if m.device.IRQ() {
+			return true
+		}
+	}
+	return false
+}
+
+// Cycles returns the cycle the devices are clocked to
+func (b *Bus) Cycles() uint64 {
+	return b.cycles
+}
+
+// Peek returns the data on the given address
+func (b *Bus) Peek(address uint32) uint8 {
+	if m, ok := b.find(address); ok {
+		return m.device.Read(address - m.address)
+	}
+	return b.mem.Peek(address)
+}
+
+// PeekCode returns the data on the given address
+func (b *Bus) PeekCode(address uint32) uint8 {
+	if m, ok := b.find(address); ok {
+		return m.device.Read(address - m.address)
+	}
+	return b.mem.PeekCode(address)
+}
+
+// Poke sets the data at the given address
+func (b *Bus) Poke(address uint32, value uint8) {
+	if m, ok := b.find(address); ok {
+		m.device.Write(address-m.address, value)
+		return
+	}
+	b.mem.Poke(address, value)
+}
+
+// PeekAccess clocks the devices to the cycle before reading one
+func (b *Bus) PeekAccess(address uint32, kind iz6502.AccessKind, cycle uint64) uint8 {
+	if m, ok := b.find(address); ok {
+		b.advance(cycle)
+		return m.device.Read(address - m.address)
+	}
+	if b.access != nil {
+		return b.access.PeekAccess(address, kind, cycle)
+	}
+	if kind == iz6502.AccessOpcodeFetch || kind == iz6502.AccessOperandFetch {
+		return b.mem.PeekCode(address)
+	}
+	return b.mem.Peek(address)
+}
+
+// PokeAccess clocks the devices to the cycle before writing one
+func (b *Bus) PokeAccess(address uint32, value uint8, kind iz6502.AccessKind, cycle uint64) {
+	if m, ok := b.find(address); ok {
+		b.advance(cycle)
+		m.device.Write(address-m.address, value)
+		return
+	}
+	if b.access != nil {
+		b.access.PokeAccess(address, value, kind, cycle)
+		return
+	}
+	b.mem.Poke(address, value)
+}
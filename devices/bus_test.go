@@ -0,0 +1,142 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/runner"
+)
+
+// testTimer counts down the cycles and interrupts at zero. Writing offset 0
+// restarts it and acknowledges the interrupt, offset 1 reads the cycles.
+type testTimer struct {
+	start   uint64
+	counter uint64
+	elapsed uint64
+	irq     bool
+}
+
+func (d *testTimer) Read(offset uint32) uint8 {
+	if offset == 1 {
+		return uint8(d.elapsed)
+	}
+	return uint8(d.counter)
+}
+
+func (d *testTimer) Write(offset uint32, value uint8) {
+	d.start = uint64(value)
+	d.counter = d.start
+	d.irq = false
+}
+
+func (d *testTimer) Tick(cycles uint64) {
+	d.elapsed += cycles
+	if d.counter == 0 {
+		return
+	}
+	if cycles >= d.counter {
+		d.counter = 0
+		d.irq = true
+	} else {
+		d.counter -= cycles
+	}
+}
+
+func (d *testTimer) IRQ() bool {
+	return d.irq
+}
+
+func TestBusRouting(t *testing.T) {
+	mem := new(iz6502.FlatMemory)
+	b := NewBus(mem)
+	timer := new(testTimer)
+	if err := b.Mount(0xd000, 0x10, timer); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Mount(0xd00f, 0x10, new(testTimer)); err == nil {
+		t.Error("Overlapping device mounted")
+	}
+
+	b.Poke(0xd000, 0x20)
+	b.Poke(0xd010, 0x33)
+	if timer.start != 0x20 || mem.Peek(0xd000) != 0 || mem.Peek(0xd010) != 0x33 {
+		t.Error("Wrong write routing")
+	}
+	if b.Peek(0xd000) != 0x20 || b.PeekCode(0xd010) != 0x33 {
+		t.Error("Wrong read routing")
+	}
+
+	b.Unmount(timer)
+	if b.Peek(0xd000) != 0 || len(b.Devices()) != 0 {
+		t.Error("Device not unmounted")
+	}
+}
+
+func TestBusClock(t *testing.T) {
+	mem := new(iz6502.FlatMemory)
+	b := NewBus(mem)
+	timer := new(testTimer)
+	b.Mount(0xd000, 0x10, timer)
+	program := []uint8{
+		0xea,             // NOP
+		0xea,             // NOP
+		0xad, 0x01, 0xd0, // LDA $d001
+	}
+	for i, v := range program {
+		mem.Poke(0x0400+uint32(i), v)
+	}
+	s := iz6502.NewCMOS65c02(b)
+	s.SetPC(0x0400)
+	for i := 0; i < 3; i++ {
+		s.Step()
+	}
+	// The device is clocked up to the read, after the two NOPs
+	if r := s.GetRegisters(); r.A != 4 {
+		t.Errorf("Device read at cycle %v", r.A)
+	}
+	b.Sync(s)
+	if timer.elapsed != s.GetCycles() || b.Cycles() != s.GetCycles() {
+		t.Errorf("Device at %v cycles, processor at %v", timer.elapsed, s.GetCycles())
+	}
+}
+
+func TestBusIRQ(t *testing.T) {
+	mem := new(iz6502.FlatMemory)
+	b := NewBus(mem)
+	timer := new(testTimer)
+	b.Mount(0xd000, 0x10, timer)
+	program := []uint8{
+		0xa9, 0x40, // LDA #$40
+		0x8d, 0x00, 0xd0, // STA $d000
+		0x58,             // CLI
+		0xe8,             // INX
+		0x4c, 0x06, 0x04, // JMP $0406
+	}
+	for i, v := range program {
+		mem.Poke(0x0400+uint32(i), v)
+	}
+	handler := []uint8{
+		0xee, 0x00, 0x02, // INC $0200
+		0x8d, 0x00, 0xd0, // STA $d000, acknowledges
+		0x40, // RTI
+	}
+	for i, v := range handler {
+		mem.Poke(0x0500+uint32(i), v)
+	}
+	mem.Poke(0xfffe, 0x00)
+	mem.Poke(0xffff, 0x05)
+
+	s := iz6502.NewCMOS65c02(b)
+	s.SetRegisters(iz6502.Registers{SP: 0xff, P: 0x24, PC: 0x0400})
+	r := runner.Run(s, runner.Config{Hook: b.Hook, MaxCycles: 1000})
+	if r.Reason != runner.ReasonCycles {
+		t.Fatalf("Wrong result %v", &r)
+	}
+	// An interrupt each $40 cycles plus the handler
+	if n := mem.Peek(0x0200); n < 10 || n > 16 {
+		t.Errorf("%v interrupts in 1000 cycles", n)
+	}
+	if s.GetIRQ() != timer.IRQ() {
+		t.Error("IRQ line not driven by the device")
+	}
+}
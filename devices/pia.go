@@ -0,0 +1,238 @@
+package devices
+
+/*
+MOS 6520 and Motorola 6821 Peripheral Interface Adapter.
+
+Each side has a data direction register and an output register sharing the
+same offset, selected by bit 2 of the control register, and two control lines.
+C1 is an interrupt input, C2 an interrupt input or an output, manual or as a
+handshake. Reading the output register clears the interrupt flags of its side.
+
+The IRQA and IRQB outputs are usually wired together to the IRQ line of the
+processor, IRQ returns both.
+*/
+
+// Bits of the control registers
+const (
+	PIAControlC1Enable = 0x01
+	PIAControlC1Rising = 0x02
+	PIAControlOutput   = 0x04 // The output register instead of the DDR
+	PIAControlC2Enable = 0x08 // As an input
+	PIAControlC2Rising = 0x10 // As an input
+	PIAControlC2Output = 0x20
+	PIAControlIRQ2     = 0x40
+	PIAControlIRQ1     = 0x80
+)
+
+const (
+	piaC2Manual   = 0x10 // As an output, C2 follows bit 3
+	piaC2Pulse    = 0x08 // As a handshake output, a pulse of one cycle
+	piaC2Level    = 0x08
+	piaWritableCR = 0x3f
+)
+
+// piaSide is the port and control lines of a side of the PIA
+type piaSide struct {
+	or, ddr, cr uint8
+	in          uint8
+	c1, c2      bool
+	c2Out       bool
+	c2Pulse     bool
+}
+
+// PIA is a 6520 or 6821 mounted on 4 addresses
+type PIA struct {
+	// OutputA and OutputB are called with the pins of the port when the
+	// outputs change. The bits programmed as inputs read high.
+	OutputA func(pins uint8)
+	OutputB func(pins uint8)
+	// OutputCA2 and OutputCB2 are called with the level of the control line
+	// when programmed as an output
+	OutputCA2 func(high bool)
+	OutputCB2 func(high bool)
+
+	a, b piaSide
+}
+
+// NewPIA returns a PIA after reset, with the inputs pulled up
+func NewPIA() *PIA {
+	var p PIA
+	p.a.in, p.b.in = 0xff, 0xff
+	p.a.c1, p.a.c2, p.b.c1, p.b.c2 = true, true, true, true
+	p.Reset()
+	return &p
+}
+
+// Reset clears the registers as the RES line does
+func (p *PIA) Reset() {
+	for _, side := range []*piaSide{&p.a, &p.b} {
+		side.or, side.ddr, side.cr = 0, 0, 0
+		side.c2Out = true
+		side.c2Pulse = false
+	}
+}
+
+func (p *PIA) side(offset uint32) *piaSide {
+	if offset&0x2 == 0 {
+		return &p.a
+	}
+	return &p.b
+}
+
+// IRQA returns true while an enabled interrupt flag of side A is set
+func (p *PIA) IRQA() bool {
+	return p.a.irq()
+}
+
+// IRQB returns true while an enabled interrupt flag of side B is set
+func (p *PIA) IRQB() bool {
+	return p.b.irq()
+}
+
+// IRQ returns true if IRQA or IRQB are asserted
+func (p *PIA) IRQ() bool {
+	return p.a.irq() || p.b.irq()
+}
+
+// Read returns the register at offset, the registers repeat every 4 bytes
+func (p *PIA) Read(offset uint32) uint8 {
+	side := p.side(offset)
+	if offset&0x1 != 0 {
+		return side.cr
+	}
+	if side.cr&PIAControlOutput == 0 {
+		return side.ddr
+	}
+
+	side.cr &^= PIAControlIRQ1 | PIAControlIRQ2
+	if side == &p.a {
+		// Side A does the handshake on reads
+		p.handshake(side)
+	}
+	return side.or&side.ddr | side.in&^side.ddr
+}
+
+// Write sets the register at offset, the registers repeat every 4 bytes
+func (p *PIA) Write(offset uint32, value uint8) {
+	side := p.side(offset)
+	if offset&0x1 != 0 {
+		side.cr = side.cr&^piaWritableCR | value&piaWritableCR
+		if side.cr&PIAControlC2Output != 0 && side.cr&piaC2Manual != 0 {
+			p.setC2(side, side.cr&piaC2Level != 0)
+		}
+		return
+	}
+	if side.cr&PIAControlOutput == 0 {
+		side.ddr = value
+	} else {
+		side.or = value
+	}
+	output := p.OutputA
+	if side == &p.b {
+		output = p.OutputB
+	}
+	if output != nil {
+		output(side.or&side.ddr | ^side.ddr)
+	}
+	if side == &p.b && side.cr&PIAControlOutput != 0 {
+		// Side B does the handshake on writes, with the data already output
+		p.handshake(side)
+	}
+}
+
+// Tick ends the pulses on CA2 and CB2
+func (p *PIA) Tick(cycles uint64) {
+	for _, side := range []*piaSide{&p.a, &p.b} {
+		if side.c2Pulse && cycles > 0 {
+			side.c2Pulse = false
+			p.setC2(side, true)
+		}
+	}
+}
+
+func (s *piaSide) irq() bool {
+	if s.cr&PIAControlIRQ1 != 0 && s.cr&PIAControlC1Enable != 0 {
+		return true
+	}
+	return s.cr&PIAControlIRQ2 != 0 && s.cr&PIAControlC2Output == 0 && s.cr&PIAControlC2Enable != 0
+}
+
+// handshake drives C2 low on an access to the output register
+func (p *PIA) handshake(s *piaSide) {
+	if s.cr&PIAControlC2Output == 0 || s.cr&piaC2Manual != 0 {
+		return
+	}
+	p.setC2(s, false)
+	if s.cr&piaC2Pulse != 0 {
+		s.c2Pulse = true
+	}
+}
+
+func (p *PIA) setC2(s *piaSide, high bool) {
+	if s.c2Out == high {
+		return
+	}
+	s.c2Out = high
+	output := p.OutputCA2
+	if s == &p.b {
+		output = p.OutputCB2
+	}
+	if output != nil {
+		output(high)
+	}
+}
+
+func (p *PIA) setC1(s *piaSide, high bool) {
+	if s.c1 == high {
+		return
+	}
+	s.c1 = high
+	if high != (s.cr&PIAControlC1Rising != 0) {
+		return
+	}
+	s.cr |= PIAControlIRQ1
+	if s.cr&PIAControlC2Output != 0 && s.cr&(piaC2Manual|piaC2Pulse) == 0 {
+		// The active edge of C1 ends the handshake
+		p.setC2(s, true)
+	}
+}
+
+func (s *piaSide) setC2Input(high bool) {
+	if s.c2 == high {
+		return
+	}
+	s.c2 = high
+	if s.cr&PIAControlC2Output == 0 && high == (s.cr&PIAControlC2Rising != 0) {
+		s.cr |= PIAControlIRQ2
+	}
+}
+
+// SetInputA drives the pins of port A programmed as inputs
+func (p *PIA) SetInputA(pins uint8) {
+	p.a.in = pins
+}
+
+// SetInputB drives the pins of port B programmed as inputs
+func (p *PIA) SetInputB(pins uint8) {
+	p.b.in = pins
+}
+
+// SetCA1 drives the CA1 input
+func (p *PIA) SetCA1(high bool) {
+	p.setC1(&p.a, high)
+}
+
+// SetCA2 drives the CA2 line when programmed as an input
+func (p *PIA) SetCA2(high bool) {
+	p.a.setC2Input(high)
+}
+
+// SetCB1 drives the CB1 input
+func (p *PIA) SetCB1(high bool) {
+	p.setC1(&p.b, high)
+}
+
+// SetCB2 drives the CB2 line when programmed as an input
+func (p *PIA) SetCB2(high bool) {
+	p.b.setC2Input(high)
+}
@@ -0,0 +1,127 @@
+package devices
+
+import (
+	"testing"
+)
+
+func TestPIAPorts(t *testing.T) {
+	p := NewPIA()
+	var outA uint8
+	p.OutputA = func(pins uint8) {
+		outA = pins
+	}
+	// The DDR is selected after reset
+	p.Write(0x0, 0x0f)
+	p.Write(0x1, PIAControlOutput)
+	p.Write(0x0, 0x35)
+	if outA != 0xf5 {
+		t.Errorf("Wrong port A outputs $%02x", outA)
+	}
+	p.SetInputA(0xa0)
+	if a := p.Read(0x0); a != 0xa5 {
+		t.Errorf("Wrong port A read $%02x", a)
+	}
+	p.Write(0x1, 0)
+	if p.Read(0x0) != 0x0f {
+		t.Error("DDR not selected")
+	}
+
+	p.Write(0x2, 0xff)
+	p.Write(0x3, PIAControlOutput)
+	p.Write(0x2, 0x42)
+	if p.Read(0x2) != 0x42 {
+		t.Error("Wrong port B")
+	}
+}
+
+func TestPIAInterrupts(t *testing.T) {
+	p := NewPIA()
+	p.Write(0x1, PIAControlOutput|PIAControlC1Enable)
+	p.SetCA1(false)
+	if !p.IRQ() || !p.IRQA() || p.IRQB() || p.Read(0x1)&PIAControlIRQ1 == 0 {
+		t.Fatal("CA1 negative edge did not interrupt")
+	}
+	p.SetCA1(true)
+	p.Read(0x0)
+	if p.IRQ() || p.Read(0x1)&PIAControlIRQ1 != 0 {
+		t.Fatal("Reading port A did not clear the interrupt")
+	}
+
+	// CB2 positive edge, flag only
+	p.Write(0x3, PIAControlOutput|PIAControlC2Rising)
+	p.SetCB2(false)
+	p.SetCB2(true)
+	if p.IRQ() || p.Read(0x3)&PIAControlIRQ2 == 0 {
+		t.Fatal("Wrong CB2 flag")
+	}
+	p.Write(0x3, PIAControlOutput|PIAControlC2Rising|PIAControlC2Enable)
+	if !p.IRQB() {
+		t.Fatal("CB2 did not interrupt once enabled")
+	}
+	p.Write(0x3, 0x3f)
+	if p.Read(0x3)&PIAControlIRQ2 == 0 {
+		t.Error("Flags written")
+	}
+	p.Read(0x2)
+	if p.Read(0x3)&PIAControlIRQ2 != 0 {
+		t.Error("Reading port B did not clear the flags")
+	}
+}
+
+func TestPIAControlOutputs(t *testing.T) {
+	p := NewPIA()
+	var ca2, cb2 []bool
+	p.OutputCA2 = func(high bool) {
+		ca2 = append(ca2, high)
+	}
+	p.OutputCB2 = func(high bool) {
+		cb2 = append(cb2, high)
+	}
+
+	// CA2 low from the read of port A to the active edge of CA1
+	p.Write(0x1, PIAControlOutput|PIAControlC2Output)
+	p.Read(0x0)
+	p.Tick(10)
+	if len(ca2) != 1 || ca2[0] {
+		t.Fatalf("Wrong handshake %v", ca2)
+	}
+	p.SetCA1(false)
+	if len(ca2) != 2 || !ca2[1] {
+		t.Fatalf("Handshake not ended by CA1 %v", ca2)
+	}
+
+	// CB2 low for a cycle after a write to port B
+	p.Write(0x3, PIAControlOutput|PIAControlC2Output|piaC2Pulse)
+	p.Write(0x2, 0)
+	p.Tick(1)
+	if len(cb2) != 2 || cb2[0] || !cb2[1] {
+		t.Fatalf("Wrong pulse %v", cb2)
+	}
+
+	// Manual
+	cb2 = nil
+	p.Write(0x3, PIAControlC2Output|piaC2Manual)
+	p.Write(0x3, PIAControlC2Output|piaC2Manual|piaC2Level)
+	if len(cb2) != 2 || cb2[0] || !cb2[1] {
+		t.Fatalf("Wrong manual CB2 %v", cb2)
+	}
+}
+
+func TestPIAHandshakeData(t *testing.T) {
+	p := NewPIA()
+	var pins, latched uint8
+	p.OutputB = func(out uint8) {
+		pins = out
+	}
+	p.OutputCB2 = func(high bool) {
+		if !high {
+			latched = pins
+		}
+	}
+	p.Write(0x2, 0xff)
+	p.Write(0x3, PIAControlOutput|PIAControlC2Output|piaC2Pulse)
+	p.Write(0x2, 0x41)
+	if latched != 0x41 {
+		t.Errorf("CB2 low with $%02x on port B", latched)
+	}
+}
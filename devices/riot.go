@@ -0,0 +1,221 @@
+package devices
+
+/*
+MOS 6532 RAM-I/O-Timer.
+
+The RAM and the I/O are selected by the RS pin, decoded differently on each
+board, so they are two devices: RAM() on 128 addresses and the RIOT itself,
+with the ports and the timer, on 32 addresses.
+
+The 6530 of the KIM-1 has the same ports and timer, with the timer written on
+all the addresses with A2 set and without the PA7 edge detection. Its ROM is
+mapped apart. Set Layout6530 for its registers.
+
+Writing the timer loads N and the prescaler. It reads N-1 right after the
+write and interrupts N times the prescaler cycles later, then decrements every
+cycle from $ff until written again.
+*/
+
+// Bits of the interrupt flags of the 6532
+const (
+	RIOTIntPA7   uint8 = 0x40
+	RIOTIntTimer uint8 = 0x80
+)
+
+const (
+	riotRAMSize       = 128
+	riotTimerSelect   = 0x04 // A2 selects the timer and flags
+	riotTimerWrite    = 0x10 // A4 writes the timer instead of the edge control
+	riotTimerIRQ      = 0x08 // A3 enables the timer interrupt
+	riotReadFlags     = 0x01 // A0 reads the flags instead of the timer
+	riotEdgePositive  = 0x01 // A0 sets the PA7 edge
+	riotEdgeIRQ       = 0x02 // A1 enables the PA7 interrupt
+	riotPortRegisters = 0x03
+)
+
+var riotPrescalerShifts = [4]uint{0, 3, 6, 10}
+
+// RIOT is a 6532 with its I/O mounted on 32 addresses
+type RIOT struct {
+	// OutputA and OutputB are called with the pins of the port when the
+	// outputs change. The bits programmed as inputs read high.
+	OutputA func(pins uint8)
+	OutputB func(pins uint8)
+	// Layout6530 decodes the registers as the I/O of a 6530
+	Layout6530 bool
+
+	ram        [riotRAMSize]uint8
+	ora, orb   uint8
+	ddra, ddrb uint8
+	inA, inB   uint8
+
+	timer      int64 // Cycles left, negative after the time out
+	shift      uint
+	timerIRQ   bool
+	flags      uint8
+	edgeIRQ    bool
+	edgeRising bool
+}
+
+type riotRAM struct {
+	r *RIOT
+}
+
+// NewRIOT returns a 6532 after reset, with the inputs pulled up
+func NewRIOT() *RIOT {
+	var r RIOT
+	r.inA = 0xff
+	r.inB = 0xff
+	r.Reset()
+	return &r
+}
+
+// Reset clears the ports and the interrupts as the RES line does
+func (r *RIOT) Reset() {
+	r.ora, r.orb = 0, 0
+	r.ddra, r.ddrb = 0, 0
+	r.timerIRQ = false
+	r.edgeIRQ = false
+	r.edgeRising = false
+	r.flags = 0
+}
+
+// RAM returns the device of the 128 bytes of RAM
+func (r *RIOT) RAM() Device {
+	return riotRAM{r}
+}
+
+func (m riotRAM) Read(offset uint32) uint8 {
+	return m.r.ram[offset%riotRAMSize]
+}
+
+func (m riotRAM) Write(offset uint32, value uint8) {
+	m.r.ram[offset%riotRAMSize] = value
+}
+
+func (m riotRAM) Tick(cycles uint64) {}
+
+func (m riotRAM) IRQ() bool {
+	return false
+}
+
+// IRQ returns true while an enabled interrupt flag is set
+func (r *RIOT) IRQ() bool {
+	return r.timerIRQ && r.flags&RIOTIntTimer != 0 || r.edgeIRQ && r.flags&RIOTIntPA7 != 0
+}
+
+// Read returns the I/O register at offset, the registers repeat every 32 bytes
+func (r *RIOT) Read(offset uint32) uint8 {
+	if offset&riotTimerSelect == 0 {
+		switch offset & riotPortRegisters {
+		case 0:
+			return r.pinsA()
+		case 1:
+			return r.ddra
+		case 2:
+			return r.orb&r.ddrb | r.inB&^r.ddrb
+		default:
+			return r.ddrb
+		}
+	}
+
+	if offset&riotReadFlags != 0 {
+		flags := r.flags
+		r.flags &^= RIOTIntPA7
+		return flags
+	}
+	r.timerIRQ = offset&riotTimerIRQ != 0
+	if r.timer >= 0 {
+		return uint8(r.timer >> r.shift)
+	}
+	r.flags &^= RIOTIntTimer
+	return uint8(r.timer)
+}
+
+// Write sets the I/O register at offset, the registers repeat every 32 bytes
+func (r *RIOT) Write(offset uint32, value uint8) {
+	if offset&riotTimerSelect == 0 {
+		switch offset & riotPortRegisters {
+		case 0:
+			before := r.pinsA()
+			r.ora = value
+			r.outputA()
+			r.checkEdge(before)
+		case 1:
+			before := r.pinsA()
+			r.ddra = value
+			r.outputA()
+			r.checkEdge(before)
+		case 2:
+			r.orb = value
+			r.outputB()
+		default:
+			r.ddrb = value
+			r.outputB()
+		}
+		return
+	}
+
+	if offset&riotTimerWrite != 0 || r.Layout6530 {
+		r.shift = riotPrescalerShifts[offset&0x3]
+		r.timer = int64(value)<<r.shift - 1
+		r.timerIRQ = offset&riotTimerIRQ != 0
+		r.flags &^= RIOTIntTimer
+		return
+	}
+	r.edgeRising = offset&riotEdgePositive != 0
+	r.edgeIRQ = offset&riotEdgeIRQ != 0
+}
+
+// Tick decrements the timer
+func (r *RIOT) Tick(cycles uint64) {
+	before := r.timer
+	r.timer -= int64(cycles)
+	if before >= 0 && r.timer < 0 {
+		r.flags |= RIOTIntTimer
+	}
+	if r.timer < -0x100 {
+		// Keep counting the 8 bits from $ff
+		r.timer = -1 - (-1-r.timer)%0x100
+	}
+}
+
+func (r *RIOT) pinsA() uint8 {
+	return r.ora&r.ddra | r.inA&^r.ddra
+}
+
+func (r *RIOT) outputA() {
+	if r.OutputA != nil {
+		r.OutputA(r.ora&r.ddra | ^r.ddra)
+	}
+}
+
+// checkEdge sets the PA7 flag on the edge programmed
+func (r *RIOT) checkEdge(before uint8) {
+	if r.Layout6530 {
+		return
+	}
+	after := r.pinsA()
+	if before&0x80 != after&0x80 && (after&0x80 != 0) == r.edgeRising {
+		r.flags |= RIOTIntPA7
+	}
+}
+
+func (r *RIOT) outputB() {
+	if r.OutputB != nil {
+		r.OutputB(r.orb&r.ddrb | ^r.ddrb)
+	}
+}
+
+// SetInputA drives the pins of port A programmed as inputs. An edge on PA7
+// sets its interrupt flag.
+func (r *RIOT) SetInputA(pins uint8) {
+	before := r.pinsA()
+	r.inA = pins
+	r.checkEdge(before)
+}
+
+// SetInputB drives the pins of port B programmed as inputs
+func (r *RIOT) SetInputB(pins uint8) {
+	r.inB = pins
+}
@@ -0,0 +1,101 @@
+package devices
+
+import (
+	"testing"
+)
+
+func TestRIOTRAM(t *testing.T) {
+	r := NewRIOT()
+	ram := r.RAM()
+	ram.Write(0x7f, 0x12)
+	if ram.Read(0x7f) != 0x12 || ram.Read(0xff) != 0x12 || ram.IRQ() {
+		t.Error("Wrong RAM")
+	}
+}
+
+func TestRIOTPorts(t *testing.T) {
+	r := NewRIOT()
+	var outB uint8
+	r.OutputB = func(pins uint8) {
+		outB = pins
+	}
+	r.Write(0x03, 0xf0) // DDRB
+	r.Write(0x02, 0x55) // ORB
+	if outB != 0x5f {
+		t.Errorf("Wrong port B outputs $%02x", outB)
+	}
+	r.SetInputB(0x0a)
+	if b := r.Read(0x02); b != 0x5a {
+		t.Errorf("Wrong port B read $%02x", b)
+	}
+
+	// PA7 edges
+	r.Write(0x05, 0) // Positive edge, interrupt disabled
+	r.SetInputA(0x00)
+	if r.Read(0x05)&RIOTIntPA7 != 0 {
+		t.Fatal("Falling edge detected")
+	}
+	r.SetInputA(0x80)
+	if r.IRQ() || r.Read(0x05)&RIOTIntPA7 == 0 {
+		t.Fatal("Rising edge not detected, or interrupting while disabled")
+	}
+	if r.Read(0x05)&RIOTIntPA7 != 0 {
+		t.Error("Reading the flags did not clear PA7")
+	}
+	r.Write(0x06, 0) // Negative edge, interrupt enabled
+	r.SetInputA(0x00)
+	if !r.IRQ() {
+		t.Error("PA7 did not interrupt")
+	}
+}
+
+func TestRIOTTimer(t *testing.T) {
+	cases := []struct {
+		offset    uint32
+		prescaler uint64
+	}{
+		{0x14, 1},
+		{0x15, 8},
+		{0x16, 64},
+		{0x17, 1024},
+	}
+	for _, c := range cases {
+		r := NewRIOT()
+		r.Tick(1)
+		// Reads N-1 from the write, interrupts after N periods
+		r.Write(c.offset|0x08, 10)
+		if r.IRQ() || r.Read(0x04) != 9 {
+			t.Fatalf("/%v: wrong start", c.prescaler)
+		}
+		r.Tick(c.prescaler)
+		if r.Read(0x0c) != 8 {
+			t.Errorf("/%v: timer at %v after a period", c.prescaler, r.Read(0x0c))
+		}
+		r.Tick(9*c.prescaler - 1)
+		if r.IRQ() || r.Read(0x0c) != 0 {
+			t.Errorf("/%v: timer at %v at the end", c.prescaler, r.Read(0x0c))
+		}
+		r.Tick(1)
+		if !r.IRQ() || r.Read(0x05) != RIOTIntTimer {
+			t.Fatalf("/%v: no interrupt after 10 periods", c.prescaler)
+		}
+
+		// Then counts every cycle
+		r.Tick(2)
+		if r.Read(0x0c) != 0xfd || r.IRQ() {
+			t.Errorf("/%v: wrong count after the time out", c.prescaler)
+		}
+		r.Tick(0x300)
+		if r.Read(0x0c) != 0xfd || r.IRQ() {
+			t.Errorf("/%v: wrong count wrapping or interrupting again", c.prescaler)
+		}
+	}
+
+	// Without interrupt, the flag is still set
+	r := NewRIOT()
+	r.Write(0x14, 1)
+	r.Tick(1)
+	if r.IRQ() || r.Read(0x05)&RIOTIntTimer == 0 {
+		t.Error("Wrong timer without interrupt")
+	}
+}
@@ -0,0 +1,600 @@
+package devices
+
+/*
+MOS 6522 Versatile Interface Adapter.
+
+The VIA is clocked one cycle at a time. After writing the high byte of T1 or
+T2 the counter decrements every cycle from the value loaded, and the interrupt
+flag is set on the cycle the counter rolls over from 0 to $ffff, N+1 cycles
+later. In free running mode T1 reloads from the latches on the next cycle, for
+a period of N+2 cycles. In one shot mode the counter keeps decrementing and
+only interrupts again after being rewritten.
+
+With the shift register clocked by phi2 a bit takes two cycles. Clocked by T2,
+the low byte of T2 counts down from its latch and reloads, and a bit takes
+twice N+2 cycles.
+
+The pins of the ports and the control lines are exposed to the host with the
+Output callbacks and the Set methods.
+*/
+
+// Registers of the 6522, by offset
+const (
+	viaORB  = 0x0
+	viaORA  = 0x1
+	viaDDRB = 0x2
+	viaDDRA = 0x3
+	viaT1CL = 0x4
+	viaT1CH = 0x5
+	viaT1LL = 0x6
+	viaT1LH = 0x7
+	viaT2CL = 0x8
+	viaT2CH = 0x9
+	viaSR   = 0xa
+	viaACR  = 0xb
+	viaPCR  = 0xc
+	viaIFR  = 0xd
+	viaIER  = 0xe
+	viaORAN = 0xf // ORA without handshake
+)
+
+// Bits of the interrupt flag and enable registers
+const (
+	VIAIntCA2 uint8 = 1 << iota
+	VIAIntCA1
+	VIAIntSR
+	VIAIntCB2
+	VIAIntCB1
+	VIAIntT2
+	VIAIntT1
+	VIAIntAny
+)
+
+// Modes of CA2 and CB2 on the PCR
+const (
+	viaC2InputNegative = iota
+	viaC2IndependentNegative
+	viaC2InputPositive
+	viaC2IndependentPositive
+	viaC2Handshake
+	viaC2Pulse
+	viaC2Low
+	viaC2High
+)
+
+// Modes of the shift register on the ACR
+const (
+	viaSRDisabled = iota
+	viaSRInT2
+	viaSRInPhi2
+	viaSRInCB1
+	viaSROutFreeT2
+	viaSROutT2
+	viaSROutPhi2
+	viaSROutCB1
+)
+
+const (
+	viaACRLatchA       = 0x01
+	viaACRLatchB       = 0x02
+	viaACRT2PulseCount = 0x20
+	viaACRT1FreeRun    = 0x40
+	viaACRT1PB7        = 0x80
+	viaPCRCA1Positive  = 0x01
+	viaPCRCB1Positive  = 0x10
+)
+
+// VIA is a 6522 mounted on 16 addresses
+type VIA struct {
+	// OutputA and OutputB are called with the pins of the port when the
+	// outputs change. The bits programmed as inputs read high.
+	OutputA func(pins uint8)
+	OutputB func(pins uint8)
+	// OutputCA2, OutputCB1 and OutputCB2 are called with the level of the
+	// control line when the VIA drives it
+	OutputCA2 func(high bool)
+	OutputCB1 func(high bool)
+	OutputCB2 func(high bool)
+
+	ora, orb   uint8
+	ddra, ddrb uint8
+	inA, inB   uint8
+	latchA     uint8
+	latchB     uint8
+	acr, pcr   uint8
+	ifr, ier   uint8
+
+	t1       uint16
+	t1Latch  uint16
+	t1Armed  bool
+	t1Reload bool
+	pb7      bool
+
+	t2        uint16
+	t2LatchLo uint8
+	t2Armed   bool
+
+	sr       uint8
+	srCount  int
+	srReload bool
+	cb1Clock bool
+
+	ca1, ca2, cb1, cb2 bool
+	ca2Out, cb2Out     bool
+	ca2Pulse, cb2Pulse bool
+}
+
+// NewVIA returns a VIA after reset, with the inputs pulled up
+func NewVIA() *VIA {
+	var v VIA
+	v.inA = 0xff
+	v.inB = 0xff
+	v.ca1, v.ca2, v.cb1, v.cb2 = true, true, true, true
+	v.t1, v.t2 = 0xffff, 0xffff
+	v.Reset()
+	return &v
+}
+
+// Reset clears the registers as the RES line does. The timers and the shift
+// register are not cleared.
+func (v *VIA) Reset() {
+	v.ora, v.orb = 0, 0
+	v.ddra, v.ddrb = 0, 0
+	v.acr, v.pcr = 0, 0
+	v.ifr, v.ier = 0, 0
+	v.t1Armed, v.t2Armed = false, false
+	v.srCount = 0
+	v.pb7 = true
+	v.ca2Out, v.cb2Out = true, true
+	v.cb1Clock = true
+}
+
+// IRQ returns true while an enabled interrupt flag is set
+func (v *VIA) IRQ() bool {
+	return v.ifr&v.ier&0x7f != 0
+}
+
+func (v *VIA) setFlag(flag uint8) {
+	v.ifr |= flag
+}
+
+func (v *VIA) clearFlag(flag uint8) {
+	v.ifr &^= flag
+}
+
+// Read returns the register at offset, the registers repeat every 16 bytes
+func (v *VIA) Read(offset uint32) uint8 {
+	switch offset & 0xf {
+	case viaORB:
+		v.clearFlag(VIAIntCB1)
+		if !v.independent(v.cb2Mode()) {
+			v.clearFlag(VIAIntCB2)
+		}
+		return v.readB()
+	case viaORA:
+		v.accessA()
+		return v.readA()
+	case viaDDRB:
+		return v.ddrb
+	case viaDDRA:
+		return v.ddra
+	case viaT1CL:
+		v.clearFlag(VIAIntT1)
+		return uint8(v.t1)
+	case viaT1CH:
+		return uint8(v.t1 >> 8)
+	case viaT1LL:
+		return uint8(v.t1Latch)
+	case viaT1LH:
+		return uint8(v.t1Latch >> 8)
+	case viaT2CL:
+		v.clearFlag(VIAIntT2)
+		return uint8(v.t2)
+	case viaT2CH:
+		return uint8(v.t2 >> 8)
+	case viaSR:
+		v.startShift()
+		return v.sr
+	case viaACR:
+		return v.acr
+	case viaPCR:
+		return v.pcr
+	case viaIFR:
+		if v.IRQ() {
+			return v.ifr | VIAIntAny
+		}
+		return v.ifr
+	case viaIER:
+		return v.ier | 0x80
+	default: // viaORAN
+		return v.readA()
+	}
+}
+
+// Write sets the register at offset, the registers repeat every 16 bytes
+func (v *VIA) Write(offset uint32, value uint8) {
+	switch offset & 0xf {
+	case viaORB:
+		v.orb = value
+		v.clearFlag(VIAIntCB1)
+		if !v.independent(v.cb2Mode()) {
+			v.clearFlag(VIAIntCB2)
+		}
+		switch v.cb2Mode() {
+		case viaC2Handshake:
+			v.setCB2Out(false)
+		case viaC2Pulse:
+			v.setCB2Out(false)
+			v.cb2Pulse = true
+		}
+		v.outputB()
+	case viaORA:
+		v.ora = value
+		v.accessA()
+		v.outputA()
+	case viaDDRB:
+		v.ddrb = value
+		v.outputB()
+	case viaDDRA:
+		v.ddra = value
+		v.outputA()
+	case viaT1CL, viaT1LL:
+		v.t1Latch = v.t1Latch&0xff00 | uint16(value)
+	case viaT1CH:
+		v.t1Latch = v.t1Latch&0x00ff | uint16(value)<<8
+		v.t1 = v.t1Latch
+		v.t1Reload = false
+		v.t1Armed = true
+		v.clearFlag(VIAIntT1)
+		if v.acr&viaACRT1PB7 != 0 {
+			v.pb7 = false
+			v.outputB()
+		}
+	case viaT1LH:
+		v.t1Latch = v.t1Latch&0x00ff | uint16(value)<<8
+		v.clearFlag(VIAIntT1)
+	case viaT2CL:
+		v.t2LatchLo = value
+	case viaT2CH:
+		v.t2 = uint16(value)<<8 | uint16(v.t2LatchLo)
+		v.t2Armed = true
+		v.clearFlag(VIAIntT2)
+	case viaSR:
+		v.sr = value
+		v.startShift()
+	case viaACR:
+		pb7 := v.acr&viaACRT1PB7 != 0
+		v.acr = value
+		if pb7 != (v.acr&viaACRT1PB7 != 0) {
+			v.outputB()
+		}
+	case viaPCR:
+		v.pcr = value
+		switch v.ca2Mode() {
+		case viaC2Low:
+			v.setCA2Out(false)
+		case viaC2High:
+			v.setCA2Out(true)
+		}
+		switch v.cb2Mode() {
+		case viaC2Low:
+			v.setCB2Out(false)
+		case viaC2High:
+			v.setCB2Out(true)
+		}
+	case viaIFR:
+		v.clearFlag(value & 0x7f)
+	case viaIER:
+		if value&0x80 != 0 {
+			v.ier |= value & 0x7f
+		} else {
+			v.ier &^= value & 0x7f
+		}
+	default: // viaORAN
+		v.ora = value
+		v.outputA()
+	}
+}
+
+// Tick advances the timers and the shift register
+func (v *VIA) Tick(cycles uint64) {
+	for i := uint64(0); i < cycles; i++ {
+		v.cycle()
+	}
+}
+
+func (v *VIA) cycle() {
+	if v.ca2Pulse {
+		v.ca2Pulse = false
+		v.setCA2Out(true)
+	}
+	if v.cb2Pulse {
+		v.cb2Pulse = false
+		v.setCB2Out(true)
+	}
+
+	// Timer 1
+	if v.t1Reload {
+		v.t1 = v.t1Latch
+		v.t1Reload = false
+	} else {
+		v.t1--
+		if v.t1 == 0xffff {
+			v.t1Timeout()
+		}
+	}
+
+	// Timer 2, its low byte clocks the shift register on some modes
+	switch v.srMode() {
+	case viaSRInT2, viaSROutT2, viaSROutFreeT2:
+		low := uint8(v.t2)
+		if v.srReload {
+			low = v.t2LatchLo
+			v.srReload = false
+		} else {
+			low--
+			if low == 0xff {
+				v.srReload = true
+				v.shiftClock()
+			}
+		}
+		v.t2 = v.t2&0xff00 | uint16(low)
+	default:
+		if v.acr&viaACRT2PulseCount == 0 {
+			v.t2--
+			if v.t2 == 0xffff && v.t2Armed {
+				v.t2Armed = false
+				v.setFlag(VIAIntT2)
+			}
+		}
+	}
+
+	switch v.srMode() {
+	case viaSRInPhi2, viaSROutPhi2:
+		v.shiftClock()
+	}
+}
+
+func (v *VIA) t1Timeout() {
+	if v.acr&viaACRT1FreeRun != 0 {
+		v.setFlag(VIAIntT1)
+		v.t1Reload = true
+		if v.acr&viaACRT1PB7 != 0 {
+			v.pb7 = !v.pb7
+			v.outputB()
+		}
+		return
+	}
+	if v.t1Armed {
+		v.t1Armed = false
+		v.setFlag(VIAIntT1)
+		if v.acr&viaACRT1PB7 != 0 {
+			v.pb7 = true
+			v.outputB()
+		}
+	}
+}
+
+func (v *VIA) srMode() uint8 {
+	return (v.acr >> 2) & 0x7
+}
+
+func (v *VIA) srOut() bool {
+	return v.srMode() >= viaSROutFreeT2
+}
+
+// startShift starts shifting 8 bits on an access to the shift register
+func (v *VIA) startShift() {
+	v.clearFlag(VIAIntSR)
+	if v.srMode() != viaSRDisabled {
+		v.srCount = 8
+	}
+}
+
+// shiftClock toggles the CB1 clock generated by the VIA, shifting on the
+// rising edge
+func (v *VIA) shiftClock() {
+	if v.srCount == 0 && v.srMode() != viaSROutFreeT2 {
+		return
+	}
+	v.cb1Clock = !v.cb1Clock
+	if v.OutputCB1 != nil {
+		v.OutputCB1(v.cb1Clock)
+	}
+	if v.cb1Clock {
+		v.shiftBit()
+	}
+}
+
+func (v *VIA) shiftBit() {
+	if v.srOut() {
+		bit := v.sr >> 7
+		v.sr = v.sr<<1 | bit
+		v.setCB2Out(bit != 0)
+	} else {
+		v.sr <<= 1
+		if v.cb2 {
+			v.sr |= 1
+		}
+	}
+	if v.srMode() == viaSROutFreeT2 {
+		return
+	}
+	v.srCount--
+	if v.srCount == 0 {
+		v.setFlag(VIAIntSR)
+	}
+}
+
+func (v *VIA) ca2Mode() uint8 {
+	return (v.pcr >> 1) & 0x7
+}
+
+func (v *VIA) cb2Mode() uint8 {
+	return (v.pcr >> 5) & 0x7
+}
+
+func (v *VIA) independent(mode uint8) bool {
+	return mode == viaC2IndependentNegative || mode == viaC2IndependentPositive
+}
+
+// accessA clears the flags and does the handshake of a read or write of ORA
+func (v *VIA) accessA() {
+	v.clearFlag(VIAIntCA1)
+	if !v.independent(v.ca2Mode()) {
+		v.clearFlag(VIAIntCA2)
+	}
+	switch v.ca2Mode() {
+	case viaC2Handshake:
+		v.setCA2Out(false)
+	case viaC2Pulse:
+		v.setCA2Out(false)
+		v.ca2Pulse = true
+	}
+}
+
+func (v *VIA) pinsA() uint8 {
+	return v.ora&v.ddra | v.inA&^v.ddra
+}
+
+func (v *VIA) readA() uint8 {
+	if v.acr&viaACRLatchA != 0 {
+		return v.latchA
+	}
+	return v.pinsA()
+}
+
+func (v *VIA) readB() uint8 {
+	in := v.inB
+	if v.acr&viaACRLatchB != 0 {
+		in = v.latchB
+	}
+	value := v.orb&v.ddrb | in&^v.ddrb
+	if v.acr&viaACRT1PB7 != 0 {
+		value = value&0x7f | v.pb7Bit()
+	}
+	return value
+}
+
+func (v *VIA) pb7Bit() uint8 {
+	if v.pb7 {
+		return 0x80
+	}
+	return 0
+}
+
+func (v *VIA) outputA() {
+	if v.OutputA != nil {
+		v.OutputA(v.ora&v.ddra | ^v.ddra)
+	}
+}
+
+func (v *VIA) outputB() {
+	if v.OutputB != nil {
+		pins := v.orb&v.ddrb | ^v.ddrb
+		if v.acr&viaACRT1PB7 != 0 {
+			pins = pins&0x7f | v.pb7Bit()
+		}
+		v.OutputB(pins)
+	}
+}
+
+func (v *VIA) setCA2Out(high bool) {
+	if v.ca2Out != high {
+		v.ca2Out = high
+		if v.OutputCA2 != nil {
+			v.OutputCA2(high)
+		}
+	}
+}
+
+func (v *VIA) setCB2Out(high bool) {
+	if v.cb2Out != high {
+		v.cb2Out = high
+		if v.OutputCB2 != nil {
+			v.OutputCB2(high)
+		}
+	}
+}
+
+// SetInputA drives the pins of port A programmed as inputs
+func (v *VIA) SetInputA(pins uint8) {
+	v.inA = pins
+}
+
+// SetInputB drives the pins of port B programmed as inputs. A falling edge of
+// PB6 decrements T2 when counting pulses.
+func (v *VIA) SetInputB(pins uint8) {
+	falling := v.inB&0x40 != 0 && pins&0x40 == 0
+	v.inB = pins
+	if falling && v.acr&viaACRT2PulseCount != 0 {
+		v.t2--
+		if v.t2 == 0 && v.t2Armed {
+			v.t2Armed = false
+			v.setFlag(VIAIntT2)
+		}
+	}
+}
+
+// SetCA1 drives the CA1 input
+func (v *VIA) SetCA1(high bool) {
+	if v.ca1 == high {
+		return
+	}
+	v.ca1 = high
+	if high != (v.pcr&viaPCRCA1Positive != 0) {
+		return
+	}
+	v.setFlag(VIAIntCA1)
+	v.latchA = v.pinsA()
+	if v.ca2Mode() == viaC2Handshake {
+		v.setCA2Out(true)
+	}
+}
+
+// SetCA2 drives the CA2 line when programmed as an input
+func (v *VIA) SetCA2(high bool) {
+	if v.ca2 == high {
+		return
+	}
+	v.ca2 = high
+	mode := v.ca2Mode()
+	if mode < viaC2Handshake && high == (mode == viaC2InputPositive || mode == viaC2IndependentPositive) {
+		v.setFlag(VIAIntCA2)
+	}
+}
+
+// SetCB1 drives the CB1 input, that is also the external shift clock
+func (v *VIA) SetCB1(high bool) {
+	if v.cb1 == high {
+		return
+	}
+	v.cb1 = high
+	if high && v.srCount > 0 {
+		switch v.srMode() {
+		case viaSRInCB1, viaSROutCB1:
+			v.shiftBit()
+		}
+	}
+	if high != (v.pcr&viaPCRCB1Positive != 0) {
+		return
+	}
+	v.setFlag(VIAIntCB1)
+	v.latchB = v.orb&v.ddrb | v.inB&^v.ddrb
+	if v.cb2Mode() == viaC2Handshake {
+		v.setCB2Out(true)
+	}
+}
+
+// SetCB2 drives the CB2 line when programmed as an input, that is also the
+// data shifted in
+func (v *VIA) SetCB2(high bool) {
+	if v.cb2 == high {
+		return
+	}
+	v.cb2 = high
+	mode := v.cb2Mode()
+	if mode < viaC2Handshake && high == (mode == viaC2InputPositive || mode == viaC2IndependentPositive) {
+		v.setFlag(VIAIntCB2)
+	}
+}
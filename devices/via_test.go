@@ -0,0 +1,319 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/runner"
+)
+
+func TestVIAT1OneShot(t *testing.T) {
+	v := NewVIA()
+	v.Write(viaIER, 0x80|VIAIntT1)
+	v.Write(viaT1CL, 0x10)
+	v.Write(viaT1CH, 0x00)
+
+	// Interrupts N+1 cycles after the write
+	v.Tick(0x10)
+	if v.IRQ() || v.Read(viaT1CH) != 0 {
+		t.Fatalf("T1 interrupted early, counter $%02x", v.t1)
+	}
+	v.Tick(1)
+	if !v.IRQ() || v.Read(viaIFR) != VIAIntAny|VIAIntT1 {
+		t.Fatalf("T1 did not interrupt, IFR $%02x", v.ifr)
+	}
+	v.Read(viaT1CL)
+	if v.IRQ() {
+		t.Error("Reading T1 low did not clear the interrupt")
+	}
+
+	// The counter keeps decrementing without interrupting again
+	v.Tick(0x20000)
+	if v.IRQ() {
+		t.Error("One shot T1 interrupted twice")
+	}
+	if v.Read(viaT1CH) == 0x00 {
+		t.Error("One shot T1 reloaded")
+	}
+}
+
+func TestVIAT1FreeRun(t *testing.T) {
+	v := NewVIA()
+	v.Write(viaACR, viaACRT1FreeRun)
+	v.Write(viaT1CL, 0x08)
+	v.Write(viaT1CH, 0x00)
+	v.Tick(9)
+	if v.ifr&VIAIntT1 == 0 {
+		t.Fatal("T1 did not time out")
+	}
+
+	// Each period is N+2 cycles
+	for i := 0; i < 5; i++ {
+		v.Write(viaIFR, VIAIntT1)
+		v.Tick(9)
+		if v.ifr&VIAIntT1 != 0 {
+			t.Fatalf("Period %v shorter than N+2", i)
+		}
+		v.Tick(1)
+		if v.ifr&VIAIntT1 == 0 {
+			t.Fatalf("Period %v longer than N+2", i)
+		}
+	}
+
+	// Writing the latches changes the next period only
+	v.Write(viaIFR, VIAIntT1)
+	v.Tick(4)
+	v.Write(viaT1LL, 0x20)
+	v.Tick(6)
+	if v.ifr&VIAIntT1 == 0 {
+		t.Fatal("Latch write changed the running period")
+	}
+	v.Write(viaIFR, VIAIntT1)
+	v.Tick(0x21)
+	if v.ifr&VIAIntT1 != 0 {
+		t.Fatal("New latch ignored")
+	}
+	v.Tick(1)
+	if v.ifr&VIAIntT1 == 0 {
+		t.Fatal("New period longer than N+2")
+	}
+}
+
+func TestVIAPB7(t *testing.T) {
+	v := NewVIA()
+	var levels []bool
+	v.OutputB = func(pins uint8) {
+		levels = append(levels, pins&0x80 != 0)
+	}
+
+	// Square wave on PB7, toggling each N+2 cycles
+	v.Write(viaACR, viaACRT1FreeRun|viaACRT1PB7)
+	v.Write(viaT1CL, 0x04)
+	levels = nil
+	v.Write(viaT1CH, 0x00)
+	v.Tick(5 + 3*6)
+	expected := []bool{false, true, false, true, false}
+	if len(levels) != len(expected) {
+		t.Fatalf("PB7 changed %v times: %v", len(levels), levels)
+	}
+	for i := range expected {
+		if levels[i] != expected[i] {
+			t.Fatalf("Wrong PB7 levels %v", levels)
+		}
+	}
+
+	// A single low pulse in one shot mode
+	v.Write(viaACR, viaACRT1PB7)
+	v.Write(viaT1CH, 0x00)
+	if v.Read(viaORB)&0x80 != 0 {
+		t.Error("PB7 not low while T1 runs")
+	}
+	v.Tick(5)
+	if v.Read(viaORB)&0x80 == 0 {
+		t.Error("PB7 not high after the time out")
+	}
+	v.Tick(0x20000)
+	if v.Read(viaORB)&0x80 == 0 {
+		t.Error("PB7 pulsed twice")
+	}
+}
+
+func TestVIAT2(t *testing.T) {
+	v := NewVIA()
+	v.Write(viaIER, 0x80|VIAIntT2)
+	v.Write(viaT2CL, 0x00)
+	v.Write(viaT2CH, 0x01)
+	v.Tick(0x100)
+	if v.IRQ() {
+		t.Fatal("T2 interrupted early")
+	}
+	v.Tick(1)
+	if !v.IRQ() {
+		t.Fatal("T2 did not interrupt")
+	}
+	v.Read(viaT2CL)
+	v.Tick(0x10000)
+	if v.IRQ() {
+		t.Error("T2 interrupted twice")
+	}
+}
+
+func TestVIAT2PulseCounting(t *testing.T) {
+	v := NewVIA()
+	v.Write(viaACR, viaACRT2PulseCount)
+	v.Write(viaIER, 0x80|VIAIntT2)
+	v.Write(viaT2CL, 3)
+	v.Write(viaT2CH, 0)
+
+	pulse := func() {
+		v.SetInputB(0xbf)
+		v.SetInputB(0xff)
+	}
+	// The clock does not count
+	v.Tick(100)
+	if v.Read(viaT2CL) != 3 {
+		t.Fatalf("T2 decremented by the clock to %v", v.t2)
+	}
+	pulse()
+	pulse()
+	if v.IRQ() || v.Read(viaT2CL) != 1 {
+		t.Fatalf("T2 at %v after two pulses", v.t2)
+	}
+	// Only the falling edges count
+	v.SetInputB(0xff)
+	pulse()
+	if !v.IRQ() {
+		t.Fatal("T2 did not interrupt after three pulses")
+	}
+}
+
+func TestVIAPorts(t *testing.T) {
+	v := NewVIA()
+	var outA uint8
+	v.OutputA = func(pins uint8) {
+		outA = pins
+	}
+	v.Write(viaDDRA, 0x0f)
+	v.Write(viaORA, 0x35)
+	if outA != 0xf5 {
+		t.Errorf("Wrong port A outputs $%02x", outA)
+	}
+	v.SetInputA(0xa0)
+	if a := v.Read(viaORA); a != 0xa5 {
+		t.Errorf("Wrong port A read $%02x", a)
+	}
+
+	// Input latched on the CA1 edge, here positive
+	v.Write(viaPCR, 0x01)
+	v.Write(viaACR, viaACRLatchA)
+	v.Write(viaIER, 0x80|VIAIntCA1)
+	v.SetCA1(false)
+	v.SetCA1(true)
+	v.SetInputA(0x50)
+	if !v.IRQ() || v.Read(viaORAN) != 0xa5 {
+		t.Error("CA1 edge did not latch port A")
+	}
+	if !v.IRQ() {
+		t.Error("ORA without handshake cleared CA1")
+	}
+	v.Read(viaORA)
+	if v.IRQ() {
+		t.Error("ORA read did not clear CA1")
+	}
+}
+
+func TestVIAHandshake(t *testing.T) {
+	v := NewVIA()
+	var ca2 []bool
+	v.OutputCA2 = func(high bool) {
+		ca2 = append(ca2, high)
+	}
+	// CA2 low from the ORA access to the data ready edge on CA1
+	v.Write(viaPCR, viaC2Handshake<<1)
+	v.Read(viaORA)
+	v.Tick(10)
+	if len(ca2) != 1 || ca2[0] {
+		t.Fatalf("Wrong handshake %v", ca2)
+	}
+	v.SetCA1(false)
+	if len(ca2) != 2 || !ca2[1] {
+		t.Fatalf("Handshake not ended by CA1 %v", ca2)
+	}
+
+	// CA2 low for one cycle
+	ca2 = nil
+	v.Write(viaPCR, viaC2Pulse<<1)
+	v.Write(viaORA, 0)
+	v.Tick(1)
+	if len(ca2) != 2 || ca2[0] || !ca2[1] {
+		t.Fatalf("Wrong pulse %v", ca2)
+	}
+
+	var cb2 []bool
+	v.OutputCB2 = func(high bool) {
+		cb2 = append(cb2, high)
+	}
+	v.Write(viaPCR, viaC2Low<<5)
+	v.Write(viaPCR, viaC2High<<5)
+	if len(cb2) != 2 || cb2[0] || !cb2[1] {
+		t.Fatalf("Wrong manual CB2 %v", cb2)
+	}
+}
+
+func TestVIAShiftRegister(t *testing.T) {
+	v := NewVIA()
+	var bits []bool
+	v.OutputCB2 = func(high bool) {
+		bits = append(bits, high)
+	}
+	v.Write(viaACR, viaSROutPhi2<<2)
+	v.Write(viaSR, 0x81)
+	v.Tick(15)
+	if v.ifr&VIAIntSR != 0 {
+		t.Fatal("Shift done early")
+	}
+	v.Tick(1)
+	if v.ifr&VIAIntSR == 0 || v.Read(viaSR) != 0x81 {
+		t.Fatalf("Shift not done, SR $%02x", v.sr)
+	}
+	// CB2 goes low with the second bit and high with the last
+	if len(bits) != 2 || bits[0] || !bits[1] {
+		t.Errorf("Wrong CB2 changes %v", bits)
+	}
+
+	// Shift in with the external clock
+	v.Write(viaACR, viaSRInCB1<<2)
+	v.Read(viaSR)
+	for i := 0; i < 8; i++ {
+		v.SetCB2(i%2 == 0)
+		v.SetCB1(false)
+		v.SetCB1(true)
+	}
+	if v.ifr&VIAIntSR == 0 || v.sr != 0xaa {
+		t.Errorf("Shifted in $%02x", v.sr)
+	}
+}
+
+func TestVIAInterruptsCPU(t *testing.T) {
+	mem := new(iz6502.FlatMemory)
+	b := NewBus(mem)
+	via := NewVIA()
+	b.Mount(0x6000, 0x10, via)
+
+	program := []uint8{
+		0xa9, 0xc0, // LDA #$c0
+		0x8d, 0x0e, 0x60, // STA IER, enables T1
+		0xa9, 0x40, // LDA #$40
+		0x8d, 0x0b, 0x60, // STA ACR, T1 free running
+		0xa9, 0xfe, // LDA #$fe
+		0x8d, 0x04, 0x60, // STA T1CL
+		0xa9, 0x00, // LDA #$00
+		0x8d, 0x05, 0x60, // STA T1CH, period of 256 cycles
+		0x58,             // CLI
+		0xe8,             // INX
+		0x4c, 0x15, 0x04, // JMP $0415
+	}
+	for i, v := range program {
+		mem.Poke(0x0400+uint32(i), v)
+	}
+	handler := []uint8{
+		0xee, 0x00, 0x02, // INC $0200
+		0xad, 0x04, 0x60, // LDA T1CL, acknowledges
+		0x40, // RTI
+	}
+	for i, v := range handler {
+		mem.Poke(0x0500+uint32(i), v)
+	}
+	mem.Poke(0xfffe, 0x00)
+	mem.Poke(0xffff, 0x05)
+
+	s := iz6502.NewCMOS65c02(b)
+	s.SetRegisters(iz6502.Registers{SP: 0xff, P: 0x24, PC: 0x0400})
+	r := runner.Run(s, runner.Config{Hook: b.Hook, MaxCycles: 256*10 + 30})
+	if r.Reason != runner.ReasonCycles {
+		t.Fatalf("Wrong result %v", &r)
+	}
+	if n := mem.Peek(0x0200); n != 10 {
+		t.Errorf("%v interrupts in 10 periods", n)
+	}
+}
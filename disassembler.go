@@ -0,0 +1,444 @@
+package iz6502
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Widths are the 65c24T8 address and register widths used to decode an
+// instruction. The zero value is the 16-bit address and 8-bit registers of
+// the 6502 and 65c02.
+type Widths struct {
+	Address  uint8 // AB16 or AB24
+	Register uint8 // R08, R16 or R24
+}
+
+// Instruction is a decoded instruction
+type Instruction struct {
+	Address   uint32
+	Bytes     []uint8
+	Length    int
+	Prefix    string // 65c24T8 prefixes, like W24. Empty if none
+	Mnemonic  string
+	Operand   string
+	Mode      AddressMode
+	Target    uint32 // Branch destination or referenced address
+	HasTarget bool
+	Widths    Widths // Widths used to decode the operand
+	Undefined bool   // Not a valid opcode for the model
+}
+
+func (i Instruction) String() string {
+	t := i.Mnemonic
+	if i.Prefix != "" {
+		t = i.Prefix + " " + t
+	}
+	if i.Operand != "" {
+		t += " " + i.Operand
+	}
+	return t
+}
+
+// Symbolizer names addresses. The disassembler and the tracers use it to print
+// JSR print_string instead of JSR $c0a3.
+type Symbolizer interface {
+	// SymbolAt returns the name of the symbol with the exact address
+	SymbolAt(address uint32) (string, bool)
+	// NearestSymbol returns the name of the closest label at or before the
+	// address, and the offset of the address from it
+	NearestSymbol(address uint32) (string, uint32, bool)
+}
+
+// SymbolString returns the address as name+$offset of the nearest symbol, or as
+// hex if sym is nil or has no symbol before the address
+func SymbolString(sym Symbolizer, address uint32) string {
+	if sym != nil {
+		if name, offset, ok := sym.NearestSymbol(address); ok {
+			if offset == 0 {
+				return name
+			}
+			return fmt.Sprintf("%v+$%x", name, offset)
+		}
+	}
+	return targetString(address)
+}
+
+var modelOpcodesOnce sync.Once
+var modelOpcodes map[Model]*[256]opcode
+
+func opcodesForModel(model Model) (*[256]opcode, error) {
+	modelOpcodesOnce.Do(func() {
+		modelOpcodes = map[Model]*[256]opcode{
+			ModelNMOS6502:        NewNMOS6502(nil).opcodes,
+			ModelCMOS65c02:       NewCMOS65c02(nil).opcodes,
+			ModelWDC65c02:        NewWDC65c02(nil).opcodes,
+			ModelMythical65c24T8: NewMythical65c24T8(nil).opcodes,
+		}
+	})
+	opcodes, ok := modelOpcodes[model]
+	if !ok {
+		return nil, fmt.Errorf("unknown cpu model %v", model)
+	}
+	return opcodes, nil
+}
+
+// OpcodeInfo describes an opcode of a model, for tools like assemblers
+type OpcodeInfo struct {
+	Opcode   uint8
+	Mnemonic string
+	Mode     AddressMode
+	Cycles   int
+	IsPrefix bool
+	op       opcode
+}
+
+// Length returns the bytes of the instruction with the given 65c24T8 widths
+func (o OpcodeInfo) Length(widths Widths) int {
+	return int(instructionLength(o.op, widths.Address, widths.Register))
+}
+
+// Widths returns the widths selected by a 65c24T8 prefix opcode
+func (o OpcodeInfo) Widths() Widths {
+	if !o.IsPrefix {
+		return Widths{}
+	}
+	var scratch State
+	o.op.action(&scratch, nil, o.op)
+	return Widths{scratch.abWidth, scratch.rWidth}
+}
+
+// Opcodes returns the defined opcodes of the model
+func Opcodes(model Model) ([]OpcodeInfo, error) {
+	opcodes, err := opcodesForModel(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []OpcodeInfo
+	for i, op := range opcodes {
+		if op.cycles == 0 {
+			continue
+		}
+		infos = append(infos, OpcodeInfo{
+			Opcode:   uint8(i),
+			Mnemonic: op.name,
+			Mode:     AddressMode(op.addressMode),
+			Cycles:   op.cycles,
+			IsPrefix: op.isPrefix,
+			op:       op,
+		})
+	}
+	return infos, nil
+}
+
+// Disassemble decodes the instruction at addr. On the 65c24T8, prefixes are
+// walked and the instruction after them is decoded with the widths they
+// select. Returns the instruction and the address of the next one, or an error
+// for an unknown model.
+func Disassemble(model Model, mem Memory, addr uint32, widths Widths) (Instruction, uint32, error) {
+	return DisassembleSymbols(model, mem, addr, widths, nil)
+}
+
+// DisassembleSymbols decodes the instruction at addr as Disassemble, with the
+// addresses in the operand replaced by the names of their symbols
+func DisassembleSymbols(model Model, mem Memory, addr uint32, widths Widths, sym Symbolizer) (Instruction, uint32, error) {
+	opcodes, err := opcodesForModel(model)
+	if err != nil {
+		return Instruction{}, addr, err
+	}
+	inst, next := disassemble(opcodes, mem, addr, widths, sym)
+	return inst, next, nil
+}
+
+func disassemble(opcodes *[256]opcode, mem Memory, addr uint32, widths Widths, sym Symbolizer) (Instruction, uint32) {
+	inst := Instruction{Address: addr}
+	pc := addr
+
+	op := opcodes[mem.PeekCode(pc)]
+	for op.isPrefix {
+		widths = OpcodeInfo{op: op, IsPrefix: true}.Widths()
+		if inst.Prefix != "" {
+			inst.Prefix += " "
+		}
+		inst.Prefix += op.name
+		inst.Bytes = append(inst.Bytes, mem.PeekCode(pc))
+		pc++
+		op = opcodes[mem.PeekCode(pc)]
+	}
+
+	if op.cycles == 0 {
+		value := mem.PeekCode(pc)
+		inst.Bytes = append(inst.Bytes, value)
+		inst.Mnemonic = ".byte"
+		inst.Operand = fmt.Sprintf("$%02x", value)
+		inst.Undefined = true
+		inst.Length = len(inst.Bytes)
+		return inst, pc + 1
+	}
+
+	nBytes := instructionLength(op, widths.Address, widths.Register)
+	line := make([]uint8, maxInstructionSize)
+	for i := uint16(0); i < nBytes; i++ {
+		line[i] = mem.PeekCode(pc + uint32(i))
+		inst.Bytes = append(inst.Bytes, line[i])
+	}
+	pc += uint32(nBytes)
+
+	inst.Mnemonic = op.name
+	inst.Mode = AddressMode(op.addressMode)
+	inst.Widths = widths
+	inst.Operand, inst.Target, inst.HasTarget = operandString(op, line, widths.Address, widths.Register, pc, sym)
+	inst.Length = len(inst.Bytes)
+	return inst, pc
+}
+
+// instructionLength returns the bytes of the instruction for the given widths
+func instructionLength(op opcode, abWidth uint8, rWidth uint8) uint16 {
+	nBytes := op.bytes
+	// 24T8 - add one more byte when an opcode has an address or a long branch
+	if (abWidth == AB24) && ((nBytes >= 3) || (op.addressMode == modeRelative)) {
+		nBytes++
+	}
+	// 24T8 - add more bytes for long immediates
+	if op.addressMode == modeImmediate {
+		switch rWidth {
+		case R16:
+			nBytes++
+		case R24:
+			nBytes += 2
+		}
+	}
+	return nBytes
+}
+
+// operandString formats the operand of an instruction. next is the address
+// of the following instruction, the base for relative branches. The addresses
+// with a symbol in sym, that can be nil, are replaced by its name.
+func operandString(op opcode, line []uint8, abWidth uint8, rWidth uint8, next uint32, sym Symbolizer) (string, uint32, bool) {
+	address := getWordInLine(line)
+	addressFormat := "$%04x"
+	if abWidth == AB24 {
+		address = get24BitsInLine(line)
+		addressFormat = "$%06x"
+	}
+	name := func(a uint32, format string) string {
+		if sym != nil {
+			if n, ok := sym.SymbolAt(a); ok {
+				return n
+			}
+		}
+		return fmt.Sprintf(format, a)
+	}
+	zeroPage := uint32(line[1])
+
+	switch op.addressMode {
+	case modeImplicit, modeImplicitX, modeImplicitY:
+		return "", 0, false
+	case modeAccumulator:
+		return "A", 0, false
+	case modeImmediate:
+		switch rWidth {
+		case R24:
+			return fmt.Sprintf("#$%02x%02x%02x", line[3], line[2], line[1]), 0, false
+		case R16:
+			return fmt.Sprintf("#$%02x%02x", line[2], line[1]), 0, false
+		default:
+			return fmt.Sprintf("#$%02x", line[1]), 0, false
+		}
+	case modeZeroPage:
+		return name(zeroPage, "$%02x"), zeroPage, true
+	case modeZeroPageX:
+		return name(zeroPage, "$%02x") + ",X", zeroPage, true
+	case modeZeroPageY:
+		return name(zeroPage, "$%02x") + ",Y", zeroPage, true
+	case modeRelative:
+		var target uint32
+		if abWidth == AB24 {
+			target = (next + uint32(int16(getWordInLine(line)))) & 0xffffff
+		} else {
+			target = next + uint32(int8(line[1]))
+			if next <= 0xffff {
+				target &= 0xffff
+			}
+		}
+		return name(target, targetFormat(target)), target, true
+	case modeAbsolute:
+		return name(address, addressFormat), address, true
+	case modeAbsoluteX, modeAbsoluteX65c02:
+		return name(address, addressFormat) + ",X", address, true
+	case modeAbsoluteY:
+		return name(address, addressFormat) + ",Y", address, true
+	case modeIndirect, modeIndirect65c02Fix:
+		return "(" + name(address, addressFormat) + ")", address, true
+	case modeIndexedIndirectX:
+		return "(" + name(zeroPage, "$%02x") + ",X)", zeroPage, true
+	case modeIndirectIndexedY:
+		return "(" + name(zeroPage, "$%02x") + "),Y", zeroPage, true
+	case modeIndirectZeroPage:
+		return "(" + name(zeroPage, "$%02x") + ")", zeroPage, true
+	case modeAbsoluteIndexedIndirectX:
+		return "(" + name(address, addressFormat) + ",X)", address, true
+	case modeZeroPageAndRelative:
+		target := next + uint32(int8(line[2]))
+		if next <= 0xffff {
+			target &= 0xffff
+		}
+		return name(zeroPage, "$%02x") + "," + name(target, targetFormat(target)), target, true
+	case modeX:
+		return "X", 0, false
+	case modeXY:
+		return "XY", 0, false
+	default:
+		return "UNKNOWN MODE", 0, false
+	}
+}
+
+func targetString(target uint32) string {
+	return fmt.Sprintf(targetFormat(target), target)
+}
+
+func targetFormat(target uint32) string {
+	if target > 0xffff {
+		return "$%06x"
+	}
+	return "$%04x"
+}
+
+// DisassembleRange decodes the instructions from start up to, and excluding, end
+func DisassembleRange(model Model, mem Memory, start uint32, end uint32) ([]Instruction, error) {
+	return DisassembleRangeSymbols(model, mem, start, end, nil)
+}
+
+// DisassembleRangeSymbols decodes the instructions from start up to, and
+// excluding, end with the addresses replaced by the names of their symbols
+func DisassembleRangeSymbols(model Model, mem Memory, start uint32, end uint32, sym Symbolizer) ([]Instruction, error) {
+	opcodes, err := opcodesForModel(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var instructions []Instruction
+	for addr := start; addr < end; {
+		var inst Instruction
+		inst, addr = disassemble(opcodes, mem, addr, Widths{}, sym)
+		instructions = append(instructions, inst)
+	}
+	return instructions, nil
+}
+
+// WriteListing writes a ca65 compatible listing of the range from start up
+// to, and excluding, end. Each line has the address and bytes as a comment.
+func WriteListing(w io.Writer, model Model, mem Memory, start uint32, end uint32) error {
+	return WriteListingSymbols(w, model, mem, start, end, nil)
+}
+
+// WriteListingSymbols writes a listing as WriteListing, using the symbols. The
+// symbols at the start of instructions are written as labels, the others
+// referenced are defined with their address at the beginning.
+func WriteListingSymbols(w io.Writer, model Model, mem Memory, start uint32, end uint32, sym Symbolizer) error {
+	cpu := ""
+	switch model {
+	case ModelNMOS6502:
+		cpu = "6502X"
+	case ModelCMOS65c02:
+		cpu = "65C02"
+	case ModelWDC65c02:
+		cpu = "W65C02"
+	}
+	if cpu != "" {
+		_, err := fmt.Fprintf(w, "\t.setcpu \"%v\"\n", cpu)
+		if err != nil {
+			return err
+		}
+	}
+	instructions, err := DisassembleRangeSymbols(model, mem, start, end, sym)
+	if err != nil {
+		return err
+	}
+	if sym != nil {
+		if err := writeListingEquates(w, instructions, sym); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "\t.org %v\n", targetString(start))
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range instructions {
+		if sym != nil {
+			if name, ok := sym.SymbolAt(inst.Address); ok {
+				if _, err = fmt.Fprintf(w, "%v:\n", name); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = fmt.Fprintf(w, "\t%-20s; %v\n", listingText(inst), listingComment(inst))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeListingEquates defines the symbols referenced that are not labels of
+// the listing
+func writeListingEquates(w io.Writer, instructions []Instruction, sym Symbolizer) error {
+	labels := make(map[uint32]bool)
+	for _, inst := range instructions {
+		labels[inst.Address] = true
+	}
+
+	defined := make(map[string]bool)
+	for _, inst := range instructions {
+		if !inst.HasTarget {
+			continue
+		}
+		addresses := []uint32{inst.Target}
+		if inst.Mode == ModeZeroPageAndRelative {
+			addresses = append(addresses, uint32(inst.Bytes[len(inst.Bytes)-2]))
+		}
+		for _, a := range addresses {
+			name, ok := sym.SymbolAt(a)
+			if !ok || labels[a] || defined[name] {
+				continue
+			}
+			defined[name] = true
+			if _, err := fmt.Fprintf(w, "%v = %v\n", name, targetString(a)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listingText(inst Instruction) string {
+	operand := inst.Operand
+	// ca65 would choose the zero page opcode for absolute addresses below $100
+	switch inst.Mode {
+	case ModeAbsolute, ModeAbsoluteX, ModeAbsoluteX65c02, ModeAbsoluteY:
+		if inst.Widths.Address == AB16 && inst.Target < 0x100 {
+			operand = "a:" + operand
+		}
+	}
+
+	t := inst.Mnemonic
+	if operand != "" {
+		t += " " + operand
+	}
+	if inst.Prefix != "" {
+		// Prefixes go in their own line
+		t = inst.Prefix + "\n\t" + t
+	}
+	return t
+}
+
+func listingComment(inst Instruction) string {
+	t := targetString(inst.Address) + ":"
+	for _, b := range inst.Bytes {
+		t += fmt.Sprintf(" %02x", b)
+	}
+	return t
+}
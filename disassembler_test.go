@@ -0,0 +1,159 @@
+package iz6502
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func pokeBytes(m Memory, address uint32, data []uint8) {
+	for i, v := range data {
+		m.Poke(address+uint32(i), v)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	m := new(FlatMemory)
+	pokeBytes(m, 0x0400, []uint8{
+		0xA9, 0x42, // LDA #$42
+		0xD0, 0xFC, // BNE $0400
+		0xBD, 0x34, 0x12, // LDA $1234,X
+		0x6C, 0x00, 0x03, // JMP ($0300)
+		0x0F, 0x12, 0x03, // BBR0 $12,$0410 on 65c02
+	})
+
+	cases := []struct {
+		model  Model
+		addr   uint32
+		text   string
+		length int
+		target uint32
+		mode   AddressMode
+	}{
+		{ModelNMOS6502, 0x0400, "LDA #$42", 2, 0, ModeImmediate},
+		{ModelNMOS6502, 0x0402, "BNE $0400", 2, 0x0400, ModeRelative},
+		{ModelNMOS6502, 0x0404, "LDA $1234,X", 3, 0x1234, ModeAbsoluteX},
+		{ModelNMOS6502, 0x0407, "JMP ($0300)", 3, 0x0300, ModeIndirect},
+		{ModelNMOS6502, 0x040A, "SLO $0312", 3, 0x0312, ModeAbsolute},
+		{ModelCMOS65c02, 0x040A, "BBR0 $12,$0410", 3, 0x0410, ModeZeroPageAndRelative},
+	}
+	for _, c := range cases {
+		inst, next, err := Disassemble(c.model, m, c.addr, Widths{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inst.String() != c.text || inst.Length != c.length || next != c.addr+uint32(c.length) {
+			t.Errorf("Disassembled %v with length %v, expected %v", inst, inst.Length, c.text)
+		}
+		if inst.Target != c.target || inst.Mode != c.mode {
+			t.Errorf("Wrong target $%04x or mode %v for %v", inst.Target, inst.Mode, inst)
+		}
+	}
+}
+
+func TestDisassemblePrefix24T8(t *testing.T) {
+	m := new(Flat256KMemory)
+	pokeBytes(m, 0x0400, []uint8{
+		0x6F, 0xA9, 0x56, 0x34, 0x12, // W24 LDA #$123456
+		0x4F, 0xAD, 0x56, 0x34, 0x12, // A24 LDA $123456
+		0x4F, 0xD0, 0x00, 0x01, // A24 BNE $04010E+$0100
+		0xEA, // NOP
+	})
+
+	inst, next, _ := Disassemble(ModelMythical65c24T8, m, 0x0400, Widths{})
+	if inst.String() != "W24 LDA #$123456" || next != 0x0405 {
+		t.Errorf("Wrong W24 prefixed instruction %v", inst)
+	}
+	inst, next, _ = Disassemble(ModelMythical65c24T8, m, next, Widths{})
+	if inst.String() != "A24 LDA $123456" || inst.Target != 0x123456 || next != 0x040A {
+		t.Errorf("Wrong A24 prefixed instruction %v", inst)
+	}
+	inst, next, _ = Disassemble(ModelMythical65c24T8, m, next, Widths{})
+	if inst.Target != 0x050E || next != 0x040E {
+		t.Errorf("Wrong long branch %v to $%06x", inst, inst.Target)
+	}
+}
+
+func TestDisassembleUnknownModel(t *testing.T) {
+	m := new(FlatMemory)
+	if _, _, err := Disassemble(Model(0), m, 0x0400, Widths{}); err == nil {
+		t.Error("Disassembled for an unknown model")
+	}
+	if _, err := DisassembleRange(Model(0), m, 0x0400, 0x0410); err == nil {
+		t.Error("Disassembled a range for an unknown model")
+	}
+	if err := WriteListing(ioutil.Discard, Model(0), m, 0x0400, 0x0410); err == nil {
+		t.Error("Listing written for an unknown model")
+	}
+}
+
+func TestWriteListing(t *testing.T) {
+	m := new(FlatMemory)
+	pokeBytes(m, 0x0400, []uint8{
+		0xAD, 0x12, 0x00, // LDA a:$0012
+		0xEA, // NOP
+	})
+
+	var buf bytes.Buffer
+	err := WriteListing(&buf, ModelCMOS65c02, m, 0x0400, 0x0404)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := buf.String()
+	for _, expected := range []string{".setcpu \"65C02\"", ".org $0400", "LDA a:$0012", "; $0400: ad 12 00"} {
+		if !strings.Contains(listing, expected) {
+			t.Errorf("Missing %v in listing:\n%v", expected, listing)
+		}
+	}
+}
+
+// testSymbols names the addresses, all of them labels
+type testSymbols map[uint32]string
+
+func (ts testSymbols) SymbolAt(address uint32) (string, bool) {
+	name, ok := ts[address]
+	return name, ok
+}
+
+func (ts testSymbols) NearestSymbol(address uint32) (string, uint32, bool) {
+	best, found := uint32(0), false
+	for a := range ts {
+		if a <= address && (!found || a > best) {
+			best, found = a, true
+		}
+	}
+	return ts[best], address - best, found
+}
+
+func TestWriteListingSymbols(t *testing.T) {
+	m := new(FlatMemory)
+	pokeBytes(m, 0x0400, []uint8{
+		0x20, 0xa3, 0xc0, // JSR print_string
+		0xA5, 0x12, // LDA counter
+		0x80, 0xf9, // BRA main
+	})
+	sym := testSymbols{0x0400: "main", 0xc0a3: "print_string", 0x0012: "counter"}
+
+	var buf bytes.Buffer
+	err := WriteListingSymbols(&buf, ModelCMOS65c02, m, 0x0400, 0x0406, sym)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := buf.String()
+	for _, expected := range []string{"print_string = $c0a3", "counter = $0012", "main:\n", "JSR print_string", "LDA counter", "BRA main"} {
+		if !strings.Contains(listing, expected) {
+			t.Errorf("Missing %v in listing:\n%v", expected, listing)
+		}
+	}
+	if strings.Contains(listing, "main =") {
+		t.Errorf("Label defined as equate in listing:\n%v", listing)
+	}
+
+	if s := SymbolString(sym, 0x0405); s != "main+$5" {
+		t.Errorf("Wrong symbol string %v", s)
+	}
+	if s := SymbolString(nil, 0x0405); s != "$0405" {
+		t.Errorf("Wrong symbol string without symbols %v", s)
+	}
+}
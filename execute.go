@@ -1,9 +1,9 @@
 package iz6502
 
 import (
-	"encoding/binary"
 	"fmt"
-	"io"
+	"os"
+	"strings"
 )
 
 // https://www.masswerk.at/6502/6502_instruction_set.html
@@ -15,13 +15,75 @@ const (
 	maxInstructionSize = 4
 )
 
+// Model identifies the processor being emulated
+type Model uint8
+
+const (
+	// ModelNMOS6502 is the original NMOS 6502, with the undocumented opcodes
+	ModelNMOS6502 Model = iota + 1
+	// ModelCMOS65c02 is the 65c02 with the Rockwell bit instructions
+	ModelCMOS65c02
+	// ModelWDC65c02 is the WDC 65c02, adding WAI and STP
+	ModelWDC65c02
+	// ModelMythical65c24T8 is the 24-bit, 8 threads, 65c24T8
+	ModelMythical65c24T8
+)
+
+func (m Model) String() string {
+	switch m {
+	case ModelNMOS6502:
+		return "NMOS6502"
+	case ModelCMOS65c02:
+		return "CMOS65c02"
+	case ModelWDC65c02:
+		return "WDC65c02"
+	case ModelMythical65c24T8:
+		return "65c24T8"
+	default:
+		return fmt.Sprintf("Model(%d)", uint8(m))
+	}
+}
+
+// ParseModel returns the model for a name as returned by String, or an alias
+// like 6502, 65c02 or 24t8. Case is ignored.
+func ParseModel(name string) (Model, error) {
+	switch strings.ToLower(name) {
+	case "nmos6502", "6502", "nmos":
+		return ModelNMOS6502, nil
+	case "cmos65c02", "65c02", "cmos":
+		return ModelCMOS65c02, nil
+	case "wdc65c02", "w65c02", "wdc":
+		return ModelWDC65c02, nil
+	case "65c24t8", "24t8", "mythical65c24t8":
+		return ModelMythical65c24T8, nil
+	}
+	return 0, fmt.Errorf("unknown cpu model '%v'", name)
+}
+
+// NewState returns an initialized processor of the model
+func NewState(model Model, m Memory) (*State, error) {
+	switch model {
+	case ModelNMOS6502:
+		return NewNMOS6502(m), nil
+	case ModelCMOS65c02:
+		return NewCMOS65c02(m), nil
+	case ModelWDC65c02:
+		return NewWDC65c02(m), nil
+	case ModelMythical65c24T8:
+		return NewMythical65c24T8(m), nil
+	}
+	return nil, fmt.Errorf("unknown cpu model %v", model)
+}
+
 // State represents the state of the simulated device
 type State struct {
+	model   Model
 	opcodes *[256]opcode
-	trace   bool
+	tracer  Tracer
 
 	reg    registers
 	mem    Memory
+	access AccessMemory // mem, when it implements AccessMemory
 	cycles uint64
 
 	// 24T8 state for current and maximum address and register widths
@@ -32,12 +94,39 @@ type State struct {
 	rMaxWidth 	uint8
 	sWidth 		uint8
 
+	// 24T8 register banks of the hardware threads. The current one is in reg
+	threads []thread
+	thread  uint8
+
+	// Interrupt lines
+	irqLine    bool
+	nmiPending bool
+
+	// Magic constant of the unstable NMOS ANE and LXA opcodes
+	magicConstant uint8
+
+	// WDC 65c02 low power states
+	waiting bool
+	stopped bool
+
+	// What to do with opcodes not defined for the model
+	illegalPolicy  IllegalOpcodePolicy
+	illegalHandler IllegalOpcodeHandler
+
 	extraCycleCrossingBoundaries bool
 	extraCycleBranchTaken        bool
 	extraCycleBCD                bool
 	lineCache                    []uint8
 	// We cache the allocation of a line to avoid a malloc per instruction. To be used only
 	// by ExecuteInstruction(). 2x speedup on the emulation!!
+
+	// Event of the instruction being traced, with the address it accesses
+	traceEvent          TraceEvent
+	effectiveAddress    uint32
+	hasEffectiveAddress bool
+
+	// Instruction in progress with Tick
+	tick cycleState
 }
 
 const (
@@ -64,7 +153,7 @@ type opFunc func(s *State, line []uint8, opcode opcode)
 func (s *State) executeLine(line []uint8) {
 	opcode := s.opcodes[line[0]]
 	if opcode.cycles == 0 {
-		panic(fmt.Sprintf("Unknown opcode 0x%02x\n", line[0]))
+		panic(&IllegalOpcodeError{PC: s.reg.getPC(), Opcode: line[0], Model: s.model})
 	}
 
 	// 24T8 if the previous instruction is not a prefix code, switch back to 16/8 mode
@@ -78,13 +167,77 @@ func (s *State) executeLine(line []uint8) {
 }
 
 // ExecuteInstruction transforms the state given after a single instruction is executed.
+// It panics on errors, use Step to handle them.
 func (s *State) ExecuteInstruction() {
+	if err := s.Step(); err != nil {
+		panic(err)
+	}
+}
+
+// Step transforms the state given after a single instruction is executed. Illegal
+// opcodes are handled as configured with SetIllegalOpcodePolicy.
+func (s *State) Step() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			assert, ok := r.(assertError)
+			if !ok {
+				panic(r)
+			}
+			err = assert
+		}
+	}()
+
+	if s.tick.active {
+		// Completes the instruction started with Tick
+		for s.tick.active {
+			s.tickNext()
+		}
+		return nil
+	}
+
+	if s.stopped {
+		// After STP only a reset restarts the processor
+		s.cycles++
+		return nil
+	}
+	if s.waiting {
+		// After WAI the processor idles until an interrupt line is asserted
+		if !s.irqLine && !s.nmiPending {
+			s.cycles++
+			return nil
+		}
+		s.waiting = false
+	}
+
+	if s.irqLine || s.nmiPending {
+		var before Registers
+		cycle := s.cycles
+		if s.tracer != nil {
+			before = s.registers()
+		}
+		if s.pollInterrupts() {
+			if s.tracer != nil {
+				s.traceEvent = TraceEvent{
+					Cycle:     cycle,
+					Cycles:    s.cycles - cycle,
+					PC:        before.PC,
+					Before:    before,
+					After:     s.registers(),
+					Widths:    Widths{s.abWidth, s.rWidth},
+					Interrupt: true,
+				}
+				s.tracer.Trace(&s.traceEvent)
+			}
+			return nil
+		}
+	}
+
 	pc := s.reg.getPC()
-	opcodeID := s.mem.PeekCode(pc)
+	opcodeID := s.peek(pc, AccessOpcodeFetch)
 	opcode := s.opcodes[opcodeID]
 
 	if opcode.cycles == 0 {
-		panic(fmt.Sprintf("Unknown opcode 0x%02x\n", opcodeID))
+		return s.illegalOpcode(pc, opcodeID)
 	}
 
 	// 24T8 if the previous instruction is not a prefix code, switch back to 16/8 mode
@@ -97,19 +250,12 @@ func (s *State) ExecuteInstruction() {
 	if s.lineCache == nil {
 		s.lineCache = make([]uint8, maxInstructionSize)
 	}
-	nBytes := opcode.bytes
-	// 24T8 - add one more byte when an opcode has an address or a long branch
-	if (s.abWidth == AB24) && ((nBytes >= 3) || (opcode.addressMode == modeRelative)) {
-		nBytes += 1
-	}
-	if (opcode.addressMode == modeImmediate) && (s.rWidth != R08) {  // 24T8 - add more bytes for long immediates
-		switch s.rWidth {
-		case R16: nBytes += 1
-		case R24: nBytes += 2
-		}
-	}
+	nBytes := instructionLength(opcode, s.abWidth, s.rWidth)
+	s.lineCache[0] = opcodeID
 	for i := uint16(0); i < nBytes; i++ {
-		s.lineCache[i] = s.mem.PeekCode(pc)
+		if i > 0 {
+			s.lineCache[i] = s.peek(pc, AccessOperandFetch)
+		}
 		pc++
 
 		// 24T8 BACKWARD COMPATIBILITY - roll around the PC from $FFFF to $0000 if in 16-bit address mode
@@ -117,12 +263,16 @@ func (s *State) ExecuteInstruction() {
 			pc = 0x000000;
 		}
 	}
-	s.reg.setPC(pc)
 
-	if s.trace {
-		//fmt.Printf("%#06x %#02x\n", pc-uint32(opcode.bytes), opcodeID)
-		fmt.Printf("%#06x %-13s: ", pc-uint32(nBytes), lineString(s, s.lineCache, opcode))
+	var before Registers
+	widths := Widths{s.abWidth, s.rWidth}
+	cycle := s.cycles
+	if s.tracer != nil {
+		before = s.registers()
+		s.hasEffectiveAddress = false
 	}
+	s.reg.setPC(pc)
+
 	opcode.action(s, s.lineCache, opcode)
 	s.cycles += uint64(opcode.cycles)
 
@@ -140,9 +290,24 @@ func (s *State) ExecuteInstruction() {
 		s.extraCycleBCD = false
 	}
 
-	if s.trace {
-		fmt.Printf("%v, [%02x] <w%x/%x>\n", s.reg, s.lineCache[0:opcode.bytes], s.abWidth, s.rWidth)
+	if s.tracer != nil {
+		s.traceEvent = TraceEvent{
+			Cycle:      cycle,
+			Cycles:     s.cycles - cycle,
+			PC:         before.PC,
+			Length:     int(nBytes),
+			Mnemonic:   opcode.name,
+			Address:    s.effectiveAddress,
+			HasAddress: s.hasEffectiveAddress,
+			Before:     before,
+			After:      s.registers(),
+			Widths:     widths,
+			op:         opcode,
+		}
+		copy(s.traceEvent.Raw[:], s.lineCache[:nBytes])
+		s.tracer.Trace(&s.traceEvent)
 	}
+	return nil
 }
 
 // Reset resets the processor. Moves the program counter to the vector in 0xfffc (24T8 or 0xffffc )
@@ -153,12 +318,21 @@ func (s *State) Reset() {
 	switch (s.abMaxWidth) {
 		case AB24:
 			s.abWidth = s.abMaxWidth
-			startAddress = get24Bits(s.mem, vector24Reset)
+			startAddress = get24Bits(s, vector24Reset, AccessVector)
 		default:
-			startAddress = uint32(getWord(s.mem, vectorReset))
+			startAddress = uint32(getWord(s, vectorReset, AccessVector))
 	}
 	s.cycles += 6
 	s.reg.setPC(startAddress)
+	s.nmiPending = false
+	s.waiting = false
+	s.stopped = false
+	s.resetThreads()
+}
+
+// Model returns the processor being emulated
+func (s *State) Model() Model {
+	return s.model
 }
 
 // GetCycles returns the count of CPU cycles since last reset.
@@ -166,19 +340,54 @@ func (s *State) GetCycles() uint64 {
 	return s.cycles
 }
 
-// SetTrace activates tracing of the cpu execution
+// IsWaiting returns true if the processor is suspended by WAI waiting for an interrupt
+func (s *State) IsWaiting() bool {
+	return s.waiting
+}
+
+// IsStopped returns true if the processor is stopped by STP until the next reset
+func (s *State) IsStopped() bool {
+	return s.stopped
+}
+
+// SkipCycles advances the cycle counter. Used by hosts to fast-forward while the
+// processor is waiting or stopped.
+func (s *State) SkipCycles(cycles uint64) {
+	s.cycles += cycles
+}
+
+// SetMagicConstant changes the constant ORed with A by the unstable ANE and LXA
+// opcodes of the NMOS 6502. It depends on the chip and temperature, usually
+// 0x00, 0xee or 0xff. Defaults to 0xee.
+func (s *State) SetMagicConstant(magic uint8) {
+	s.magicConstant = magic
+}
+
+// SetTrace activates tracing of the cpu execution as text on the standard output.
+// Use SetTracer for other destinations.
 func (s *State) SetTrace(trace bool) {
-	s.trace = trace
+	if trace {
+		s.tracer = NewTextTracer(os.Stdout)
+	} else {
+		s.tracer = nil
+	}
 }
 
 // GetTrace gets trhe tracing state of the cpu execution
 func (s *State) GetTrace() bool {
-	return s.trace
+	return s.tracer != nil
 }
 
-// SetMemory changes the memory provider
+// SetMemory changes the memory provider. If it implements AccessMemory, it
+// is told the kind of each access.
 func (s *State) SetMemory(mem Memory) {
 	s.mem = mem
+	s.access, _ = mem.(AccessMemory)
+}
+
+// GetMemory returns the memory provider
+func (s *State) GetMemory() Memory {
+	return s.mem
 }
 
 // GetPCAndSP returns the current program counter and stack pointer. Used to trace MLI calls
@@ -209,33 +418,28 @@ func (s *State) SetPC(pc uint32) {
 	s.reg.setPC(pc)
 }
 
-// Save saves the CPU state (registers and cycle counter)
-func (s *State) Save(w io.Writer) error {
-	err := binary.Write(w, binary.BigEndian, s.cycles)
-	if err != nil {
-		return err
-	}
-	binary.Write(w, binary.BigEndian, s.reg.data)
-	if err != nil {
-		return err
-	}
-	return nil
+// Exported view of the PC
+func (s *State) GetPC() uint32 {
+	return s.reg.getPC()
 }
 
-// Load loads the CPU state (registers and cycle counter)
-func (s *State) Load(r io.Reader) error {
-	err := binary.Read(r, binary.BigEndian, &s.cycles)
-	if err != nil {
-		return err
-	}
-	err = binary.Read(r, binary.BigEndian, &s.reg.data)
-	if err != nil {
-		return err
-	}
-	return nil
+// GetRegisters returns all the registers, with the 65c24T8 registers in their full 24 bits
+func (s *State) GetRegisters() Registers {
+	return s.registers()
 }
 
-// Exported view of the PC
-func (s *State) GetPC() uint32 {
-	return s.reg.getPC()
+// SetRegisters changes all the registers. The values are truncated to 8 bits, and
+// 16 bits for the PC, except on the 65c24T8.
+func (s *State) SetRegisters(r Registers) {
+	width := uint8(R24)
+	if s.abMaxWidth == AB16 {
+		width = R08
+		r.PC &= 0xffff
+	}
+	s.reg.setA(width, r.A)
+	s.reg.setX(width, r.X)
+	s.reg.setY(width, r.Y)
+	s.reg.setSP(width, r.SP)
+	s.reg.setP(r.P)
+	s.reg.setPC(r.PC)
 }
@@ -483,3 +483,22 @@ func TestStack(t *testing.T) {
 		t.Errorf("Error in PLP, %v", s.reg)
 	}
 }
+
+func TestParseModel(t *testing.T) {
+	for _, m := range []Model{ModelNMOS6502, ModelCMOS65c02, ModelWDC65c02, ModelMythical65c24T8} {
+		parsed, err := ParseModel(m.String())
+		if err != nil || parsed != m {
+			t.Errorf("Error parsing %v: %v %v", m, parsed, err)
+		}
+		s, err := NewState(m, new(FlatMemory))
+		if err != nil || s.Model() != m {
+			t.Errorf("Error creating %v: %v", m, err)
+		}
+	}
+	if m, _ := ParseModel("65C02"); m != ModelCMOS65c02 {
+		t.Errorf("Error parsing alias 65C02")
+	}
+	if _, err := ParseModel("z80"); err == nil {
+		t.Errorf("Unknown model accepted")
+	}
+}
@@ -0,0 +1,557 @@
+// Package gdbstub serves the GDB remote serial protocol for a processor, to
+// debug guest code with GDB, LLDB or any frontend speaking the protocol.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/debug"
+)
+
+// Signals reported in the stop replies
+const (
+	sigINT  = 2
+	sigILL  = 4
+	sigTRAP = 5
+)
+
+// Server is a GDB stub for a processor. It handles one connection at a time.
+type Server struct {
+	d *debug.Debugger
+
+	// Breakpoint and watchpoint ids by packet, to remove them with z
+	points map[string]int
+
+	lastStop string
+	noAck    bool // Only used by the reader goroutine
+
+	w  *bufio.Writer
+	mu sync.Mutex // Protects w
+}
+
+// New creates a stub for the processor, attaching a debugger to it
+func New(s *iz6502.State) *Server {
+	return NewWithDebugger(debug.New(s))
+}
+
+// NewWithDebugger creates a stub sharing a debugger already attached
+func NewWithDebugger(d *debug.Debugger) *Server {
+	return &Server{
+		d:        d,
+		points:   make(map[string]int),
+		lastStop: fmt.Sprintf("S%02x", sigTRAP),
+	}
+}
+
+// Debugger returns the debugger used by the stub
+func (g *Server) Debugger() *debug.Debugger {
+	return g.d
+}
+
+// ListenAndServe accepts connections on a "tcp" or "unix" address and serves
+// them one after the other
+func (g *Server) ListenAndServe(network string, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = g.Serve(conn)
+		conn.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
+
+// Serve handles a connection until the client detaches, kills or disconnects
+func (g *Server) Serve(conn io.ReadWriter) error {
+	g.w = bufio.NewWriter(conn)
+	g.noAck = false
+	packets := make(chan string)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	// The packets are read on a goroutine to receive the interrupt
+	// requests while the processor runs
+	go func() {
+		errs <- g.readPackets(bufio.NewReader(conn), packets, done)
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case packet := <-packets:
+			reply, end := g.handle(packet)
+			if err := g.send(reply); err != nil {
+				return err
+			}
+			if end {
+				return nil
+			}
+		}
+	}
+}
+
+func (g *Server) readPackets(r *bufio.Reader, packets chan<- string, done <-chan struct{}) error {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case 0x03:
+			g.d.Interrupt()
+			continue
+		case '$':
+		default:
+			// Acks and noise
+			continue
+		}
+
+		data, err := r.ReadString('#')
+		if err != nil {
+			return err
+		}
+		data = data[:len(data)-1]
+		checksum := make([]byte, 2)
+		if _, err := io.ReadFull(r, checksum); err != nil {
+			return err
+		}
+
+		if !g.noAck {
+			expected, err := strconv.ParseUint(string(checksum), 16, 8)
+			ack := "+"
+			if err != nil || uint8(expected) != packetChecksum(data) {
+				ack = "-"
+			}
+			if err := g.write(ack); err != nil {
+				return err
+			}
+			if ack == "-" {
+				continue
+			}
+			if data == "QStartNoAckMode" {
+				g.noAck = true
+			}
+		}
+
+		select {
+		case packets <- unescape(data):
+		case <-done:
+			return nil
+		}
+	}
+}
+
+func (g *Server) write(s string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := g.w.WriteString(s); err != nil {
+		return err
+	}
+	return g.w.Flush()
+}
+
+func (g *Server) send(data string) error {
+	data = escape(data)
+	return g.write(fmt.Sprintf("$%s#%02x", data, packetChecksum(data)))
+}
+
+func packetChecksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func escape(data string) string {
+	if !strings.ContainsAny(data, "$#}*") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c == '$' || c == '#' || c == '}' || c == '*' {
+			b.WriteByte('}')
+			c ^= 0x20
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func unescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c == '}' && i+1 < len(data) {
+			i++
+			c = data[i] ^ 0x20
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// handle processes a packet, returning the reply and true to end the session
+func (g *Server) handle(packet string) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+
+	switch packet[0] {
+	case '?':
+		return g.lastStop, false
+	case 'g':
+		return g.readRegisters(), false
+	case 'G':
+		return g.writeRegisters(packet[1:]), false
+	case 'p':
+		return g.readRegister(packet[1:]), false
+	case 'P':
+		return g.writeRegister(packet[1:]), false
+	case 'm':
+		return g.readMemory(packet[1:]), false
+	case 'M':
+		return g.writeMemory(packet[1:], false), false
+	case 'X':
+		return g.writeMemory(packet[1:], true), false
+	case 'c', 's':
+		if len(packet) > 1 {
+			address, err := strconv.ParseUint(packet[1:], 16, 32)
+			if err != nil {
+				return "E01", false
+			}
+			g.d.State().SetPC(uint32(address))
+		}
+		var stop debug.Stop
+		if packet[0] == 'c' {
+			stop = g.d.Continue()
+		} else {
+			stop = g.d.Step()
+		}
+		g.lastStop = stopReply(stop)
+		return g.lastStop, false
+	case 'Z', 'z':
+		return g.breakpoint(packet), false
+	case 'H', 'T':
+		// A single thread
+		return "OK", false
+	case 'k':
+		return "OK", true
+	case 'D':
+		return "OK", true
+	case 'q':
+		return g.query(packet[1:]), false
+	case 'Q':
+		if packet == "QStartNoAckMode" {
+			// Acks are disabled by the reader
+			return "OK", false
+		}
+	}
+	return "", false
+}
+
+func stopReply(stop debug.Stop) string {
+	switch stop.Reason {
+	case debug.StopInterrupted:
+		return fmt.Sprintf("S%02x", sigINT)
+	case debug.StopError:
+		return fmt.Sprintf("S%02x", sigILL)
+	case debug.StopWatchpoint:
+		kind := "awatch"
+		switch stop.Watchpoint.Access {
+		case debug.AccessWrite:
+			kind = "watch"
+		case debug.AccessRead:
+			kind = "rwatch"
+		}
+		return fmt.Sprintf("T%02x%s:%x;", sigTRAP, kind, stop.Address)
+	case debug.StopBreakpoint:
+		return fmt.Sprintf("T%02xswbreak:;", sigTRAP)
+	default:
+		return fmt.Sprintf("S%02x", sigTRAP)
+	}
+}
+
+func (g *Server) query(q string) string {
+	switch {
+	case strings.HasPrefix(q, "Supported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+"
+	case q == "Attached":
+		return "1"
+	case q == "C":
+		return "QC1"
+	case q == "fThreadInfo":
+		return "m1"
+	case q == "sThreadInfo":
+		return "l"
+	case strings.HasPrefix(q, "Xfer:features:read:"):
+		return g.targetXML(q[len("Xfer:features:read:"):])
+	}
+	return ""
+}
+
+// registerSizes returns the size in bytes of A, X, Y, SP, PC and P
+func (g *Server) registerSizes() []int {
+	if g.d.State().AddressMaxWidth() == iz6502.AB24 {
+		return []int{3, 3, 3, 3, 3, 1}
+	}
+	return []int{1, 1, 1, 1, 2, 1}
+}
+
+var registerNames = []string{"a", "x", "y", "sp", "pc", "p"}
+
+func (g *Server) targetXML(annex string) string {
+	parts := strings.SplitN(annex, ":", 2)
+	if len(parts) != 2 || parts[0] != "target.xml" {
+		return "E00"
+	}
+	var offset, length uint64
+	if _, err := fmt.Sscanf(parts[1], "%x,%x", &offset, &length); err != nil {
+		return "E01"
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>` + "\n")
+	b.WriteString(`<!DOCTYPE target SYSTEM "gdb-target.dtd">` + "\n")
+	b.WriteString(`<target version="1.0">` + "\n")
+	fmt.Fprintf(&b, `  <feature name="org.lunarmobiscuit.iz6502.%v">`+"\n", g.d.State().Model())
+	for i, size := range g.registerSizes() {
+		kind := "uint" + strconv.Itoa(size*8)
+		switch registerNames[i] {
+		case "pc":
+			kind = "code_ptr"
+		case "sp":
+			kind = "data_ptr"
+		}
+		fmt.Fprintf(&b, `    <reg name="%s" bitsize="%d" regnum="%d" type="%s"/>`+"\n",
+			registerNames[i], size*8, i, kind)
+	}
+	b.WriteString("  </feature>\n</target>\n")
+
+	xml := b.String()
+	if offset >= uint64(len(xml)) {
+		return "l"
+	}
+	end := offset + length
+	if end >= uint64(len(xml)) {
+		return "l" + xml[offset:]
+	}
+	return "m" + xml[offset:end]
+}
+
+func registerValues(r iz6502.Registers) []uint32 {
+	return []uint32{r.A, r.X, r.Y, r.SP, r.PC, uint32(r.P)}
+}
+
+func setRegisterValue(r *iz6502.Registers, i int, v uint32) {
+	switch i {
+	case 0:
+		r.A = v
+	case 1:
+		r.X = v
+	case 2:
+		r.Y = v
+	case 3:
+		r.SP = v
+	case 4:
+		r.PC = v
+	case 5:
+		r.P = uint8(v)
+	}
+}
+
+// Registers are sent little endian
+func encodeRegister(v uint32, size int) string {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(v >> (8 * uint(i)))
+	}
+	return hex.EncodeToString(b)
+}
+
+func decodeRegister(h string) (uint32, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return 0, err
+	}
+	var v uint32
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint32(b[i])
+	}
+	return v, nil
+}
+
+func (g *Server) readRegisters() string {
+	values := registerValues(g.d.State().GetRegisters())
+	var b strings.Builder
+	for i, size := range g.registerSizes() {
+		b.WriteString(encodeRegister(values[i], size))
+	}
+	return b.String()
+}
+
+func (g *Server) writeRegisters(data string) string {
+	r := g.d.State().GetRegisters()
+	for i, size := range g.registerSizes() {
+		if len(data) < size*2 {
+			return "E01"
+		}
+		v, err := decodeRegister(data[:size*2])
+		if err != nil {
+			return "E01"
+		}
+		setRegisterValue(&r, i, v)
+		data = data[size*2:]
+	}
+	g.d.State().SetRegisters(r)
+	return "OK"
+}
+
+func (g *Server) readRegister(data string) string {
+	i, err := strconv.ParseUint(data, 16, 8)
+	sizes := g.registerSizes()
+	if err != nil || int(i) >= len(sizes) {
+		return "E01"
+	}
+	values := registerValues(g.d.State().GetRegisters())
+	return encodeRegister(values[i], sizes[i])
+}
+
+func (g *Server) writeRegister(data string) string {
+	parts := strings.SplitN(data, "=", 2)
+	if len(parts) != 2 {
+		return "E01"
+	}
+	i, err := strconv.ParseUint(parts[0], 16, 8)
+	if err != nil || int(i) >= len(registerNames) {
+		return "E01"
+	}
+	v, err := decodeRegister(parts[1])
+	if err != nil {
+		return "E01"
+	}
+	r := g.d.State().GetRegisters()
+	setRegisterValue(&r, int(i), v)
+	g.d.State().SetRegisters(r)
+	return "OK"
+}
+
+func parseAddressLength(data string) (uint32, int, error) {
+	var address, length uint64
+	if _, err := fmt.Sscanf(data, "%x,%x", &address, &length); err != nil {
+		return 0, 0, err
+	}
+	if length > 0x10000 {
+		return 0, 0, fmt.Errorf("length too long")
+	}
+	return uint32(address), int(length), nil
+}
+
+func (g *Server) readMemory(data string) string {
+	address, length, err := parseAddressLength(data)
+	if err != nil {
+		return "E01"
+	}
+	mem := g.d.Memory()
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = mem.Peek(address + uint32(i))
+	}
+	return hex.EncodeToString(b)
+}
+
+func (g *Server) writeMemory(data string, binary bool) string {
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return "E01"
+	}
+	address, length, err := parseAddressLength(parts[0])
+	if err != nil {
+		return "E01"
+	}
+	b := []byte(parts[1])
+	if !binary {
+		b, err = hex.DecodeString(parts[1])
+		if err != nil {
+			return "E01"
+		}
+	}
+	if len(b) != length {
+		return "E01"
+	}
+	mem := g.d.Memory()
+	for i, v := range b {
+		mem.Poke(address+uint32(i), v)
+	}
+	return "OK"
+}
+
+// breakpoint handles Z and z packets, type,address,kind
+func (g *Server) breakpoint(packet string) string {
+	insert := packet[0] == 'Z'
+	var kind, length uint64
+	var address uint64
+	if _, err := fmt.Sscanf(packet[1:], "%d,%x,%x", &kind, &address, &length); err != nil {
+		return "E01"
+	}
+	key := packet[1:]
+
+	if !insert {
+		id, ok := g.points[key]
+		if !ok {
+			return "E02"
+		}
+		g.d.Remove(id)
+		delete(g.points, key)
+		return "OK"
+	}
+
+	if _, ok := g.points[key]; ok {
+		return "OK"
+	}
+	var id int
+	switch kind {
+	case 0, 1:
+		b, err := g.d.AddBreakpoint(uint32(address), "")
+		if err != nil {
+			return "E01"
+		}
+		id = b.ID
+	case 2, 3, 4:
+		access := map[uint64]debug.Access{
+			2: debug.AccessWrite,
+			3: debug.AccessRead,
+			4: debug.AccessRead | debug.AccessWrite,
+		}[kind]
+		if length == 0 {
+			length = 1
+		}
+		id = g.d.AddWatchpoint(uint32(address), uint32(address+length-1), access).ID
+	default:
+		return ""
+	}
+	g.points[key] = id
+	return "OK"
+}
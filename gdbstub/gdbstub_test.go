@@ -0,0 +1,162 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestClient(t *testing.T, s *iz6502.State) (*testClient, *Server, chan error) {
+	server, client := net.Pipe()
+	g := New(s)
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Serve(server)
+		server.Close()
+	}()
+	return &testClient{t, client, bufio.NewReader(client)}, g, done
+}
+
+func (c *testClient) request(packet string) string {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c.conn, "$%s#%02x", packet, packetChecksum(packet))
+	ack, err := c.r.ReadByte()
+	if err != nil || ack != '+' {
+		c.t.Fatalf("Packet %v not acknowledged: %c %v", packet, ack, err)
+	}
+	return c.reply()
+}
+
+func (c *testClient) reply() string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatalf("Error reading the reply: %v", err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("Error reading the reply: %v", err)
+	}
+	checksum := make([]byte, 2)
+	c.r.Read(checksum)
+	c.conn.Write([]byte("+"))
+	return unescape(data[:len(data)-1])
+}
+
+func newTestState() *iz6502.State {
+	m := new(iz6502.FlatMemory)
+	program := []uint8{
+		0xa9, 0x42, // LDA #$42
+		0x8d, 0x00, 0x20, // STA $2000
+		0xe8,             // INX
+		0x4c, 0x05, 0x04, // JMP $0405
+	}
+	for i, b := range program {
+		m.Poke(0x0400+uint32(i), b)
+	}
+	s := iz6502.NewNMOS6502(m)
+	s.SetRegisters(iz6502.Registers{SP: 0xff, PC: 0x0400})
+	return s
+}
+
+func TestRegistersAndMemory(t *testing.T) {
+	c, _, done := newTestClient(t, newTestState())
+
+	if r := c.request("qSupported:multiprocess+"); !strings.Contains(r, "qXfer:features:read+") {
+		t.Errorf("Wrong qSupported reply %v", r)
+	}
+	if r := c.request("?"); r != "S05" {
+		t.Errorf("Wrong stop reason %v", r)
+	}
+	// A, X, Y, SP, PC little endian, P
+	if r := c.request("g"); r != "000000ff000400" {
+		t.Errorf("Wrong registers %v", r)
+	}
+	if r := c.request("P1=07"); r != "OK" {
+		t.Errorf("Wrong reply to P %v", r)
+	}
+	if r := c.request("p1"); r != "07" {
+		t.Errorf("Wrong X %v", r)
+	}
+	if r := c.request("m400,5"); r != "a9428d0020" {
+		t.Errorf("Wrong memory %v", r)
+	}
+	if r := c.request("M10,2:cafe"); r != "OK" {
+		t.Errorf("Wrong reply to M %v", r)
+	}
+	if r := c.request("m10,2"); r != "cafe" {
+		t.Errorf("Wrong memory written %v", r)
+	}
+	xml := c.request("qXfer:features:read:target.xml:0,1000")
+	if !strings.HasPrefix(xml, "l<?xml") || !strings.Contains(xml, `name="pc" bitsize="16"`) {
+		t.Errorf("Wrong target description %v", xml)
+	}
+	if r := c.request("D"); r != "OK" {
+		t.Errorf("Wrong reply to D %v", r)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBreakpointsAndRun(t *testing.T) {
+	s := newTestState()
+	c, _, _ := newTestClient(t, s)
+
+	if r := c.request("s"); r != "S05" || s.GetPC() != 0x0402 {
+		t.Errorf("Wrong step %v to $%04x", r, s.GetPC())
+	}
+	c.request("Z0,405,1")
+	if r := c.request("c"); r != "T05swbreak:;" || s.GetPC() != 0x0405 {
+		t.Errorf("Wrong continue %v to $%04x", r, s.GetPC())
+	}
+	if r := c.request("c"); r != "T05swbreak:;" {
+		t.Errorf("Breakpoint not hit again %v", r)
+	}
+	if r := c.request("z0,405,1"); r != "OK" {
+		t.Errorf("Wrong reply to z0 %v", r)
+	}
+
+	if r := c.request("Z2,2000,1"); r != "OK" {
+		t.Errorf("Wrong reply to Z2 %v", r)
+	}
+	if r := c.request("c400"); r != "T05watch:2000;" || s.GetPC() != 0x0405 {
+		t.Errorf("Wrong watchpoint stop %v at $%04x", r, s.GetPC())
+	}
+	c.request("z2,2000,1")
+
+	// Interrupt a running processor
+	fmt.Fprintf(c.conn, "$c#63")
+	c.r.ReadByte()
+	time.Sleep(10 * time.Millisecond)
+	c.conn.Write([]byte{0x03})
+	if r := c.reply(); r != "S02" {
+		t.Errorf("Wrong reply to the interrupt %v", r)
+	}
+}
+
+func TestRegisters24T8(t *testing.T) {
+	s := iz6502.NewMythical65c24T8(new(iz6502.Flat256KMemory))
+	s.SetRegisters(iz6502.Registers{A: 0x123456, SP: 0x01ff, PC: 0x012345})
+	c, _, _ := newTestClient(t, s)
+
+	if r := c.request("g"); r != "563412"+"000000"+"000000"+"ff0100"+"452301"+"00" {
+		t.Errorf("Wrong 24 bits registers %v", r)
+	}
+	if r := c.request("P2=aabbcc"); r != "OK" || s.GetRegisters().Y != 0xccbbaa {
+		t.Errorf("Wrong 24 bits Y %x", s.GetRegisters().Y)
+	}
+	xml := c.request("qXfer:features:read:target.xml:0,1000")
+	if !strings.Contains(xml, `name="a" bitsize="24"`) {
+		t.Errorf("Wrong target description %v", xml)
+	}
+}
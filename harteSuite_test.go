@@ -5,13 +5,15 @@ package iz6502
 
 	Know issues:
 		- Test 6502/v1/20_55_13 (Note 1)
-		- Not implemented undocumented opcodes for NMOS (Note 2)
+		- Not implemented opcodes for 65C24T8 (Note 2)
 		- Errors on flag N for ADC in BCD mode (Note 3)
 
 	The tests are disabled by defaut because they take long to run
 	and require a huge download.
 	To enable them, clone the repo https://github.com/TomHarte/ProcessorTests
 	and change the variables ProcessorTestsEnable and ProcessorTestsPath.
+	With ProcessorTestsCyclesEnable, the scenarios are run again with Tick
+	and each bus cycle is checked, not only the count.
 */
 
 import (
@@ -24,6 +26,7 @@ import (
 var ProcessorTestsNMOSEnable = false
 var ProcessorTestsCMOSEnable = false
 var ProcessorTests24T8Enable = false
+var ProcessorTestsCyclesEnable = false
 var ProcessorTestsPath = "../ProcessorTests/"
 
 type scenarioState struct {
@@ -53,20 +56,13 @@ func TestHarteNMOS6502(t *testing.T) {
 	path := ProcessorTestsPath + "6502/v1/"
 	for i := 0x00; i <= 0xff; i++ {
 		mnemonic := s.opcodes[i].name
-		if mnemonic != "" { // Note 2
-			opcode := fmt.Sprintf("%02x", i)
-			t.Run(opcode+mnemonic, func(t *testing.T) {
-				t.Parallel()
-				m := new(FlatMemory)
-				s := NewNMOS6502(m)
-				testOpcode(t, s, path, opcode, mnemonic)
-			})
-			//} else {
-			//	opcode := fmt.Sprintf("%02x", i)
-			//	t.Run(opcode+mnemonic, func(t *testing.T) {
-			//		t.Error("Opcode not implemented")
-			//	})
-		}
+		opcode := fmt.Sprintf("%02x", i)
+		t.Run(opcode+mnemonic, func(t *testing.T) {
+			t.Parallel()
+			m := new(FlatMemory)
+			s := NewNMOS6502(m)
+			testOpcode(t, s, path, opcode, mnemonic)
+		})
 	}
 }
 
@@ -172,6 +168,44 @@ func testScenario(t *testing.T, s *State, sc *scenario, mnemonic string) {
 	if cycles != uint64(len(sc.Cycles)) {
 		t.Errorf("Took %v cycles, it should be %v for %+v", cycles, len(sc.Cycles), sc)
 	}
+
+	if ProcessorTestsCyclesEnable && s.abMaxWidth == AB16 {
+		testScenarioCycles(t, s, sc)
+	}
+}
+
+func testScenarioCycles(t *testing.T, s *State, sc *scenario) {
+	// Setup CPU again
+	s.reg.setPC(sc.Initial.Pc)
+	s.reg.setSP(R08, uint32(sc.Initial.S))
+	s.reg.setA(R08, uint32(sc.Initial.A))
+	s.reg.setX(R08, uint32(sc.Initial.X))
+	s.reg.setY(R08, uint32(sc.Initial.Y))
+	s.reg.setP(sc.Initial.P)
+
+	for _, e := range sc.Initial.Ram {
+		s.mem.Poke(uint32(e[0]), uint8(e[1]))
+	}
+
+	// Execute instruction a cycle at a time
+	cycles, err := s.TickInstruction(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check each cycle
+	if len(cycles) != len(sc.Cycles) {
+		t.Errorf("Took %v cycles with Tick, it should be %v for %+v", len(cycles), len(sc.Cycles), sc)
+		return
+	}
+	for i, c := range sc.Cycles {
+		address := uint32(c[0].(float64))
+		value := uint8(c[1].(float64))
+		write := c[2].(string) == "write"
+		if cycles[i].Address != address || cycles[i].Data != value || cycles[i].Write != write {
+			t.Errorf("Cycle %v is %v and should be %v for %+v", i, cycles[i], c, sc)
+		}
+	}
 }
 
 func assertReg8(t *testing.T, sc *scenario, name string, actual uint8, wanted uint8) {
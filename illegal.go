@@ -0,0 +1,85 @@
+package iz6502
+
+import "fmt"
+
+// IllegalOpcodeError is returned by Step when the processor fetches an opcode
+// not defined for its model
+type IllegalOpcodeError struct {
+	PC     uint32
+	Opcode uint8
+	Model  Model
+}
+
+func (e *IllegalOpcodeError) Error() string {
+	return fmt.Sprintf("illegal opcode $%02x at $%06x on the %v", e.Opcode, e.PC, e.Model)
+}
+
+// IllegalOpcodePolicy selects what Step does when it fetches an illegal opcode
+type IllegalOpcodePolicy uint8
+
+const (
+	// IllegalOpcodeFail returns an *IllegalOpcodeError and leaves the PC on the opcode
+	IllegalOpcodeFail IllegalOpcodePolicy = iota
+	// IllegalOpcodeTrap calls the handler set with SetIllegalOpcodeHandler
+	IllegalOpcodeTrap
+	// IllegalOpcodeNOP skips the opcode as a NOP of the length and cycles documented
+	// for that opcode on the 65c02
+	IllegalOpcodeNOP
+	// IllegalOpcodeHalt stops the processor as STP does, until the next reset
+	IllegalOpcodeHalt
+)
+
+// IllegalOpcodeHandler is called under the IllegalOpcodeTrap policy with the PC
+// still on the illegal opcode. The handler may change the state, usually moving
+// the PC. If it returns an error, Step returns it.
+type IllegalOpcodeHandler func(s *State, err *IllegalOpcodeError) error
+
+// SetIllegalOpcodePolicy changes what happens when an illegal opcode is fetched.
+// Defaults to IllegalOpcodeFail.
+func (s *State) SetIllegalOpcodePolicy(policy IllegalOpcodePolicy) {
+	s.illegalPolicy = policy
+}
+
+// SetIllegalOpcodeHandler sets the host callback used by the IllegalOpcodeTrap policy
+func (s *State) SetIllegalOpcodeHandler(handler IllegalOpcodeHandler) {
+	s.illegalHandler = handler
+}
+
+// assertError is the panic value used when the opcode tables are inconsistent. Step
+// recovers it and returns it as an error.
+type assertError string
+
+func (e assertError) Error() string {
+	return "assert failed. " + string(e)
+}
+
+func (s *State) illegalOpcode(pc uint32, opcodeID uint8) error {
+	err := &IllegalOpcodeError{PC: pc, Opcode: opcodeID, Model: s.model}
+	switch s.illegalPolicy {
+	case IllegalOpcodeTrap:
+		if s.illegalHandler == nil {
+			return err
+		}
+		return s.illegalHandler(s, err)
+	case IllegalOpcodeNOP:
+		// The 65c02 documents every opcode, use its length and cycles
+		reference, refErr := opcodesForModel(ModelCMOS65c02)
+		if refErr != nil {
+			return refErr
+		}
+		nop := reference[opcodeID]
+		pc += uint32(nop.bytes)
+		if s.abWidth == AB16 {
+			pc &= 0xffff
+		}
+		s.reg.setPC(pc)
+		s.cycles += uint64(nop.cycles)
+		s.wasPrefix = false
+		return nil
+	case IllegalOpcodeHalt:
+		s.stopped = true
+		return nil
+	default:
+		return err
+	}
+}
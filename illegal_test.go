@@ -0,0 +1,101 @@
+package iz6502
+
+import (
+	"errors"
+	"testing"
+)
+
+func newIllegalTest() (*State, *FlatMemory) {
+	m := new(FlatMemory)
+	s := NewMythical65c24T8(m)
+	m.Poke(0x0400, 0x07) // Undefined on the 65c24T8, RMB0 zpg on the 65c02
+	m.Poke(0x0401, 0x12)
+	m.Poke(0x0402, 0xea) // NOP
+	s.reg.setPC(0x0400)
+	return s, m
+}
+
+func TestIllegalOpcodeFail(t *testing.T) {
+	s, _ := newIllegalTest()
+
+	err := s.Step()
+	var illegal *IllegalOpcodeError
+	if !errors.As(err, &illegal) {
+		t.Fatalf("Expected an IllegalOpcodeError, got %v", err)
+	}
+	if illegal.PC != 0x0400 || illegal.Opcode != 0x07 || illegal.Model != ModelMythical65c24T8 {
+		t.Errorf("Wrong error content %+v", illegal)
+	}
+	if s.reg.getPC() != 0x0400 {
+		t.Errorf("The PC must stay on the illegal opcode. %v", s.reg)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("ExecuteInstruction must panic on an illegal opcode")
+		}
+	}()
+	s.ExecuteInstruction()
+}
+
+func TestIllegalOpcodeNOP(t *testing.T) {
+	s, m := newIllegalTest()
+	s.SetIllegalOpcodePolicy(IllegalOpcodeNOP)
+
+	cycles := s.GetCycles()
+	if err := s.Step(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if s.reg.getPC() != 0x0402 {
+		t.Errorf("Illegal opcode not skipped with the 65c02 length. %v", s.reg)
+	}
+	if s.GetCycles()-cycles != 5 {
+		t.Errorf("Illegal opcode took %v cycles instead of 5", s.GetCycles()-cycles)
+	}
+	if m.Peek(0x12) != 0 {
+		t.Error("Illegal opcode skipped as NOP must not write memory")
+	}
+}
+
+func TestIllegalOpcodeTrap(t *testing.T) {
+	s, _ := newIllegalTest()
+	s.SetIllegalOpcodePolicy(IllegalOpcodeTrap)
+
+	trapped := uint8(0)
+	s.SetIllegalOpcodeHandler(func(s *State, err *IllegalOpcodeError) error {
+		trapped = err.Opcode
+		s.reg.setPC(err.PC + 2)
+		return nil
+	})
+	if err := s.Step(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if trapped != 0x07 || s.reg.getPC() != 0x0402 {
+		t.Errorf("Illegal opcode not trapped. %v", s.reg)
+	}
+
+	hostErr := errors.New("host error")
+	s.SetIllegalOpcodeHandler(func(s *State, err *IllegalOpcodeError) error {
+		return hostErr
+	})
+	s.reg.setPC(0x0400)
+	if err := s.Step(); err != hostErr {
+		t.Errorf("Handler error not returned, got %v", err)
+	}
+}
+
+func TestIllegalOpcodeHalt(t *testing.T) {
+	s, _ := newIllegalTest()
+	s.SetIllegalOpcodePolicy(IllegalOpcodeHalt)
+
+	if err := s.Step(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !s.IsStopped() {
+		t.Error("Illegal opcode did not halt the processor")
+	}
+	s.Step()
+	if s.reg.getPC() != 0x0400 {
+		t.Errorf("Halted processor must not execute. %v", s.reg)
+	}
+}
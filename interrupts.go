@@ -0,0 +1,82 @@
+package iz6502
+
+/*
+Interrupt lines.
+
+IRQ is level triggered: it is serviced before the next instruction for as long
+as the line is asserted and the I flag is clear. The host must clear the line
+once the device is acknowledged.
+
+NMI is edge triggered: raising it latches a request that is serviced once
+before the next instruction, regardless of the I flag.
+
+The 65c24T8 pushes a 3-byte return address and uses the 3-byte vectors. Its
+handlers must return with A24 RTI.
+*/
+
+const interruptCycles = 7
+
+// RaiseIRQ asserts the IRQ line
+func (s *State) RaiseIRQ() {
+	s.irqLine = true
+}
+
+// ClearIRQ releases the IRQ line
+func (s *State) ClearIRQ() {
+	s.irqLine = false
+}
+
+// GetIRQ returns true if the IRQ line is asserted
+func (s *State) GetIRQ() bool {
+	return s.irqLine
+}
+
+// RaiseNMI signals an edge on the NMI line
+func (s *State) RaiseNMI() {
+	s.nmiPending = true
+}
+
+// pollInterrupts services a pending NMI or IRQ. Returns true if one was serviced.
+func (s *State) pollInterrupts() bool {
+	if s.wasPrefix {
+		// 24T8 prefixes and the instruction they modify are not interruptible
+		return false
+	}
+
+	if s.nmiPending {
+		s.nmiPending = false
+		s.serviceInterrupt(vectorNMI, vector24NMI)
+		return true
+	}
+
+	if s.irqLine && !s.reg.getFlag(flagI) {
+		s.serviceInterrupt(vectorBreak, vector24Break)
+		return true
+	}
+
+	return false
+}
+
+func (s *State) serviceInterrupt(vector uint32, vector24 uint32) {
+	switch s.abMaxWidth {
+	case AB24:
+		push24Bits(s, s.reg.getPC())
+	default:
+		pushWord(s, uint16(s.reg.getPC()))
+	}
+	// Same as BRK, but with the B flag clear
+	pushByte(s, (s.reg.getP()|flag5)&^flagB)
+	s.reg.setFlag(flagI)
+	if s.model != ModelNMOS6502 {
+		// Like BRK, the 65c02 clears the D flag on interrupts
+		s.reg.clearFlag(flagD)
+	}
+
+	switch s.abMaxWidth {
+	case AB24:
+		s.reg.setPC(get24Bits(s, vector24, AccessVector))
+	default:
+		s.reg.setPC(uint32(getWord(s, vector, AccessVector)))
+	}
+	s.cycles += interruptCycles
+}
@@ -0,0 +1,97 @@
+package iz6502
+
+import (
+	"testing"
+)
+
+func TestIRQ(t *testing.T) {
+	m := new(FlatMemory)
+	s := NewNMOS6502(m)
+	m.Poke(0xfffe, 0x00)
+	m.Poke(0xffff, 0x80)
+	m.Poke(0x0400, 0xea) // NOP
+	s.reg.setPC(0x0400)
+	s.reg.setSP(R08, 0xff)
+	s.reg.setP(flagI)
+
+	s.RaiseIRQ()
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x0401 {
+		t.Fatalf("IRQ serviced with the I flag set, PC = $%04x", s.reg.getPC())
+	}
+
+	s.reg.clearFlag(flagI)
+	cycles := s.GetCycles()
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x8000 {
+		t.Fatalf("IRQ not serviced, PC = $%04x", s.reg.getPC())
+	}
+	if s.GetCycles()-cycles != 7 {
+		t.Errorf("IRQ took %v cycles instead of 7", s.GetCycles()-cycles)
+	}
+	if !s.reg.getFlag(flagI) {
+		t.Error("Flag I not set by IRQ")
+	}
+	if m.Peek(0x01ff) != 0x04 || m.Peek(0x01fe) != 0x01 {
+		t.Errorf("Wrong return address pushed $%02x%02x", m.Peek(0x01ff), m.Peek(0x01fe))
+	}
+	if m.Peek(0x01fd) != flag5 {
+		t.Errorf("Wrong P pushed %08b, B must be clear", m.Peek(0x01fd))
+	}
+
+	// Level triggered, masked by the I flag set on entry
+	m.Poke(0x8000, 0xea) // NOP
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x8001 {
+		t.Errorf("IRQ serviced twice, PC = $%04x", s.reg.getPC())
+	}
+}
+
+func TestNMI(t *testing.T) {
+	m := new(FlatMemory)
+	s := NewCMOS65c02(m)
+	m.Poke(0xfffa, 0x00)
+	m.Poke(0xfffb, 0x90)
+	m.Poke(0x9000, 0xea) // NOP
+	s.reg.setPC(0x0400)
+	s.reg.setSP(R08, 0xff)
+	s.reg.setP(flagI | flagD)
+
+	s.RaiseNMI()
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x9000 {
+		t.Fatalf("NMI not serviced, PC = $%04x", s.reg.getPC())
+	}
+	if s.reg.getFlag(flagD) {
+		t.Error("Flag D not cleared by NMI on 65c02")
+	}
+
+	// Edge triggered, serviced only once
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x9001 {
+		t.Errorf("NMI serviced twice, PC = $%04x", s.reg.getPC())
+	}
+}
+
+func TestIRQ24T8(t *testing.T) {
+	m := new(Flat256KMemory)
+	s := NewMythical65c24T8(m)
+	m.Poke(vector24Break, 0x56)
+	m.Poke(vector24Break+1, 0x34)
+	m.Poke(vector24Break+2, 0x02)
+	s.reg.setPC(0x012345)
+	s.reg.setSP(R08, 0xff)
+
+	s.RaiseIRQ()
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x023456 {
+		t.Fatalf("IRQ not using the 24-bit vector, PC = $%06x", s.reg.getPC())
+	}
+	if m.Peek(0x01ff) != 0x01 || m.Peek(0x01fe) != 0x23 || m.Peek(0x01fd) != 0x45 {
+		t.Errorf("Wrong 3-byte return address pushed $%02x%02x%02x",
+			m.Peek(0x01ff), m.Peek(0x01fe), m.Peek(0x01fd))
+	}
+	if s.reg.getSP(R08) != 0xfb {
+		t.Errorf("Wrong SP after IRQ $%02x", s.reg.getSP(R08))
+	}
+}
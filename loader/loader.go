@@ -0,0 +1,209 @@
+// Package loader reads program images: raw binaries, Commodore PRG files with
+// the load address in the first two bytes, and Intel HEX files.
+package loader
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+// Format is the format of an image file
+type Format int
+
+const (
+	// FormatAuto chooses the format from the file extension, raw if unknown
+	FormatAuto Format = iota
+	// FormatRaw is a binary loaded at a given address
+	FormatRaw
+	// FormatPRG is a binary with the little endian load address in the first two bytes
+	FormatPRG
+	// FormatHex is an Intel HEX file
+	FormatHex
+)
+
+// ParseFormat returns the format for a name: auto, raw, bin, prg, hex or ihex
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		return FormatAuto, nil
+	case "raw", "bin":
+		return FormatRaw, nil
+	case "prg":
+		return FormatPRG, nil
+	case "hex", "ihex", "ihx":
+		return FormatHex, nil
+	}
+	return 0, fmt.Errorf("unknown image format '%v'", name)
+}
+
+// Segment is a block of contiguous bytes
+type Segment struct {
+	Address uint32
+	Data    []uint8
+}
+
+// Image is a loaded program
+type Image struct {
+	Segments []Segment
+	Entry    uint32 // Start address, for the Intel HEX files that have it
+	HasEntry bool
+}
+
+// Poke writes the image in memory
+func (img *Image) Poke(mem iz6502.Memory) {
+	for _, s := range img.Segments {
+		for i, b := range s.Data {
+			mem.Poke(s.Address+uint32(i), b)
+		}
+	}
+}
+
+// Start returns the address of the first byte of the first segment
+func (img *Image) Start() uint32 {
+	if len(img.Segments) == 0 {
+		return 0
+	}
+	return img.Segments[0].Address
+}
+
+// LoadFile reads an image. The address is used for the raw files only.
+func LoadFile(filename string, format Format, address uint32) (*Image, error) {
+	if format == FormatAuto {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".prg":
+			format = FormatPRG
+		case ".hex", ".ihx", ".ihex":
+			format = FormatHex
+		default:
+			format = FormatRaw
+		}
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var img *Image
+	switch format {
+	case FormatPRG:
+		img, err = ReadPRG(f)
+	case FormatHex:
+		img, err = ReadHex(f)
+	default:
+		img, err = ReadRaw(f, address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return img, nil
+}
+
+// ReadRaw reads a binary to be loaded at address
+func ReadRaw(r io.Reader, address uint32) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Segments: []Segment{{address, data}}}, nil
+}
+
+// ReadPRG reads a binary with the load address in the first two bytes
+func ReadPRG(r io.Reader) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 {
+		return nil, fmt.Errorf("PRG file without load address")
+	}
+	address := uint32(data[0]) | uint32(data[1])<<8
+	return &Image{Segments: []Segment{{address, data[2:]}}}, nil
+}
+
+// ReadHex reads an Intel HEX file. The data records are merged in segments
+// when contiguous. The extended segment and linear address records set the
+// upper bits of the addresses, and the start records the entry point.
+func ReadHex(r io.Reader) (*Image, error) {
+	img := &Image{}
+	var base uint32
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, ":") {
+			return nil, fmt.Errorf("line %v: missing ':'", n)
+		}
+		record, err := hex.DecodeString(line[1:])
+		if err != nil || len(record) < 5 || len(record) != int(record[0])+5 {
+			return nil, fmt.Errorf("line %v: invalid record", n)
+		}
+		var sum uint8
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("line %v: wrong checksum", n)
+		}
+
+		data := record[4 : len(record)-1]
+		offset := uint32(record[1])<<8 | uint32(record[2])
+		switch record[3] {
+		case 0x00: // Data
+			img.add(base+offset, data)
+		case 0x01: // End of file
+			return img, nil
+		case 0x02: // Extended segment address
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %v: invalid segment address", n)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 4
+		case 0x04: // Extended linear address
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %v: invalid linear address", n)
+			}
+			base = (uint32(data[0])<<8 | uint32(data[1])) << 16
+		case 0x03: // Start segment address, CS:IP
+			if len(data) != 4 {
+				return nil, fmt.Errorf("line %v: invalid start address", n)
+			}
+			cs := uint32(data[0])<<8 | uint32(data[1])
+			ip := uint32(data[2])<<8 | uint32(data[3])
+			img.Entry, img.HasEntry = cs<<4+ip, true
+		case 0x05: // Start linear address
+			if len(data) != 4 {
+				return nil, fmt.Errorf("line %v: invalid start address", n)
+			}
+			img.Entry = uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+			img.HasEntry = true
+		default:
+			return nil, fmt.Errorf("line %v: unknown record type %02x", n, record[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("missing end of file record")
+}
+
+func (img *Image) add(address uint32, data []uint8) {
+	if n := len(img.Segments); n > 0 {
+		last := &img.Segments[n-1]
+		if last.Address+uint32(len(last.Data)) == address {
+			last.Data = append(last.Data, data...)
+			return
+		}
+	}
+	img.Segments = append(img.Segments, Segment{address, append([]uint8(nil), data...)})
+}
@@ -0,0 +1,90 @@
+package loader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+const testHex = `:03040000A9428D81
+:020403000002F5
+:020000040001F9
+:01000000EA15
+:0400000500000400F3
+:00000001FF
+`
+
+func TestReadHex(t *testing.T) {
+	img, err := ReadHex(strings.NewReader(testHex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Segments) != 2 {
+		t.Fatalf("Wrong segments %+v", img.Segments)
+	}
+	if s := img.Segments[0]; s.Address != 0x0400 || !bytes.Equal(s.Data, []uint8{0xa9, 0x42, 0x8d, 0x00, 0x02}) {
+		t.Errorf("Contiguous records not merged %+v", s)
+	}
+	if s := img.Segments[1]; s.Address != 0x10000 || len(s.Data) != 1 {
+		t.Errorf("Extended linear address not applied %+v", s)
+	}
+	if !img.HasEntry || img.Entry != 0x0400 {
+		t.Errorf("Wrong entry $%x", img.Entry)
+	}
+
+	m := new(iz6502.Flat256KMemory)
+	img.Poke(m)
+	if m.Peek(0x0401) != 0x42 || m.Peek(0x10000) != 0xea {
+		t.Error("Wrong memory after poke")
+	}
+
+	bad := []string{
+		"03040000A9428D81\n",
+		":03040000A9428D82\n",
+		":0304000A9428D81\n",
+		":03040000A9428D81\n",
+	}
+	for _, b := range bad {
+		if _, err := ReadHex(strings.NewReader(b)); err == nil {
+			t.Errorf("Invalid file accepted: %q", b)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "prog.prg"), []byte{0x01, 0x08, 0xea, 0x60}, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "prog.bin"), []byte{0xea, 0x60}, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "prog.hex"), []byte(testHex), 0644)
+
+	img, err := LoadFile(filepath.Join(dir, "prog.prg"), FormatAuto, 0)
+	if err != nil || img.Start() != 0x0801 || len(img.Segments[0].Data) != 2 {
+		t.Errorf("Wrong PRG %+v %v", img, err)
+	}
+	img, err = LoadFile(filepath.Join(dir, "prog.bin"), FormatAuto, 0xc000)
+	if err != nil || img.Start() != 0xc000 || img.HasEntry {
+		t.Errorf("Wrong raw image %+v %v", img, err)
+	}
+	img, err = LoadFile(filepath.Join(dir, "prog.hex"), FormatAuto, 0)
+	if err != nil || img.Start() != 0x0400 {
+		t.Errorf("Wrong hex image %+v %v", img, err)
+	}
+	// Forcing the format ignores the extension
+	img, err = LoadFile(filepath.Join(dir, "prog.prg"), FormatRaw, 0x0200)
+	if err != nil || img.Start() != 0x0200 || len(img.Segments[0].Data) != 4 {
+		t.Errorf("Wrong forced raw image %+v %v", img, err)
+	}
+
+	if _, err := ParseFormat("elf"); err == nil {
+		t.Error("Unknown format accepted")
+	}
+}
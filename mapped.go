@@ -0,0 +1,258 @@
+package iz6502
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+MappedMemory divides the 24 bits address space in pages, each one being RAM,
+ROM, a device or unmapped. The page table is resolved on every access, so the
+mappings can change while the processor runs, as the bank switching of a board
+does.
+
+The addresses of the 6502 and 65c02 are 16 bits, they only see the first 64Kb.
+The 65c24T8 sees the full 16Mb.
+*/
+
+// MappedAddressSpace is the size of the space covered by a MappedMemory
+const MappedAddressSpace = 1 << 24
+
+// PageKind is what backs a page of a MappedMemory
+type PageKind uint8
+
+const (
+	// PageUnmapped pages read the open bus value and ignore the writes
+	PageUnmapped PageKind = iota
+	// PageRAM pages are read and written
+	PageRAM
+	// PageROM pages are read, the writes are ignored
+	PageROM
+	// PageDevice pages are handled by a MemoryHandler
+	PageDevice
+)
+
+func (k PageKind) String() string {
+	switch k {
+	case PageUnmapped:
+		return "unmapped"
+	case PageRAM:
+		return "RAM"
+	case PageROM:
+		return "ROM"
+	case PageDevice:
+		return "device"
+	default:
+		return fmt.Sprintf("PageKind(%d)", uint8(k))
+	}
+}
+
+// MemoryHandler serves the accesses to a device mapped in memory. The offset
+// is relative to the start of the device region.
+type MemoryHandler interface {
+	Read(offset uint32) uint8
+	Write(offset uint32, value uint8)
+}
+
+type mappedPage struct {
+	kind    PageKind
+	data    []uint8 // The page within the RAM or ROM region
+	handler MemoryHandler
+	offset  uint32 // Offset of the page within the device region
+}
+
+// MappedMemory is a Memory built from RAM, ROM and device regions
+type MappedMemory struct {
+	// OpenBus is the value read on unmapped pages
+	OpenBus uint8
+
+	pageBits uint
+	pageMask uint32
+	pages    []mappedPage
+}
+
+var errMappedPageSize = errors.New("the page size must be a power of two between $100 and $10000")
+
+// NewMappedMemory returns a memory with all the pages unmapped. The page size
+// is the granularity of the mappings.
+func NewMappedMemory(pageSize uint32) (*MappedMemory, error) {
+	var bits uint
+	for bits = 8; bits <= 16; bits++ {
+		if pageSize == 1<<bits {
+			break
+		}
+	}
+	if bits > 16 {
+		return nil, errMappedPageSize
+	}
+
+	var m MappedMemory
+	m.OpenBus = 0xff
+	m.pageBits = bits
+	m.pageMask = pageSize - 1
+	m.pages = make([]mappedPage, MappedAddressSpace>>bits)
+	return &m, nil
+}
+
+// PageSize returns the granularity of the mappings
+func (m *MappedMemory) PageSize() uint32 {
+	return m.pageMask + 1
+}
+
+// Peek returns the data on the given address
+func (m *MappedMemory) Peek(address uint32) uint8 {
+	address &= MappedAddressSpace - 1
+	p := &m.pages[address>>m.pageBits]
+	switch p.kind {
+	case PageRAM, PageROM:
+		return p.data[address&m.pageMask]
+	case PageDevice:
+		return p.handler.Read(p.offset + address&m.pageMask)
+	default:
+		return m.OpenBus
+	}
+}
+
+// PeekCode returns the data on the given address
+func (m *MappedMemory) PeekCode(address uint32) uint8 {
+	return m.Peek(address)
+}
+
+// Poke sets the data at the given address
+func (m *MappedMemory) Poke(address uint32, value uint8) {
+	address &= MappedAddressSpace - 1
+	p := &m.pages[address>>m.pageBits]
+	switch p.kind {
+	case PageRAM:
+		p.data[address&m.pageMask] = value
+	case PageDevice:
+		p.handler.Write(p.offset+address&m.pageMask, value)
+	}
+}
+
+// PageKindAt returns what is mapped on an address
+func (m *MappedMemory) PageKindAt(address uint32) PageKind {
+	return m.pages[(address&(MappedAddressSpace-1))>>m.pageBits].kind
+}
+
+// checkRegion verifies that a region starts and ends on page boundaries
+func (m *MappedMemory) checkRegion(address uint32, size uint32) error {
+	if address&m.pageMask != 0 || size&m.pageMask != 0 || size == 0 {
+		return fmt.Errorf("region $%06x of $%x bytes is not aligned to pages of $%x bytes", address, size, m.PageSize())
+	}
+	if address >= MappedAddressSpace || size > MappedAddressSpace-address {
+		return fmt.Errorf("region $%06x of $%x bytes is past the 24 bits address space", address, size)
+	}
+	return nil
+}
+
+func (m *MappedMemory) mapData(kind PageKind, address uint32, data []uint8) error {
+	size := uint32(len(data))
+	if err := m.checkRegion(address, size); err != nil {
+		return err
+	}
+	first := address >> m.pageBits
+	for i := uint32(0); i < size>>m.pageBits; i++ {
+		start := i << m.pageBits
+		m.pages[first+i] = mappedPage{
+			kind: kind,
+			data: data[start : start+m.pageMask+1],
+		}
+	}
+	return nil
+}
+
+// MapRAM maps the data as RAM on the address. The data is used in place, the
+// host sees the writes of the processor.
+func (m *MappedMemory) MapRAM(address uint32, data []uint8) error {
+	return m.mapData(PageRAM, address, data)
+}
+
+// MapROM maps the data as ROM on the address. The writes to it are ignored.
+func (m *MappedMemory) MapROM(address uint32, data []uint8) error {
+	return m.mapData(PageROM, address, data)
+}
+
+// MapDevice maps a device handler on the region
+func (m *MappedMemory) MapDevice(address uint32, size uint32, handler MemoryHandler) error {
+	if err := m.checkRegion(address, size); err != nil {
+		return err
+	}
+	first := address >> m.pageBits
+	for i := uint32(0); i < size>>m.pageBits; i++ {
+		m.pages[first+i] = mappedPage{
+			kind:    PageDevice,
+			handler: handler,
+			offset:  i << m.pageBits,
+		}
+	}
+	return nil
+}
+
+// Unmap removes the mappings of the region
+func (m *MappedMemory) Unmap(address uint32, size uint32) error {
+	if err := m.checkRegion(address, size); err != nil {
+		return err
+	}
+	first := address >> m.pageBits
+	for i := uint32(0); i < size>>m.pageBits; i++ {
+		m.pages[first+i] = mappedPage{}
+	}
+	return nil
+}
+
+// BankWindow is a region of a MappedMemory switching between banks of the
+// same size
+type BankWindow struct {
+	m       *MappedMemory
+	address uint32
+	kind    PageKind
+	banks   [][]uint8
+	current int
+}
+
+// MapBanks maps the first bank on the address and returns the window to
+// switch to the others. The banks are ROM if readOnly is set, RAM otherwise.
+func (m *MappedMemory) MapBanks(address uint32, banks [][]uint8, readOnly bool) (*BankWindow, error) {
+	if len(banks) == 0 {
+		return nil, errors.New("no banks to map")
+	}
+	for i := 1; i < len(banks); i++ {
+		if len(banks[i]) != len(banks[0]) {
+			return nil, fmt.Errorf("bank %v is $%x bytes, bank 0 is $%x", i, len(banks[i]), len(banks[0]))
+		}
+	}
+
+	w := &BankWindow{
+		m:       m,
+		address: address,
+		kind:    PageRAM,
+		banks:   banks,
+	}
+	if readOnly {
+		w.kind = PageROM
+	}
+	if err := m.mapData(w.kind, address, banks[0]); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Select maps the given bank on the window
+func (w *BankWindow) Select(bank int) error {
+	if bank < 0 || bank >= len(w.banks) {
+		return fmt.Errorf("bank %v out of the %v banks", bank, len(w.banks))
+	}
+	w.current = bank
+	return w.m.mapData(w.kind, w.address, w.banks[bank])
+}
+
+// Selected returns the bank mapped on the window
+func (w *BankWindow) Selected() int {
+	return w.current
+}
+
+// Banks returns the number of banks of the window
+func (w *BankWindow) Banks() int {
+	return len(w.banks)
+}
@@ -0,0 +1,130 @@
+package iz6502
+
+import (
+	"testing"
+)
+
+type testHandler struct {
+	regs   [0x200]uint8
+	writes int
+}
+
+func (h *testHandler) Read(offset uint32) uint8 {
+	return h.regs[offset]
+}
+
+func (h *testHandler) Write(offset uint32, value uint8) {
+	h.regs[offset] = value
+	h.writes++
+}
+
+func TestMappedMemory(t *testing.T) {
+	m, err := NewMappedMemory(0x100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ram := make([]uint8, 0x8000)
+	rom := make([]uint8, 0x1000)
+	rom[0xffc] = 0x34
+	h := new(testHandler)
+	if err := m.MapRAM(0x0000, ram); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MapROM(0xf000, rom); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MapDevice(0xc000, 0x200, h); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Poke(0x1234, 0x56)
+	if ram[0x1234] != 0x56 || m.Peek(0x1234) != 0x56 {
+		t.Error("RAM write lost")
+	}
+	m.Poke(0xfffc, 0x12)
+	if m.Peek(0xfffc) != 0x34 || m.PeekCode(0xfffc) != 0x34 {
+		t.Error("ROM written")
+	}
+	m.Poke(0xc1ff, 0x77)
+	if h.regs[0x1ff] != 0x77 || m.Peek(0xc1ff) != 0x77 || h.writes != 1 {
+		t.Error("Wrong device offset")
+	}
+	m.Poke(0x9000, 0x77)
+	if m.Peek(0x9000) != 0xff || m.PageKindAt(0x9000) != PageUnmapped {
+		t.Error("Unmapped page not on open bus")
+	}
+	m.OpenBus = 0x00
+	if m.Peek(0x9000) != 0x00 {
+		t.Error("Open bus value ignored")
+	}
+
+	if err := m.Unmap(0x1200, 0x100); err != nil {
+		t.Fatal(err)
+	}
+	if m.PageKindAt(0x1234) != PageUnmapped || m.PageKindAt(0x1300) != PageRAM {
+		t.Error("Wrong unmap")
+	}
+}
+
+func TestMappedMemoryErrors(t *testing.T) {
+	if _, err := NewMappedMemory(0x180); err == nil {
+		t.Error("Page size not a power of two accepted")
+	}
+	m, _ := NewMappedMemory(0x1000)
+	if err := m.MapRAM(0x0800, make([]uint8, 0x1000)); err == nil {
+		t.Error("Unaligned address accepted")
+	}
+	if err := m.MapRAM(0x0000, make([]uint8, 0x800)); err == nil {
+		t.Error("Unaligned size accepted")
+	}
+	if err := m.MapRAM(0xfff000, make([]uint8, 0x2000)); err == nil {
+		t.Error("Region past 16Mb accepted")
+	}
+}
+
+func TestMappedMemoryBanks(t *testing.T) {
+	m, _ := NewMappedMemory(0x1000)
+	banks := [][]uint8{make([]uint8, 0x4000), make([]uint8, 0x4000), make([]uint8, 0x4000)}
+	w, err := m.MapBanks(0x8000, banks, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Poke(0x8010, 1)
+	if err := w.Select(2); err != nil {
+		t.Fatal(err)
+	}
+	m.Poke(0x8010, 3)
+	if banks[0][0x10] != 1 || banks[2][0x10] != 3 || w.Selected() != 2 {
+		t.Error("Wrong bank written")
+	}
+	if err := w.Select(3); err == nil {
+		t.Error("Missing bank selected")
+	}
+
+	if _, err := m.MapBanks(0x0000, [][]uint8{make([]uint8, 0x1000), make([]uint8, 0x2000)}, true); err == nil {
+		t.Error("Banks of different sizes accepted")
+	}
+}
+
+func TestMappedMemory24Bits(t *testing.T) {
+	m, _ := NewMappedMemory(0x10000)
+	m.MapRAM(0x000000, make([]uint8, 0x10000))
+	high := make([]uint8, 0x10000)
+	m.MapRAM(0xff0000, high)
+	s := NewMythical65c24T8(m)
+
+	program := []uint8{
+		0xa9, 0x56, // LDA #$56
+		0x4f, 0x8d, 0x00, 0x10, 0xff, // A24 STA $ff1000
+	}
+	for i, b := range program {
+		m.Poke(0x0400+uint32(i), b)
+	}
+	s.SetPC(0x0400)
+	for s.GetPC() < 0x0400+uint32(len(program)) {
+		s.ExecuteInstruction()
+	}
+	if high[0x1000] != 0x56 {
+		t.Errorf("Write above 256Kb lost, got $%02x", high[0x1000])
+	}
+}
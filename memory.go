@@ -1,6 +1,9 @@
 package iz6502
 
-import "io/ioutil"
+import (
+	"fmt"
+	"io/ioutil"
+)
 
 // Memory represents the addressable space of the processor
 type Memory interface {
@@ -12,36 +15,117 @@ type Memory interface {
 	PeekCode(address uint32) uint8
 }
 
-func getWord(m Memory, address uint32) uint16 {
+// AccessKind tells why the processor accesses the memory
+type AccessKind uint8
+
+const (
+	// AccessOpcodeFetch is the read of the opcode of an instruction
+	AccessOpcodeFetch AccessKind = iota
+	// AccessOperandFetch is the read of the operand bytes of an instruction
+	AccessOperandFetch
+	// AccessRead is the read of the value of an operation
+	AccessRead
+	// AccessWrite is the write of the result of an operation
+	AccessWrite
+	// AccessPointer is the read of an indirect address
+	AccessPointer
+	// AccessStackPush is a write to the stack
+	AccessStackPush
+	// AccessStackPull is a read from the stack
+	AccessStackPull
+	// AccessVector is the read of the reset, interrupt or BRK vector
+	AccessVector
+	// AccessDummyRead is a read with the value discarded, only with Tick
+	AccessDummyRead
+	// AccessDummyWrite is the NMOS 6502 writing back the value being modified, only with Tick
+	AccessDummyWrite
+)
+
+func (k AccessKind) String() string {
+	switch k {
+	case AccessOpcodeFetch:
+		return "opcode"
+	case AccessOperandFetch:
+		return "operand"
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessPointer:
+		return "pointer"
+	case AccessStackPush:
+		return "push"
+	case AccessStackPull:
+		return "pull"
+	case AccessVector:
+		return "vector"
+	case AccessDummyRead:
+		return "dummy read"
+	case AccessDummyWrite:
+		return "dummy write"
+	default:
+		return fmt.Sprintf("AccessKind(%d)", uint8(k))
+	}
+}
+
+// AccessMemory is a Memory told the kind of each access of the processor and
+// its cycle. When the memory of a State implements it, the processor uses
+// PeekAccess and PokeAccess instead of Peek, PeekCode and Poke. With Step the
+// cycle is the one starting the instruction, with Tick the one of the access.
+type AccessMemory interface {
+	Memory
+	PeekAccess(address uint32, kind AccessKind, cycle uint64) uint8
+	PokeAccess(address uint32, value uint8, kind AccessKind, cycle uint64)
+}
+
+func (s *State) peek(address uint32, kind AccessKind) uint8 {
+	if s.access != nil {
+		return s.access.PeekAccess(address, kind, s.cycles)
+	}
+	if kind == AccessOpcodeFetch || kind == AccessOperandFetch {
+		return s.mem.PeekCode(address)
+	}
+	return s.mem.Peek(address)
+}
+
+func (s *State) poke(address uint32, value uint8, kind AccessKind) {
+	if s.access != nil {
+		s.access.PokeAccess(address, value, kind, s.cycles)
+		return
+	}
+	s.mem.Poke(address, value)
+}
+
+func getWord(s *State, address uint32, kind AccessKind) uint16 {
 	address = address & 0x0FFFF
 	addressP1 := (address + 1) & 0x0FFFF
-	return uint16(m.Peek(address)) | (uint16(m.Peek(addressP1)) << 8)
+	return uint16(s.peek(address, kind)) | (uint16(s.peek(addressP1, kind)) << 8)
 }
 
-func getWordNoCrossPage(m Memory, address uint32) uint16 {
+func getWordNoCrossPage(s *State, address uint32, kind AccessKind) uint16 {
 	addressMSB := address + 1
 	if address&0xff == 0xff {
 		// We won't cross the page bounday for the MSB byte
 		addressMSB -= 0x100
 	}
-	return uint16(m.Peek(address)) | (uint16(m.Peek(addressMSB)) << 8)
+	return uint16(s.peek(address, kind)) | (uint16(s.peek(addressMSB, kind)) << 8)
 }
 
-func getZeroPageWord(m Memory, address uint32) uint16 {
+func getZeroPageWord(s *State, address uint32, kind AccessKind) uint16 {
 	address = address & 0x0FF
 	addressP1 := (address + 1) & 0x0FF
-	return uint16(m.Peek(address)) | (uint16(m.Peek(addressP1)) << 8)
+	return uint16(s.peek(address, kind)) | (uint16(s.peek(addressP1, kind)) << 8)
 }
 
-func get24Bits(m Memory, address uint32) uint32 {
-	return uint32(m.Peek(address)) | (uint32(m.Peek(address + 1)) << 8) | (uint32(m.Peek(address + 2)) << 16)
+func get24Bits(s *State, address uint32, kind AccessKind) uint32 {
+	return uint32(s.peek(address, kind)) | (uint32(s.peek(address + 1, kind)) << 8) | (uint32(s.peek(address + 2, kind)) << 16)
 }
 
-func getZeroPage24Bits(m Memory, address uint32) uint32 {
+func getZeroPage24Bits(s *State, address uint32, kind AccessKind) uint32 {
 	address = address & 0x0FF
 	addressP1 := (address + 1) & 0x0FF
 	addressP2 := (address + 2) & 0x0FF
-	return uint32(m.Peek(address)) | (uint32(m.Peek(addressP1)) << 8) | (uint32(m.Peek(addressP2)) << 16)
+	return uint32(s.peek(address, kind)) | (uint32(s.peek(addressP1, kind)) << 8) | (uint32(s.peek(addressP2, kind)) << 16)
 }
 
 // FlatMemory puts RAM on the 64Kb addressable by the processor
@@ -0,0 +1,118 @@
+package iz6502
+
+import (
+	"testing"
+)
+
+type access struct {
+	address uint32
+	kind    AccessKind
+	cycle   uint64
+	write   bool
+}
+
+// accessRecorder is a FlatMemory logging the accesses of the processor
+type accessRecorder struct {
+	FlatMemory
+	log []access
+}
+
+func (m *accessRecorder) PeekAccess(address uint32, kind AccessKind, cycle uint64) uint8 {
+	m.log = append(m.log, access{address, kind, cycle, false})
+	return m.Peek(address)
+}
+
+func (m *accessRecorder) PokeAccess(address uint32, value uint8, kind AccessKind, cycle uint64) {
+	m.log = append(m.log, access{address, kind, cycle, true})
+	m.Poke(address, value)
+}
+
+func (m *accessRecorder) check(t *testing.T, name string, expected []access) {
+	if len(m.log) != len(expected) {
+		t.Errorf("%v: got %v, expected %v", name, m.log, expected)
+		return
+	}
+	for i := range expected {
+		if m.log[i] != expected[i] {
+			t.Errorf("%v: got %v, expected %v", name, m.log, expected)
+			return
+		}
+	}
+}
+
+func TestAccessKinds(t *testing.T) {
+	m := new(accessRecorder)
+	program := []uint8{
+		0x20, 0x00, 0x05, // JSR $0500
+		0x00, 0x00, // BRK
+	}
+	for i, b := range program {
+		m.Poke(0x0400+uint32(i), b)
+	}
+	m.Poke(0x0500, 0xb1) // LDA ($10),Y
+	m.Poke(0x0501, 0x10)
+	m.Poke(0x0502, 0x60) // RTS
+	m.Poke(0x0010, 0x00)
+	m.Poke(0x0011, 0x20)
+	s := NewNMOS6502(m)
+	s.SetRegisters(Registers{SP: 0xff, PC: 0x0400})
+
+	s.Step()
+	m.check(t, "JSR", []access{
+		{0x0400, AccessOpcodeFetch, 0, false},
+		{0x0401, AccessOperandFetch, 0, false},
+		{0x0402, AccessOperandFetch, 0, false},
+		{0x01ff, AccessStackPush, 0, true},
+		{0x01fe, AccessStackPush, 0, true},
+	})
+
+	m.log = nil
+	s.Step()
+	m.check(t, "LDA (zp),Y", []access{
+		{0x0500, AccessOpcodeFetch, 6, false},
+		{0x0501, AccessOperandFetch, 6, false},
+		{0x0010, AccessPointer, 6, false},
+		{0x0011, AccessPointer, 6, false},
+		{0x2000, AccessRead, 6, false},
+	})
+
+	m.log = nil
+	s.Step()
+	m.check(t, "RTS", []access{
+		{0x0502, AccessOpcodeFetch, 11, false},
+		{0x01fe, AccessStackPull, 11, false},
+		{0x01ff, AccessStackPull, 11, false},
+	})
+
+	m.log = nil
+	s.Step()
+	m.check(t, "BRK", []access{
+		{0x0403, AccessOpcodeFetch, 17, false},
+		{0x01ff, AccessStackPush, 17, true},
+		{0x01fe, AccessStackPush, 17, true},
+		{0x01fd, AccessStackPush, 17, true},
+		{0xfffe, AccessVector, 17, false},
+		{0xffff, AccessVector, 17, false},
+	})
+}
+
+func TestAccessKindsTick(t *testing.T) {
+	m := new(accessRecorder)
+	m.Poke(0x0400, 0xee) // INC $1000
+	m.Poke(0x0401, 0x00)
+	m.Poke(0x0402, 0x10)
+	s := NewNMOS6502(m)
+	s.SetRegisters(Registers{SP: 0xff, PC: 0x0400})
+
+	if _, err := s.TickInstruction(nil); err != nil {
+		t.Fatal(err)
+	}
+	m.check(t, "INC abs", []access{
+		{0x0400, AccessOpcodeFetch, 0, false},
+		{0x0401, AccessOperandFetch, 1, false},
+		{0x0402, AccessOperandFetch, 2, false},
+		{0x1000, AccessRead, 3, false},
+		{0x1000, AccessDummyWrite, 4, true},
+		{0x1000, AccessWrite, 5, true},
+	})
+}
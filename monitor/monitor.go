@@ -0,0 +1,727 @@
+/*
+Package monitor is a machine language monitor in the tradition of the Apple II
+and VICE monitors. It reads commands from a reader and writes the results to
+a writer:
+
+	load prog.bin 0400      load a binary file at $0400
+	model 65c02             change the cpu model (nmos, cmos, wdc or 24t8)
+	r                       show the registers
+	r pc=0400 a=10          set registers
+	m 0400 04ff             dump memory
+	> 0400 a9 42            write bytes to memory
+	d 0400                  disassemble
+	a 0400                  assemble, a line per instruction up to an empty line
+	g 0400                  go, run up to a breakpoint
+	t 10                    trace 10 instructions, or up to a breakpoint
+	s 3                     step 3 instructions
+	n                       step over a subroutine call
+	b 0400 X == 3           add a breakpoint, with an optional condition
+	bd 1                    delete a breakpoint
+	sym prog.lbl            load symbols
+	save state.snap         save the cpu state and the memory
+	restore state.snap      restore them
+
+Addresses and values are hex, with an optional $ or 0x prefix, or symbol
+names. Counts are decimal.
+*/
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/asm"
+	"github.com/lunarmobiscuit/iz6502/debug"
+	"github.com/lunarmobiscuit/iz6502/symbols"
+)
+
+const (
+	dumpLines        = 8
+	disassemblyLines = 16
+)
+
+type command struct {
+	run   func(m *Monitor, args []string) error
+	usage string
+	help  string
+}
+
+// commands is set in init, as the commands use it for the usage
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"load":    {(*Monitor).load, "load FILE ADDR", "load a binary file in memory"},
+		"model":   {(*Monitor).model, "model [NAME]", "show or change the cpu model: nmos, cmos, wdc or 24t8"},
+		"r":       {(*Monitor).registers, "r [REG=VALUE...]", "show or set the registers A X Y SP PC P"},
+		"m":       {(*Monitor).memory, "m [START [END]]", "dump memory"},
+		">":       {(*Monitor).poke, "> ADDR BYTE...", "write bytes to memory"},
+		"d":       {(*Monitor).disassemble, "d [START [END]]", "disassemble"},
+		"a":       {(*Monitor).assemble, "a ADDR [INSTRUCTION]", "assemble, a line per instruction up to an empty line"},
+		"g":       {(*Monitor).goCommand, "g [ADDR]", "run up to a breakpoint, a watchpoint or an interruption"},
+		"t":       {(*Monitor).trace, "t [COUNT]", "trace COUNT instructions, or run traced up to a breakpoint"},
+		"s":       {(*Monitor).step, "s [COUNT]", "step COUNT instructions"},
+		"n":       {(*Monitor).next, "n", "step over a subroutine call"},
+		"b":       {(*Monitor).breakpoint, "b [ADDR [CONDITION]]", "list breakpoints or add one"},
+		"bd":      {(*Monitor).deleteBreakpoint, "bd ID", "delete a breakpoint"},
+		"sym":     {(*Monitor).loadSymbols, "sym FILE", "load symbols: ld65 debug info, VICE labels or SYMBOL = $ADDR maps"},
+		"save":    {(*Monitor).save, "save FILE", "save the cpu state and the memory"},
+		"restore": {(*Monitor).restore, "restore FILE", "restore the cpu state and the memory saved with save"},
+		"bsave":   {(*Monitor).bsave, "bsave FILE START END", "save memory to a binary file"},
+		"help":    {(*Monitor).help, "help", "show the commands"},
+	}
+	commands["?"] = commands["help"]
+}
+
+// Monitor is a machine language monitor session
+type Monitor struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	mu      sync.Mutex // Protects d when replaced, for Interrupt
+	d       *debug.Debugger
+	symbols *symbols.Table
+
+	nextDump        uint32
+	nextDisassembly uint32
+}
+
+// New creates a monitor for a new processor of the model, with its memory
+// cleared. The commands are read from in, the results written to out.
+func New(model iz6502.Model, in io.Reader, out io.Writer) (*Monitor, error) {
+	m := &Monitor{
+		in:      bufio.NewScanner(in),
+		out:     out,
+		symbols: symbols.New(),
+	}
+	err := m.setModel(model, nil)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Debugger returns the debugger controlling the processor. It is replaced
+// when the model changes.
+func (m *Monitor) Debugger() *debug.Debugger {
+	return m.d
+}
+
+// Interrupt stops a running g or t command. It can be called from another
+// goroutine, like a signal handler.
+func (m *Monitor) Interrupt() {
+	m.mu.Lock()
+	m.d.Interrupt()
+	m.mu.Unlock()
+}
+
+// Run reads and executes commands up to the end of the input or the q command
+func (m *Monitor) Run() error {
+	for {
+		fmt.Fprint(m.out, "* ")
+		if !m.in.Scan() {
+			fmt.Fprintln(m.out)
+			return m.in.Err()
+		}
+		line := strings.TrimSpace(m.in.Text())
+		if line == "q" || line == "quit" {
+			return nil
+		}
+		if err := m.Execute(line); err != nil {
+			fmt.Fprintf(m.out, "?%v\n", err)
+		}
+	}
+}
+
+// Execute runs a command
+func (m *Monitor) Execute(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	c, ok := commands[strings.ToLower(fields[0])]
+	if !ok {
+		return fmt.Errorf("unknown command '%v', try help", fields[0])
+	}
+	return c.run(m, fields[1:])
+}
+
+func (m *Monitor) state() *iz6502.State {
+	return m.d.State()
+}
+
+func (m *Monitor) mem() iz6502.Memory {
+	return m.d.Memory()
+}
+
+func (m *Monitor) is24T8() bool {
+	return m.state().Model() == iz6502.ModelMythical65c24T8
+}
+
+// memorySize is the size of the flat memory of the model
+func (m *Monitor) memorySize() uint32 {
+	if m.is24T8() {
+		return 256 * 1024
+	}
+	return 64 * 1024
+}
+
+// setModel creates the processor with a memory big enough for the model,
+// copying the memory, registers and breakpoints of the previous one
+func (m *Monitor) setModel(model iz6502.Model, old *debug.Debugger) error {
+	var mem iz6502.Memory = new(iz6502.FlatMemory)
+	size := uint32(64 * 1024)
+	if model == iz6502.ModelMythical65c24T8 {
+		mem = new(iz6502.Flat256KMemory)
+		size = 256 * 1024
+	}
+	s, err := iz6502.NewState(model, mem)
+	if err != nil {
+		return err
+	}
+
+	d := debug.New(s)
+	d.SetSymbols(m.symbols)
+	if old == nil {
+		s.SetRegisters(iz6502.Registers{SP: 0xff})
+	} else {
+		oldMem := old.Memory()
+		if old.State().Model() != iz6502.ModelMythical65c24T8 {
+			// The rest of the 65c24T8 memory stays cleared
+			size = 64 * 1024
+		}
+		for a := uint32(0); a < size; a++ {
+			mem.Poke(a, oldMem.Peek(a))
+		}
+		s.SetRegisters(old.State().GetRegisters())
+		for _, b := range old.Breakpoints() {
+			d.AddBreakpoint(b.Address, b.Condition)
+		}
+	}
+	m.mu.Lock()
+	m.d = d
+	m.mu.Unlock()
+	return nil
+}
+
+// value parses a hex number, with an optional $ or 0x prefix, or a symbol
+func (m *Monitor) value(text string) (uint32, error) {
+	if a, ok := m.symbols.Lookup(text); ok {
+		return a, nil
+	}
+	digits := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(text), "$"), "0x")
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%v'", text)
+	}
+	return uint32(v), nil
+}
+
+// count parses a decimal count, 1 if absent
+func count(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count '%v'", args[0])
+	}
+	return n, nil
+}
+
+// rangeArgs parses the optional START and END, with defaults
+func (m *Monitor) rangeArgs(args []string, start uint32) (uint32, uint32, bool, error) {
+	if len(args) > 2 {
+		return 0, 0, false, fmt.Errorf("too many arguments")
+	}
+	var err error
+	if len(args) >= 1 {
+		if start, err = m.value(args[0]); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	if len(args) == 2 {
+		end, err := m.value(args[1])
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if end < start {
+			return 0, 0, false, fmt.Errorf("end before start")
+		}
+		return start, end, true, nil
+	}
+	return start, 0, false, nil
+}
+
+func (m *Monitor) addressString(a uint32) string {
+	if m.is24T8() {
+		return fmt.Sprintf("$%06x", a)
+	}
+	return fmt.Sprintf("$%04x", a)
+}
+
+func (m *Monitor) load(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %v", commands["load"].usage)
+	}
+	address, err := m.value(args[1])
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if uint64(address)+uint64(len(data)) > uint64(m.memorySize()) {
+		return fmt.Errorf("the file does not fit in memory")
+	}
+	mem := m.mem()
+	for i, b := range data {
+		mem.Poke(address+uint32(i), b)
+	}
+	fmt.Fprintf(m.out, "Loaded %v bytes at %v-%v\n", len(data),
+		m.addressString(address), m.addressString(address+uint32(len(data))-1))
+	m.nextDump, m.nextDisassembly = address, address
+	return nil
+}
+
+func (m *Monitor) model(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(m.out, m.state().Model())
+		return nil
+	}
+	model, err := iz6502.ParseModel(args[0])
+	if err != nil {
+		return err
+	}
+	return m.setModel(model, m.d)
+}
+
+func (m *Monitor) printRegisters() {
+	r := m.state().GetRegisters()
+	format := "PC=%v A=$%02x X=$%02x Y=$%02x SP=$%02x"
+	if m.is24T8() {
+		format = "PC=%v A=$%06x X=$%06x Y=$%06x SP=$%06x"
+	}
+	fmt.Fprintf(m.out, format, m.addressString(r.PC), r.A, r.X, r.Y, r.SP)
+	fmt.Fprintf(m.out, " P=$%02x NV-BDIZC=%08b cycles=%v", r.P, r.P, m.state().GetCycles())
+	if _, _, ok := m.symbols.NearestSymbol(r.PC); ok {
+		fmt.Fprintf(m.out, " <%v>", iz6502.SymbolString(m.symbols, r.PC))
+	}
+	fmt.Fprintln(m.out)
+}
+
+func (m *Monitor) registers(args []string) error {
+	if len(args) == 0 {
+		m.printRegisters()
+		return nil
+	}
+	r := m.state().GetRegisters()
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("usage: %v", commands["r"].usage)
+		}
+		v, err := m.value(parts[1])
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(parts[0]) {
+		case "a":
+			r.A = v
+		case "x":
+			r.X = v
+		case "y":
+			r.Y = v
+		case "sp", "s":
+			r.SP = v
+		case "pc":
+			r.PC = v
+			m.nextDisassembly = v
+		case "p":
+			r.P = uint8(v)
+		default:
+			return fmt.Errorf("unknown register '%v'", parts[0])
+		}
+	}
+	m.state().SetRegisters(r)
+	return nil
+}
+
+func (m *Monitor) memory(args []string) error {
+	start, end, hasEnd, err := m.rangeArgs(args, m.nextDump)
+	if err != nil {
+		return err
+	}
+	if !hasEnd {
+		end = start + 16*dumpLines - 1
+	}
+	if end >= m.memorySize() {
+		end = m.memorySize() - 1
+	}
+
+	mem := m.mem()
+	for line := start; line <= end; line += 16 {
+		var hex, text strings.Builder
+		for a := line; a < line+16; a++ {
+			if a > end {
+				hex.WriteString("   ")
+				continue
+			}
+			b := mem.Peek(a)
+			fmt.Fprintf(&hex, " %02x", b)
+			if b >= 0x20 && b < 0x7f {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(m.out, "%v:%v  %v\n", m.addressString(line), hex.String(), text.String())
+		if line+16 < line {
+			break
+		}
+	}
+	m.nextDump = end + 1
+	return nil
+}
+
+func (m *Monitor) poke(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %v", commands[">"].usage)
+	}
+	address, err := m.value(args[0])
+	if err != nil {
+		return err
+	}
+	mem := m.mem()
+	for i, arg := range args[1:] {
+		v, err := m.value(arg)
+		if err != nil {
+			return err
+		}
+		if v > 0xff {
+			return fmt.Errorf("invalid byte '%v'", arg)
+		}
+		mem.Poke(address+uint32(i), uint8(v))
+	}
+	return nil
+}
+
+func (m *Monitor) printInstruction(i iz6502.Instruction) {
+	if name, ok := m.symbols.SymbolAt(i.Address); ok {
+		fmt.Fprintf(m.out, "%v:\n", name)
+	}
+	fmt.Fprintf(m.out, "%v  %-12s %v\n", m.addressString(i.Address), fmt.Sprintf("% x", i.Bytes), i)
+}
+
+func (m *Monitor) disassemble(args []string) error {
+	start, end, hasEnd, err := m.rangeArgs(args, m.nextDisassembly)
+	if err != nil {
+		return err
+	}
+	model := m.state().Model()
+	a := start
+	for n := 0; hasEnd || n < disassemblyLines; n++ {
+		if hasEnd && a > end {
+			break
+		}
+		i, next, err := iz6502.DisassembleSymbols(model, m.mem(), a, iz6502.Widths{}, m.symbols)
+		if err != nil {
+			return err
+		}
+		m.printInstruction(i)
+		if next <= a {
+			// Wrapped around the end of memory
+			break
+		}
+		a = next
+	}
+	m.nextDisassembly = a
+	return nil
+}
+
+// assemble assembles an instruction, or enters the assembly mode reading an
+// instruction per line up to an empty line
+func (m *Monitor) assemble(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %v", commands["a"].usage)
+	}
+	address, err := m.value(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		_, err := m.assembleLine(address, strings.Join(args[1:], " "))
+		return err
+	}
+
+	for {
+		fmt.Fprintf(m.out, "%v: ", m.addressString(address))
+		if !m.in.Scan() {
+			fmt.Fprintln(m.out)
+			return m.in.Err()
+		}
+		line := strings.TrimSpace(m.in.Text())
+		if line == "" {
+			return nil
+		}
+		next, err := m.assembleLine(address, line)
+		if err != nil {
+			fmt.Fprintf(m.out, "?%v\n", err)
+			continue
+		}
+		address = next
+	}
+}
+
+// assembleLine assembles a line at address, with the symbols defined. It
+// returns the address after the instruction.
+func (m *Monitor) assembleLine(address uint32, line string) (uint32, error) {
+	var source strings.Builder
+	for _, s := range m.symbols.Symbols() {
+		if !strings.ContainsAny(s.Name, "@.") {
+			fmt.Fprintf(&source, "%v = $%x\n", s.Name, s.Address)
+		}
+	}
+	fmt.Fprintf(&source, "\t.org $%x\n\t%v\n", address, line)
+
+	program, err := asm.Assemble(m.state().Model(), source.String())
+	if err != nil {
+		if e, ok := err.(*asm.Error); ok {
+			return 0, fmt.Errorf("%v", e.Message)
+		}
+		return 0, err
+	}
+	start, image := program.Image()
+	if len(image) == 0 {
+		return address, nil
+	}
+	for i, b := range image {
+		m.mem().Poke(start+uint32(i), b)
+	}
+	i, next, err := iz6502.DisassembleSymbols(m.state().Model(), m.mem(), start, iz6502.Widths{}, m.symbols)
+	if err != nil {
+		return address, err
+	}
+	m.printInstruction(i)
+	m.nextDisassembly = next
+	return start + uint32(len(image)), nil
+}
+
+// stopped prints why the processor stopped, the registers and the next instruction
+func (m *Monitor) stopped(stop debug.Stop, describe bool) {
+	if describe {
+		fmt.Fprintln(m.out, m.d.Describe(stop))
+	}
+	m.printRegisters()
+	// The model of a processor is always known
+	i, next, _ := iz6502.DisassembleSymbols(m.state().Model(), m.mem(), stop.PC, iz6502.Widths{}, m.symbols)
+	m.printInstruction(i)
+	m.nextDisassembly = next
+}
+
+func (m *Monitor) goCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: %v", commands["g"].usage)
+	}
+	if len(args) == 1 {
+		pc, err := m.value(args[0])
+		if err != nil {
+			return err
+		}
+		m.state().SetPC(pc)
+	}
+	m.d.CancelInterrupt()
+	m.stopped(m.d.Continue(), true)
+	return nil
+}
+
+func (m *Monitor) trace(args []string) error {
+	tracer := iz6502.NewTextTracer(m.out)
+	if m.symbols.Len() > 0 {
+		tracer.SetSymbols(m.symbols)
+	}
+	m.d.SetTracer(tracer)
+	defer m.d.SetTracer(nil)
+	m.d.CancelInterrupt()
+
+	if len(args) == 0 {
+		m.stopped(m.d.Continue(), true)
+		return nil
+	}
+	n, err := count(args)
+	if err != nil {
+		return err
+	}
+	var stop debug.Stop
+	for i := 0; i < n; i++ {
+		if stop = m.d.Step(); stop.Reason != debug.StopStep {
+			break
+		}
+	}
+	m.stopped(stop, stop.Reason != debug.StopStep)
+	return nil
+}
+
+func (m *Monitor) step(args []string) error {
+	n, err := count(args)
+	if err != nil {
+		return err
+	}
+	m.d.CancelInterrupt()
+	var stop debug.Stop
+	for i := 0; i < n; i++ {
+		if stop = m.d.Step(); stop.Reason != debug.StopStep {
+			break
+		}
+	}
+	m.stopped(stop, stop.Reason != debug.StopStep)
+	return nil
+}
+
+func (m *Monitor) next(args []string) error {
+	m.d.CancelInterrupt()
+	stop := m.d.StepOver()
+	m.stopped(stop, stop.Reason != debug.StopStep)
+	return nil
+}
+
+func (m *Monitor) breakpoint(args []string) error {
+	if len(args) == 0 {
+		for _, b := range m.d.Breakpoints() {
+			fmt.Fprintf(m.out, "%v: %v", b.ID, iz6502.SymbolString(m.symbols, b.Address))
+			if b.Condition != "" {
+				fmt.Fprintf(m.out, " if %v", b.Condition)
+			}
+			fmt.Fprintf(m.out, ", %v hits\n", b.Hits)
+		}
+		return nil
+	}
+	address, err := m.value(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := m.d.AddBreakpoint(address, strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(m.out, "Breakpoint %v at %v\n", b.ID, iz6502.SymbolString(m.symbols, address))
+	return nil
+}
+
+func (m *Monitor) deleteBreakpoint(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %v", commands["bd"].usage)
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || !m.d.Remove(id) {
+		return fmt.Errorf("no breakpoint '%v'", args[0])
+	}
+	return nil
+}
+
+func (m *Monitor) loadSymbols(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %v", commands["sym"].usage)
+	}
+	before := m.symbols.Len()
+	if err := m.symbols.LoadFile(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.out, "Loaded %v symbols\n", m.symbols.Len()-before)
+	return nil
+}
+
+// save writes the cpu snapshot followed by the memory
+func (m *Monitor) save(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %v", commands["save"].usage)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = m.state().Save(w)
+	mem := m.mem()
+	for a := uint32(0); err == nil && a < m.memorySize(); a++ {
+		err = w.WriteByte(mem.Peek(a))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (m *Monitor) restore(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %v", commands["restore"].usage)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if err := m.state().Load(r); err != nil {
+		return err
+	}
+	data := make([]uint8, m.memorySize())
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("memory missing in the state file: %v", err)
+	}
+	mem := m.mem()
+	for a, b := range data {
+		mem.Poke(uint32(a), b)
+	}
+	m.nextDisassembly = m.state().GetPC()
+	return nil
+}
+
+func (m *Monitor) bsave(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: %v", commands["bsave"].usage)
+	}
+	start, end, _, err := m.rangeArgs(args[1:], 0)
+	if err != nil {
+		return err
+	}
+	if start >= m.memorySize() {
+		return fmt.Errorf("start past the memory")
+	}
+	if end >= m.memorySize() {
+		end = m.memorySize() - 1
+	}
+	data := make([]uint8, 0, end-start+1)
+	for a := start; a <= end; a++ {
+		data = append(data, m.mem().Peek(a))
+	}
+	return ioutil.WriteFile(args[0], data, 0644)
+}
+
+func (m *Monitor) help(args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if name != "?" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(m.out, "%-26s %v\n", c.usage, c.help)
+	}
+	fmt.Fprintf(m.out, "%-26s %v\n", "q", "quit")
+	return nil
+}
@@ -0,0 +1,149 @@
+package monitor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+func runMonitor(t *testing.T, model iz6502.Model, script string) (*Monitor, string) {
+	var out bytes.Buffer
+	m, err := New(model, strings.NewReader(script), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return m, out.String()
+}
+
+func assertContains(t *testing.T, output string, expected ...string) {
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("Missing %q in output:\n%v", e, output)
+		}
+	}
+}
+
+func TestAssembleAndRun(t *testing.T) {
+	m, out := runMonitor(t, iz6502.ModelCMOS65c02, `a 0400
+ldx #$00
+inx
+cpx #$05
+bne $0402
+brk
+
+d 0400 0406
+b 0407
+r pc=0400
+g
+`)
+	assertContains(t, out,
+		"$0402  e8           INX",
+		"$0405  d0 fb        BNE $0402",
+		"Breakpoint 1 at $0407",
+		"breakpoint 1 at $0407",
+		"X=$05")
+	if r := m.Debugger().State().GetRegisters(); r.X != 5 || r.PC != 0x0407 {
+		t.Errorf("Wrong registers %v", r)
+	}
+}
+
+func TestMemoryAndSteps(t *testing.T) {
+	m, out := runMonitor(t, iz6502.ModelNMOS6502, `> 0200 48 65 6c 6c 6f
+m 0200 020f
+> 0300 a9 42 85 10 ea
+r pc=0300
+s 2
+t 1
+m 10 10
+bogus
+`)
+	assertContains(t, out,
+		"$0200: 48 65 6c 6c 6f 00",
+		"Hello...",
+		"$0304  ea           NOP",
+		"0x000304 NOP",
+		"$0010: 42",
+		"?unknown command 'bogus'")
+	if pc := m.Debugger().State().GetPC(); pc != 0x0305 {
+		t.Errorf("Wrong PC $%04x", pc)
+	}
+}
+
+func TestSymbolsAndModel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "prog.sym"), []byte("print = $c0a3\nmain = $0400\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "prog.bin"), []byte{0x20, 0xa3, 0xc0}, 0644)
+
+	m, out := runMonitor(t, iz6502.ModelNMOS6502, strings.Replace(`sym DIR/prog.sym
+load DIR/prog.bin main
+d main main
+a c0a3 jmp main
+model 24t8
+model
+r pc=print
+s
+`, "DIR", dir, -1))
+	assertContains(t, out,
+		"Loaded 2 symbols",
+		"main:\n$0400  20 a3 c0     JSR print",
+		"$c0a3  4c 00 04     JMP main",
+		"65c24T8",
+		"PC=$000400")
+	if m.Debugger().State().Model() != iz6502.ModelMythical65c24T8 {
+		t.Errorf("Model not changed")
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.snap")
+
+	m, out := runMonitor(t, iz6502.ModelWDC65c02, strings.Replace(`> 1000 aa
+r a=12 pc=1000
+save FILE
+> 1000 00
+r a=00 pc=0
+restore FILE
+m 1000 1000
+`, "FILE", file, -1))
+	assertContains(t, out, "$1000: aa")
+	if r := m.Debugger().State().GetRegisters(); r.A != 0x12 || r.PC != 0x1000 {
+		t.Errorf("Wrong registers after restore %v", r)
+	}
+
+	_, out = runMonitor(t, iz6502.ModelNMOS6502, "restore "+file+"\n")
+	assertContains(t, out, "?snapshot is for a")
+}
+
+func TestBsave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "memory.bin")
+
+	// The end is clamped to the memory
+	_, out := runMonitor(t, iz6502.ModelCMOS65c02, "> fffe 12 34\nbsave "+file+" fffe ffffffff\nbsave "+file+"x 10000 10001\n")
+	data, err := ioutil.ReadFile(file)
+	if err != nil || !bytes.Equal(data, []uint8{0x12, 0x34}) {
+		t.Errorf("Wrong file %v %v", data, err)
+	}
+	assertContains(t, out, "?start past the memory")
+}
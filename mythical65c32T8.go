@@ -23,10 +23,13 @@ const (
 // NewMythical65c24T8 returns an initialized (mythical) 65c24T8
 func NewMythical65c24T8(m Memory) *State {
 	var s State
-	s.mem = m
+	s.SetMemory(m)
+	s.model = ModelMythical65c24T8
 
 	s.abWidth = AB24
 	s.abMaxWidth = AB24
+	s.threads = make([]thread, N_THREADS)
+	s.threads[0].running = true
 
 	var opcodes [256]opcode
 	add65c02NOPs(&opcodes)
@@ -60,6 +63,141 @@ func (s *State) RegisterMaxWidth() uint8 {
 	return s.rMaxWidth
 }
 
+/*
+Hardware threads of the 65c24T8.
+
+There are N_THREADS banks of registers. Thread 0 runs after a reset, the
+others are started and stopped with STT and SPT. The active bank lives in
+s.reg and the widths of State, switching threads takes two cycles to swap
+them with the bank of the next thread:
+	STT: starts thread X, with PC = A and SP = Y (24-bit values)
+	SPT: stops thread X, switching to the next running thread if X is current
+	YLD: switches to the next running thread
+	TID: loads the current thread number in A
+	TRM: loads the mask of running threads in A
+*/
+type thread struct {
+	running bool
+	reg     registers
+	abWidth uint8
+	rWidth  uint8
+	sWidth  uint8
+}
+
+// ThreadState is a view of the registers of one of the 65c24T8 threads
+type ThreadState struct {
+	Running       bool
+	A             uint32
+	X             uint32
+	Y             uint32
+	SP            uint32
+	PC            uint32
+	P             uint8
+	AddressWidth  uint8
+	RegisterWidth uint8
+	StackWidth    uint8
+}
+
+// Threads returns the number of hardware threads. 1 for the 6502 and 65c02
+func (s *State) Threads() int {
+	if s.threads == nil {
+		return 1
+	}
+	return len(s.threads)
+}
+
+// CurrentThread returns the thread being executed
+func (s *State) CurrentThread() int {
+	return int(s.thread)
+}
+
+// ThreadState returns the registers of the thread i, from 0 to Threads()-1.
+// Returns false if there is no such thread.
+func (s *State) ThreadState(i int) (ThreadState, bool) {
+	if i < 0 || i >= s.Threads() {
+		return ThreadState{}, false
+	}
+	if i == int(s.thread) {
+		return ThreadState{
+			Running:       s.threads == nil || s.threads[i].running,
+			A:             s.reg.getA(R24),
+			X:             s.reg.getX(R24),
+			Y:             s.reg.getY(R24),
+			SP:            s.reg.getSP(R24),
+			PC:            s.reg.getPC(),
+			P:             s.reg.getP(),
+			AddressWidth:  s.abWidth,
+			RegisterWidth: s.rWidth,
+			StackWidth:    s.sWidth,
+		}, true
+	}
+
+	t := &s.threads[i]
+	return ThreadState{
+		Running:       t.running,
+		A:             t.reg.getA(R24),
+		X:             t.reg.getX(R24),
+		Y:             t.reg.getY(R24),
+		SP:            t.reg.getSP(R24),
+		PC:            t.reg.getPC(),
+		P:             t.reg.getP(),
+		AddressWidth:  t.abWidth,
+		RegisterWidth: t.rWidth,
+		StackWidth:    t.sWidth,
+	}, true
+}
+
+// SwitchThread makes the thread i the current one, as done by YLD. Returns false
+// if the thread is not running.
+func (s *State) SwitchThread(i int) bool {
+	if s.threads == nil || i < 0 || i >= len(s.threads) || !s.threads[i].running {
+		return false
+	}
+	s.switchThread(uint8(i))
+	return true
+}
+
+func (s *State) switchThread(next uint8) {
+	if next == s.thread {
+		return
+	}
+
+	current := &s.threads[s.thread]
+	current.reg = s.reg
+	current.abWidth = s.abWidth
+	current.rWidth = s.rWidth
+	current.sWidth = s.sWidth
+
+	t := &s.threads[next]
+	s.reg = t.reg
+	s.abWidth = t.abWidth
+	s.rWidth = t.rWidth
+	s.sWidth = t.sWidth
+	s.thread = next
+}
+
+// nextThread returns the next running thread after the current one, round robin
+func (s *State) nextThread() (uint8, bool) {
+	n := uint8(len(s.threads))
+	for i := uint8(1); i <= n; i++ {
+		candidate := (s.thread + i) % n
+		if s.threads[candidate].running {
+			return candidate, true
+		}
+	}
+	return 0, false
+}
+
+func (s *State) resetThreads() {
+	if s.threads == nil {
+		return
+	}
+	for i := range s.threads {
+		s.threads[i] = thread{}
+	}
+	s.thread = 0
+	s.threads[0].running = true
+}
 
 var opcodes65c24T8Delta = [256]opcode{
 	// New address modes
@@ -72,6 +210,11 @@ var opcodes65c24T8Delta = [256]opcode{
 	0x2F: {"R24", 1, 2, true, modeImplicit, opR24},
 	0x5F: {"W16", 1, 2, true, modeImplicit, opW16},
 	0x6F: {"W24", 1, 2, true, modeImplicit, opW24},
+	0x8F: {"STT", 1, 2, false, modeImplicit, opSTT},
+	0x9F: {"SPT", 1, 2, false, modeImplicit, opSPT},
+	0xAF: {"YLD", 1, 2, false, modeImplicit, opYLD},
+	0xBF: {"TID", 1, 2, false, modeImplicit, opTID},
+	0xCF: {"TRM", 1, 2, false, modeImplicit, opTRM},
 	0xFC: {"SWS", 1, 2, false, modeImplicit, opSWS},
 	0x0B: {"SL8", 1, 2, false, modeImplicit, opSL8},
 	0x1B: {"SR8", 1, 2, false, modeImplicit, opSR8},
@@ -60,3 +60,84 @@ func TestWiderPC(t *testing.T) {
 		t.Error("Error storing and loading 24-bit PC")
 	}
 }
+
+func TestThreads24T8(t *testing.T) {
+	m := new(Flat256KMemory)
+	s := NewMythical65c24T8(m)
+
+	// Thread 0 at $0400 starts thread 1 at $012000 and yields
+	code := []uint8{
+		0x6F, 0xA9, 0x00, 0x20, 0x01, // W24 LDA #$012000
+		0x6F, 0xA0, 0xFF, 0x01, 0x00, // W24 LDY #$0001FF
+		0xA2, 0x01, // LDX #$01
+		0x8F,       // STT
+		0xAF,       // YLD
+		0xBF,       // TID
+	}
+	for i, v := range code {
+		m.Poke(0x0400+uint32(i), v)
+	}
+	m.Poke(0x012000, 0xBF) // TID
+	m.Poke(0x012001, 0xAF) // YLD
+	s.reg.setPC(0x0400)
+	s.reg.setSP(R08, 0xf0)
+
+	for i := 0; i < 7; i++ {
+		s.ExecuteInstruction()
+	}
+	thread1, ok := s.ThreadState(1)
+	if !ok || !thread1.Running {
+		t.Fatal("Thread 1 not started")
+	}
+	if s.CurrentThread() != 1 || s.reg.getPC() != 0x012000 {
+		t.Fatalf("YLD did not switch to thread 1, thread %v PC = $%06x", s.CurrentThread(), s.reg.getPC())
+	}
+	thread0, _ := s.ThreadState(0)
+	thread1, _ = s.ThreadState(1)
+	if thread1.SP != 0x01ff || thread0.SP != 0xf0 {
+		t.Errorf("Wrong thread stacks %06x %06x", thread0.SP, thread1.SP)
+	}
+
+	cycles := s.GetCycles()
+	s.ExecuteInstruction() // TID
+	if s.reg.getA(R08) != 1 {
+		t.Errorf("TID returned %v on thread 1", s.reg.getA(R08))
+	}
+	s.ExecuteInstruction() // YLD
+	if s.GetCycles()-cycles != 4 {
+		t.Errorf("TID and YLD took %v cycles, expected 4", s.GetCycles()-cycles)
+	}
+	thread0, _ = s.ThreadState(0)
+	if s.CurrentThread() != 0 || s.reg.getPC() != 0x040E || thread0.X != 1 {
+		t.Fatalf("YLD did not switch back to thread 0, thread %v PC = $%06x", s.CurrentThread(), s.reg.getPC())
+	}
+	s.ExecuteInstruction() // TID
+	if s.reg.getA(R08) != 0 {
+		t.Errorf("TID returned %v on thread 0", s.reg.getA(R08))
+	}
+}
+
+func TestThreadStop24T8(t *testing.T) {
+	m := new(Flat256KMemory)
+	s := NewMythical65c24T8(m)
+	m.Poke(0x0400, 0x9F) // SPT
+	s.reg.setPC(0x0400)
+
+	s.ExecuteInstruction()
+	if !s.IsStopped() {
+		t.Error("Processor not stopped with no threads running")
+	}
+	if s.Threads() != N_THREADS || NewCMOS65c02(nil).Threads() != 1 {
+		t.Error("Wrong number of threads")
+	}
+	if _, ok := s.ThreadState(N_THREADS); ok {
+		t.Error("State of a thread past the last one")
+	}
+	cmos := NewCMOS65c02(nil)
+	if _, ok := cmos.ThreadState(1); ok {
+		t.Error("State of a second thread on the 65c02")
+	}
+	if _, ok := cmos.ThreadState(0); !ok {
+		t.Error("No state for the only thread of the 65c02")
+	}
+}
@@ -3,8 +3,18 @@ package iz6502
 // NewNMOS6502 returns an initialized NMOS6502
 func NewNMOS6502(m Memory) *State {
 	var s State
-	s.mem = m
-	s.opcodes = &opcodesNMOS6502
+	s.SetMemory(m)
+	s.model = ModelNMOS6502
+	s.magicConstant = 0xee
+
+	var opcodes [256]opcode
+	for i := 0; i < 256; i++ {
+		opcodes[i] = opcodesNMOS6502[i]
+		if opcodesNMOS6502Undocumented[i].cycles != 0 {
+			opcodes[i] = opcodesNMOS6502Undocumented[i]
+		}
+	}
+	s.opcodes = &opcodes
 	return &s
 }
 
@@ -230,3 +240,111 @@ var opcodesNMOS6502 = [256]opcode{
 	0xD2: {"KIL", 1, 3, false, modeImplicit, opHALT},
 	0xF2: {"KIL", 1, 3, false, modeImplicit, opHALT},
 }
+
+/*
+Undocumented opcodes exclusive to the NMOS 6502. Not inherited by the 65c02
+and the 65c24T8. They also replace the DOP and TOP entries above with the
+proper addressing modes and cycle counts.
+	see https://www.masswerk.at/6502/6502_instruction_set.html
+*/
+var opcodesNMOS6502Undocumented = [256]opcode{
+	0x07: {"SLO", 2, 5, false, modeZeroPage, buildOpShiftLogic(true, false, operationOr)},
+	0x17: {"SLO", 2, 6, false, modeZeroPageX, buildOpShiftLogic(true, false, operationOr)},
+	0x0F: {"SLO", 3, 6, false, modeAbsolute, buildOpShiftLogic(true, false, operationOr)},
+	0x1F: {"SLO", 3, 7, false, modeAbsoluteX, buildOpShiftLogic(true, false, operationOr)},
+	0x1B: {"SLO", 3, 7, false, modeAbsoluteY, buildOpShiftLogic(true, false, operationOr)},
+	0x03: {"SLO", 2, 8, false, modeIndexedIndirectX, buildOpShiftLogic(true, false, operationOr)},
+	0x13: {"SLO", 2, 8, false, modeIndirectIndexedY, buildOpShiftLogic(true, false, operationOr)},
+
+	0x27: {"RLA", 2, 5, false, modeZeroPage, buildOpShiftLogic(true, true, operationAnd)},
+	0x37: {"RLA", 2, 6, false, modeZeroPageX, buildOpShiftLogic(true, true, operationAnd)},
+	0x2F: {"RLA", 3, 6, false, modeAbsolute, buildOpShiftLogic(true, true, operationAnd)},
+	0x3F: {"RLA", 3, 7, false, modeAbsoluteX, buildOpShiftLogic(true, true, operationAnd)},
+	0x3B: {"RLA", 3, 7, false, modeAbsoluteY, buildOpShiftLogic(true, true, operationAnd)},
+	0x23: {"RLA", 2, 8, false, modeIndexedIndirectX, buildOpShiftLogic(true, true, operationAnd)},
+	0x33: {"RLA", 2, 8, false, modeIndirectIndexedY, buildOpShiftLogic(true, true, operationAnd)},
+
+	0x47: {"SRE", 2, 5, false, modeZeroPage, buildOpShiftLogic(false, false, operationXor)},
+	0x57: {"SRE", 2, 6, false, modeZeroPageX, buildOpShiftLogic(false, false, operationXor)},
+	0x4F: {"SRE", 3, 6, false, modeAbsolute, buildOpShiftLogic(false, false, operationXor)},
+	0x5F: {"SRE", 3, 7, false, modeAbsoluteX, buildOpShiftLogic(false, false, operationXor)},
+	0x5B: {"SRE", 3, 7, false, modeAbsoluteY, buildOpShiftLogic(false, false, operationXor)},
+	0x43: {"SRE", 2, 8, false, modeIndexedIndirectX, buildOpShiftLogic(false, false, operationXor)},
+	0x53: {"SRE", 2, 8, false, modeIndirectIndexedY, buildOpShiftLogic(false, false, operationXor)},
+
+	0x67: {"RRA", 2, 5, false, modeZeroPage, opRRA},
+	0x77: {"RRA", 2, 6, false, modeZeroPageX, opRRA},
+	0x6F: {"RRA", 3, 6, false, modeAbsolute, opRRA},
+	0x7F: {"RRA", 3, 7, false, modeAbsoluteX, opRRA},
+	0x7B: {"RRA", 3, 7, false, modeAbsoluteY, opRRA},
+	0x63: {"RRA", 2, 8, false, modeIndexedIndirectX, opRRA},
+	0x73: {"RRA", 2, 8, false, modeIndirectIndexedY, opRRA},
+
+	0x87: {"SAX", 2, 3, false, modeZeroPage, opSAX},
+	0x97: {"SAX", 2, 4, false, modeZeroPageY, opSAX},
+	0x8F: {"SAX", 3, 4, false, modeAbsolute, opSAX},
+	0x83: {"SAX", 2, 6, false, modeIndexedIndirectX, opSAX},
+
+	0xA7: {"LAX", 2, 3, false, modeZeroPage, opLAX},
+	0xB7: {"LAX", 2, 4, false, modeZeroPageY, opLAX},
+	0xAF: {"LAX", 3, 4, false, modeAbsolute, opLAX},
+	0xBF: {"LAX", 3, 4, false, modeAbsoluteY, opLAX}, // Extra cycles
+	0xA3: {"LAX", 2, 6, false, modeIndexedIndirectX, opLAX},
+	0xB3: {"LAX", 2, 5, false, modeIndirectIndexedY, opLAX}, // Extra cycles
+
+	0xC7: {"DCP", 2, 5, false, modeZeroPage, opDCP},
+	0xD7: {"DCP", 2, 6, false, modeZeroPageX, opDCP},
+	0xCF: {"DCP", 3, 6, false, modeAbsolute, opDCP},
+	0xDF: {"DCP", 3, 7, false, modeAbsoluteX, opDCP},
+	0xDB: {"DCP", 3, 7, false, modeAbsoluteY, opDCP},
+	0xC3: {"DCP", 2, 8, false, modeIndexedIndirectX, opDCP},
+	0xD3: {"DCP", 2, 8, false, modeIndirectIndexedY, opDCP},
+
+	0xE7: {"ISC", 2, 5, false, modeZeroPage, opISC},
+	0xF7: {"ISC", 2, 6, false, modeZeroPageX, opISC},
+	0xEF: {"ISC", 3, 6, false, modeAbsolute, opISC},
+	0xFF: {"ISC", 3, 7, false, modeAbsoluteX, opISC},
+	0xFB: {"ISC", 3, 7, false, modeAbsoluteY, opISC},
+	0xE3: {"ISC", 2, 8, false, modeIndexedIndirectX, opISC},
+	0xF3: {"ISC", 2, 8, false, modeIndirectIndexedY, opISC},
+
+	0x0B: {"ANC", 2, 2, false, modeImmediate, opANC},
+	0x2B: {"ANC", 2, 2, false, modeImmediate, opANC},
+	0x4B: {"ALR", 2, 2, false, modeImmediate, opALR},
+	0x6B: {"ARR", 2, 2, false, modeImmediate, opARR},
+	0xCB: {"SBX", 2, 2, false, modeImmediate, opSBX},
+	0xEB: {"SBC", 2, 2, false, modeImmediate, opSBC},
+
+	// Unstable
+	0x8B: {"ANE", 2, 2, false, modeImmediate, opANE},
+	0xAB: {"LXA", 2, 2, false, modeImmediate, opLXA},
+	0xBB: {"LAS", 3, 4, false, modeAbsoluteY, opLAS}, // Extra cycles
+	0x9B: {"TAS", 3, 5, false, modeAbsoluteY, opTAS},
+	0x9C: {"SHY", 3, 5, false, modeAbsoluteX, buildOpStoreUnstable(regY)},
+	0x9E: {"SHX", 3, 5, false, modeAbsoluteY, buildOpStoreUnstable(regX)},
+	0x9F: {"SHA", 3, 5, false, modeAbsoluteY, opSHA},
+	0x93: {"SHA", 2, 6, false, modeIndirectIndexedY, opSHA},
+
+	// Multi-byte NOPs, they do perform the read
+	0x80: {"NOP", 2, 2, false, modeImmediate, opNOP},
+	0x82: {"NOP", 2, 2, false, modeImmediate, opNOP},
+	0x89: {"NOP", 2, 2, false, modeImmediate, opNOP},
+	0xC2: {"NOP", 2, 2, false, modeImmediate, opNOP},
+	0xE2: {"NOP", 2, 2, false, modeImmediate, opNOP},
+	0x04: {"NOP", 2, 3, false, modeZeroPage, opNOPRead},
+	0x44: {"NOP", 2, 3, false, modeZeroPage, opNOPRead},
+	0x64: {"NOP", 2, 3, false, modeZeroPage, opNOPRead},
+	0x14: {"NOP", 2, 4, false, modeZeroPageX, opNOPRead},
+	0x34: {"NOP", 2, 4, false, modeZeroPageX, opNOPRead},
+	0x54: {"NOP", 2, 4, false, modeZeroPageX, opNOPRead},
+	0x74: {"NOP", 2, 4, false, modeZeroPageX, opNOPRead},
+	0xD4: {"NOP", 2, 4, false, modeZeroPageX, opNOPRead},
+	0xF4: {"NOP", 2, 4, false, modeZeroPageX, opNOPRead},
+	0x0C: {"NOP", 3, 4, false, modeAbsolute, opNOPRead},
+	0x1C: {"NOP", 3, 4, false, modeAbsoluteX, opNOPRead}, // Extra cycles
+	0x3C: {"NOP", 3, 4, false, modeAbsoluteX, opNOPRead}, // Extra cycles
+	0x5C: {"NOP", 3, 4, false, modeAbsoluteX, opNOPRead}, // Extra cycles
+	0x7C: {"NOP", 3, 4, false, modeAbsoluteX, opNOPRead}, // Extra cycles
+	0xDC: {"NOP", 3, 4, false, modeAbsoluteX, opNOPRead}, // Extra cycles
+	0xFC: {"NOP", 3, 4, false, modeAbsoluteX, opNOPRead}, // Extra cycles
+}
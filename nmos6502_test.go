@@ -37,3 +37,101 @@ func executeSuite(t *testing.T, s *State, stepAddress uint32, steps uint8, showS
 	}
 }
 
+
+func TestNMOS6502NoUndefined(t *testing.T) {
+	m := new(FlatMemory)
+	s := NewNMOS6502(m)
+
+	for i := 0; i < 256; i++ {
+		if s.opcodes[i].cycles == 0 {
+			t.Errorf("Opcode missing for $%02x.", i)
+		}
+	}
+}
+
+func TestUndocumentedNotInCMOS(t *testing.T) {
+	s := NewCMOS65c02(new(FlatMemory))
+	if s.opcodes[0xa7].name == "LAX" {
+		t.Error("NMOS undocumented opcodes leaked into the 65c02")
+	}
+	s = NewMythical65c24T8(new(FlatMemory))
+	if s.opcodes[0x07].name == "SLO" {
+		t.Error("NMOS undocumented opcodes leaked into the 65c24T8")
+	}
+}
+
+func TestUndocumented(t *testing.T) {
+	s := NewNMOS6502(new(FlatMemory))
+
+	s.mem.Poke(0x40, 0x81)
+	s.executeLine([]uint8{0xA7, 0x40})
+	if s.reg.getA(R08) != 0x81 || s.reg.getX(R08) != 0x81 {
+		t.Errorf("Error in LAX. %v", s.reg)
+	}
+
+	s.reg.setA(R08, 0xF0)
+	s.reg.setX(R08, 0x3C)
+	s.executeLine([]uint8{0x87, 0x41})
+	if s.mem.Peek(0x41) != 0x30 {
+		t.Errorf("Error in SAX. %v", s.reg)
+	}
+
+	s.reg.setA(R08, 0x01)
+	s.mem.Poke(0x42, 0x81)
+	s.executeLine([]uint8{0x07, 0x42})
+	if s.mem.Peek(0x42) != 0x02 || s.reg.getA(R08) != 0x03 || !s.reg.getFlag(flagC) {
+		t.Errorf("Error in SLO. %v", s.reg)
+	}
+
+	s.reg.setA(R08, 0x10)
+	s.mem.Poke(0x43, 0x11)
+	s.executeLine([]uint8{0xC7, 0x43})
+	if s.mem.Peek(0x43) != 0x10 || s.reg.getP()&(flagZ|flagC) != flagZ|flagC {
+		t.Errorf("Error in DCP. %v", s.reg)
+	}
+
+	s.reg.setA(R08, 0x10)
+	s.reg.setFlag(flagC)
+	s.mem.Poke(0x44, 0x04)
+	s.executeLine([]uint8{0xE7, 0x44})
+	if s.mem.Peek(0x44) != 0x05 || s.reg.getA(R08) != 0x0B {
+		t.Errorf("Error in ISC. %v", s.reg)
+	}
+
+	s.reg.setA(R08, 0xFF)
+	s.reg.setX(R08, 0x0F)
+	s.executeLine([]uint8{0xCB, 0x03})
+	if s.reg.getX(R08) != 0x0C || !s.reg.getFlag(flagC) {
+		t.Errorf("Error in SBX. %v", s.reg)
+	}
+
+	s.reg.setA(R08, 0xFF)
+	s.reg.clearFlag(flagC)
+	s.executeLine([]uint8{0x6B, 0xC1})
+	if s.reg.getA(R08) != 0x60 || !s.reg.getFlag(flagC) || s.reg.getFlag(flagV) {
+		t.Errorf("Error in ARR. %v", s.reg)
+	}
+
+	s.reg.setA(R08, 0x00)
+	s.reg.setX(R08, 0xFF)
+	s.SetMagicConstant(0xFF)
+	s.executeLine([]uint8{0xAB, 0x5A})
+	if s.reg.getA(R08) != 0x5A || s.reg.getX(R08) != 0x5A {
+		t.Errorf("Error in LXA. %v", s.reg)
+	}
+}
+
+func TestUndocumentedNOPCycles(t *testing.T) {
+	m := new(FlatMemory)
+	s := NewNMOS6502(m)
+	m.Poke(0x0400, 0x1C) // NOP $10F0,X
+	m.Poke(0x0401, 0xF0)
+	m.Poke(0x0402, 0x10)
+	s.reg.setPC(0x0400)
+	s.reg.setX(R08, 0x20)
+
+	s.ExecuteInstruction()
+	if s.reg.getPC() != 0x0403 || s.GetCycles() != 5 {
+		t.Errorf("Error in NOP abs,X crossing a page, PC $%04x in %v cycles", s.reg.getPC(), s.GetCycles())
+	}
+}
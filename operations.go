@@ -125,7 +125,7 @@ func buildOpBranchOnBit(bit uint8, test bool) opFunc {
 		// Note that those operations have two addressing modes:
 		// one for the zero page value, another for the relative jump.
 		// We will have to resolve the first one here.
-		value := s.mem.Peek(uint32(line[1]))
+		value := s.peek(uint32(line[1]), AccessRead)
 		bitValue := ((value >> bit) & 1) == 1
 
 		if bitValue == test {
@@ -205,6 +205,10 @@ func buildOpLogic(operation func(uint32, uint32) uint32) opFunc {
 
 func opADC(s *State, line []uint8, opcode opcode) {
 	value := resolveValue(s, line, opcode)
+	addWithCarry(s, value)
+}
+
+func addWithCarry(s *State, value uint32) {
 	aValue := s.reg.getA(s.rWidth)
 	carry := s.reg.getFlagBit(flagC)
 
@@ -278,6 +282,10 @@ func opADCAlt(s *State, line []uint8, opcode opcode) {
 
 func opSBC(s *State, line []uint8, opcode opcode) {
 	value := resolveValue(s, line, opcode)
+	subtractWithBorrow(s, value)
+}
+
+func subtractWithBorrow(s *State, value uint32) {
 	aValue := s.reg.getA(s.rWidth)
 	carry := s.reg.getFlagBit(flagC)
 
@@ -356,7 +364,7 @@ func pushByte(s *State, value uint8) {
 	} else {
 		adresss = s.reg.getSP(s.sWidth)
 	}
-	s.mem.Poke(adresss, value)
+	s.poke(adresss, value, AccessStackPush)
 	s.reg.setSP(s.sWidth, s.reg.getSP(s.sWidth) - 1)
 }
 
@@ -368,7 +376,7 @@ func pullByte(s *State) uint8 {
 	} else {
 		adresss = s.reg.getSP(s.sWidth)
 	}
-	return s.mem.Peek(adresss)
+	return s.peek(adresss, AccessStackPull)
 }
 
 func pushWord(s *State, value uint16) {
@@ -451,6 +459,14 @@ func opHALT(s *State, line []uint8, opcode opcode) {
 	s.reg.setPC(pc)
 }
 
+func opWAI(s *State, line []uint8, opcode opcode) {
+	s.waiting = true
+}
+
+func opSTP(s *State, line []uint8, opcode opcode) {
+	s.stopped = true
+}
+
 func opJSR(s *State, line []uint8, opcode opcode) {
 	switch s.abWidth {
 	case AB24: push24Bits(s, s.reg.getPC()-1)
@@ -484,8 +500,8 @@ func opBRK(s *State, line []uint8, opcode opcode) {
 	pushByte(s, s.reg.getP()|(flagB+flag5))
 	s.reg.setFlag(flagI)
 	switch s.abWidth {
-	case AB24: s.reg.setPC(get24Bits(s.mem, vector24Break))
-	default: s.reg.setPC(uint32(getWord(s.mem, vectorBreak)))
+	case AB24: s.reg.setPC(get24Bits(s, vector24Break, AccessVector))
+	default: s.reg.setPC(uint32(getWord(s, vectorBreak, AccessVector)))
 	}
 }
 
@@ -591,3 +607,266 @@ func opAXY(s *State, line []uint8, opcode opcode) {
 	s.reg.setA(s.rWidth, a)
 	s.reg.updateFlagZN(s.rWidth, a)
 }
+
+// New opcode in 65C24T8 to start the thread X at the address in A with the stack in Y
+func opSTT(s *State, line []uint8, opcode opcode) {
+	i := uint8(s.reg.getX(R08)) % uint8(len(s.threads))
+	if i == s.thread {
+		return
+	}
+	t := &s.threads[i]
+	t.running = true
+	t.reg = registers{}
+	t.reg.setPC(s.reg.getA(R24))
+	t.reg.setSP(R24, s.reg.getY(R24))
+	t.reg.setP(flag5)
+	t.abWidth = AB16
+	t.rWidth = R08
+	t.sWidth = s.sWidth
+}
+
+// New opcode in 65C24T8 to stop the thread X
+func opSPT(s *State, line []uint8, opcode opcode) {
+	i := uint8(s.reg.getX(R08)) % uint8(len(s.threads))
+	s.threads[i].running = false
+	if i == s.thread {
+		next, ok := s.nextThread()
+		if !ok {
+			// No threads left, the processor stops until the next reset
+			s.stopped = true
+			return
+		}
+		s.switchThread(next)
+	}
+}
+
+// New opcode in 65C24T8 to switch to the next running thread
+func opYLD(s *State, line []uint8, opcode opcode) {
+	next, ok := s.nextThread()
+	if ok {
+		s.switchThread(next)
+	}
+}
+
+// New opcode in 65C24T8 to load the current thread number in A
+func opTID(s *State, line []uint8, opcode opcode) {
+	s.reg.setA(s.rWidth, uint32(s.thread))
+	s.reg.updateFlagZN(s.rWidth, uint32(s.thread))
+}
+
+// New opcode in 65C24T8 to load the mask of running threads in A
+func opTRM(s *State, line []uint8, opcode opcode) {
+	mask := uint32(0)
+	for i := range s.threads {
+		if s.threads[i].running {
+			mask |= 1 << uint(i)
+		}
+	}
+	s.reg.setA(s.rWidth, mask)
+	s.reg.updateFlagZN(s.rWidth, mask)
+}
+
+/*
+Undocumented NMOS 6502 opcodes, see:
+	https://www.masswerk.at/nowgobang/2021/6502-illegal-opcodes
+	https://csdb.dk/release/?id=212346 (NMOS 6510 Unintended Opcodes)
+They are only used on the NMOS 6502 and always work on 8 bits.
+*/
+
+// Reads the operand and discards it. The read may have side effects and
+// may take an extra cycle when crossing page boundaries.
+func opNOPRead(s *State, line []uint8, opcode opcode) {
+	resolveValue(s, line, opcode)
+}
+
+// SLO, RLA and SRE: a shift or rotation on memory followed by a logic operation with A
+func buildOpShiftLogic(isLeft bool, isRotate bool, operation func(uint32, uint32) uint32) opFunc {
+	return func(s *State, line []uint8, opcode opcode) {
+		value := resolveValue(s, line, opcode)
+		oldCarry := uint32(s.reg.getFlagBit(flagC))
+		var carry bool
+		if isLeft {
+			carry = value&0x80 != 0
+			value = (value << 1) & 0xff
+			if isRotate {
+				value |= oldCarry
+			}
+		} else {
+			carry = value&0x01 != 0
+			value >>= 1
+			if isRotate {
+				value |= oldCarry << 7
+			}
+		}
+		resolveSetValue(s, line, opcode, value)
+		s.reg.updateFlag(flagC, carry)
+
+		result := operation(value, s.reg.getA(R08))
+		s.reg.setA(R08, result)
+		s.reg.updateFlagZN(R08, result)
+	}
+}
+
+// RRA: ROR followed by ADC
+func opRRA(s *State, line []uint8, opcode opcode) {
+	value := resolveValue(s, line, opcode)
+	carry := value&0x01 != 0
+	value = (value >> 1) | (uint32(s.reg.getFlagBit(flagC)) << 7)
+	resolveSetValue(s, line, opcode, value)
+	s.reg.updateFlag(flagC, carry)
+	addWithCarry(s, value)
+}
+
+// DCP: DEC followed by CMP
+func opDCP(s *State, line []uint8, opcode opcode) {
+	value := (resolveValue(s, line, opcode) - 1) & 0xff
+	resolveSetValue(s, line, opcode, value)
+	a := s.reg.getA(R08)
+	s.reg.updateFlagZN(R08, a-value)
+	s.reg.updateFlag(flagC, a >= value)
+}
+
+// ISC: INC followed by SBC
+func opISC(s *State, line []uint8, opcode opcode) {
+	value := (resolveValue(s, line, opcode) + 1) & 0xff
+	resolveSetValue(s, line, opcode, value)
+	subtractWithBorrow(s, value)
+}
+
+// LAX: LDA and LDX with the same value
+func opLAX(s *State, line []uint8, opcode opcode) {
+	value := resolveValue(s, line, opcode)
+	s.reg.setA(R08, value)
+	s.reg.setX(R08, value)
+	s.reg.updateFlagZN(R08, value)
+}
+
+// SAX: stores A AND X
+func opSAX(s *State, line []uint8, opcode opcode) {
+	resolveSetValue(s, line, opcode, s.reg.getA(R08)&s.reg.getX(R08))
+}
+
+// ANC: AND with the carry getting the N flag
+func opANC(s *State, line []uint8, opcode opcode) {
+	value := resolveValue(s, line, opcode) & s.reg.getA(R08)
+	s.reg.setA(R08, value)
+	s.reg.updateFlagZN(R08, value)
+	s.reg.updateFlag(flagC, value&0x80 != 0)
+}
+
+// ALR: AND followed by LSR A
+func opALR(s *State, line []uint8, opcode opcode) {
+	value := resolveValue(s, line, opcode) & s.reg.getA(R08)
+	s.reg.updateFlag(flagC, value&0x01 != 0)
+	value >>= 1
+	s.reg.setA(R08, value)
+	s.reg.updateFlagZN(R08, value)
+}
+
+// ARR: AND followed by ROR A, with the flags and BCD fixes of the ADC circuitry
+func opARR(s *State, line []uint8, opcode opcode) {
+	t := resolveValue(s, line, opcode) & s.reg.getA(R08)
+	carry := uint32(s.reg.getFlagBit(flagC))
+	value := (t >> 1) | (carry << 7)
+
+	if s.reg.getFlag(flagD) {
+		s.reg.updateFlag(flagN, carry != 0)
+		s.reg.updateFlag(flagZ, value == 0)
+		s.reg.updateFlag(flagV, (t^value)&0x40 != 0)
+		if (t&0x0f)+(t&0x01) > 5 {
+			value = (value & 0xf0) | ((value + 6) & 0x0f)
+		}
+		if (t&0xf0)+(t&0x10) > 0x50 {
+			value = (value + 0x60) & 0xff
+			s.reg.setFlag(flagC)
+		} else {
+			s.reg.clearFlag(flagC)
+		}
+	} else {
+		s.reg.updateFlagZN(R08, value)
+		s.reg.updateFlag(flagC, value&0x40 != 0)
+		s.reg.updateFlag(flagV, ((value>>6)^(value>>5))&0x01 != 0)
+	}
+	s.reg.setA(R08, value)
+}
+
+// SBX: X gets (A AND X) minus the operand, without borrow
+func opSBX(s *State, line []uint8, opcode opcode) {
+	value := resolveValue(s, line, opcode)
+	ax := s.reg.getA(R08) & s.reg.getX(R08)
+	result := (ax - value) & 0xff
+	s.reg.setX(R08, result)
+	s.reg.updateFlagZN(R08, result)
+	s.reg.updateFlag(flagC, ax >= value)
+}
+
+// ANE: unstable, depends on the magic constant of the chip
+func opANE(s *State, line []uint8, opcode opcode) {
+	value := (s.reg.getA(R08) | uint32(s.magicConstant)) & s.reg.getX(R08) & resolveValue(s, line, opcode)
+	s.reg.setA(R08, value)
+	s.reg.updateFlagZN(R08, value)
+}
+
+// LXA: unstable, depends on the magic constant of the chip
+func opLXA(s *State, line []uint8, opcode opcode) {
+	value := (s.reg.getA(R08) | uint32(s.magicConstant)) & resolveValue(s, line, opcode)
+	s.reg.setA(R08, value)
+	s.reg.setX(R08, value)
+	s.reg.updateFlagZN(R08, value)
+}
+
+// LAS: A, X and SP get the operand AND SP
+func opLAS(s *State, line []uint8, opcode opcode) {
+	value := resolveValue(s, line, opcode) & s.reg.getSP(R08)
+	s.reg.setA(R08, value)
+	s.reg.setX(R08, value)
+	s.reg.setSP(R08, value)
+	s.reg.updateFlagZN(R08, value)
+}
+
+// TAS: SP gets A AND X, then stores as SHA
+func opTAS(s *State, line []uint8, opcode opcode) {
+	s.reg.setSP(R08, s.reg.getA(R08)&s.reg.getX(R08))
+	resolveSetValueUnstable(s, line, opcode, s.reg.getA(R08)&s.reg.getX(R08))
+}
+
+func buildOpStoreUnstable(regSrc int) opFunc {
+	return func(s *State, line []uint8, opcode opcode) {
+		resolveSetValueUnstable(s, line, opcode, s.reg.getRegister(R08, regSrc))
+	}
+}
+
+// SHA: stores A AND X, with the same quirks as SHX and SHY
+func opSHA(s *State, line []uint8, opcode opcode) {
+	resolveSetValueUnstable(s, line, opcode, s.reg.getA(R08)&s.reg.getX(R08))
+}
+
+/*
+The SHA, SHX, SHY and TAS stores AND the value with the high byte of the base
+address plus one. When the index crosses a page boundary that value also
+replaces the high byte of the effective address.
+*/
+func resolveSetValueUnstable(s *State, line []uint8, opcode opcode, value uint32) {
+	var base uint32
+	var index uint32
+	switch opcode.addressMode {
+	case modeAbsoluteX:
+		base = getWordInLine(line)
+		index = s.reg.getX(R08)
+	case modeAbsoluteY:
+		base = getWordInLine(line)
+		index = s.reg.getY(R08)
+	case modeIndirectIndexedY:
+		base = uint32(getZeroPageWord(s, uint32(line[1]), AccessPointer))
+		index = s.reg.getY(R08)
+	default:
+		panic(assertError("Unexpected addressing mode for unstable store"))
+	}
+
+	value &= ((base >> 8) + 1) & 0xff
+	address := (base + index) & 0xffff
+	if (base & 0xff00) != (address & 0xff00) {
+		address = (value << 8) | (address & 0xff)
+	}
+	s.poke(address, uint8(value), AccessWrite)
+}
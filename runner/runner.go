@@ -0,0 +1,239 @@
+// Package runner runs programs without supervision, like test ROMs in CI, up
+// to an exit condition: a trap, a BRK, a write to an exit port, a cycle
+// budget or a timeout.
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+// Exit codes of a run, see Result.ExitCode. A write to the exit port exits
+// with the value written, and a program calling exit with its code.
+const (
+	ExitSuccess = 0
+	ExitFailure = 1
+	ExitUsage   = 2 // For the commands, on invalid arguments
+	ExitCycles  = 3
+	ExitTimeout = 4
+	ExitError   = 5
+)
+
+// Reason tells why a run ended
+type Reason int
+
+const (
+	// ReasonTrap is an instruction jumping or branching to itself
+	ReasonTrap Reason = iota
+	// ReasonBRK is a BRK about to be executed
+	ReasonBRK
+	// ReasonExitPort is a write to the exit port
+	ReasonExitPort
+	// ReasonCycles is the cycle budget used up
+	ReasonCycles
+	// ReasonTimeout is the wall clock timeout
+	ReasonTimeout
+	// ReasonHalted is the processor stopped with STP, or waiting with WAI
+	// with nothing to raise an interrupt
+	ReasonHalted
+	// ReasonError is an illegal opcode, or other error of the emulation
+	ReasonError
+	// ReasonExit is the program exiting through a host call of the Hook
+	ReasonExit
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonTrap:
+		return "trap"
+	case ReasonBRK:
+		return "brk"
+	case ReasonExitPort:
+		return "exit port"
+	case ReasonCycles:
+		return "cycles"
+	case ReasonTimeout:
+		return "timeout"
+	case ReasonHalted:
+		return "halted"
+	case ReasonError:
+		return "error"
+	case ReasonExit:
+		return "exit"
+	default:
+		return fmt.Sprintf("Reason(%d)", int(r))
+	}
+}
+
+// Config are the exit conditions of a run
+type Config struct {
+	MaxCycles   uint64        // 0 for no limit
+	Timeout     time.Duration // 0 for no limit
+	ExitPort    uint32
+	HasExitPort bool
+	StopOnBRK   bool
+	// NoTrap keeps running on traps, for programs waiting for an interrupt
+	// in a loop on themselves
+	NoTrap bool
+	// With HasSuccess, a trap or BRK at Success is a success and anywhere
+	// else a failure. Without it, every trap and BRK is a success.
+	Success    uint32
+	HasSuccess bool
+	// Hook is called before each instruction, to implement host calls. An
+	// error with an ExitCode() int method ends the run with ReasonExit, other
+	// errors with ReasonError. With a hook, WAI waits for it to raise an
+	// interrupt instead of halting.
+	Hook func(s *iz6502.State) error
+}
+
+// Result is the end of a run
+type Result struct {
+	Reason Reason
+	PC     uint32 // Address of the trap or BRK, or the PC after the last instruction
+	Cycles uint64
+	Value  uint8 // Written to the exit port
+	Code   int   // For ReasonExit
+	Err    error // For ReasonError
+}
+
+// ExitCode returns the process exit code for the result
+func (r *Result) ExitCode(c *Config) int {
+	switch r.Reason {
+	case ReasonTrap, ReasonBRK:
+		if c.HasSuccess && r.PC != c.Success {
+			return ExitFailure
+		}
+		return ExitSuccess
+	case ReasonExitPort:
+		return int(r.Value)
+	case ReasonCycles:
+		return ExitCycles
+	case ReasonTimeout:
+		return ExitTimeout
+	case ReasonHalted:
+		return ExitFailure
+	case ReasonExit:
+		return r.Code
+	default:
+		return ExitError
+	}
+}
+
+func (r *Result) String() string {
+	switch r.Reason {
+	case ReasonExitPort:
+		return fmt.Sprintf("exit port written with $%02x at $%04x after %v cycles", r.Value, r.PC, r.Cycles)
+	case ReasonExit:
+		return fmt.Sprintf("exit(%v) after %v cycles", r.Code, r.Cycles)
+	case ReasonError:
+		return fmt.Sprintf("error after %v cycles: %v", r.Cycles, r.Err)
+	default:
+		return fmt.Sprintf("%v at $%04x after %v cycles", r.Reason, r.PC, r.Cycles)
+	}
+}
+
+// timeoutCheckInstructions is how often the clock is checked
+const timeoutCheckInstructions = 1024
+
+// Run executes from the current PC up to an exit condition
+func Run(s *iz6502.State, c Config) Result {
+	mem := s.GetMemory()
+	port := &exitPortMemory{Memory: mem, port: c.ExitPort}
+	if c.HasExitPort {
+		s.SetMemory(port)
+		defer s.SetMemory(mem)
+	}
+
+	start := time.Now()
+	result := func(reason Reason) Result {
+		return Result{Reason: reason, PC: s.GetPC(), Cycles: s.GetCycles()}
+	}
+	for n := 0; ; n++ {
+		pc := s.GetPC()
+		if c.StopOnBRK && mem.Peek(pc) == 0x00 {
+			return result(ReasonBRK)
+		}
+		if c.MaxCycles > 0 && s.GetCycles() >= c.MaxCycles {
+			return result(ReasonCycles)
+		}
+		if c.Timeout > 0 && n%timeoutCheckInstructions == 0 && time.Since(start) >= c.Timeout {
+			return result(ReasonTimeout)
+		}
+
+		if c.Hook != nil {
+			if err := c.Hook(s); err != nil {
+				if exit, ok := err.(interface{ ExitCode() int }); ok {
+					r := result(ReasonExit)
+					r.Code = exit.ExitCode()
+					return r
+				}
+				r := result(ReasonError)
+				r.Err = err
+				return r
+			}
+		}
+		if err := s.Step(); err != nil {
+			r := result(ReasonError)
+			r.Err = err
+			return r
+		}
+		if port.written {
+			r := result(ReasonExitPort)
+			r.Value = port.value
+			return r
+		}
+		if s.IsStopped() || s.IsWaiting() && c.Hook == nil {
+			return result(ReasonHalted)
+		}
+		if s.IsWaiting() {
+			// The hook can raise the interrupt, Step idles meanwhile
+			continue
+		}
+		if s.GetPC() == pc && !c.NoTrap {
+			return result(ReasonTrap)
+		}
+	}
+}
+
+// exitPortMemory records the writes to the exit port
+type exitPortMemory struct {
+	iz6502.Memory
+	port    uint32
+	written bool
+	value   uint8
+}
+
+func (m *exitPortMemory) Poke(address uint32, value uint8) {
+	m.check(address, value)
+	m.Memory.Poke(address, value)
+}
+
+// PeekAccess and PokeAccess forward the kinds of the accesses when the memory
+// is an AccessMemory
+func (m *exitPortMemory) PeekAccess(address uint32, kind iz6502.AccessKind, cycle uint64) uint8 {
+	if access, ok := m.Memory.(iz6502.AccessMemory); ok {
+		return access.PeekAccess(address, kind, cycle)
+	}
+	if kind == iz6502.AccessOpcodeFetch || kind == iz6502.AccessOperandFetch {
+		return m.Memory.PeekCode(address)
+	}
+	return m.Memory.Peek(address)
+}
+
+func (m *exitPortMemory) PokeAccess(address uint32, value uint8, kind iz6502.AccessKind, cycle uint64) {
+	m.check(address, value)
+	if access, ok := m.Memory.(iz6502.AccessMemory); ok {
+		access.PokeAccess(address, value, kind, cycle)
+		return
+	}
+	m.Memory.Poke(address, value)
+}
+
+func (m *exitPortMemory) check(address uint32, value uint8) {
+	if address == m.port {
+		m.written = true
+		m.value = value
+	}
+}
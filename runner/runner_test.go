@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/loader"
+)
+
+func newTestState(program []uint8) *iz6502.State {
+	m := new(iz6502.FlatMemory)
+	for i, b := range program {
+		m.Poke(0x0400+uint32(i), b)
+	}
+	s := iz6502.NewWDC65c02(m)
+	s.SetPC(0x0400)
+	return s
+}
+
+func TestExitConditions(t *testing.T) {
+	cases := []struct {
+		name    string
+		program []uint8
+		config  Config
+		reason  Reason
+		pc      uint32
+		code    int
+	}{
+		{"trap", []uint8{0xe8, 0x4c, 0x01, 0x04}, Config{}, ReasonTrap, 0x0401, ExitSuccess},
+		{"failure trap", []uint8{0xe8, 0x4c, 0x01, 0x04}, Config{Success: 0x0500, HasSuccess: true}, ReasonTrap, 0x0401, ExitFailure},
+		{"brk", []uint8{0xe8, 0x00}, Config{StopOnBRK: true}, ReasonBRK, 0x0401, ExitSuccess},
+		{"exit port", []uint8{0xa9, 0x07, 0x8d, 0x00, 0xf0, 0x4c, 0x00, 0x04}, Config{ExitPort: 0xf000, HasExitPort: true}, ReasonExitPort, 0x0405, 7},
+		{"cycles", []uint8{0xe8, 0x4c, 0x00, 0x04}, Config{MaxCycles: 1000}, ReasonCycles, 0, ExitCycles},
+		{"no trap", []uint8{0xe8, 0x4c, 0x01, 0x04}, Config{MaxCycles: 1000, NoTrap: true}, ReasonCycles, 0, ExitCycles},
+		{"stp", []uint8{0xea, 0xdb}, Config{}, ReasonHalted, 0, ExitFailure},
+		{"wai", []uint8{0xea, 0xcb}, Config{}, ReasonHalted, 0, ExitFailure},
+		{"wai with a hook", []uint8{0xea, 0xcb}, Config{MaxCycles: 1000, Hook: func(s *iz6502.State) error { return nil }}, ReasonCycles, 0, ExitCycles},
+	}
+	for _, c := range cases {
+		s := newTestState(c.program)
+		r := Run(s, c.config)
+		if r.Reason != c.reason || (c.pc != 0 && r.PC != c.pc) || r.ExitCode(&c.config) != c.code {
+			t.Errorf("Wrong result for %v: %v, exit code %v", c.name, &r, r.ExitCode(&c.config))
+		}
+	}
+}
+
+type testExit int
+
+func (e testExit) Error() string { return "exit" }
+func (e testExit) ExitCode() int { return int(e) }
+
+func TestHook(t *testing.T) {
+	s := newTestState([]uint8{0xe8, 0xe8, 0x4c, 0x00, 0x04}) // INX, INX, JMP $0400
+	config := Config{Hook: func(s *iz6502.State) error {
+		if s.GetPC() == 0x0402 {
+			return testExit(9)
+		}
+		return nil
+	}}
+	r := Run(s, config)
+	if r.Reason != ReasonExit || r.PC != 0x0402 || r.ExitCode(&config) != 9 {
+		t.Errorf("Expected an exit, got %v", &r)
+	}
+
+	config.Hook = func(s *iz6502.State) error { return errors.New("host") }
+	r = Run(s, config)
+	if r.Reason != ReasonError || r.ExitCode(&config) != ExitError {
+		t.Errorf("Expected an error, got %v", &r)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := newTestState([]uint8{0xe8, 0x4c, 0x00, 0x04}) // INX, JMP $0400
+	config := Config{Timeout: 20 * time.Millisecond}
+	r := Run(s, config)
+	if r.Reason != ReasonTimeout || r.ExitCode(&config) != ExitTimeout {
+		t.Errorf("Expected timeout, got %v", &r)
+	}
+}
+
+func TestIllegalOpcode(t *testing.T) {
+	s := iz6502.NewMythical65c24T8(new(iz6502.Flat256KMemory))
+	s.GetMemory().Poke(0x0400, 0x07)
+	s.SetPC(0x0400)
+	config := Config{}
+	r := Run(s, config)
+	if r.Reason != ReasonError || r.Err == nil || r.ExitCode(&config) != ExitError {
+		t.Errorf("Expected an error, got %v", &r)
+	}
+}
+
+func TestFunctionalSuite(t *testing.T) {
+	img, err := loader.LoadFile("../testdata/6502_functional_test.bin", loader.FormatRaw, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(iz6502.FlatMemory)
+	img.Poke(m)
+	s := iz6502.NewNMOS6502(m)
+	s.SetPC(0x0400)
+
+	config := Config{Success: 0x3469, HasSuccess: true, MaxCycles: 200000000}
+	r := Run(s, config)
+	if r.ExitCode(&config) != ExitSuccess {
+		t.Errorf("Functional test failed: %v", &r)
+	}
+}
+
+// accessRecorder records the kinds of the accesses
+type accessRecorder struct {
+	iz6502.FlatMemory
+	kinds []iz6502.AccessKind
+}
+
+func (m *accessRecorder) PeekAccess(address uint32, kind iz6502.AccessKind, cycle uint64) uint8 {
+	m.kinds = append(m.kinds, kind)
+	return m.Peek(address)
+}
+
+func (m *accessRecorder) PokeAccess(address uint32, value uint8, kind iz6502.AccessKind, cycle uint64) {
+	m.kinds = append(m.kinds, kind)
+	m.Poke(address, value)
+}
+
+func TestExitPortAccessKinds(t *testing.T) {
+	m := new(accessRecorder)
+	poke := []uint8{0xa9, 0x07, 0x8d, 0x00, 0xf0} // LDA #$07, STA $f000
+	for i, b := range poke {
+		m.Poke(0x0400+uint32(i), b)
+	}
+	s := iz6502.NewWDC65c02(m)
+	s.SetPC(0x0400)
+	r := Run(s, Config{ExitPort: 0xf000, HasExitPort: true})
+	if r.Reason != ReasonExitPort || r.Value != 7 {
+		t.Fatalf("Expected the exit port, got %v", &r)
+	}
+	if len(m.kinds) == 0 || m.kinds[len(m.kinds)-1] != iz6502.AccessWrite {
+		t.Errorf("Access kinds lost with the exit port %v", m.kinds)
+	}
+}
@@ -0,0 +1,532 @@
+/*
+Package sim65 runs the programs built for sim65, the simulator of cc65, with
+the same paravirtualized host calls. The programs call the host functions with
+a JSR to magic addresses at the top of memory:
+
+	$fff3 lseek   $fff4 open   $fff5 close   $fff6 read
+	$fff7 write   $fff8 args   $fff9 exit
+
+Before executing an instruction at those addresses, Paravirt.Hook does the call
+with the cc65 calling convention: the last parameter in A and X, the others
+on the C stack pointed by the zero page variable sp, and the result in A and
+X. The RTS stubs installed by Paravirt.Install return to the caller.
+
+The 65c24T8 variant, with Wide set, keeps the convention with 24 bits values:
+the parameters on the C stack and sp are 3 bytes long, the last parameter and
+the result are in the 24 bits of A, and the calls are made with A24 JSR. The
+magic addresses are below the 24 bits vectors, 2 bytes apart for the A24 RTS
+stubs:
+
+	$ffffe8 lseek   $ffffea open   $ffffec close   $ffffee read
+	$fffff0 write   $fffff2 args   $fffff4 exit
+
+The files are opened in a sandbox directory. Standard input, output and error
+are the file descriptors 0, 1 and 2.
+*/
+package sim65
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/loader"
+)
+
+// Magic addresses of the host calls
+const (
+	Base     uint32 = 0xfff3
+	WideBase uint32 = 0xffffe8
+)
+
+// Host calls, in the order of the magic addresses
+const (
+	callLseek = iota
+	callOpen
+	callClose
+	callRead
+	callWrite
+	callArgs
+	callExit
+	callCount
+)
+
+// Flags of open in the fcntl.h of cc65
+const (
+	oRDONLY = 0x01
+	oWRONLY = 0x02
+	oRDWR   = 0x03
+	oCREAT  = 0x10
+	oTRUNC  = 0x20
+	oAPPEND = 0x40
+	oEXCL   = 0x80
+)
+
+const (
+	opRTS = 0x60
+	opA24 = 0x4f
+)
+
+// maxPath is the longest file name read from the guest memory
+const maxPath = 1024
+
+// ExitError is returned by Hook when the program calls exit
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit(%v)", e.Code)
+}
+
+// ExitCode returns the code passed to exit
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// Paravirt implements the host calls for a program
+type Paravirt struct {
+	SP   uint32   // Zero page address of the C stack pointer, sp in cc65
+	Wide bool     // 65c24T8 variant, with 24 bits values
+	Dir  string   // Sandbox for the files, empty to forbid them
+	Args []string // Arguments of the program, the first is its name
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	files map[int]*os.File
+}
+
+// New creates the host calls for a program with the C stack pointer at sp,
+// with the files in dir and the standard streams of the process
+func New(sp uint32, dir string, args ...string) *Paravirt {
+	return &Paravirt{
+		SP:     sp,
+		Dir:    dir,
+		Args:   args,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		files:  make(map[int]*os.File),
+	}
+}
+
+func (p *Paravirt) base() uint32 {
+	if p.Wide {
+		return WideBase
+	}
+	return Base
+}
+
+func (p *Paravirt) stride() uint32 {
+	if p.Wide {
+		return 2
+	}
+	return 1
+}
+
+// Install writes the return stubs at the magic addresses
+func (p *Paravirt) Install(mem iz6502.Memory) {
+	for i := uint32(0); i < callCount; i++ {
+		address := p.base() + i*p.stride()
+		if p.Wide {
+			mem.Poke(address, opA24)
+			mem.Poke(address+1, opRTS)
+		} else {
+			mem.Poke(address, opRTS)
+		}
+	}
+}
+
+// Close closes the files left open by the program
+func (p *Paravirt) Close() {
+	for fd, f := range p.files {
+		f.Close()
+		delete(p.files, fd)
+	}
+}
+
+// Hook does the host call if the processor is about to execute a magic
+// address. It returns an ExitError when the program exits. Call it before
+// each instruction, it can be the Hook of a runner.Config.
+func (p *Paravirt) Hook(s *iz6502.State) error {
+	pc := s.GetPC()
+	if pc < p.base() || pc >= p.base()+callCount*p.stride() || (pc-p.base())%p.stride() != 0 {
+		return nil
+	}
+
+	c := &call{p: p, s: s, mem: s.GetMemory()}
+	switch (pc - p.base()) / p.stride() {
+	case callLseek:
+		c.lseek()
+	case callOpen:
+		c.open()
+	case callClose:
+		c.close()
+	case callRead:
+		c.read()
+	case callWrite:
+		c.write()
+	case callArgs:
+		c.args()
+	case callExit:
+		return &ExitError{int(c.last() & 0xff)}
+	}
+	return nil
+}
+
+// call accesses the parameters and the result of a host call
+type call struct {
+	p   *Paravirt
+	s   *iz6502.State
+	mem iz6502.Memory
+}
+
+// size is the size of an int or pointer
+func (c *call) size() uint32 {
+	if c.p.Wide {
+		return 3
+	}
+	return 2
+}
+
+func (c *call) peek(address uint32) uint32 {
+	v := uint32(c.mem.Peek(address)) | uint32(c.mem.Peek(address+1))<<8
+	if c.p.Wide {
+		v |= uint32(c.mem.Peek(address+2)) << 16
+	}
+	return v
+}
+
+func (c *call) poke(address uint32, v uint32) {
+	c.mem.Poke(address, uint8(v))
+	c.mem.Poke(address+1, uint8(v>>8))
+	if c.p.Wide {
+		c.mem.Poke(address+2, uint8(v>>16))
+	}
+}
+
+func (c *call) sp() uint32 {
+	return c.peek(c.p.SP)
+}
+
+func (c *call) setSP(sp uint32) {
+	c.poke(c.p.SP, sp)
+}
+
+// pop reads the parameter on top of the C stack, and drops incr bytes
+func (c *call) pop(incr uint32) uint32 {
+	sp := c.sp()
+	v := c.peek(sp)
+	c.setSP(sp + incr)
+	return v
+}
+
+// last returns the parameter passed in registers
+func (c *call) last() uint32 {
+	r := c.s.GetRegisters()
+	if c.p.Wide {
+		return r.A
+	}
+	return r.A&0xff | (r.X&0xff)<<8
+}
+
+// result returns a value in registers, -1 for errors
+func (c *call) result(v int) {
+	r := c.s.GetRegisters()
+	if c.p.Wide {
+		r.A = uint32(v) & 0xffffff
+	} else {
+		r.A = uint32(v) & 0xff
+		r.X = uint32(v>>8) & 0xff
+	}
+	c.s.SetRegisters(r)
+}
+
+func (c *call) file(fd int) io.ReadWriter {
+	switch fd {
+	case 0:
+		return readWriter{r: c.p.Stdin}
+	case 1:
+		return readWriter{w: c.p.Stdout}
+	case 2:
+		return readWriter{w: c.p.Stderr}
+	}
+	if f, ok := c.p.files[fd]; ok {
+		return f
+	}
+	return nil
+}
+
+// signed converts an int parameter
+func (c *call) signed(v uint32) int {
+	bits := 8 * c.size()
+	return int(int32(v<<(32-bits)) >> (32 - bits))
+}
+
+// lseek(int fd, off_t offset, int whence), with offset a long, or 24 bits in
+// the 65c24T8 variant. Only the lower bits of the position are returned.
+func (c *call) lseek() {
+	whence := int(c.last())
+	var offset int64
+	if c.p.Wide {
+		offset = int64(c.signed(c.pop(3)))
+	} else {
+		low := c.pop(2)
+		offset = int64(int32(low | c.pop(2)<<16))
+	}
+	fd := c.signed(c.pop(c.size()))
+	f, ok := c.p.files[fd]
+	if !ok {
+		c.result(-1)
+		return
+	}
+	position, err := f.Seek(offset, whence)
+	if err != nil {
+		c.result(-1)
+		return
+	}
+	c.result(int(position))
+}
+
+// open(const char* name, int flags, ...) is variadic, Y has the size of the
+// parameters pushed
+func (c *call) open() {
+	y := c.s.GetRegisters().Y & 0xff
+	extra := uint32(0)
+	if y >= 2*c.size() {
+		extra = y - 2*c.size()
+	}
+	c.pop(extra) // The mode is ignored, the files are created 0644
+	flags := c.pop(c.size())
+	name := c.pop(c.size())
+
+	var b bytes.Buffer
+	for i := uint32(0); i < maxPath; i++ {
+		ch := c.mem.Peek(name + i)
+		if ch == 0 {
+			break
+		}
+		b.WriteByte(ch)
+	}
+	if c.p.Dir == "" {
+		c.result(-1)
+		return
+	}
+
+	var mode int
+	switch flags & oRDWR {
+	case oRDONLY:
+		mode = os.O_RDONLY
+	case oWRONLY:
+		mode = os.O_WRONLY
+	case oRDWR:
+		mode = os.O_RDWR
+	}
+	if flags&oCREAT != 0 {
+		mode |= os.O_CREATE
+	}
+	if flags&oTRUNC != 0 {
+		mode |= os.O_TRUNC
+	}
+	if flags&oAPPEND != 0 {
+		mode |= os.O_APPEND
+	}
+	if flags&oEXCL != 0 {
+		mode |= os.O_EXCL
+	}
+
+	// Cleaning the name as absolute keeps it inside the sandbox
+	filename := filepath.Join(c.p.Dir, filepath.FromSlash(path.Clean("/"+b.String())))
+	f, err := os.OpenFile(filename, mode, 0644)
+	if err != nil {
+		c.result(-1)
+		return
+	}
+	fd := 3
+	for c.p.files[fd] != nil {
+		fd++
+	}
+	if c.p.files == nil {
+		c.p.files = make(map[int]*os.File)
+	}
+	c.p.files[fd] = f
+	c.result(fd)
+}
+
+// close(int fd)
+func (c *call) close() {
+	fd := c.signed(c.last())
+	f, ok := c.p.files[fd]
+	if !ok {
+		// The standard streams stay open
+		if fd >= 0 && fd <= 2 {
+			c.result(0)
+		} else {
+			c.result(-1)
+		}
+		return
+	}
+	delete(c.p.files, fd)
+	if f.Close() != nil {
+		c.result(-1)
+		return
+	}
+	c.result(0)
+}
+
+// read(int fd, void* buf, unsigned count)
+func (c *call) read() {
+	count := c.last()
+	buf := c.pop(c.size())
+	f := c.file(c.signed(c.pop(c.size())))
+	if f == nil {
+		c.result(-1)
+		return
+	}
+	data := make([]uint8, count)
+	n, err := f.Read(data)
+	if err != nil && err != io.EOF {
+		c.result(-1)
+		return
+	}
+	for i := 0; i < n; i++ {
+		c.mem.Poke(buf+uint32(i), data[i])
+	}
+	c.result(n)
+}
+
+// write(int fd, const void* buf, unsigned count)
+func (c *call) write() {
+	count := c.last()
+	buf := c.pop(c.size())
+	f := c.file(c.signed(c.pop(c.size())))
+	if f == nil {
+		c.result(-1)
+		return
+	}
+	data := make([]uint8, count)
+	for i := range data {
+		data[i] = c.mem.Peek(buf + uint32(i))
+	}
+	n, err := f.Write(data)
+	if err != nil {
+		c.result(-1)
+		return
+	}
+	c.result(n)
+}
+
+// args(char*** argv) copies the arguments to the C stack, sets *argv to the
+// array of pointers and returns argc
+func (c *call) args() {
+	argv := c.last()
+	sp := c.sp()
+	argc := uint32(len(c.p.Args))
+	array := sp - (argc+1)*c.size()
+	c.poke(argv, array)
+
+	sp = array
+	for i, arg := range c.p.Args {
+		sp -= uint32(len(arg)) + 1
+		for j := 0; j < len(arg); j++ {
+			c.mem.Poke(sp+uint32(j), arg[j])
+		}
+		c.mem.Poke(sp+uint32(len(arg)), 0)
+		c.poke(array+uint32(i)*c.size(), sp)
+	}
+	c.poke(array+argc*c.size(), 0)
+	c.setSP(sp)
+	c.result(int(argc))
+}
+
+// readWriter adapts the standard streams, missing ones fail
+type readWriter struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (rw readWriter) Read(p []byte) (int, error) {
+	if rw.r == nil {
+		return 0, os.ErrInvalid
+	}
+	return rw.r.Read(p)
+}
+
+func (rw readWriter) Write(p []byte) (int, error) {
+	if rw.w == nil {
+		return 0, os.ErrInvalid
+	}
+	return rw.w.Write(p)
+}
+
+// Header is the header of the programs built for sim65
+type Header struct {
+	Version uint8
+	CPU     uint8  // 0 for the 6502, 1 for the 65c02, 2 for the 6502 with the undocumented opcodes
+	SP      uint32 // Zero page address of the C stack pointer
+	Load    uint32
+	Reset   uint32
+}
+
+var magic = []byte("sim65")
+
+// Model returns the model for the CPU of the header
+func (h *Header) Model() (iz6502.Model, error) {
+	switch h.CPU {
+	case 0, 2:
+		return iz6502.ModelNMOS6502, nil
+	case 1:
+		return iz6502.ModelCMOS65c02, nil
+	}
+	return 0, fmt.Errorf("unknown sim65 cpu %v", h.CPU)
+}
+
+// ReadProgram reads a program with the sim65 header, as linked by ld65 with
+// -t sim6502 or -t sim65c02. The image starts at the load address, with the
+// reset address as entry.
+func ReadProgram(r io.Reader) (*Header, *loader.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < 12 || !bytes.Equal(data[:5], magic) {
+		return nil, nil, fmt.Errorf("not a sim65 program")
+	}
+	h := &Header{
+		Version: data[5],
+		CPU:     data[6],
+		SP:      uint32(data[7]),
+		Load:    uint32(data[8]) | uint32(data[9])<<8,
+		Reset:   uint32(data[10]) | uint32(data[11])<<8,
+	}
+	if h.Version != 2 {
+		return nil, nil, fmt.Errorf("unsupported sim65 header version %v", h.Version)
+	}
+	img := &loader.Image{
+		Segments: []loader.Segment{{Address: h.Load, Data: data[12:]}},
+		Entry:    h.Reset,
+		HasEntry: true,
+	}
+	return h, img, nil
+}
+
+// LoadProgram reads a sim65 program file
+func LoadProgram(filename string) (*Header, *loader.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	h, img, err := ReadProgram(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return h, img, nil
+}
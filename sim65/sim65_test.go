@@ -0,0 +1,257 @@
+package sim65
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+	"github.com/lunarmobiscuit/iz6502/runner"
+)
+
+const (
+	testSP    = 0x02
+	testStack = 0xc000
+)
+
+type testHost struct {
+	t   *testing.T
+	s   *iz6502.State
+	mem iz6502.Memory
+	p   *Paravirt
+	out bytes.Buffer
+}
+
+func newTestHost(t *testing.T, wide bool, dir string) *testHost {
+	h := &testHost{t: t}
+	if wide {
+		h.mem = new(iz6502.Flat256KMemory)
+		h.s = iz6502.NewMythical65c24T8(h.mem)
+	} else {
+		h.mem = new(iz6502.FlatMemory)
+		h.s = iz6502.NewCMOS65c02(h.mem)
+	}
+	h.p = New(testSP, dir, "prog", "arg1")
+	h.p.Wide = wide
+	h.p.Stdout = &h.out
+	h.p.Stdin = strings.NewReader("input")
+	h.p.Install(h.mem)
+	h.setSP(testStack)
+	return h
+}
+
+func (h *testHost) size() uint32 {
+	if h.p.Wide {
+		return 3
+	}
+	return 2
+}
+
+func (h *testHost) setSP(sp uint32) {
+	c := &call{p: h.p, s: h.s, mem: h.mem}
+	c.setSP(sp)
+}
+
+// push pushes the parameters on the C stack, the first is the deepest
+func (h *testHost) push(values ...uint32) {
+	c := &call{p: h.p, s: h.s, mem: h.mem}
+	for _, v := range values {
+		sp := c.sp() - h.size()
+		c.poke(sp, v)
+		c.setSP(sp)
+	}
+}
+
+func (h *testHost) pokeString(address uint32, text string) {
+	for i := 0; i < len(text); i++ {
+		h.mem.Poke(address+uint32(i), text[i])
+	}
+	h.mem.Poke(address+uint32(len(text)), 0)
+}
+
+// call does the host call with the last parameter in registers
+func (h *testHost) call(function int, last uint32, y uint32) int {
+	r := h.s.GetRegisters()
+	if h.p.Wide {
+		r.A = last
+	} else {
+		r.A, r.X = last&0xff, last>>8
+	}
+	r.Y = y
+	r.PC = h.p.base() + uint32(function)*h.p.stride()
+	h.s.SetRegisters(r)
+	if err := h.p.Hook(h.s); err != nil {
+		h.t.Fatal(err)
+	}
+	c := &call{p: h.p, s: h.s, mem: h.mem}
+	return c.signed(c.last())
+}
+
+func TestWriteAndExit(t *testing.T) {
+	h := newTestHost(t, false, "")
+	h.pokeString(0x0300, "hello\n")
+	h.push(1, 0x0300) // fd, buf
+	program := []uint8{
+		0xa9, 0x06, // LDA #6
+		0xa2, 0x00, // LDX #0
+		0x20, 0xf7, 0xff, // JSR write
+		0x85, 0x10, // STA $10
+		0xa9, 0x03, // LDA #3
+		0x20, 0xf9, 0xff, // JSR exit
+	}
+	for i, b := range program {
+		h.mem.Poke(0x0400+uint32(i), b)
+	}
+	h.s.SetRegisters(iz6502.Registers{SP: 0xff, PC: 0x0400})
+
+	config := runner.Config{Hook: h.p.Hook}
+	r := runner.Run(h.s, config)
+	if r.Reason != runner.ReasonExit || r.ExitCode(&config) != 3 {
+		t.Errorf("Wrong result %v", &r)
+	}
+	if h.out.String() != "hello\n" || h.mem.Peek(0x10) != 6 {
+		t.Errorf("Wrong write '%v', returned %v", h.out.String(), h.mem.Peek(0x10))
+	}
+	if sp := (&call{p: h.p, s: h.s, mem: h.mem}).sp(); sp != testStack {
+		t.Errorf("Parameters not popped, sp is $%04x", sp)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sim65")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, wide := range []bool{false, true} {
+		h := newTestHost(t, wide, dir)
+		defer h.p.Close()
+
+		// The parent directory is outside the sandbox, stays in it
+		h.pokeString(0x0300, "../out.txt")
+		h.push(0x0300, oWRONLY|oCREAT|oTRUNC)
+		fd := h.call(callOpen, 0, 2*h.size())
+		if fd != 3 {
+			t.Fatalf("Wrong fd %v", fd)
+		}
+		h.pokeString(0x0320, "data")
+		h.push(uint32(fd), 0x0320)
+		if n := h.call(callWrite, 4, 0); n != 4 {
+			t.Errorf("Wrong write %v", n)
+		}
+		if result := h.call(callClose, uint32(fd), 0); result != 0 {
+			t.Errorf("Wrong close %v", result)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "out.txt")); err != nil || string(data) != "data" {
+			t.Errorf("Wrong file '%s' %v", data, err)
+		}
+
+		// With the optional mode parameter
+		h.push(0x0300, oRDONLY, 0x01)
+		fd = h.call(callOpen, 0, 3*h.size())
+		h.push(uint32(fd), 0x0340)
+		if n := h.call(callRead, 10, 0); n != 4 || h.mem.Peek(0x0343) != 'a' {
+			t.Errorf("Wrong read %v", n)
+		}
+		for _, offset := range []uint32{0, 2} {
+			if h.p.Wide {
+				// The offset is a single parameter
+				h.push(uint32(fd), offset)
+			} else {
+				h.push(uint32(fd), 0, offset)
+			}
+			if position := h.call(callLseek, 0, 0); position != int(offset) {
+				t.Errorf("Wrong lseek %v", position)
+			}
+		}
+		h.call(callClose, uint32(fd), 0)
+
+		h.pokeString(0x0300, "missing.txt")
+		h.push(0x0300, oRDONLY)
+		if fd := h.call(callOpen, 0, 2*h.size()); fd != -1 {
+			t.Errorf("Missing file opened %v", fd)
+		}
+		h.push(0, 0x0340)
+		if n := h.call(callRead, 3, 0); n != 3 || h.mem.Peek(0x0340) != 'i' {
+			t.Errorf("Wrong read from stdin %v", n)
+		}
+		if result := h.call(callClose, 9, 0); result != -1 {
+			t.Errorf("Wrong close of a bad fd %v", result)
+		}
+	}
+}
+
+func TestNoSandbox(t *testing.T) {
+	h := newTestHost(t, false, "")
+	h.pokeString(0x0300, "out.txt")
+	h.push(0x0300, oWRONLY|oCREAT)
+	if fd := h.call(callOpen, 0, 4); fd != -1 {
+		t.Errorf("File opened without sandbox %v", fd)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	for _, wide := range []bool{false, true} {
+		h := newTestHost(t, wide, "")
+		c := &call{p: h.p, s: h.s, mem: h.mem}
+		if argc := h.call(callArgs, 0x0200, 0); argc != 2 {
+			t.Errorf("Wrong argc %v", argc)
+		}
+		argv := c.peek(0x0200)
+		if argv != c.sp()+uint32(len("prog")+len("arg1")+2) || c.peek(argv+2*h.size()) != 0 {
+			t.Errorf("Wrong argv $%x", argv)
+		}
+		arg1 := c.peek(argv + h.size())
+		if h.mem.Peek(arg1) != 'a' || h.mem.Peek(arg1+4) != 0 {
+			t.Errorf("Wrong arg1 at $%x", arg1)
+		}
+	}
+}
+
+func TestWide(t *testing.T) {
+	h := newTestHost(t, true, "")
+	h.pokeString(0x0300, "24 bits")
+	h.push(1, 0x0300)
+	program := []uint8{
+		0x4f, 0x20, 0xf0, 0xff, 0xff, // A24 JSR write
+		0x85, 0x10, // STA $10
+		0x4f, 0x20, 0xf4, 0xff, 0xff, // A24 JSR exit
+	}
+	for i, b := range program {
+		h.mem.Poke(0x0400+uint32(i), b)
+	}
+	h.s.SetRegisters(iz6502.Registers{A: 7, SP: 0xff, PC: 0x0400})
+
+	config := runner.Config{Hook: h.p.Hook, MaxCycles: 1000}
+	r := runner.Run(h.s, config)
+	if r.Reason != runner.ReasonExit || r.ExitCode(&config) != 7 {
+		t.Errorf("Wrong result %v", &r)
+	}
+	if h.out.String() != "24 bits" || h.mem.Peek(0x10) != 7 {
+		t.Errorf("Wrong write '%v', returned %v", h.out.String(), h.mem.Peek(0x10))
+	}
+}
+
+func TestReadProgram(t *testing.T) {
+	data := append([]byte("sim65\x02\x01\x02\x00\x02\x00\x02"), 0xea, 0x60)
+	header, img, err := ReadProgram(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.SP != 0x02 || header.Load != 0x0200 || !img.HasEntry || img.Entry != 0x0200 {
+		t.Errorf("Wrong header %+v", header)
+	}
+	if model, _ := header.Model(); model != iz6502.ModelCMOS65c02 {
+		t.Errorf("Wrong model %v", model)
+	}
+	if len(img.Segments) != 1 || len(img.Segments[0].Data) != 2 {
+		t.Errorf("Wrong image %+v", img)
+	}
+
+	if _, _, err := ReadProgram(bytes.NewReader([]byte("sim65\x01"))); err == nil {
+		t.Error("Truncated header accepted")
+	}
+}
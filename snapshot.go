@@ -0,0 +1,252 @@
+package iz6502
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Snapshot format, big endian:
+	"IZ65"      magic header
+	uint8       version
+	uint8       model
+	snapshotCPU
+	uint8       number of thread banks, 0 except for the 65c24T8
+	snapshotThread for each bank
+
+The legacy format had no header, just the cycles and reg.data. It is
+recognized because the upper half of the cycles never matches the magic.
+*/
+
+const snapshotVersion uint8 = 1
+
+var snapshotMagic = [4]byte{'I', 'Z', '6', '5'}
+
+// ErrSnapshotMidInstruction is returned by Save while Tick is in the middle of
+// an instruction, complete it with Step first
+var ErrSnapshotMidInstruction = errors.New("cannot save a snapshot in the middle of an instruction")
+
+type snapshotCPU struct {
+	Cycles     uint64
+	Data       [4]uint32
+	P          uint8
+	PC         uint32
+	AbWidth    uint8
+	AbMaxWidth uint8
+	RWidth     uint8
+	RMaxWidth  uint8
+	SWidth     uint8
+	WasPrefix  bool
+
+	ExtraCycleCrossingBoundaries bool
+	ExtraCycleBranchTaken        bool
+	ExtraCycleBCD                bool
+
+	IrqLine       bool
+	NmiPending    bool
+	Waiting       bool
+	Stopped       bool
+	MagicConstant uint8
+	Thread        uint8
+}
+
+type snapshotThread struct {
+	Running bool
+	Data    [4]uint32
+	P       uint8
+	PC      uint32
+	AbWidth uint8
+	RWidth  uint8
+	SWidth  uint8
+}
+
+// Save saves the full CPU state: registers, widths, interrupt lines, threads and cycle counter
+func (s *State) Save(w io.Writer) error {
+	if s.tick.active {
+		return ErrSnapshotMidInstruction
+	}
+	header := append(snapshotMagic[:], snapshotVersion, uint8(s.model))
+	_, err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	cpu := snapshotCPU{
+		Cycles:     s.cycles,
+		Data:       s.reg.data,
+		P:          s.reg.p,
+		PC:         s.reg.pc,
+		AbWidth:    s.abWidth,
+		AbMaxWidth: s.abMaxWidth,
+		RWidth:     s.rWidth,
+		RMaxWidth:  s.rMaxWidth,
+		SWidth:     s.sWidth,
+		WasPrefix:  s.wasPrefix,
+
+		ExtraCycleCrossingBoundaries: s.extraCycleCrossingBoundaries,
+		ExtraCycleBranchTaken:        s.extraCycleBranchTaken,
+		ExtraCycleBCD:                s.extraCycleBCD,
+
+		IrqLine:       s.irqLine,
+		NmiPending:    s.nmiPending,
+		Waiting:       s.waiting,
+		Stopped:       s.stopped,
+		MagicConstant: s.magicConstant,
+		Thread:        s.thread,
+	}
+	err = binary.Write(w, binary.BigEndian, &cpu)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.BigEndian, uint8(len(s.threads)))
+	if err != nil {
+		return err
+	}
+	for i := range s.threads {
+		t := &s.threads[i]
+		err = binary.Write(w, binary.BigEndian, &snapshotThread{
+			Running: t.running,
+			Data:    t.reg.data,
+			P:       t.reg.p,
+			PC:      t.reg.pc,
+			AbWidth: t.abWidth,
+			RWidth:  t.rWidth,
+			SWidth:  t.sWidth,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load loads the CPU state saved with Save. It also accepts the legacy
+// format with only the registers and the cycle counter. The state is left
+// unchanged if the snapshot is not valid for the cpu.
+func (s *State) Load(r io.Reader) error {
+	var magic [4]byte
+	_, err := io.ReadFull(r, magic[:])
+	if err != nil {
+		return err
+	}
+	if magic != snapshotMagic {
+		return s.loadLegacy(io.MultiReader(bytes.NewReader(magic[:]), r))
+	}
+
+	var header [2]uint8
+	_, err = io.ReadFull(r, header[:])
+	if err != nil {
+		return err
+	}
+	if header[0] != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %v", header[0])
+	}
+	if Model(header[1]) != s.model {
+		return fmt.Errorf("snapshot is for a %v, the cpu is a %v", Model(header[1]), s.model)
+	}
+
+	var cpu snapshotCPU
+	err = binary.Read(r, binary.BigEndian, &cpu)
+	if err != nil {
+		return err
+	}
+
+	var threadCount uint8
+	err = binary.Read(r, binary.BigEndian, &threadCount)
+	if err != nil {
+		return err
+	}
+	if int(threadCount) != len(s.threads) {
+		return errors.New("snapshot with a wrong number of threads")
+	}
+	threads := make([]snapshotThread, threadCount)
+	err = binary.Read(r, binary.BigEndian, threads)
+	if err != nil {
+		return err
+	}
+
+	// Nothing is changed until the whole snapshot is valid
+	if int(cpu.Thread) >= s.Threads() {
+		return fmt.Errorf("snapshot with thread %v, the cpu has %v", cpu.Thread, s.Threads())
+	}
+	if cpu.AbMaxWidth != s.abMaxWidth || !validWidth(cpu.RMaxWidth) {
+		return errors.New("snapshot with wrong maximum widths")
+	}
+	err = s.checkWidths(cpu.AbWidth, cpu.RWidth, cpu.SWidth)
+	if err != nil {
+		return err
+	}
+	for i := range threads {
+		err = s.checkWidths(threads[i].AbWidth, threads[i].RWidth, threads[i].SWidth)
+		if err != nil {
+			return fmt.Errorf("thread %v: %v", i, err)
+		}
+	}
+
+	s.cycles = cpu.Cycles
+	s.reg.data = cpu.Data
+	s.reg.p = cpu.P
+	s.reg.pc = cpu.PC
+	s.abWidth = cpu.AbWidth
+	s.abMaxWidth = cpu.AbMaxWidth
+	s.rWidth = cpu.RWidth
+	s.rMaxWidth = cpu.RMaxWidth
+	s.sWidth = cpu.SWidth
+	s.wasPrefix = cpu.WasPrefix
+	s.extraCycleCrossingBoundaries = cpu.ExtraCycleCrossingBoundaries
+	s.extraCycleBranchTaken = cpu.ExtraCycleBranchTaken
+	s.extraCycleBCD = cpu.ExtraCycleBCD
+	s.irqLine = cpu.IrqLine
+	s.nmiPending = cpu.NmiPending
+	s.waiting = cpu.Waiting
+	s.stopped = cpu.Stopped
+	s.magicConstant = cpu.MagicConstant
+	s.thread = cpu.Thread
+
+	for i := range threads {
+		t := &s.threads[i]
+		t.running = threads[i].Running
+		t.reg.data = threads[i].Data
+		t.reg.p = threads[i].P
+		t.reg.pc = threads[i].PC
+		t.abWidth = threads[i].AbWidth
+		t.rWidth = threads[i].RWidth
+		t.sWidth = threads[i].SWidth
+	}
+	return nil
+}
+
+// checkWidths verifies the address, register and stack widths of a snapshot
+func (s *State) checkWidths(ab uint8, r uint8, sp uint8) error {
+	if (ab != AB16 && ab != AB24) || ab > s.abMaxWidth {
+		return fmt.Errorf("snapshot with a wrong address width $%02x", ab)
+	}
+	if !validWidth(r) || !validWidth(sp) {
+		return fmt.Errorf("snapshot with a wrong register width $%02x or stack width $%02x", r, sp)
+	}
+	return nil
+}
+
+func validWidth(w uint8) bool {
+	return w == R08 || w == R16 || w == R24
+}
+
+func (s *State) loadLegacy(r io.Reader) error {
+	var cycles uint64
+	err := binary.Read(r, binary.BigEndian, &cycles)
+	if err != nil {
+		return err
+	}
+	var data [4]uint32
+	err = binary.Read(r, binary.BigEndian, &data)
+	if err != nil {
+		return err
+	}
+	s.cycles = cycles
+	s.reg.data = data
+	return nil
+}
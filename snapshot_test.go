@@ -0,0 +1,134 @@
+package iz6502
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestSnapshotMidPrefix(t *testing.T) {
+	m := new(Flat256KMemory)
+	s := NewMythical65c24T8(m)
+	m.Poke(0x0400, 0x6F) // W24
+	m.Poke(0x0401, 0xA9) // LDA #$123456
+	m.Poke(0x0402, 0x56)
+	m.Poke(0x0403, 0x34)
+	m.Poke(0x0404, 0x12)
+	s.reg.setPC(0x0400)
+	s.reg.setP(flagC | flagN)
+	s.ExecuteInstruction()
+
+	var buf bytes.Buffer
+	err := s.Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := NewMythical65c24T8(m)
+	err = s2.Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.reg.getP() != flagC|flagN || s2.GetCycles() != s.GetCycles() {
+		t.Errorf("Registers not restored %v", s2.reg)
+	}
+
+	s2.ExecuteInstruction()
+	if s2.reg.getA(R24) != 0x123456 || s2.reg.getPC() != 0x0405 {
+		t.Errorf("Prefix state not restored, %v", s2.reg)
+	}
+}
+
+func TestSnapshotModelMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewNMOS6502(new(FlatMemory)).Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewCMOS65c02(new(FlatMemory)).Load(&buf)
+	if err == nil {
+		t.Error("Snapshot loaded on a different model")
+	}
+}
+
+func TestSnapshotLegacy(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint64(1234))
+	binary.Write(&buf, binary.BigEndian, [4]uint32{1, 2, 3, 4})
+
+	s := NewNMOS6502(new(FlatMemory))
+	err := s.Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.GetCycles() != 1234 || s.reg.getX(R08) != 2 || s.reg.getSP(R08) != 4 {
+		t.Errorf("Legacy snapshot not loaded, %v", s.reg)
+	}
+}
+
+func TestSnapshotMidInstruction(t *testing.T) {
+	m := new(FlatMemory)
+	m.Poke(0x0400, 0xad) // LDA $1234
+	m.Poke(0x0401, 0x34)
+	m.Poke(0x0402, 0x12)
+	m.Poke(0x1234, 0x77)
+	s := NewNMOS6502(m)
+	s.SetPC(0x400)
+	s.Tick()
+	s.Tick()
+
+	var buf bytes.Buffer
+	if err := s.Save(&buf); err != ErrSnapshotMidInstruction {
+		t.Errorf("Snapshot saved in the middle of an instruction: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%v bytes written", buf.Len())
+	}
+
+	if err := s.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s2 := NewNMOS6502(m)
+	if err := s2.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if s2.GetPC() != 0x403 || s2.reg.getA(R08) != 0x77 {
+		t.Errorf("Instruction not completed, %v", s2.reg)
+	}
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	threadOffset := len(snapshotMagic) + 2 + binary.Size(snapshotCPU{}) - 1
+	widthOffset := len(snapshotMagic) + 2 + 8 + 16 + 1 + 4 // AbWidth, after the cycles, data, P and PC
+
+	patch := func(s *State, offset int, value uint8) []uint8 {
+		var buf bytes.Buffer
+		err := s.Save(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		data[offset] = value
+		return data
+	}
+	check := func(name string, s *State, data []uint8) {
+		s.SetPC(0x1234)
+		cycles := s.GetCycles()
+		if err := s.Load(bytes.NewReader(data)); err == nil {
+			t.Errorf("%v: snapshot loaded", name)
+		}
+		if s.GetPC() != 0x1234 || s.GetCycles() != cycles || s.CurrentThread() != 0 {
+			t.Errorf("%v: state changed by a failed load", name)
+		}
+	}
+
+	s24 := NewMythical65c24T8(new(Flat256KMemory))
+	check("thread 9", NewMythical65c24T8(new(Flat256KMemory)), patch(s24, threadOffset, 9))
+	check("address width", NewMythical65c24T8(new(Flat256KMemory)), patch(s24, widthOffset, 0x80))
+	s := NewNMOS6502(new(FlatMemory))
+	check("NMOS thread 1", NewNMOS6502(new(FlatMemory)), patch(s, threadOffset, 1))
+	check("NMOS address width", NewNMOS6502(new(FlatMemory)), patch(s, widthOffset, AB24))
+}
@@ -0,0 +1,143 @@
+package iz6502
+
+/*
+SparseMemory covers the 16Mb of the 65c24T8 allocating the pages only when
+they are written. A page never written reads the fill value.
+*/
+
+// SparsePageSize is the allocation unit of a SparseMemory
+const SparsePageSize = 0x1000
+
+const (
+	sparsePageBits = 12
+	sparsePageMask = SparsePageSize - 1
+	sparsePages    = MappedAddressSpace / SparsePageSize
+)
+
+type sparsePage [SparsePageSize]uint8
+
+// SparseMemory puts RAM on the 16Mb addressable by the 65c24T8
+type SparseMemory struct {
+	fill    uint8
+	touched int
+	pages   [sparsePages]*sparsePage
+}
+
+// NewSparseMemory returns a memory reading fill where never written. The
+// zero SparseMemory is usable too, with fill 0.
+func NewSparseMemory(fill uint8) *SparseMemory {
+	var m SparseMemory
+	m.fill = fill
+	return &m
+}
+
+// Fill returns the value of the bytes never written
+func (m *SparseMemory) Fill() uint8 {
+	return m.fill
+}
+
+// Peek returns the data on the given address
+func (m *SparseMemory) Peek(address uint32) uint8 {
+	address &= MappedAddressSpace - 1
+	p := m.pages[address>>sparsePageBits]
+	if p == nil {
+		return m.fill
+	}
+	return p[address&sparsePageMask]
+}
+
+// PeekCode returns the data on the given address
+func (m *SparseMemory) PeekCode(address uint32) uint8 {
+	return m.Peek(address)
+}
+
+// Poke sets the data at the given address
+func (m *SparseMemory) Poke(address uint32, value uint8) {
+	address &= MappedAddressSpace - 1
+	p := m.pages[address>>sparsePageBits]
+	if p == nil {
+		if value == m.fill {
+			// No need to allocate to store what is already read
+			return
+		}
+		p = m.touch(address >> sparsePageBits)
+	}
+	p[address&sparsePageMask] = value
+}
+
+func (m *SparseMemory) touch(page uint32) *sparsePage {
+	p := new(sparsePage)
+	if m.fill != 0 {
+		for i := range p {
+			p[i] = m.fill
+		}
+	}
+	m.pages[page] = p
+	m.touched++
+	return p
+}
+
+// Touched returns the number of pages allocated
+func (m *SparseMemory) Touched() int {
+	return m.touched
+}
+
+// Pages calls visit with the address and data of the pages allocated, in
+// address order, until it returns false. The data can be modified in place.
+func (m *SparseMemory) Pages(visit func(address uint32, data []uint8) bool) {
+	for i, p := range m.pages {
+		if p != nil && !visit(uint32(i)<<sparsePageBits, p[:]) {
+			return
+		}
+	}
+}
+
+// Load copies the data to memory starting on address, wrapping at 16Mb
+func (m *SparseMemory) Load(address uint32, data []uint8) {
+	for len(data) > 0 {
+		address &= MappedAddressSpace - 1
+		offset := address & sparsePageMask
+		n := SparsePageSize - int(offset)
+		if n > len(data) {
+			n = len(data)
+		}
+		page := address >> sparsePageBits
+		p := m.pages[page]
+		if p == nil {
+			p = m.touch(page)
+		}
+		copy(p[offset:], data[:n])
+		data = data[n:]
+		address += uint32(n)
+	}
+}
+
+// Dump copies the memory starting on address to data, wrapping at 16Mb
+func (m *SparseMemory) Dump(address uint32, data []uint8) {
+	for len(data) > 0 {
+		address &= MappedAddressSpace - 1
+		offset := address & sparsePageMask
+		n := SparsePageSize - int(offset)
+		if n > len(data) {
+			n = len(data)
+		}
+		p := m.pages[address>>sparsePageBits]
+		if p == nil {
+			for i := 0; i < n; i++ {
+				data[i] = m.fill
+			}
+		} else {
+			copy(data[:n], p[offset:])
+		}
+		data = data[n:]
+		address += uint32(n)
+	}
+}
+
+// Clear releases all the pages
+func (m *SparseMemory) Clear() {
+	for i := range m.pages {
+		m.pages[i] = nil
+	}
+	m.touched = 0
+}
@@ -0,0 +1,78 @@
+package iz6502
+
+import (
+	"testing"
+)
+
+func TestSparseMemory(t *testing.T) {
+	m := NewSparseMemory(0xff)
+	if m.Peek(0xabcdef) != 0xff || m.Touched() != 0 {
+		t.Error("Wrong fill value")
+	}
+	m.Poke(0x123456, 0xff)
+	if m.Touched() != 0 {
+		t.Error("Page allocated to write the fill value")
+	}
+
+	m.Poke(0xffffff, 0x12)
+	m.Poke(0x03ffff, 0x34)
+	if m.Peek(0xffffff) != 0x12 || m.Peek(0x03ffff) != 0x34 || m.Peek(0xfffffe) != 0xff {
+		t.Error("Addresses above 256Kb aliased")
+	}
+	if m.Peek(0x1ffffff) != 0x12 {
+		t.Error("Address not wrapped to 24 bits")
+	}
+
+	var addresses []uint32
+	m.Pages(func(address uint32, data []uint8) bool {
+		addresses = append(addresses, address)
+		return true
+	})
+	if len(addresses) != 2 || addresses[0] != 0x03f000 || addresses[1] != 0xfff000 {
+		t.Errorf("Wrong touched pages %x", addresses)
+	}
+	count := 0
+	m.Pages(func(address uint32, data []uint8) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Error("Iteration not stopped")
+	}
+
+	m.Clear()
+	if m.Touched() != 0 || m.Peek(0xffffff) != 0xff {
+		t.Error("Memory not cleared")
+	}
+}
+
+func TestSparseMemoryBulk(t *testing.T) {
+	var m SparseMemory
+	data := make([]uint8, 3*SparsePageSize)
+	for i := range data {
+		data[i] = uint8(i)
+	}
+	// Across pages and wrapping to the start
+	address := uint32(0xfff800)
+	m.Load(address, data)
+	if m.Touched() != 4 || m.Peek(0xfff800) != 0 || m.Peek(0x000000) != 0x00 || m.Peek(0x000001) != 0x01 {
+		t.Errorf("Wrong load, %v pages", m.Touched())
+	}
+
+	dump := make([]uint8, len(data)+0x10)
+	m.Dump(address, dump)
+	for i := range data {
+		if dump[i] != data[i] {
+			t.Fatalf("Wrong dump at %v", i)
+		}
+	}
+	if dump[len(data)] != 0 {
+		t.Error("Untouched memory not dumped as the fill value")
+	}
+
+	s := NewMythical65c24T8(&m)
+	s.SetPC(0xfff800)
+	if s.GetPC() != 0xfff800 || m.PeekCode(s.GetPC()) != 0 {
+		t.Error("Wrong code fetch")
+	}
+}
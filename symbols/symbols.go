@@ -0,0 +1,318 @@
+// Package symbols is a table of names for the addresses of a program. It loads
+// the debug info of the cc65 linker, ld65, with the --dbgfile option, the VICE
+// label files written with the -Ln option and simple maps of SYMBOL = $ADDR
+// lines. The table implements iz6502.Symbolizer to print JSR print_string
+// instead of JSR $c0a3 in the disassembler, the tracers and the debuggers.
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lunarmobiscuit/iz6502/dbginfo"
+)
+
+// Symbol is a name for an address
+type Symbol struct {
+	Name    string
+	Address uint32
+	Label   bool // Labels name code or data, the rest are constants
+}
+
+// local returns true for the cheap local labels and the linker generated names
+func (s *Symbol) local() bool {
+	return strings.HasPrefix(s.Name, "@") || strings.HasPrefix(s.Name, "__")
+}
+
+// better returns true if s is preferred to other to name an address
+func (s *Symbol) better(other *Symbol) bool {
+	if s.Label != other.Label {
+		return s.Label
+	}
+	if s.local() != other.local() {
+		return !s.local()
+	}
+	return s.Name < other.Name
+}
+
+// Table is a symbol table. Names are unique, the last definition wins. Many
+// names can share an address.
+type Table struct {
+	byName    map[string]*Symbol
+	byAddress map[uint32]*Symbol // Preferred symbol of each address
+	labels    []*Symbol          // Preferred labels sorted by address, built when needed
+}
+
+// New creates an empty table
+func New() *Table {
+	return &Table{
+		byName:    make(map[string]*Symbol),
+		byAddress: make(map[uint32]*Symbol),
+	}
+}
+
+// Load creates a table with the symbols of the files, see LoadFile
+func Load(filenames ...string) (*Table, error) {
+	t := New()
+	for _, filename := range filenames {
+		if err := t.LoadFile(filename); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Add defines a symbol, replacing the previous symbol with the same name
+func (t *Table) Add(name string, address uint32, label bool) {
+	if old, ok := t.byName[name]; ok {
+		delete(t.byName, name)
+		if t.byAddress[old.Address] == old {
+			t.rebuildAddress(old.Address)
+		}
+	}
+	s := &Symbol{Name: name, Address: address, Label: label}
+	t.byName[name] = s
+	if best, ok := t.byAddress[address]; !ok || s.better(best) {
+		t.byAddress[address] = s
+	}
+	t.labels = nil
+}
+
+func (t *Table) rebuildAddress(address uint32) {
+	delete(t.byAddress, address)
+	for _, s := range t.byName {
+		if s.Address != address {
+			continue
+		}
+		if best, ok := t.byAddress[address]; !ok || s.better(best) {
+			t.byAddress[address] = s
+		}
+	}
+}
+
+// Len returns the number of symbols
+func (t *Table) Len() int {
+	return len(t.byName)
+}
+
+// Lookup returns the address of a symbol
+func (t *Table) Lookup(name string) (uint32, bool) {
+	s, ok := t.byName[name]
+	if !ok {
+		return 0, false
+	}
+	return s.Address, true
+}
+
+// Symbols returns a copy of the symbols sorted by address and name
+func (t *Table) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(t.byName))
+	for _, s := range t.byName {
+		symbols = append(symbols, *s)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Address != symbols[j].Address {
+			return symbols[i].Address < symbols[j].Address
+		}
+		return symbols[i].Name < symbols[j].Name
+	})
+	return symbols
+}
+
+// SymbolAt returns the name of the symbol with the exact address. Labels are
+// preferred to constants, and global names to local ones.
+func (t *Table) SymbolAt(address uint32) (string, bool) {
+	s, ok := t.byAddress[address]
+	if !ok {
+		return "", false
+	}
+	return s.Name, true
+}
+
+// NearestSymbol returns the closest label at or before the address, and the
+// offset of the address from it. Constants are not used.
+func (t *Table) NearestSymbol(address uint32) (string, uint32, bool) {
+	if t.labels == nil {
+		t.labels = make([]*Symbol, 0, len(t.byAddress))
+		for _, s := range t.byAddress {
+			if s.Label {
+				t.labels = append(t.labels, s)
+			}
+		}
+		sort.Slice(t.labels, func(i, j int) bool {
+			return t.labels[i].Address < t.labels[j].Address
+		})
+	}
+	i := sort.Search(len(t.labels), func(i int) bool {
+		return t.labels[i].Address > address
+	})
+	if i == 0 {
+		return "", 0, false
+	}
+	s := t.labels[i-1]
+	return s.Name, address - s.Address, true
+}
+
+// AddDbgInfo adds the symbols of ld65 debug info. Labels in the debug info
+// are labels in the table, the rest are constants.
+func (t *Table) AddDbgInfo(info *dbginfo.Info) {
+	for _, s := range info.Symbols {
+		t.Add(s.Name, s.Value, s.Label)
+	}
+}
+
+// ReadDbgInfo adds the symbols of a debug info file written by ld65
+func (t *Table) ReadDbgInfo(r io.Reader) error {
+	info, err := dbginfo.Parse(r, "")
+	if err != nil {
+		return err
+	}
+	t.AddDbgInfo(info)
+	return nil
+}
+
+// ReadVICE adds the labels of a VICE label file, as written by ld65 with -Ln:
+//
+//	al 00c0a3 .print_string
+//	al C:c0a3 .print_string
+//
+// Other VICE monitor commands are ignored.
+func (t *Table) ReadVICE(r io.Reader) error {
+	return scanLines(r, func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "al" {
+			return nil
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid label '%v'", line)
+		}
+		text := fields[1]
+		if colon := strings.IndexByte(text, ':'); colon >= 0 {
+			// Memory space prefix
+			text = text[colon+1:]
+		}
+		address, err := strconv.ParseUint(text, 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid address in '%v'", line)
+		}
+		t.Add(strings.TrimPrefix(fields[2], "."), uint32(address), true)
+		return nil
+	})
+}
+
+// ReadMap adds the symbols of lines like SYMBOL = $ADDR. The separator can be
+// =, := or EQU, and the address is hex with $ or 0x, binary with %, or
+// decimal. Comments start with ; # or //. The symbols are used as labels.
+func (t *Table) ReadMap(r io.Reader) error {
+	return scanLines(r, func(line string) error {
+		for _, comment := range []string{";", "#", "//"} {
+			if i := strings.Index(line, comment); i >= 0 {
+				line = line[:i]
+			}
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+
+		var name, value string
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name = strings.TrimSuffix(strings.TrimSpace(line[:i]), ":")
+			value = line[i+1:]
+		} else if fields := strings.Fields(line); len(fields) == 3 && strings.EqualFold(fields[1], "equ") {
+			name, value = fields[0], fields[2]
+		} else {
+			return fmt.Errorf("invalid symbol '%v'", line)
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("invalid symbol '%v'", line)
+		}
+		address, err := parseNumber(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid address in '%v'", line)
+		}
+		t.Add(name, address, true)
+		return nil
+	})
+}
+
+// LoadFile adds the symbols of a file. The format is detected from the
+// content: debug info starts with a version line, VICE files have al
+// commands and the rest are read as maps.
+func (t *Table) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var read func(io.Reader) error
+	switch detect(r) {
+	case "dbg":
+		read = func(r io.Reader) error {
+			info, err := dbginfo.Parse(r, filepath.Dir(filename))
+			if err == nil {
+				t.AddDbgInfo(info)
+			}
+			return err
+		}
+	case "vice":
+		read = t.ReadVICE
+	default:
+		read = t.ReadMap
+	}
+	if err := read(r); err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+	return nil
+}
+
+// detect returns the format of the first line with content, without consuming it
+func detect(r *bufio.Reader) string {
+	data, _ := r.Peek(4096)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "version\t"):
+			return "dbg"
+		case strings.HasPrefix(line, "al "):
+			return "vice"
+		}
+		return "map"
+	}
+	return "map"
+}
+
+func scanLines(r io.Reader, f func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		if err := f(strings.TrimRight(scanner.Text(), "\r")); err != nil {
+			return fmt.Errorf("line %v: %v", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func parseNumber(text string) (uint32, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(text, "$"):
+		text, base = text[1:], 16
+	case strings.HasPrefix(text, "0x"), strings.HasPrefix(text, "0X"):
+		text, base = text[2:], 16
+	case strings.HasPrefix(text, "%"):
+		text, base = text[1:], 2
+	}
+	value, err := strconv.ParseUint(text, base, 32)
+	return uint32(value), err
+}
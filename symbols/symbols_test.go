@@ -0,0 +1,149 @@
+package symbols
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lunarmobiscuit/iz6502"
+)
+
+func TestTable(t *testing.T) {
+	table := New()
+	table.Add("main", 0x0400, true)
+	table.Add("@loop", 0x0410, true)
+	table.Add("loop", 0x0410, true)
+	table.Add("CR", 0x000d, false)
+	table.Add("line", 0x000d, true)
+
+	if name, ok := table.SymbolAt(0x0410); !ok || name != "loop" {
+		t.Errorf("Global names must be preferred, got %v", name)
+	}
+	if name, ok := table.SymbolAt(0x000d); !ok || name != "line" {
+		t.Errorf("Labels must be preferred, got %v", name)
+	}
+	if name, offset, ok := table.NearestSymbol(0x0413); !ok || name != "loop" || offset != 3 {
+		t.Errorf("Wrong nearest symbol %v+%v", name, offset)
+	}
+	if _, _, ok := table.NearestSymbol(0x0005); ok {
+		t.Error("Symbol found before the first label")
+	}
+
+	// Redefinitions move the symbol
+	table.Add("loop", 0x0420, true)
+	if name, _ := table.SymbolAt(0x0410); name != "@loop" {
+		t.Errorf("Wrong symbol after redefinition %v", name)
+	}
+	if name, offset, _ := table.NearestSymbol(0x0421); name != "loop" || offset != 1 {
+		t.Errorf("Wrong nearest symbol after redefinition %v+%v", name, offset)
+	}
+	if address, ok := table.Lookup("loop"); !ok || address != 0x0420 {
+		t.Errorf("Wrong lookup $%x", address)
+	}
+	if table.Len() != 5 || table.Symbols()[0].Name != "CR" {
+		t.Errorf("Wrong symbols %v", table.Symbols())
+	}
+}
+
+func TestReadVICE(t *testing.T) {
+	table := New()
+	err := table.ReadVICE(strings.NewReader("al 00C0A3 .print_string\r\nal C:0400 .main\nbreak 0400\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, _ := table.Lookup("print_string"); address != 0xc0a3 {
+		t.Errorf("Wrong address $%x", address)
+	}
+	if address, _ := table.Lookup("main"); address != 0x0400 {
+		t.Errorf("Wrong address $%x", address)
+	}
+	if err := table.ReadVICE(strings.NewReader("al zz .bad\n")); err == nil {
+		t.Error("Invalid label accepted")
+	}
+}
+
+func TestReadMap(t *testing.T) {
+	table := New()
+	err := table.ReadMap(strings.NewReader(`; Monitor entry points
+ECHO = $FFEF
+GETLINE := 0xff1f  # comment
+KBD EQU %1101000000010000
+COUNT = 42 // decimal
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]uint32{"ECHO": 0xffef, "GETLINE": 0xff1f, "KBD": 0xd010, "COUNT": 42}
+	for name, value := range expected {
+		if address, ok := table.Lookup(name); !ok || address != value {
+			t.Errorf("Wrong address for %v: $%x", name, address)
+		}
+	}
+	for _, bad := range []string{"ECHO $FFEF\n", "ECHO = $GG\n", "= $10\n"} {
+		if err := New().ReadMap(strings.NewReader(bad)); err == nil {
+			t.Errorf("Invalid map accepted: %q", bad)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symbols")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"prog.dbg": "version\tmajor=2,minor=0\n" +
+			"sym\tid=0,name=\"print_string\",addrsize=absolute,scope=0,def=1,val=0xC0A3,seg=0,type=lab\n" +
+			"sym\tid=1,name=\"CR\",addrsize=zeropage,scope=0,def=2,val=0xD,type=equ\n",
+		"prog.lbl": "al 000400 .main\n",
+		"rom.sym":  "\nECHO = $FFEF\n",
+	}
+	var filenames []string
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		ioutil.WriteFile(filename, []byte(content), 0644)
+		filenames = append(filenames, filename)
+	}
+	table, err := Load(filenames...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Len() != 4 {
+		t.Errorf("Wrong symbols %v", table.Symbols())
+	}
+	// Constants name addresses but not PCs
+	if name, ok := table.SymbolAt(0x000d); !ok || name != "CR" {
+		t.Errorf("Wrong symbol %v", name)
+	}
+	if name, _, ok := table.NearestSymbol(0x0010); ok {
+		t.Errorf("Constant used as label %v", name)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Missing file accepted")
+	}
+}
+
+func TestDisassembleWithSymbols(t *testing.T) {
+	table := New()
+	table.Add("main", 0x0400, true)
+	table.Add("print_string", 0xc0a3, true)
+	var m iz6502.FlatMemory
+	m.Poke(0x0400, 0x20) // JSR $c0a3
+	m.Poke(0x0401, 0xa3)
+	m.Poke(0x0402, 0xc0)
+	m.Poke(0x0403, 0xd0) // BNE $0400
+	m.Poke(0x0404, 0xfb)
+
+	i, next, _ := iz6502.DisassembleSymbols(iz6502.ModelCMOS65c02, &m, 0x0400, iz6502.Widths{}, table)
+	if i.String() != "JSR print_string" {
+		t.Errorf("Wrong instruction '%v'", i)
+	}
+	i, _, _ = iz6502.DisassembleSymbols(iz6502.ModelCMOS65c02, &m, next, iz6502.Widths{}, table)
+	if i.String() != "BNE main" {
+		t.Errorf("Wrong instruction '%v'", i)
+	}
+}
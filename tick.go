@@ -0,0 +1,697 @@
+package iz6502
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+Cycle accurate execution.
+
+Tick runs the processor one cycle at a time and returns the bus access of each
+cycle: the dummy reads, the double write of the read-modify-write instructions
+on the NMOS 6502, the read of the wrong address when an index crosses a page
+boundary. The registers and memory end as with Step, Tick only adds the order
+and timing of the accesses.
+
+The operations are shared with Step. Tick sequences the bus cycles of the
+addressing mode, and runs the operation when its operand is on the bus, with a
+memory that returns the values already read in the instruction and holds the
+writes until their cycle.
+
+See:
+	http://nesdev.org/6502_cpu.txt
+	http://www.westerndesigncenter.com/wdc/documentation/w65c02s.pdf
+*/
+
+// ErrNoBusTiming is returned by Tick for the 65c24T8, that has no bus timing to reproduce
+var ErrNoBusTiming = errors.New("cycle accurate execution is not available for the 65c24T8")
+
+// BusCycle is the bus access of a cycle
+type BusCycle struct {
+	Address uint32
+	Data    uint8
+	Write   bool
+	Sync    bool // Opcode fetch, as the SYNC pin
+	Kind    AccessKind
+}
+
+func (c BusCycle) String() string {
+	if c.Write {
+		return fmt.Sprintf("$%04x <- $%02x %v", c.Address, c.Data, c.Kind)
+	}
+	return fmt.Sprintf("$%04x -> $%02x %v", c.Address, c.Data, c.Kind)
+}
+
+// Kinds of memory access of the operations
+const (
+	kindRead = iota
+	kindWrite
+	kindModify
+)
+
+func operationKind(op opcode) int {
+	switch op.name {
+	case "STA", "STX", "STY", "STZ", "SAX", "SHA", "SHX", "SHY", "TAS":
+		return kindWrite
+	case "ASL", "LSR", "ROL", "ROR", "INC", "DEC", "TRB", "TSB",
+		"SLO", "RLA", "SRE", "RRA", "DCP", "ISC":
+		return kindModify
+	}
+	if strings.HasPrefix(op.name, "RMB") || strings.HasPrefix(op.name, "SMB") {
+		return kindModify
+	}
+	return kindRead
+}
+
+type microOp func(s *State) BusCycle
+
+type busAccess struct {
+	address uint32
+	value   uint8
+}
+
+// cycleState is the instruction in progress with Tick
+type cycleState struct {
+	active   bool
+	op       opcode
+	kind     int
+	mode     int
+	line     [maxInstructionSize]uint8
+	n        int
+	ops      []microOp
+	next     int
+	count    int // Cycles of the instruction so far
+	expected int // Cycles of the instruction, with the extra cycles of the operation
+
+	index   uint32
+	base    uint32
+	address uint32
+	unfixed uint32 // Address before fixing the page crossing
+	crossed bool
+	taken   bool
+	value   uint8
+	last    uint32 // Address of the previous cycle
+	pc      uint32 // PC after the operands of a branch
+	stack   uint32 // Bytes read from the stack
+	vector  uint32
+	brk     bool
+	push    bool // The writes of the operation are to the stack
+
+	// The operations run with the cycleState as memory
+	mem    Memory
+	reads  []busAccess
+	writes []BusCycle
+}
+
+// Peek returns the value read on the bus in the instruction
+func (t *cycleState) Peek(address uint32) uint8 {
+	for i := len(t.reads) - 1; i >= 0; i-- {
+		if t.reads[i].address == address {
+			return t.reads[i].value
+		}
+	}
+	return t.mem.Peek(address)
+}
+
+// PeekCode returns the value read on the bus in the instruction
+func (t *cycleState) PeekCode(address uint32) uint8 {
+	return t.Peek(address)
+}
+
+// Poke holds the write for its bus cycle
+func (t *cycleState) Poke(address uint32, value uint8) {
+	kind := AccessWrite
+	if t.push {
+		kind = AccessStackPush
+	}
+	t.writes = append(t.writes, BusCycle{Address: address, Data: value, Write: true, Kind: kind})
+}
+
+func (t *cycleState) add(ops ...microOp) {
+	t.ops = append(t.ops, ops...)
+}
+
+// insert adds a cycle before the remaining ones
+func (t *cycleState) insert(op microOp) {
+	t.ops = append(t.ops, nil)
+	copy(t.ops[t.next+1:], t.ops[t.next:])
+	t.ops[t.next] = op
+}
+
+// Tick executes a single cycle and returns its bus access. An instruction or
+// interrupt sequence spans several calls. While waiting after WAI or stopped
+// after STP there is no access and the bus keeps the PC.
+//
+// Step completes the instruction in progress before executing the next one.
+// Tracers are not called, and Save fails until the instruction is completed.
+func (s *State) Tick() (BusCycle, error) {
+	if s.abMaxWidth != AB16 {
+		return BusCycle{}, ErrNoBusTiming
+	}
+	t := &s.tick
+	if t.active {
+		return s.tickNext(), nil
+	}
+
+	pc := s.reg.getPC()
+	if s.stopped {
+		s.cycles++
+		return BusCycle{Address: pc}, nil
+	}
+	if s.waiting {
+		if !s.irqLine && !s.nmiPending {
+			s.cycles++
+			return BusCycle{Address: pc}, nil
+		}
+		s.waiting = false
+	}
+
+	t.ops = t.ops[:0]
+	t.reads = t.reads[:0]
+	t.writes = t.writes[:0]
+	t.next = 0
+	t.count = 0
+	t.n = 0
+	t.stack = 0
+	t.push = false
+
+	if s.nmiPending || (s.irqLine && !s.reg.getFlag(flagI)) {
+		// The opcode fetched is discarded
+		c := s.busRead(pc, AccessOpcodeFetch)
+		c.Sync = true
+		t.active = true
+		t.brk = false
+		t.vector = vectorBreak
+		if s.nmiPending {
+			s.nmiPending = false
+			t.vector = vectorNMI
+		}
+		t.expected = interruptCycles
+		t.add(cycleDummyPC, cyclePushPCH, cyclePushPCL, cyclePushP, cycleReadVectorLow, cycleReadVectorHigh)
+		s.tickEnd()
+		return c, nil
+	}
+
+	c := s.busFetch(AccessOpcodeFetch)
+	op := s.opcodes[c.Data]
+	if op.cycles == 0 {
+		s.reg.setPC(pc)
+		return BusCycle{}, s.illegalOpcode(pc, c.Data)
+	}
+	c.Sync = true
+	t.active = true
+	t.op = op
+	t.kind = operationKind(op)
+	t.expected = op.cycles
+	s.planInstruction()
+	s.tickEnd()
+	return c, nil
+}
+
+// TickInstruction runs Tick up to the end of the instruction in progress, or of
+// the next one, and appends the cycles to buf
+func (s *State) TickInstruction(buf []BusCycle) ([]BusCycle, error) {
+	for {
+		c, err := s.Tick()
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, c)
+		if !s.tick.active {
+			return buf, nil
+		}
+	}
+}
+
+func (s *State) tickNext() BusCycle {
+	t := &s.tick
+	op := t.ops[t.next]
+	t.next++
+	c := op(s)
+	s.tickEnd()
+	return c
+}
+
+// tickEnd counts the cycle, and ends the instruction when no cycles are left
+func (s *State) tickEnd() {
+	t := &s.tick
+	s.cycles++
+	t.count++
+	if t.next < len(t.ops) {
+		return
+	}
+	if t.count < t.expected {
+		// The cycles not sequenced keep the bus on the last address
+		t.add(cycleDummyLast)
+		return
+	}
+	t.active = false
+}
+
+func (s *State) planInstruction() {
+	t := &s.tick
+	op := t.op
+	cmos := s.model != ModelNMOS6502
+	t.mode = op.addressMode
+	if cmos && op.name == "NOP" && t.mode == modeImmediate {
+		// The 65c02 NOPs reading memory
+		switch op.cycles {
+		case 3:
+			t.mode = modeZeroPage
+		case 4:
+			t.mode = modeZeroPageX
+		}
+	}
+
+	switch op.name {
+	case "BRK":
+		t.brk = true
+		t.vector = vectorBreak
+		t.add(cycleReadPadding, cyclePushPCH, cyclePushPCL, cyclePushP, cycleReadVectorLow, cycleReadVectorHigh)
+		return
+	case "JSR":
+		t.add(cycleFetch, cycleDummyStack, cyclePushPCH, cyclePushPCL, cycleFetchJump)
+		return
+	case "RTS":
+		t.add(cycleDummyPC, cycleDummyStack, cycleReadStack, cycleReadStackExecute, cycleDummyReturn)
+		return
+	case "RTI":
+		t.add(cycleDummyPC, cycleDummyStack, cycleReadStack, cycleReadStack, cycleReadStackExecute)
+		return
+	case "PHA", "PHP", "PHX", "PHY":
+		t.push = true
+		t.add(cycleDummyPC, cycleExecuteWrite)
+		return
+	case "PLA", "PLP", "PLX", "PLY":
+		t.add(cycleDummyPC, cycleDummyStack, cycleReadStackExecute)
+		return
+	case "JMP":
+		switch t.mode {
+		case modeAbsolute:
+			t.add(cycleFetch, cycleFetchExecute)
+		case modeIndirect:
+			t.add(cycleFetch, cycleFetchPointer, cycleReadIndirect, cycleReadIndirectExecute)
+		default:
+			// The 65c02 adds a cycle to get the pointer right
+			t.index = 0
+			if t.mode == modeAbsoluteIndexedIndirectX {
+				t.index = s.reg.getX(R08)
+			}
+			t.add(cycleFetch, cycleFetchPointer, cycleIndexPointer, cycleReadIndirect, cycleReadIndirectExecute)
+		}
+		return
+	}
+
+	if op.cycles == 1 {
+		// The 65c02 single cycle NOPs
+		s.cycleExecute()
+		return
+	}
+
+	switch t.mode {
+	case modeImplicit, modeImplicitX, modeImplicitY, modeAccumulator:
+		t.add(cycleDummyPCExecute)
+		return
+	case modeImmediate:
+		t.add(cycleFetchExecute)
+		return
+	case modeRelative:
+		t.add(cycleFetchBranch)
+		return
+	case modeZeroPageAndRelative:
+		t.add(cycleFetchZeroPage, cycleRead, cycleDummyAddress, cycleFetchExecute, cycleDummyBranch)
+		return
+	case modeZeroPage:
+		t.add(cycleFetchZeroPage)
+	case modeZeroPageX:
+		t.index = s.reg.getX(R08)
+		t.add(cycleFetch, cycleIndexZeroPage)
+	case modeZeroPageY:
+		t.index = s.reg.getY(R08)
+		t.add(cycleFetch, cycleIndexZeroPage)
+	case modeAbsolute:
+		t.add(cycleFetch, cycleFetchAbsolute)
+	case modeAbsoluteX, modeAbsoluteX65c02:
+		t.index = s.reg.getX(R08)
+		t.add(cycleFetch, cycleFetchIndexed)
+	case modeAbsoluteY:
+		t.index = s.reg.getY(R08)
+		t.add(cycleFetch, cycleFetchIndexed)
+	case modeIndexedIndirectX:
+		t.index = s.reg.getX(R08)
+		t.add(cycleFetch, cycleIndexZeroPage, cycleReadPointerLow, cycleReadPointerHigh)
+	case modeIndirectIndexedY:
+		t.index = s.reg.getY(R08)
+		t.add(cycleFetchZeroPage, cycleReadPointerLow, cycleReadPointerIndexed)
+	case modeIndirectZeroPage:
+		t.index = 0
+		t.add(cycleFetchZeroPage, cycleReadPointerLow, cycleReadPointerHigh)
+	default:
+		panic(assertError("Missing addressing mode for the cycle accurate execution"))
+	}
+
+	switch t.kind {
+	case kindWrite:
+		t.add(cycleExecuteWrite)
+	case kindModify:
+		t.add(cycleReadExecute, cycleModifyDummy, cycleWrite)
+	default:
+		t.add(cycleReadExecute)
+	}
+}
+
+// cycleExecute runs the operation with the values read in the instruction
+func (s *State) cycleExecute() {
+	t := &s.tick
+	t.mem = s.mem
+	access := s.access
+	s.mem = t
+	s.access = nil
+	t.op.action(s, t.line[:], t.op)
+	s.mem = t.mem
+	s.access = access
+
+	t.taken = s.extraCycleBranchTaken
+	t.crossed = s.extraCycleCrossingBoundaries
+	if s.extraCycleBranchTaken {
+		t.expected++
+		s.extraCycleBranchTaken = false
+	}
+	if s.extraCycleCrossingBoundaries {
+		t.expected++
+		s.extraCycleCrossingBoundaries = false
+	}
+	if s.extraCycleBCD {
+		t.expected++
+		s.extraCycleBCD = false
+	}
+}
+
+func (s *State) busRead(address uint32, kind AccessKind) BusCycle {
+	t := &s.tick
+	value := s.peek(address, kind)
+	t.reads = append(t.reads, busAccess{address, value})
+	t.last = address
+	return BusCycle{Address: address, Data: value, Kind: kind}
+}
+
+func (s *State) busWrite(address uint32, value uint8, kind AccessKind) BusCycle {
+	s.poke(address, value, kind)
+	s.tick.last = address
+	return BusCycle{Address: address, Data: value, Write: true, Kind: kind}
+}
+
+// busFetch reads the next byte of the instruction
+func (s *State) busFetch(kind AccessKind) BusCycle {
+	t := &s.tick
+	pc := s.reg.getPC()
+	c := s.busRead(pc, kind)
+	if t.n < len(t.line) {
+		t.line[t.n] = c.Data
+		t.n++
+	}
+	s.reg.setPC((pc + 1) & 0xffff)
+	return c
+}
+
+func (s *State) stackAddress(offset uint32) uint32 {
+	return stackAddress | ((s.reg.getSP(R08) + offset) & 0xff)
+}
+
+func (t *cycleState) word() uint32 {
+	return uint32(t.line[1]) | uint32(t.line[2])<<8
+}
+
+func cycleFetch(s *State) BusCycle {
+	return s.busFetch(AccessOperandFetch)
+}
+
+func cycleFetchExecute(s *State) BusCycle {
+	c := s.busFetch(AccessOperandFetch)
+	s.tick.pc = s.reg.getPC()
+	s.cycleExecute()
+	return c
+}
+
+func cycleFetchZeroPage(s *State) BusCycle {
+	c := s.busFetch(AccessOperandFetch)
+	s.tick.address = uint32(s.tick.line[1])
+	s.tick.base = s.tick.address
+	return c
+}
+
+func cycleFetchAbsolute(s *State) BusCycle {
+	c := s.busFetch(AccessOperandFetch)
+	s.tick.address = s.tick.word()
+	return c
+}
+
+func cycleFetchPointer(s *State) BusCycle {
+	c := s.busFetch(AccessOperandFetch)
+	s.tick.base = s.tick.word()
+	return c
+}
+
+func cycleFetchJump(s *State) BusCycle {
+	c := s.busFetch(AccessOperandFetch)
+	s.reg.setPC(s.tick.word())
+	return c
+}
+
+// cycleFetchIndexed adds the index to the low byte of the address. The high
+// byte is fixed on the next cycle if needed.
+func cycleFetchIndexed(s *State) BusCycle {
+	c := s.busFetch(AccessOperandFetch)
+	s.tick.indexAddress(s.tick.word())
+	s.tick.insertDummyIndexed()
+	return c
+}
+
+func (t *cycleState) indexAddress(base uint32) {
+	t.address = (base + t.index) & 0xffff
+	t.unfixed = (base & 0xff00) | (t.address & 0xff)
+	t.crossed = t.unfixed != t.address
+}
+
+// insertDummyIndexed adds the cycle to fix the high byte of the address. The
+// writes always take it, as the reads with a page crossing. The 65c02 skips
+// it on shifts and rotations without a page crossing.
+func (t *cycleState) insertDummyIndexed() {
+	if t.crossed || t.kind == kindWrite || (t.kind == kindModify && t.mode != modeAbsoluteX65c02) {
+		t.insert(cycleDummyIndexed)
+	}
+}
+
+func cycleDummyIndexed(s *State) BusCycle {
+	t := &s.tick
+	if s.model == ModelNMOS6502 || !t.crossed {
+		return s.busRead(t.unfixed, AccessDummyRead)
+	}
+	// The 65c02 reads again the last address instead of the wrong one
+	return s.busRead(t.last, AccessDummyRead)
+}
+
+// cycleIndexZeroPage adds the index, the NMOS 6502 reading the base address meanwhile
+func cycleIndexZeroPage(s *State) BusCycle {
+	t := &s.tick
+	var c BusCycle
+	if s.model == ModelNMOS6502 {
+		c = s.busRead(uint32(t.line[1]), AccessDummyRead)
+	} else {
+		c = s.busRead(t.last, AccessDummyRead)
+	}
+	t.address = (uint32(t.line[1]) + t.index) & 0xff
+	t.base = t.address
+	return c
+}
+
+func cycleReadPointerLow(s *State) BusCycle {
+	t := &s.tick
+	c := s.busRead(t.base, AccessPointer)
+	t.address = uint32(c.Data)
+	return c
+}
+
+func cycleReadPointerHigh(s *State) BusCycle {
+	t := &s.tick
+	c := s.busRead((t.base+1)&0xff, AccessPointer)
+	t.address |= uint32(c.Data) << 8
+	return c
+}
+
+func cycleReadPointerIndexed(s *State) BusCycle {
+	t := &s.tick
+	c := s.busRead((t.base+1)&0xff, AccessPointer)
+	t.indexAddress(t.address | uint32(c.Data)<<8)
+	t.insertDummyIndexed()
+	return c
+}
+
+func cycleIndexPointer(s *State) BusCycle {
+	t := &s.tick
+	c := s.busRead(t.last, AccessDummyRead)
+	t.base = (t.base + t.index) & 0xffff
+	return c
+}
+
+func cycleReadIndirect(s *State) BusCycle {
+	return s.busRead(s.tick.base, AccessPointer)
+}
+
+func cycleReadIndirectExecute(s *State) BusCycle {
+	t := &s.tick
+	address := (t.base + 1) & 0xffff
+	if t.mode == modeIndirect {
+		// The NMOS 6502 does not carry to the high byte of the pointer
+		address = (t.base & 0xff00) | (address & 0xff)
+	}
+	c := s.busRead(address, AccessPointer)
+	s.cycleExecute()
+	return c
+}
+
+func cycleRead(s *State) BusCycle {
+	c := s.busRead(s.tick.address, AccessRead)
+	s.tick.value = c.Data
+	return c
+}
+
+func cycleReadExecute(s *State) BusCycle {
+	c := cycleRead(s)
+	s.cycleExecute()
+	return c
+}
+
+func cycleDummyAddress(s *State) BusCycle {
+	return s.busRead(s.tick.address, AccessDummyRead)
+}
+
+// cycleModifyDummy is the NMOS 6502 writing back the value while it is
+// modified, the 65c02 reads it again
+func cycleModifyDummy(s *State) BusCycle {
+	t := &s.tick
+	if s.model == ModelNMOS6502 {
+		return s.busWrite(t.address, t.value, AccessDummyWrite)
+	}
+	return s.busRead(t.address, AccessDummyRead)
+}
+
+// cycleWrite puts on the bus the next write of the operation
+func cycleWrite(s *State) BusCycle {
+	t := &s.tick
+	if len(t.writes) == 0 {
+		panic(assertError("Missing write for the cycle accurate execution"))
+	}
+	w := t.writes[0]
+	t.writes = t.writes[1:]
+	return s.busWrite(w.Address, w.Data, w.Kind)
+}
+
+func cycleExecuteWrite(s *State) BusCycle {
+	s.cycleExecute()
+	return cycleWrite(s)
+}
+
+func cycleDummyPC(s *State) BusCycle {
+	return s.busRead(s.reg.getPC(), AccessDummyRead)
+}
+
+func cycleDummyPCExecute(s *State) BusCycle {
+	c := cycleDummyPC(s)
+	s.cycleExecute()
+	return c
+}
+
+func cycleDummyLast(s *State) BusCycle {
+	return s.busRead(s.tick.last, AccessDummyRead)
+}
+
+func cycleDummyStack(s *State) BusCycle {
+	return s.busRead(s.stackAddress(0), AccessDummyRead)
+}
+
+// cycleReadStack reads the bytes the operation pulls next
+func cycleReadStack(s *State) BusCycle {
+	s.tick.stack++
+	return s.busRead(s.stackAddress(s.tick.stack), AccessStackPull)
+}
+
+func cycleReadStackExecute(s *State) BusCycle {
+	c := cycleReadStack(s)
+	s.cycleExecute()
+	return c
+}
+
+// cycleDummyReturn reads the address pulled by RTS, before the increment
+func cycleDummyReturn(s *State) BusCycle {
+	return s.busRead((s.reg.getPC()-1)&0xffff, AccessDummyRead)
+}
+
+// cycleFetchBranch reads the offset and runs the branch. A taken branch adds
+// a cycle, and another one to fix the high byte of the PC.
+func cycleFetchBranch(s *State) BusCycle {
+	t := &s.tick
+	c := cycleFetchExecute(s)
+	if t.crossed {
+		t.insert(cycleDummyBranchFix)
+	}
+	if t.taken || t.op.name == "BRA" {
+		t.insert(cycleDummyBranch)
+	}
+	return c
+}
+
+func cycleDummyBranch(s *State) BusCycle {
+	return s.busRead(s.tick.pc, AccessDummyRead)
+}
+
+func cycleDummyBranchFix(s *State) BusCycle {
+	return s.busRead((s.tick.pc&0xff00)|(s.reg.getPC()&0xff), AccessDummyRead)
+}
+
+func cycleReadPadding(s *State) BusCycle {
+	return s.busFetch(AccessOperandFetch)
+}
+
+func cyclePushPCH(s *State) BusCycle {
+	c := s.busWrite(s.stackAddress(0), uint8(s.reg.getPC()>>8), AccessStackPush)
+	s.reg.setSP(R08, s.reg.getSP(R08)-1)
+	return c
+}
+
+func cyclePushPCL(s *State) BusCycle {
+	c := s.busWrite(s.stackAddress(0), uint8(s.reg.getPC()), AccessStackPush)
+	s.reg.setSP(R08, s.reg.getSP(R08)-1)
+	return c
+}
+
+// cyclePushP pushes the flags, with B set only for BRK
+func cyclePushP(s *State) BusCycle {
+	p := s.reg.getP() | flag5
+	if s.tick.brk {
+		p |= flagB
+	} else {
+		p &^= flagB
+	}
+	c := s.busWrite(s.stackAddress(0), p, AccessStackPush)
+	s.reg.setSP(R08, s.reg.getSP(R08)-1)
+	s.reg.setFlag(flagI)
+	if s.model != ModelNMOS6502 {
+		s.reg.clearFlag(flagD)
+	}
+	return c
+}
+
+func cycleReadVectorLow(s *State) BusCycle {
+	c := s.busRead(s.tick.vector, AccessVector)
+	s.tick.address = uint32(c.Data)
+	return c
+}
+
+func cycleReadVectorHigh(s *State) BusCycle {
+	c := s.busRead(s.tick.vector+1, AccessVector)
+	s.reg.setPC(s.tick.address | uint32(c.Data)<<8)
+	return c
+}
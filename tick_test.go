@@ -0,0 +1,189 @@
+package iz6502
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestTickLockstep checks that Tick ends every instruction as Step does
+func TestTickLockstep(t *testing.T) {
+	models := []Model{ModelNMOS6502, ModelCMOS65c02, ModelWDC65c02}
+	r := rand.New(rand.NewSource(6502))
+	random := new(FlatMemory)
+	r.Read(random.data[:])
+	m1 := new(FlatMemory)
+	m2 := new(FlatMemory)
+	for _, model := range models {
+		for opcodeID := 0; opcodeID < 0x100; opcodeID++ {
+			for i := 0; i < 20; i++ {
+				// The operands and pointers are random, in random memory
+				*m1 = *random
+				*m2 = *random
+
+				s1, _ := NewState(model, m1)
+				if s1.opcodes[opcodeID].cycles == 0 {
+					break
+				}
+				s2, _ := NewState(model, m2)
+				regs := Registers{
+					A:  uint32(r.Intn(0x100)),
+					X:  uint32(r.Intn(0x100)),
+					Y:  uint32(r.Intn(0x100)),
+					SP: uint32(r.Intn(0x100)),
+					P:  uint8(r.Intn(0x100)) | flag5,
+					PC: uint32(r.Intn(0x10000)),
+				}
+				m1.Poke(regs.PC, uint8(opcodeID))
+				m2.Poke(regs.PC, uint8(opcodeID))
+				s1.SetRegisters(regs)
+				s2.SetRegisters(regs)
+
+				if err := s1.Step(); err != nil {
+					t.Fatal(err)
+				}
+				cycles, err := s2.TickInstruction(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				name := model.String() + " " + s1.opcodes[opcodeID].name
+				if s1.GetCycles() != s2.GetCycles() || uint64(len(cycles)) != s2.GetCycles() {
+					t.Errorf("%v $%02x took %v cycles with Tick, %v with Step, for %+v: %v",
+						name, opcodeID, len(cycles), s1.GetCycles(), regs, cycles)
+				}
+				if s1.GetRegisters() != s2.GetRegisters() {
+					t.Errorf("%v $%02x ends with %+v with Tick, %+v with Step", name, opcodeID, s2.GetRegisters(), s1.GetRegisters())
+				}
+				if *m1 != *m2 {
+					t.Errorf("%v $%02x memory differs for %+v", name, opcodeID, regs)
+				}
+				if !cycles[0].Sync || cycles[0].Address != regs.PC {
+					t.Errorf("%v $%02x does not start with the opcode fetch: %v", name, opcodeID, cycles)
+				}
+			}
+		}
+	}
+}
+
+func TestTickBusCycles(t *testing.T) {
+	read := func(address uint32, data uint8, kind AccessKind) BusCycle {
+		return BusCycle{Address: address, Data: data, Kind: kind}
+	}
+	write := func(address uint32, data uint8, kind AccessKind) BusCycle {
+		return BusCycle{Address: address, Data: data, Write: true, Kind: kind}
+	}
+	fetch := func(address uint32, data uint8) BusCycle {
+		return BusCycle{Address: address, Data: data, Sync: true, Kind: AccessOpcodeFetch}
+	}
+	operand := func(address uint32, data uint8) BusCycle {
+		return read(address, data, AccessOperandFetch)
+	}
+
+	cases := []struct {
+		name    string
+		model   Model
+		program []uint8
+		x       uint32
+		memory  map[uint32]uint8
+		cycles  []BusCycle
+	}{
+		{"NMOS LDA abs,X crossing", ModelNMOS6502, []uint8{0xbd, 0xf0, 0x12}, 0x20,
+			map[uint32]uint8{0x1210: 0x55, 0x1310: 0x66},
+			[]BusCycle{fetch(0x400, 0xbd), operand(0x401, 0xf0), operand(0x402, 0x12),
+				read(0x1210, 0x55, AccessDummyRead), read(0x1310, 0x66, AccessRead)}},
+		{"NMOS INC abs,X", ModelNMOS6502, []uint8{0xfe, 0x00, 0x12}, 0x01,
+			map[uint32]uint8{0x1201: 0x41},
+			[]BusCycle{fetch(0x400, 0xfe), operand(0x401, 0x00), operand(0x402, 0x12), read(0x1201, 0x41, AccessDummyRead),
+				read(0x1201, 0x41, AccessRead), write(0x1201, 0x41, AccessDummyWrite), write(0x1201, 0x42, AccessWrite)}},
+		{"CMOS INC zp", ModelCMOS65c02, []uint8{0xe6, 0x10}, 0,
+			map[uint32]uint8{0x10: 0x41},
+			[]BusCycle{fetch(0x400, 0xe6), operand(0x401, 0x10), read(0x10, 0x41, AccessRead),
+				read(0x10, 0x41, AccessDummyRead), write(0x10, 0x42, AccessWrite)}},
+		{"NMOS LDA zp,X", ModelNMOS6502, []uint8{0xb5, 0xf0}, 0x20,
+			map[uint32]uint8{0xf0: 0x01, 0x10: 0x02},
+			[]BusCycle{fetch(0x400, 0xb5), operand(0x401, 0xf0), read(0xf0, 0x01, AccessDummyRead), read(0x10, 0x02, AccessRead)}},
+		{"LDA (zp),Y", ModelNMOS6502, []uint8{0xb1, 0x10}, 0,
+			map[uint32]uint8{0x10: 0x00, 0x11: 0x20, 0x2000: 0x77},
+			[]BusCycle{fetch(0x400, 0xb1), operand(0x401, 0x10), read(0x10, 0x00, AccessPointer),
+				read(0x11, 0x20, AccessPointer), read(0x2000, 0x77, AccessRead)}},
+		{"JSR", ModelNMOS6502, []uint8{0x20, 0x34, 0x12}, 0,
+			map[uint32]uint8{0x1ff: 0x99},
+			[]BusCycle{fetch(0x400, 0x20), operand(0x401, 0x34), read(0x1ff, 0x99, AccessDummyRead),
+				write(0x1ff, 0x04, AccessStackPush), write(0x1fe, 0x02, AccessStackPush), operand(0x402, 0x12)}},
+		{"PHA", ModelNMOS6502, []uint8{0x48}, 0,
+			map[uint32]uint8{0x401: 0xea},
+			[]BusCycle{fetch(0x400, 0x48), read(0x401, 0xea, AccessDummyRead), write(0x1ff, 0x00, AccessStackPush)}},
+		{"BNE taken crossing", ModelNMOS6502, []uint8{0xd0, 0xfc}, 0,
+			map[uint32]uint8{0x4fe: 0xea},
+			[]BusCycle{fetch(0x400, 0xd0), operand(0x401, 0xfc), read(0x402, 0x00, AccessDummyRead), read(0x4fe, 0xea, AccessDummyRead)}},
+	}
+	for _, c := range cases {
+		m := new(FlatMemory)
+		for i, b := range c.program {
+			m.Poke(0x400+uint32(i), b)
+		}
+		for address, value := range c.memory {
+			m.Poke(address, value)
+		}
+		s, _ := NewState(c.model, m)
+		s.SetRegisters(Registers{X: c.x, SP: 0xff, PC: 0x400})
+
+		cycles, err := s.TickInstruction(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cycles) != len(c.cycles) {
+			t.Errorf("%v: got %v, expected %v", c.name, cycles, c.cycles)
+			continue
+		}
+		for i := range cycles {
+			if cycles[i] != c.cycles[i] {
+				t.Errorf("%v: got %v, expected %v", c.name, cycles, c.cycles)
+				break
+			}
+		}
+	}
+}
+
+func TestTickInterrupt(t *testing.T) {
+	m := new(FlatMemory)
+	m.Poke(0x0400, 0xea)
+	m.Poke(0xfffe, 0x00)
+	m.Poke(0xffff, 0x20)
+	s := NewCMOS65c02(m)
+	s.SetRegisters(Registers{SP: 0xff, PC: 0x400, P: flag5 | flagD})
+	s.RaiseIRQ()
+
+	cycles, err := s.TickInstruction(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cycles) != interruptCycles || s.GetPC() != 0x2000 || s.GetCycles() != interruptCycles {
+		t.Errorf("Wrong interrupt sequence %v, PC $%04x", cycles, s.GetPC())
+	}
+	if m.Peek(0x1fd) != flag5|flagD || s.reg.getFlag(flagD) || !s.reg.getFlag(flagI) {
+		t.Errorf("Wrong flags pushed $%02x", m.Peek(0x1fd))
+	}
+}
+
+func TestTickStepCompletes(t *testing.T) {
+	m := new(FlatMemory)
+	m.Poke(0x0400, 0xee) // INC $1000
+	m.Poke(0x0401, 0x00)
+	m.Poke(0x0402, 0x10)
+	s := NewNMOS6502(m)
+	s.SetPC(0x400)
+	s.Tick()
+	s.Tick()
+	if err := s.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetCycles() != 6 || m.Peek(0x1000) != 1 || s.GetPC() != 0x403 {
+		t.Errorf("Step did not complete the instruction, %v cycles", s.GetCycles())
+	}
+
+	s24 := NewMythical65c24T8(new(Flat256KMemory))
+	if _, err := s24.Tick(); err != ErrNoBusTiming {
+		t.Errorf("Tick should fail on the 65c24T8: %v", err)
+	}
+}
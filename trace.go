@@ -0,0 +1,298 @@
+package iz6502
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Registers is a copy of the processor registers, with the 65c24T8 registers
+// in their full 24 bits
+type Registers struct {
+	A, X, Y, SP, PC uint32
+	P               uint8
+}
+
+func (r Registers) String() string {
+	ch := uint8(r.A) & 0x7F
+	if ch < 0x20 {
+		ch += 0x40
+	}
+	return fmt.Sprintf("A: %#06x(%v), X: %#06x, Y: %#06x, SP: %#06x, PC: %#06x, P: %#02x, (NV-BDIZC): %08b",
+		r.A, string(ch), r.X, r.Y, r.SP, r.PC, r.P, r.P)
+}
+
+func (s *State) registers() Registers {
+	return Registers{
+		A:  s.reg.getA(R24),
+		X:  s.reg.getX(R24),
+		Y:  s.reg.getY(R24),
+		SP: s.reg.getSP(R24),
+		PC: s.reg.getPC(),
+		P:  s.reg.getP(),
+	}
+}
+
+// TraceEvent describes an executed instruction, or a serviced interrupt. The
+// operand is formatted only when requested with Operand().
+type TraceEvent struct {
+	Cycle      uint64 // Cycle count before the instruction
+	Cycles     uint64 // Cycles used, including the extra ones
+	PC         uint32
+	Raw        [maxInstructionSize]uint8
+	Length     int
+	Mnemonic   string
+	Address    uint32 // Effective address of the memory operand
+	HasAddress bool
+	Before     Registers
+	After      Registers
+	Widths     Widths // 65c24T8 widths used to execute the instruction
+	Interrupt  bool   // An interrupt was serviced instead of executing an instruction
+	op         opcode
+}
+
+// Bytes returns the bytes of the instruction
+func (e *TraceEvent) Bytes() []uint8 {
+	return e.Raw[:e.Length]
+}
+
+// Operand returns the operand formatted as in the disassembler
+func (e *TraceEvent) Operand() string {
+	return e.OperandSymbols(nil)
+}
+
+// OperandSymbols returns the operand with the addresses replaced by their symbols
+func (e *TraceEvent) OperandSymbols(sym Symbolizer) string {
+	if e.Interrupt {
+		return ""
+	}
+	next := e.PC + uint32(e.Length)
+	if e.Widths.Address == AB16 {
+		next &= 0xffff
+	}
+	operand, _, _ := operandString(e.op, e.Raw[:], e.Widths.Address, e.Widths.Register, next, sym)
+	return operand
+}
+
+// String returns the event in the format of the text tracer
+func (e *TraceEvent) String() string {
+	return e.Format(nil)
+}
+
+// Format returns the event in the format of the text tracer. With symbols, the
+// PC is followed by the nearest symbol and the operand uses the symbol names.
+func (e *TraceEvent) Format(sym Symbolizer) string {
+	if e.Interrupt {
+		return fmt.Sprintf("Interrupt serviced: %v", e.After)
+	}
+	line := e.Mnemonic
+	if operand := e.OperandSymbols(sym); operand != "" {
+		line += " " + operand
+	}
+	if sym != nil {
+		return fmt.Sprintf("%#06x %-16s %-20s: %v, [%02x] <w%x/%x>",
+			e.PC, SymbolString(sym, e.PC), line, e.After, e.Bytes(), e.Widths.Address, e.Widths.Register)
+	}
+	return fmt.Sprintf("%#06x %-13s: %v, [%02x] <w%x/%x>",
+		e.PC, line, e.After, e.Bytes(), e.Widths.Address, e.Widths.Register)
+}
+
+// Tracer receives an event for each instruction executed. The event is reused
+// by the processor, copy it to keep it after Trace returns.
+type Tracer interface {
+	Trace(e *TraceEvent)
+}
+
+// SetTracer sets the tracer of the cpu execution. Nil disables tracing.
+func (s *State) SetTracer(tracer Tracer) {
+	s.tracer = tracer
+}
+
+// GetTracer returns the tracer of the cpu execution, nil if disabled
+func (s *State) GetTracer() Tracer {
+	return s.tracer
+}
+
+// TextTracer writes a line of text per event
+type TextTracer struct {
+	w   io.Writer
+	sym Symbolizer
+}
+
+// NewTextTracer creates a tracer writing text to w
+func NewTextTracer(w io.Writer) *TextTracer {
+	return &TextTracer{w: w}
+}
+
+// SetSymbols sets the symbols used to print the addresses
+func (t *TextTracer) SetSymbols(sym Symbolizer) {
+	t.sym = sym
+}
+
+// Trace writes the event
+func (t *TextTracer) Trace(e *TraceEvent) {
+	fmt.Fprintln(t.w, e.Format(t.sym))
+}
+
+// JSONTracer writes a JSON object per line per event
+type JSONTracer struct {
+	enc *json.Encoder
+	sym Symbolizer
+}
+
+// NewJSONTracer creates a tracer writing JSON lines to w
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+// SetSymbols sets the symbols used in the operands and to add the nearest
+// symbol of the PC
+func (t *JSONTracer) SetSymbols(sym Symbolizer) {
+	t.sym = sym
+}
+
+type jsonTraceRegisters struct {
+	A  uint32 `json:"a"`
+	X  uint32 `json:"x"`
+	Y  uint32 `json:"y"`
+	SP uint32 `json:"sp"`
+	PC uint32 `json:"pc"`
+	P  uint8  `json:"p"`
+}
+
+type jsonTraceEvent struct {
+	Cycle     uint64             `json:"cycle"`
+	Cycles    uint64             `json:"cycles"`
+	PC        uint32             `json:"pc"`
+	Symbol    string             `json:"symbol,omitempty"`
+	Bytes     string             `json:"bytes,omitempty"`
+	Mnemonic  string             `json:"mnemonic,omitempty"`
+	Operand   string             `json:"operand,omitempty"`
+	Address   *uint32            `json:"address,omitempty"`
+	Before    jsonTraceRegisters `json:"before"`
+	After     jsonTraceRegisters `json:"after"`
+	AWidth    uint8              `json:"awidth"`
+	RWidth    uint8              `json:"rwidth"`
+	Interrupt bool               `json:"interrupt,omitempty"`
+}
+
+func jsonRegisters(r Registers) jsonTraceRegisters {
+	return jsonTraceRegisters{r.A, r.X, r.Y, r.SP, r.PC, r.P}
+}
+
+// widthBits converts the AB16/AB24 and R08/R16/R24 constants to bits
+func widthBits(address bool, width uint8) uint8 {
+	if address {
+		if width == AB24 {
+			return 24
+		}
+		return 16
+	}
+	switch width {
+	case R16:
+		return 16
+	case R24:
+		return 24
+	default:
+		return 8
+	}
+}
+
+// Trace writes the event. Write errors are ignored.
+func (t *JSONTracer) Trace(e *TraceEvent) {
+	j := jsonTraceEvent{
+		Cycle:     e.Cycle,
+		Cycles:    e.Cycles,
+		PC:        e.PC,
+		Mnemonic:  e.Mnemonic,
+		Operand:   e.OperandSymbols(t.sym),
+		Before:    jsonRegisters(e.Before),
+		After:     jsonRegisters(e.After),
+		AWidth:    widthBits(true, e.Widths.Address),
+		RWidth:    widthBits(false, e.Widths.Register),
+		Interrupt: e.Interrupt,
+	}
+	if t.sym != nil {
+		j.Symbol = SymbolString(t.sym, e.PC)
+	}
+	if e.Length > 0 {
+		j.Bytes = fmt.Sprintf("%02x", e.Bytes())
+	}
+	if e.HasAddress {
+		address := e.Address
+		j.Address = &address
+	}
+	t.enc.Encode(j)
+}
+
+// RingTracer keeps the last events in memory, to be dumped when needed. It is
+// safe to read the events from other goroutines.
+type RingTracer struct {
+	mu     sync.Mutex
+	events []TraceEvent
+	next   int
+	full   bool
+	sym    Symbolizer
+}
+
+// NewRingTracer creates a tracer keeping up to size events
+func NewRingTracer(size int) *RingTracer {
+	if size < 1 {
+		size = 1
+	}
+	return &RingTracer{events: make([]TraceEvent, size)}
+}
+
+// Trace stores a copy of the event, discarding the oldest if full
+func (t *RingTracer) Trace(e *TraceEvent) {
+	t.mu.Lock()
+	t.events[t.next] = *e
+	t.next++
+	if t.next == len(t.events) {
+		t.next = 0
+		t.full = true
+	}
+	t.mu.Unlock()
+}
+
+// Events returns a copy of the stored events, oldest first
+func (t *RingTracer) Events() []TraceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.full {
+		return append([]TraceEvent(nil), t.events[:t.next]...)
+	}
+	events := append([]TraceEvent(nil), t.events[t.next:]...)
+	return append(events, t.events[:t.next]...)
+}
+
+// SetSymbols sets the symbols used by Dump to print the addresses
+func (t *RingTracer) SetSymbols(sym Symbolizer) {
+	t.mu.Lock()
+	t.sym = sym
+	t.mu.Unlock()
+}
+
+// Clear discards the stored events
+func (t *RingTracer) Clear() {
+	t.mu.Lock()
+	t.next = 0
+	t.full = false
+	t.mu.Unlock()
+}
+
+// Dump writes the stored events as text, oldest first
+func (t *RingTracer) Dump(w io.Writer) error {
+	t.mu.Lock()
+	sym := t.sym
+	t.mu.Unlock()
+	var b strings.Builder
+	for _, e := range t.Events() {
+		b.WriteString(e.Format(sym))
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,131 @@
+package iz6502
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newTraceTest() *State {
+	m := new(FlatMemory)
+	s := NewNMOS6502(m)
+	m.Poke(0x0400, 0xa9) // LDA #$42
+	m.Poke(0x0401, 0x42)
+	m.Poke(0x0402, 0x8d) // STA $1234
+	m.Poke(0x0403, 0x34)
+	m.Poke(0x0404, 0x12)
+	m.Poke(0x0405, 0xe8) // INX
+	s.reg.setPC(0x0400)
+	return s
+}
+
+func TestTextTracer(t *testing.T) {
+	s := newTraceTest()
+	var b bytes.Buffer
+	s.SetTracer(NewTextTracer(&b))
+
+	s.ExecuteInstruction()
+	s.ExecuteInstruction()
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines of trace, got %q", b.String())
+	}
+	if !strings.HasPrefix(lines[0], "0x000400 LDA #$42") {
+		t.Errorf("Wrong trace line %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "0x000402 STA $1234") || !strings.Contains(lines[1], "[8d3412]") {
+		t.Errorf("Wrong trace line %q", lines[1])
+	}
+}
+
+func TestTraceEvent(t *testing.T) {
+	s := newTraceTest()
+	ring := NewRingTracer(10)
+	s.SetTracer(ring)
+	s.ExecuteInstruction()
+	s.ExecuteInstruction()
+
+	events := ring.Events()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %v", len(events))
+	}
+	e := events[1]
+	if e.PC != 0x0402 || e.Mnemonic != "STA" || e.Operand() != "$1234" {
+		t.Errorf("Wrong event %v", e.String())
+	}
+	if !e.HasAddress || e.Address != 0x1234 {
+		t.Errorf("Wrong effective address $%04x", e.Address)
+	}
+	if e.Before.A != 0x42 || e.Before.PC != 0x0402 || e.After.PC != 0x0405 {
+		t.Errorf("Wrong registers %v -> %v", e.Before, e.After)
+	}
+	if e.Cycle != 2 || e.Cycles != 4 {
+		t.Errorf("Wrong cycles %v+%v", e.Cycle, e.Cycles)
+	}
+	if events[0].HasAddress {
+		t.Error("Immediate LDA must not have an effective address")
+	}
+}
+
+func TestRingTracer(t *testing.T) {
+	s := newTraceTest()
+	ring := NewRingTracer(2)
+	s.SetTracer(ring)
+	s.ExecuteInstruction()
+	s.ExecuteInstruction()
+	s.ExecuteInstruction()
+
+	events := ring.Events()
+	if len(events) != 2 || events[0].Mnemonic != "STA" || events[1].Mnemonic != "INX" {
+		t.Fatalf("Wrong events kept %v", events)
+	}
+	var b bytes.Buffer
+	ring.Dump(&b)
+	if strings.Count(b.String(), "\n") != 2 {
+		t.Errorf("Wrong dump %q", b.String())
+	}
+	ring.Clear()
+	if len(ring.Events()) != 0 {
+		t.Error("Events not cleared")
+	}
+}
+
+func TestJSONTracer(t *testing.T) {
+	s := newTraceTest()
+	var b bytes.Buffer
+	s.SetTracer(NewJSONTracer(&b))
+	s.ExecuteInstruction()
+	s.ExecuteInstruction()
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 JSON lines, got %q", b.String())
+	}
+	var e map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatalf("Invalid JSON %v: %v", lines[1], err)
+	}
+	if e["mnemonic"] != "STA" || e["operand"] != "$1234" || e["bytes"] != "8d3412" ||
+		e["address"] != float64(0x1234) || e["pc"] != float64(0x0402) {
+		t.Errorf("Wrong JSON event %v", lines[1])
+	}
+}
+
+func TestTextTracerSymbols(t *testing.T) {
+	s := newTraceTest()
+	var b bytes.Buffer
+	tracer := NewTextTracer(&b)
+	tracer.SetSymbols(testSymbols{0x0400: "start", 0x1234: "output"})
+	s.SetTracer(tracer)
+
+	s.ExecuteInstruction()
+	s.ExecuteInstruction()
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if !strings.HasPrefix(lines[0], "0x000400 start            LDA #$42") {
+		t.Errorf("Wrong trace line %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "0x000402 start+$2         STA output") {
+		t.Errorf("Wrong trace line %q", lines[1])
+	}
+}